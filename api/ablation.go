@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ====== Prompt ablation ======
+// "hotelparser ablate" runs the ground truth against one provider with the
+// system prompt as it is (the baseline) and with variants of it, and reports
+// per-slot precision, recall and F1 of each variant with their change
+// against the baseline:
+//
+//	hotelparser ablate -provider openai -each
+//	hotelparser ablate -provider claude -variants ablation.json -repeat 3 -json
+//
+// The rules of a prompt are the "- " items of its "Regeln:" list (an item
+// runs until the next one or an empty line). A variant removes rules, found
+// by a piece of their text that must match exactly one rule, and adds new
+// ones at the end of the list:
+//
+//	[{"name": "no-negation", "remove": ["Verneinungen"]},
+//	 {"name": "price-rounding", "add": ["Preise auf volle Euro runden."]},
+//	 {"name": "stars-merged", "remove": ["Sterne/Bewertung", "Bereiche:"],
+//	  "add": ["Sterne und Bewertung: ..."]}]
+//
+// -each adds one variant per rule without it. Examples appended to the
+// prompt stay in every variant. Calls go through the regular single-stage
+// path (retries, concurrency slots, breaker); the parse cache isn't used and
+// nothing is stored. Failed calls are counted and left out of the scores,
+// and -repeat averages over several calls per query, as models rarely
+// answer the same twice.
+
+// AblationVariant is one entry of the variants file
+type AblationVariant struct {
+	Name   string   `json:"name"`
+	Remove []string `json:"remove,omitempty"`
+	Add    []string `json:"add,omitempty"`
+}
+
+type AblationReport struct {
+	Provider string           `json:"provider"`
+	Model    string           `json:"model,omitempty"`
+	Prompt   string           `json:"prompt"`
+	Rules    int              `json:"rules"`
+	Items    int              `json:"items"`
+	Repeat   int              `json:"repeat"`
+	Baseline AblationResult   `json:"baseline"`
+	Variants []AblationResult `json:"variants"`
+}
+
+type AblationResult struct {
+	Name      string   `json:"name"`
+	Removed   []string `json:"removed,omitempty"` // rule labels
+	Added     []string `json:"added,omitempty"`
+	Calls     int      `json:"calls"`
+	Errors    int      `json:"errors"`
+	Precision float64  `json:"slot_precision"`
+	Recall    float64  `json:"slot_recall"`
+	F1        float64  `json:"f1"`
+	// against the baseline; not set on the baseline itself
+	DeltaF1 *float64                `json:"delta_f1,omitempty"`
+	PerSlot map[string]AblationSlot `json:"per_slot"`
+}
+
+type AblationSlot struct {
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	F1             float64 `json:"f1"`
+	DeltaPrecision float64 `json:"delta_precision,omitempty"`
+	DeltaRecall    float64 `json:"delta_recall,omitempty"`
+	DeltaF1        float64 `json:"delta_f1,omitempty"`
+}
+
+// promptRules splits a system prompt into the text up to and including its
+// "Regeln:" line, the rule items and the rest
+func promptRules(prompt string) (head string, rules []string, tail string, err error) {
+	lines := strings.Split(prompt, "\n")
+	start := -1
+	for i, l := range lines {
+		if strings.TrimSpace(l) == "Regeln:" {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return "", nil, "", fmt.Errorf(`the prompt has no "Regeln:" list`)
+	}
+	end := start
+	for ; end < len(lines); end++ {
+		l := lines[end]
+		if strings.TrimSpace(l) == "" {
+			break
+		}
+		if strings.HasPrefix(l, "- ") || len(rules) == 0 {
+			rules = append(rules, l)
+		} else {
+			rules[len(rules)-1] += "\n" + l
+		}
+	}
+	if len(rules) == 0 {
+		return "", nil, "", fmt.Errorf(`the prompt's "Regeln:" list is empty`)
+	}
+	head = strings.Join(lines[:start], "\n") + "\n"
+	if end < len(lines) {
+		tail = "\n" + strings.Join(lines[end:], "\n")
+	}
+	return head, rules, tail, nil
+}
+
+// ruleLabel names a rule by its first words
+func ruleLabel(rule string) string {
+	s := strings.TrimPrefix(strings.SplitN(rule, "\n", 2)[0], "- ")
+	if utf8.RuneCountInString(s) <= 40 {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:40])) + "…"
+}
+
+// ablatedPrompt applies v to the prompt split by promptRules
+func ablatedPrompt(head string, rules []string, tail string, v AblationVariant) (string, []string, error) {
+	drop := map[int]bool{}
+	var removed []string
+	for _, pat := range v.Remove {
+		var hits []int
+		for i, r := range rules {
+			if strings.Contains(r, pat) {
+				hits = append(hits, i)
+			}
+		}
+		if len(hits) != 1 {
+			return "", nil, fmt.Errorf("variant %q: %q matches %d rules, not one", v.Name, pat, len(hits))
+		}
+		drop[hits[0]] = true
+		removed = append(removed, ruleLabel(rules[hits[0]]))
+	}
+	var kept []string
+	for i, r := range rules {
+		if !drop[i] {
+			kept = append(kept, r)
+		}
+	}
+	for _, add := range v.Add {
+		if !strings.HasPrefix(add, "- ") {
+			add = "- " + add
+		}
+		kept = append(kept, add)
+	}
+	return head + strings.Join(kept, "\n") + tail, removed, nil
+}
+
+func loadAblationVariants(path string) ([]AblationVariant, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vs []AblationVariant
+	if err := json.Unmarshal(b, &vs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := map[string]bool{"baseline": true}
+	for i, v := range vs {
+		switch {
+		case v.Name == "":
+			return nil, fmt.Errorf("%s: variant %d has no name", path, i+1)
+		case seen[v.Name]:
+			return nil, fmt.Errorf("%s: variant name %q is used twice", path, v.Name)
+		case len(v.Remove)+len(v.Add) == 0:
+			return nil, fmt.Errorf("%s: variant %q changes nothing", path, v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return vs, nil
+}
+
+// ablationRun scores one prompt over the ground truth
+func ablationRun(provider, model, prompt string, items []GroundTruthItem, repeat, conc int) AblationResult {
+	jobs := make(chan GroundTruthItem)
+	a := newAcc()
+	var res AblationResult
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < conc; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				plan := parsePlan{pipeline: pipelineSingle, systemPrompt: prompt, query: item.Query, uncached: true}
+				c := callProvider(context.Background(), plan, provider, model)
+				mu.Lock()
+				res.Calls++
+				if c.err != nil || c.res == nil {
+					res.Errors++
+				} else {
+					a.add(scoreAgainstGT(*c.res, item.Truth), c.latency.Milliseconds())
+					a.addSlots(*c.res, item.Truth)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for r := 0; r < repeat; r++ {
+		for _, item := range items {
+			jobs <- item
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	m := a.metrics()
+	res.Precision, res.Recall, res.F1 = m.SlotPrecision, m.SlotRecall, m.F1
+	res.PerSlot = map[string]AblationSlot{}
+	for slot, s := range m.PerSlot {
+		res.PerSlot[slot] = AblationSlot{Precision: s.Precision, Recall: s.Recall, F1: s.F1}
+	}
+	return res
+}
+
+// compareAblation sets v's deltas against the baseline. A slot only one of
+// them scored (its queries all failed in the other) has no delta.
+func compareAblation(v *AblationResult, base AblationResult) {
+	d := round2(v.F1 - base.F1)
+	v.DeltaF1 = &d
+	for slot, s := range v.PerSlot {
+		b, ok := base.PerSlot[slot]
+		if !ok {
+			continue
+		}
+		s.DeltaPrecision = round2(s.Precision - b.Precision)
+		s.DeltaRecall = round2(s.Recall - b.Recall)
+		s.DeltaF1 = round2(s.F1 - b.F1)
+		v.PerSlot[slot] = s
+	}
+}
+
+// runAblation is "hotelparser ablate"
+func runAblation(args []string) int {
+	fs := flag.NewFlagSet("ablate", flag.ExitOnError)
+	provider := fs.String("provider", "openai", "openai or claude")
+	model := fs.String("model", "", "model (default: the provider's configured model)")
+	promptPath := fs.String("prompt", (*Variant)(nil).promptPath(), "system prompt file")
+	gtPath := fs.String("groundtruth", dataPath(groundFile), "ground-truth file")
+	variantsFile := fs.String("variants", "", "variants file")
+	each := fs.Bool("each", false, "add one variant per rule without it")
+	repeat := fs.Int("repeat", 1, "calls per query and variant")
+	conc := fs.Int("c", 4, "concurrent calls")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	verbose := fs.Bool("v", false, "keep the request log")
+	_ = fs.Parse(args)
+
+	if *provider != "openai" && *provider != "claude" {
+		log.Printf("[ERROR] -provider must be openai or claude")
+		return 2
+	}
+	if *repeat < 1 || *conc < 1 {
+		log.Printf("[ERROR] -repeat and -c must be positive")
+		return 2
+	}
+	prompt := loadSystemPrompt(*promptPath, examplesFile)
+	head, rules, tail, err := promptRules(prompt)
+	if err != nil {
+		log.Printf("[ERROR] %s: %v", *promptPath, err)
+		return 2
+	}
+	var variants []AblationVariant
+	if *variantsFile != "" {
+		if variants, err = loadAblationVariants(*variantsFile); err != nil {
+			log.Printf("[ERROR] %v", err)
+			return 2
+		}
+	}
+	if *each {
+		for i, r := range rules {
+			// the whole first line, so the rule is found even when another
+			// one starts the same way
+			variants = append(variants, AblationVariant{Name: fmt.Sprintf("without-rule-%d", i+1),
+				Remove: []string{strings.SplitN(r, "\n", 2)[0]}})
+		}
+	}
+	if len(variants) == 0 {
+		log.Printf("[ERROR] no variants: give -variants or -each")
+		return 2
+	}
+	prompts := make([]string, len(variants))
+	removed := make([][]string, len(variants))
+	for i, v := range variants {
+		if prompts[i], removed[i], err = ablatedPrompt(head, rules, tail, v); err != nil {
+			log.Printf("[ERROR] %v", err)
+			return 2
+		}
+	}
+	items, err := (&fileGroundTruth{path: *gtPath}).GroundTruth()
+	if err != nil || len(items) == 0 {
+		log.Printf("[ERROR] no ground truth in %s: %v", *gtPath, err)
+		return 2
+	}
+
+	if err := useStubProvider(); err != nil {
+		log.Printf("[ERROR] %v", err)
+		return 1
+	}
+	if _, err := upstreamTransport(); err != nil {
+		log.Printf("[ERROR] %v", err)
+		return 1
+	}
+	buildClients()
+	buildLimiters()
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	rep := AblationReport{Provider: *provider, Model: *model, Prompt: *promptPath, Rules: len(rules),
+		Items: len(items), Repeat: *repeat}
+	rep.Baseline = ablationRun(*provider, *model, prompt, items, *repeat, *conc)
+	rep.Baseline.Name = "baseline"
+	for i, v := range variants {
+		res := ablationRun(*provider, *model, prompts[i], items, *repeat, *conc)
+		res.Name, res.Removed, res.Added = v.Name, removed[i], v.Add
+		compareAblation(&res, rep.Baseline)
+		rep.Variants = append(rep.Variants, res)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(rep)
+	} else {
+		printAblation(rep)
+	}
+	if rep.Baseline.Errors == rep.Baseline.Calls {
+		return 1
+	}
+	return 0
+}
+
+func printAblation(r AblationReport) {
+	fmt.Printf("provider   %s %s\n", r.Provider, r.Model)
+	fmt.Printf("prompt     %s (%d rules), %d queries × %d\n", r.Prompt, r.Rules, r.Items, r.Repeat)
+	fmt.Printf("baseline   f1 %.2f  precision %.2f  recall %.2f  errors %d\n",
+		r.Baseline.F1, r.Baseline.Precision, r.Baseline.Recall, r.Baseline.Errors)
+	for _, v := range r.Variants {
+		fmt.Printf("\n%s  f1 %.2f (%+.2f)  precision %.2f  recall %.2f  errors %d\n",
+			v.Name, v.F1, *v.DeltaF1, v.Precision, v.Recall, v.Errors)
+		for _, l := range v.Removed {
+			fmt.Printf("  - %s\n", l)
+		}
+		for _, l := range v.Added {
+			fmt.Printf("  + %s\n", ruleLabel(l))
+		}
+		slots := make([]string, 0, len(v.PerSlot))
+		for slot, s := range v.PerSlot {
+			if s.DeltaF1 != 0 {
+				slots = append(slots, slot)
+			}
+		}
+		// biggest changes first
+		sort.Slice(slots, func(i, j int) bool {
+			a, b := math.Abs(v.PerSlot[slots[i]].DeltaF1), math.Abs(v.PerSlot[slots[j]].DeltaF1)
+			if a != b {
+				return a > b
+			}
+			return slots[i] < slots[j]
+		})
+		for _, slot := range slots {
+			s := v.PerSlot[slot]
+			fmt.Printf("    %-32s f1 %.2f (%+.2f)  p %+.2f  r %+.2f\n", slot, s.F1, s.DeltaF1, s.DeltaPrecision, s.DeltaRecall)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+// ====== Accessibility profile ======
+// Accessibility needs (stufenfrei, Aufzug, rollstuhlgerechtes Bad,
+// Allergiker-Zimmer) go into the top-level "accessibility" list, backed by
+// the taxonomy slot of the same name, in every request. They decide
+// whether a guest can stay at all, so a request can also ask for the
+// "accessibility" parsing profile: the single-call system prompt then gets
+// an extra section (prompt/profile_accessibility.txt or the built-in one)
+// that reads such needs more carefully, e.g. "mit Rollator" as step-free
+// with an elevator. The profile changes the prompt hash. The two-stage
+// pipeline maps the same needs through the taxonomy and ignores the
+// profile.
+
+const (
+	profileAccessibility = "accessibility"
+
+	accessibilityPromptFile = "prompt/profile_accessibility.txt"
+)
+
+const defaultAccessibilityPrompt = `
+Profil Barrierefreiheit – besonders sorgfältig beachten:
+- Jede Angabe zu Mobilität, Sinnen oder Allergien gehört in accessibility, auch wenn sie beiläufig erwähnt wird („meine Mutter sitzt im Rollstuhl“ → wheelchair_accessible, step_free).
+- Hilfsmittel ableiten: Rollstuhl → wheelchair_accessible und step_free; Rollator/gehbehindert/„kann keine Treppen steigen“ → step_free und elevator; „Zimmer im Erdgeschoss“ → step_free.
+- Bad: „rollstuhlgerechtes Bad“, „bodengleiche Dusche“, „Haltegriffe“ → accessible_bathroom.
+- Allergien: Allergiker-Zimmer, Hausstauballergie → allergy_friendly_room.
+- Hören/Sehen: hearing_support, visual_support; Behindertenparkplatz → accessible_parking.
+- Bedürfnisse ohne passenden Wert (z. B. „Pflegebett“, „Assistenzhund erlaubt“) wortwörtlich in unsupported_criteria, nie weglassen.
+`
+
+// profileSection is the prompt section of a parsing profile ("" for none)
+func profileSection(profile string) string {
+	if profile != profileAccessibility {
+		return ""
+	}
+	return cachedPrompt(accessibilityPromptFile, func() string {
+		return promptOrDefault(accessibilityPromptFile, defaultAccessibilityPrompt)
+	}).Text
+}
+
+// withProfile is prompt with the profile's section appended
+func withProfile(prompt *loadedPrompt, profile string) *loadedPrompt {
+	section := profileSection(profile)
+	if section == "" {
+		return prompt
+	}
+	return newLoadedPrompt(func() string { return prompt.Text + "\n" + section })
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ====== Admin endpoints ======
+// /v1/admin/* requires "Authorization: Bearer $ADMIN_TOKEN". Without
+// ADMIN_TOKEN the admin endpoints are disabled.
+//
+//	GET  /v1/admin/config     effective configuration, secrets masked
+//	GET  /v1/admin/providers  provider switches and model overrides
+//	POST /v1/admin/providers  {"provider":"claude","enabled":false,"model":"..."}
+//	POST /v1/admin/flush      drop the evaluation, prompt and parse caches
+//	POST /v1/admin/warmup     parse the top stored queries into the parse cache
+//	POST /v1/admin/reload     re-read config file and prompts (same as SIGHUP)
+//	POST /v1/providers/{name}/test  canned query through the pipeline
+
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if os.Getenv("ADMIN_TOKEN") == "" {
+			writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "admin endpoints are disabled"))
+			return
+		}
+		if !isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeProblem(w, newProblem(http.StatusUnauthorized, codeUnauthorized, "missing or invalid admin token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether r carries the admin token
+func isAdmin(r *http.Request) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// --- runtime provider switches ---
+
+// ProviderOverride is the runtime state of one provider. It lives in memory
+// only and resets on restart.
+type ProviderOverride struct {
+	Enabled bool   `json:"enabled"`
+	Model   string `json:"model,omitempty"` // replaces <PROVIDER>_MODEL when set
+}
+
+var providerSwitches = struct {
+	sync.RWMutex
+	m map[string]ProviderOverride
+}{m: map[string]ProviderOverride{}}
+
+// providerOverrides reports whether provider is enabled and its admin model
+// override (empty if none)
+func providerOverrides(provider string) (enabled bool, model string) {
+	if provider == "" {
+		provider = "openai"
+	}
+	providerSwitches.RLock()
+	defer providerSwitches.RUnlock()
+	o, ok := providerSwitches.m[provider]
+	if !ok {
+		return true, ""
+	}
+	return o.Enabled, o.Model
+}
+
+func adminProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		b, err := readBody(w, r)
+		if err != nil {
+			writeProblem(w, inputProblem(err))
+			return
+		}
+		var req struct {
+			Provider string  `json:"provider"`
+			Enabled  *bool   `json:"enabled"`
+			Model    *string `json:"model"`
+		}
+		if err := json.Unmarshal(b, &req); err != nil {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid JSON body"))
+			return
+		}
+		if req.Provider != "openai" && req.Provider != "claude" {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "provider must be openai or claude"))
+			return
+		}
+		providerSwitches.Lock()
+		o, ok := providerSwitches.m[req.Provider]
+		if !ok {
+			o.Enabled = true
+		}
+		if req.Enabled != nil {
+			o.Enabled = *req.Enabled
+		}
+		if req.Model != nil {
+			o.Model = strings.TrimSpace(*req.Model)
+		}
+		providerSwitches.m[req.Provider] = o
+		providerSwitches.Unlock()
+		log.Printf("[INFO] admin: %s enabled=%t model=%q", req.Provider, o.Enabled, o.Model)
+	default:
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET or POST"))
+		return
+	}
+
+	out := map[string]ProviderOverride{}
+	for _, p := range []string{"openai", "claude"} {
+		enabled, model := providerOverrides(p)
+		out[p] = ProviderOverride{Enabled: enabled, Model: model}
+	}
+	writeAdminJSON(w, out)
+}
+
+// --- effective configuration ---
+
+// configEnvKeys are the settings shown by GET /v1/admin/config
+var configEnvKeys = []string{
+	"PORT", "CONFIG_FILE", "CORS_ORIGINS",
+	"PROMPT_DIR", "DATA_DIR",
+	"TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_AUTOCERT_DOMAINS", "TLS_AUTOCERT_EMAIL", "TLS_AUTOCERT_CACHE", "TLS_HTTP_ADDR",
+	"OPENAI_API_KEY", "OPENAI_BASE_URL", "OPENAI_MODEL", "OPENAI_TIMEOUT", "OPENAI_EXTRA_HEADERS",
+	"OPENAI_MAX_CONCURRENCY", "OPENAI_QUEUE_TIMEOUT", "OPENAI_BATCH_CONCURRENCY", "OPENAI_MAX_TOKENS", "OPENAI_MAX_TOKENS_RETRY",
+	"OPENAI_ESCALATION_MODEL", "OPENAI_SEED", "OPENAI_TEMPERATURE", "OPENAI_TOP_P", "OPENAI_LOGPROBS", "OPENAI_TOOLS",
+	"CLAUDE_API_KEY", "CLAUDE_BASE_URL", "CLAUDE_MODEL", "CLAUDE_TIMEOUT", "CLAUDE_EXTRA_HEADERS",
+	"CLAUDE_MAX_CONCURRENCY", "CLAUDE_QUEUE_TIMEOUT", "CLAUDE_BATCH_CONCURRENCY", "CLAUDE_MAX_TOKENS", "CLAUDE_MAX_TOKENS_RETRY",
+	"CLAUDE_ESCALATION_MODEL", "CLAUDE_TEMPERATURE", "CLAUDE_TOP_P", "CLAUDE_TOOLS", "ESCALATE_MAX_UNSUPPORTED",
+	"MODEL_PRICES", "API_KEYS", "USAGE_PERIOD",
+	"UPSTREAM_PROXY", "UPSTREAM_CA_FILE", "PROVIDER_BREAKER_FAILURES", "PROVIDER_BREAKER_COOLDOWN",
+	"PARSE_BUDGET", "REQUEST_TIMEOUT", "SLOW_REQUEST_THRESHOLD", "ADAPTIVE_TIMEOUT", "ADAPTIVE_TIMEOUT_MIN", "PARSE_CACHE_TTL", "PARSE_CACHE_SIZE", "PARSE_CACHE_WARMUP", "MAX_BODY_BYTES", "MAX_QUERY_CHARS", "INPUT_SCREENING",
+	"PROMPT_VARIANTS_FILE", "PROMPT_RELOAD_INTERVAL", "PARSE_PIPELINE", "STAGE2_MODE", "INTERPRETATION_MODE", "INTENT_GATE", "INTENT_MODEL", "RULES_FALLBACK",
+	"DISTANCE_WALK_M_PER_MIN", "DISTANCE_DRIVE_M_PER_MIN", "OUTPUT_LOCALE", "CONTRACT_SCHEMA_FILE", "SIMULATOR_HOTELS_FILE", "SEARCH_API_URL", "SEARCH_API_TIMEOUT", "SUGGEST_MIN_COUNT",
+	"SHADOW_PROVIDER", "SHADOW_MODEL", "SHADOW_PROMPT", "SHADOW_SAMPLE_RATE",
+	"STORAGE_BACKEND", "SQLITE_PATH", "DATABASE_URL", "PG_MAX_CONNS",
+	"RESULTS_ROTATE_BYTES", "RESULTS_ROTATE_EVERY",
+	"ARCHIVE_S3_BUCKET", "ARCHIVE_S3_REGION", "ARCHIVE_S3_ENDPOINT", "ARCHIVE_S3_PREFIX",
+	"ARCHIVE_KEEP_LOCAL", "ARCHIVE_INTERVAL", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY",
+	"EVENTS_BACKEND", "EVENTS_URL", "EVENTS_SUBJECT",
+	"TELEMETRY_URL", "TELEMETRY_INTERVAL", "TELEMETRY_DEPLOYMENT", "TELEMETRY_SECRET", "CAPTURE_RAW", "STUB_SCRIPT",
+	"PROMPT_SUGGEST_INTERVAL", "PROMPT_SUGGEST_PROVIDER", "PROMPT_SUGGEST_MODEL",
+	"CONFIDENCE_CALIBRATION", "CONFIDENCE_CALIBRATION_INTERVAL",
+	"WEBHOOK_SECRET", "WEBHOOK_ALLOWED_HOSTS", "ADMIN_TOKEN",
+}
+
+type configEntry struct {
+	Value  string `json:"value"`
+	Source string `json:"source"` // "env" or "file"
+}
+
+func adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	configState.Lock()
+	fromFile := configState.fromFile
+	configState.Unlock()
+
+	settings := map[string]configEntry{}
+	for _, key := range configEnvKeys {
+		v, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		source := "env"
+		if fv, ok := fromFile[key]; ok && fv == v {
+			source = "file"
+		}
+		settings[key] = configEntry{Value: maskSetting(key, v), Source: source}
+	}
+	prompts := map[string]string{}
+	promptCache.RLock()
+	for path, p := range promptCache.m {
+		prompts[path] = p.Hash
+	}
+	promptCache.RUnlock()
+	variants := []string{}
+	for _, v := range loadVariants() {
+		variants = append(variants, v.Name)
+	}
+	sort.Strings(variants)
+
+	writeAdminJSON(w, map[string]any{
+		"settings":  settings,
+		"prompts":   prompts,
+		"variants":  variants,
+		"providers": map[string]bool{"openai": isEnabled("openai"), "claude": isEnabled("claude")},
+	})
+}
+
+func isEnabled(provider string) bool {
+	enabled, _ := providerOverrides(provider)
+	return enabled
+}
+
+// maskSetting hides secrets, keeping the last 4 characters so keys can
+// still be told apart
+func maskSetting(key, v string) string {
+	switch key {
+	case "DATABASE_URL", "UPSTREAM_PROXY", "EVENTS_URL": // may carry user:password
+		if u, err := url.Parse(v); err == nil {
+			return u.Redacted()
+		}
+		return "****"
+	}
+	if strings.HasSuffix(key, "_EXTRA_HEADERS") {
+		return "****" // usually carries proxy credentials
+	}
+	for _, marker := range []string{"KEY", "SECRET", "TOKEN", "PASSWORD"} {
+		if strings.Contains(key, marker) {
+			if len(v) <= 8 {
+				return "****"
+			}
+			return "****" + v[len(v)-4:]
+		}
+	}
+	return v
+}
+
+// --- caches and reload ---
+
+// adminFlushHandler: POST drops the evaluation cache and the prompt cache;
+// both are rebuilt on next use
+func adminFlushHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST"))
+		return
+	}
+	evals.invalidate()
+	promptCache.Lock()
+	promptCache.m = map[string]*loadedPrompt{}
+	promptCache.Unlock()
+	n := parseCache.flush()
+	log.Printf("[INFO] admin: caches flushed (%d cached answers)", n)
+	writeAdminJSON(w, map[string]any{"status": "flushed", "parse_cache_entries": n})
+}
+
+// adminReloadHandler: POST re-reads the config file and prompts (same as SIGHUP)
+func adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST"))
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		writeProblem(w, newProblem(http.StatusUnprocessableEntity, codeInvalidInput, err.Error()))
+		return
+	}
+	writeAdminJSON(w, map[string]string{"status": "reloaded"})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+)
+
+// ====== Departure airports ======
+// Package-holiday queries name where the flight starts ("ab Frankfurt",
+// "Abflug ab MUC"). The model answers departure_airports with whatever it
+// read; normalizeAirports turns codes, airport names and cities into IATA
+// codes so the answer can go straight into dynamic packaging. A city with
+// several airports stands for all of them. Names the table doesn't know are
+// not guessed: they move to unsupported_criteria as "ab <name>".
+
+// airportsByName maps normalized airport and city names to IATA codes
+var airportsByName = map[string][]string{
+	// Germany
+	"frankfurt": {"FRA"}, "frankfurt am main": {"FRA"}, "frankfurt main": {"FRA"}, "rhein main": {"FRA"},
+	"frankfurt hahn": {"HHN"}, "hahn": {"HHN"},
+	"münchen": {"MUC"}, "muenchen": {"MUC"}, "munich": {"MUC"}, "franz josef strauß": {"MUC"},
+	"berlin": {"BER"}, "berlin brandenburg": {"BER"}, "schönefeld": {"BER"},
+	"hamburg": {"HAM"}, "fuhlsbüttel": {"HAM"},
+	"düsseldorf": {"DUS"}, "duesseldorf": {"DUS"},
+	"köln": {"CGN"}, "koeln": {"CGN"}, "köln bonn": {"CGN"}, "bonn": {"CGN"}, "konrad adenauer": {"CGN"},
+	"stuttgart": {"STR"},
+	"hannover":  {"HAJ"}, "langenhagen": {"HAJ"},
+	"nürnberg": {"NUE"}, "nuernberg": {"NUE"},
+	"leipzig": {"LEJ"}, "halle": {"LEJ"}, "leipzig halle": {"LEJ"},
+	"dresden": {"DRS"},
+	"bremen":  {"BRE"},
+	"münster": {"FMO"}, "osnabrück": {"FMO"}, "münster osnabrück": {"FMO"},
+	"dortmund":  {"DTM"},
+	"paderborn": {"PAD"}, "paderborn lippstadt": {"PAD"},
+	"weeze": {"NRN"}, "niederrhein": {"NRN"},
+	"karlsruhe": {"FKB"}, "baden baden": {"FKB"}, "karlsruhe baden baden": {"FKB"},
+	"memmingen": {"FMM"}, "allgäu": {"FMM"},
+	"friedrichshafen": {"FDH"},
+	"saarbrücken":     {"SCN"},
+	"erfurt":          {"ERF"},
+	"rostock":         {"RLG"}, "rostock laage": {"RLG"},
+	"sylt": {"GWT"},
+	// Austria, Switzerland
+	"wien": {"VIE"}, "vienna": {"VIE"}, "schwechat": {"VIE"},
+	"salzburg":   {"SZG"},
+	"innsbruck":  {"INN"},
+	"graz":       {"GRZ"},
+	"linz":       {"LNZ"},
+	"klagenfurt": {"KLU"},
+	"zürich":     {"ZRH"}, "zuerich": {"ZRH"}, "zurich": {"ZRH"},
+	"basel": {"BSL"}, "basel mulhouse": {"BSL"}, "euroairport": {"BSL"},
+	"genf": {"GVA"}, "geneva": {"GVA"},
+	"bern": {"BRN"},
+	// neighbours people fly from
+	"amsterdam": {"AMS"}, "schiphol": {"AMS"},
+	"luxemburg":  {"LUX"},
+	"straßburg":  {"SXB"},
+	"prag":       {"PRG"},
+	"kopenhagen": {"CPH"},
+	"brüssel":    {"BRU"},
+	"paris":      {"CDG", "ORY"},
+	"london":     {"LHR", "LGW", "STN", "LTN", "LCY"},
+	"mailand":    {"MXP", "LIN", "BGY"},
+}
+
+// airportCodes are the IATA codes the table knows; only these are accepted
+// as codes, so "ab ALL" doesn't become an airport
+var airportCodes = func() map[string]bool {
+	m := map[string]bool{}
+	for _, codes := range airportsByName {
+		for _, c := range codes {
+			m[c] = true
+		}
+	}
+	return m
+}()
+
+// lookupAirport is the IATA codes for a code, an airport name or a city,
+// or nil
+func lookupAirport(s string) []string {
+	if code := strings.ToUpper(strings.TrimSpace(s)); airportCodes[code] {
+		return []string{code}
+	}
+	n := normalizePhrase(s)
+	for _, prefix := range []string{"flughafen ", "airport ", "abflug ", "ab "} {
+		n = strings.TrimPrefix(n, prefix)
+	}
+	n = strings.TrimSuffix(n, " airport")
+	n = strings.TrimSuffix(n, " flughafen")
+	return airportsByName[n]
+}
+
+// normalizeAirports rewrites departure_airports to known IATA codes,
+// without duplicates; unknown names go to unsupported_criteria
+func (p *ParseResponse) normalizeAirports() {
+	if len(p.DepartureAirports) == 0 {
+		p.DepartureAirports = []string{}
+		return
+	}
+	seen := map[string]bool{}
+	codes := []string{}
+	for _, a := range p.DepartureAirports {
+		found := lookupAirport(a)
+		if found == nil {
+			if a = strings.TrimSpace(a); a != "" {
+				p.UnsupportedCriteria = append(p.UnsupportedCriteria, "ab "+a)
+			}
+			continue
+		}
+		for _, c := range found {
+			if !seen[c] {
+				seen[c] = true
+				codes = append(codes, c)
+			}
+		}
+	}
+	p.DepartureAirports = codes
+}
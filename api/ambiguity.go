@@ -0,0 +1,84 @@
+package main
+
+// ====== Per-slot ambiguity scoring ======
+// matchesAnyAcceptable only credits answers that equal an interpretation in
+// every slot, which is rare. For ambiguous ground truth the slots that differ
+// between the truth and its acceptable interpretations are the ambiguous
+// ones; an answer gets them right with any of the readings, and the other
+// slots are scored against the truth as usual.
+
+// slotValues groups a flattened answer by slot
+func slotValues(p ParseResponse) map[string]map[string]bool {
+	out := map[string]map[string]bool{}
+	for k := range flatten(p) {
+		slot := slotNameOf(k)
+		if out[slot] == nil {
+			out[slot] = map[string]bool{}
+		}
+		out[slot][k] = true
+	}
+	return out
+}
+
+// ambiguousSlots are the slots on which the readings of item disagree
+func ambiguousSlots(readings []map[string]map[string]bool) map[string]bool {
+	slots := map[string]bool{}
+	for _, r := range readings {
+		for slot := range r {
+			slots[slot] = true
+		}
+	}
+	amb := map[string]bool{}
+	for slot := range slots {
+		for _, r := range readings[1:] {
+			if !setsEqual(r[slot], readings[0][slot]) {
+				amb[slot] = true
+				break
+			}
+		}
+	}
+	return amb
+}
+
+// ambiguityScore scores pred against an ambiguous item slot by slot. It
+// returns how many ambiguous slots were answered with one of the readings,
+// out of how many, and the share of all slots answered acceptably.
+func ambiguityScore(pred ParseResponse, item GroundTruthItem) (ok, n int, score float64) {
+	readings := []map[string]map[string]bool{slotValues(item.Truth)}
+	for _, alt := range item.AcceptableInterpretation {
+		readings = append(readings, slotValues(alt))
+	}
+	amb := ambiguousSlots(readings)
+	got := slotValues(pred)
+
+	slots := map[string]bool{}
+	for slot := range got {
+		slots[slot] = true
+	}
+	for _, r := range readings {
+		for slot := range r {
+			slots[slot] = true
+		}
+	}
+	correct := 0
+	for slot := range slots {
+		if !amb[slot] {
+			if setsEqual(got[slot], readings[0][slot]) {
+				correct++
+			}
+			continue
+		}
+		n++
+		for _, r := range readings {
+			if setsEqual(got[slot], r[slot]) {
+				ok++
+				correct++
+				break
+			}
+		}
+	}
+	if len(slots) == 0 {
+		return ok, n, 1
+	}
+	return ok, n, float64(correct) / float64(len(slots))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Segment archival ======
+// With STORAGE_BACKEND=jsonl and ARCHIVE_S3_BUCKET set, compressed result
+// segments are uploaded to S3-compatible storage. Only the newest
+// ARCHIVE_KEEP_LOCAL segments stay on disk; older ones are recorded in a
+// manifest and streamed back from S3 when a full evaluation needs them.
+
+const archiveManifestFile = "data/results-archive.json"
+
+type archivedSegment struct {
+	Segment    string    `json:"segment"` // base file name, e.g. results-...jsonl.gz
+	Key        string    `json:"key"`
+	Size       int64     `json:"size"`
+	ArchivedAt time.Time `json:"archived_at"`
+	Local      bool      `json:"local"` // still kept on disk
+}
+
+type segmentArchiver struct {
+	mu        sync.Mutex
+	log       *resultLog
+	s3        *S3Client
+	prefix    string
+	keepLocal int
+	manifest  []archivedSegment
+}
+
+// startArchiver wires archival into the results log; it is a no-op unless
+// ARCHIVE_S3_BUCKET is set
+func startArchiver(l *resultLog) {
+	if os.Getenv("ARCHIVE_S3_BUCKET") == "" {
+		return
+	}
+	s3, err := NewS3ClientFromEnv()
+	if err != nil {
+		log.Printf("[ERROR] archive disabled: %v", err)
+		return
+	}
+	a := &segmentArchiver{
+		log:       l,
+		s3:        s3,
+		prefix:    strings.Trim(os.Getenv("ARCHIVE_S3_PREFIX"), "/"),
+		keepLocal: 2,
+	}
+	if v := os.Getenv("ARCHIVE_KEEP_LOCAL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			a.keepLocal = n
+		}
+	}
+	interval := time.Hour
+	if v := os.Getenv("ARCHIVE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	a.loadManifest()
+	l.archive = a
+
+	go func() {
+		for {
+			a.run()
+			time.Sleep(interval)
+		}
+	}()
+	log.Printf("[INFO] archiving result segments to s3://%s/%s every %s", s3.Bucket, a.prefix, interval)
+}
+
+func (a *segmentArchiver) loadManifest() {
+	b, err := os.ReadFile(dataPath(archiveManifestFile))
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(b, &a.manifest); err != nil {
+		log.Printf("[ERROR] invalid %s: %v", archiveManifestFile, err)
+	}
+}
+
+func (a *segmentArchiver) saveManifest() error {
+	b, err := json.MarshalIndent(a.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dataPath(archiveManifestFile), b, 0644)
+}
+
+// run uploads compressed segments not yet archived, then prunes local
+// copies beyond keepLocal
+func (a *segmentArchiver) run() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	known := map[string]bool{}
+	for _, m := range a.manifest {
+		known[m.Segment] = true
+	}
+	for _, seg := range a.log.segments() {
+		name := filepath.Base(seg)
+		if !strings.HasSuffix(name, ".gz") || known[name] {
+			continue
+		}
+		b, err := os.ReadFile(seg)
+		if err != nil {
+			log.Printf("[ERROR] archive read %s: %v", seg, err)
+			continue
+		}
+		key := name
+		if a.prefix != "" {
+			key = a.prefix + "/" + name
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		err = a.s3.PutObject(ctx, key, b)
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] archive upload %s: %v", name, err)
+			continue
+		}
+		a.manifest = append(a.manifest, archivedSegment{
+			Segment: name, Key: key, Size: int64(len(b)), ArchivedAt: time.Now(), Local: true,
+		})
+		if err := a.saveManifest(); err != nil {
+			log.Printf("[ERROR] archive manifest: %v", err)
+			return
+		}
+		log.Printf("[INFO] archived %s (%d bytes)", name, len(b))
+	}
+
+	// Drop the oldest archived local copies
+	sort.Slice(a.manifest, func(i, j int) bool { return a.manifest[i].Segment < a.manifest[j].Segment })
+	local := 0
+	for i := len(a.manifest) - 1; i >= 0; i-- {
+		m := &a.manifest[i]
+		if !m.Local {
+			continue
+		}
+		local++
+		if local <= a.keepLocal {
+			continue
+		}
+		if err := os.Remove(filepath.Join(filepath.Dir(a.log.path), m.Segment)); err != nil && !os.IsNotExist(err) {
+			log.Printf("[ERROR] archive prune %s: %v", m.Segment, err)
+			continue
+		}
+		m.Local = false
+	}
+	if err := a.saveManifest(); err != nil {
+		log.Printf("[ERROR] archive manifest: %v", err)
+	}
+}
+
+// remoteSegments lists archived segments that are no longer on disk
+func (a *segmentArchiver) remoteSegments() []archivedSegment {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []archivedSegment
+	for _, m := range a.manifest {
+		if !m.Local {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// eachRemote streams one archived segment straight from S3
+func (a *segmentArchiver) eachRemote(m archivedSegment, fn func(StoredResult) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	body, err := a.s3.GetObject(ctx, m.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return eachRunInReader(body, true, fn)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ====== Assets ======
+// The shipped defaults in prompt/ (the few-shot examples and the taxonomy)
+// are embedded in the binary, so the service runs from any working
+// directory and in scratch containers. The system prompt, calendar,
+// interpretations and two-stage prompts have their defaults in code; no
+// prompt/system.txt is shipped, so one on disk is always a real override.
+// Prompt files are looked up in shared storage (Postgres), then in
+// PROMPT_DIR (default ./prompt), then among the embedded defaults. Run
+// data (SQLite database, result logs, ground truth, certificates) lives in
+// DATA_DIR (default ./data). In code and in the prompt store, paths keep
+// their "prompt/" and "data/" prefixes; only the location on disk moves.
+
+//go:embed prompt/examples.json prompt/taxonomy.json
+var embeddedAssets embed.FS
+
+// embeddedDefault is a shipped asset; a missing one is a build error
+func embeddedDefault(name string) []byte {
+	b, err := embeddedAssets.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// promptPath is where "prompt/..." is on disk
+func promptPath(name string) string {
+	return underDir(name, "prompt", os.Getenv("PROMPT_DIR"))
+}
+
+// dataPath is where "data/..." is on disk
+func dataPath(name string) string {
+	return underDir(name, "data", os.Getenv("DATA_DIR"))
+}
+
+func underDir(name, prefix, dir string) string {
+	rest, ok := strings.CutPrefix(filepath.ToSlash(name), prefix)
+	if dir == "" || !ok || rest != "" && rest[0] != '/' {
+		return name
+	}
+	return filepath.Join(dir, filepath.FromSlash(rest))
+}
+
+// readPromptFile reads a prompt file from disk, else its embedded default
+func readPromptFile(name string) ([]byte, error) {
+	b, err := os.ReadFile(promptPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		if e, embErr := embeddedAssets.ReadFile(path.Clean(filepath.ToSlash(name))); embErr == nil {
+			return e, nil
+		}
+	}
+	return b, err
+}
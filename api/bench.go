@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====== Benchmark mode ======
+// "hotelparser bench" drives the parse handler in-process, with the full
+// middleware chain but without a network listener, against a synthetic
+// provider that answers instantly (or after -latency). That isolates the
+// service's own cost from LLM latency. The synthetic provider is the stub
+// provider (stub.go) with a script that always answers:
+//
+//	hotelparser bench -c 16 -n 5000 -provider both
+//	hotelparser bench -latency 300ms -queries queries.txt -json
+//
+// The rest of the configuration applies as usual, except that runs are
+// discarded unless -store is given. The synthetic provider lives in the
+// same process, so its allocations are counted too; -upstream points the
+// clients at an external mock instead (then provider time isn't known).
+
+type BenchReport struct {
+	Requests    int            `json:"requests"`
+	Concurrency int            `json:"concurrency"`
+	Provider    string         `json:"provider"`
+	ElapsedMS   int64          `json:"elapsed_ms"`
+	Throughput  float64        `json:"requests_per_second"`
+	Statuses    map[int]int    `json:"statuses"`
+	LatencyMS   BenchLatencies `json:"latency_ms"` // whole handler, provider included
+	// ProviderMS is the mean time spent inside the synthetic provider per
+	// call, OverheadMS the mean latency minus it (providers run in parallel)
+	ProviderMS     *float64 `json:"provider_ms,omitempty"`
+	OverheadMS     *float64 `json:"overhead_ms,omitempty"`
+	AllocsPerReq   float64  `json:"allocs_per_request"`
+	BytesPerReq    float64  `json:"bytes_per_request"`
+	GCRuns         uint32   `json:"gc_runs"`
+	GCPauseTotalMS float64  `json:"gc_pause_total_ms"`
+}
+
+type BenchLatencies struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+var benchQueries = []string{
+	"Hotel in Berlin für 2 Erwachsene vom 12.10. bis 14.10., Frühstück inklusive",
+	"Familienhotel an der Ostsee mit Pool, 2 Erwachsene und 2 Kinder, maximal 150€ pro Nacht",
+	"4 Sterne Wellnesshotel in Tirol mit Sauna und Halbpension",
+	"Günstiges Hotel in Hamburg nahe Hauptbahnhof mit Parkplatz",
+	"Strandhotel auf Mallorca, adults only, all inclusive, Bewertung mindestens 8",
+}
+
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	conc := fs.Int("c", 8, "concurrent requests")
+	n := fs.Int("n", 1000, "requests to send (after warm-up)")
+	warmup := fs.Int("warmup", 50, "requests before measuring")
+	provider := fs.String("provider", "both", "openai, claude or both")
+	latency := fs.Duration("latency", 0, "synthetic provider latency per call")
+	upstream := fs.String("upstream", "", "base URL of an external mock instead of the synthetic provider")
+	queriesFile := fs.String("queries", "", "file with one query per line (default: built-in set)")
+	keepRuns := fs.Bool("store", false, "store runs in the configured backend instead of discarding them")
+	key := fs.String("key", "", "API key to send, when API_KEYS is set")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	verbose := fs.Bool("v", false, "keep the request log")
+	_ = fs.Parse(args)
+
+	queries := benchQueries
+	if *queriesFile != "" {
+		var err error
+		if queries, err = readLines(*queriesFile); err != nil || len(queries) == 0 {
+			log.Printf("[ERROR] reading %s: %v", *queriesFile, err)
+			return 2
+		}
+	}
+	if *conc < 1 || *n < 1 {
+		log.Printf("[ERROR] -c and -n must be positive")
+		return 2
+	}
+
+	synth := newStubProvider(benchScript(*latency))
+	base := *upstream
+	if base == "" {
+		srv := httptest.NewServer(synth)
+		defer srv.Close()
+		base = srv.URL
+	}
+	base = strings.TrimSuffix(base, "/")
+	os.Setenv("OPENAI_API_KEY", "bench")
+	os.Setenv("OPENAI_BASE_URL", base+"/v1")
+	os.Setenv("CLAUDE_API_KEY", "bench")
+	os.Setenv("CLAUDE_BASE_URL", base+"/v1/messages")
+	if _, err := upstreamTransport(); err != nil {
+		log.Printf("[ERROR] %v", err)
+		return 1
+	}
+	buildClients()
+	buildLimiters()
+	if *keepRuns {
+		store = newResultStore()
+	} else {
+		store = discardStore{}
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	handler := newHandler()
+	send := func(i int) (int, time.Duration) {
+		body, _ := json.Marshal(map[string]string{"query_de": queries[i%len(queries)], "provider": *provider})
+		req := httptest.NewRequest(http.MethodPost, "/v1/parse", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		if *key != "" {
+			req.Header.Set("Authorization", "Bearer "+*key)
+		}
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rec, req)
+		return rec.Code, time.Since(start)
+	}
+	for i := 0; i < *warmup; i++ {
+		send(i)
+	}
+
+	synth.reset()
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	lat := make([]time.Duration, *n)
+	codes := make([]int, *n)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < *conc; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= *n {
+					return
+				}
+				codes[i], lat[i] = send(i)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	rep := BenchReport{
+		Requests:       *n,
+		Concurrency:    *conc,
+		Provider:       *provider,
+		ElapsedMS:      elapsed.Milliseconds(),
+		Throughput:     round2(float64(*n) / elapsed.Seconds()),
+		Statuses:       map[int]int{},
+		LatencyMS:      benchLatencies(lat),
+		AllocsPerReq:   round2(float64(after.Mallocs-before.Mallocs) / float64(*n)),
+		BytesPerReq:    round2(float64(after.TotalAlloc-before.TotalAlloc) / float64(*n)),
+		GCRuns:         after.NumGC - before.NumGC,
+		GCPauseTotalMS: round2(float64(after.PauseTotalNs-before.PauseTotalNs) / 1e6),
+	}
+	for _, c := range codes {
+		rep.Statuses[c]++
+	}
+	if *upstream == "" {
+		if mean, ok := synth.meanMS(); ok {
+			overhead := round2(rep.LatencyMS.Mean - mean)
+			rep.ProviderMS, rep.OverheadMS = &mean, &overhead
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(rep)
+	} else {
+		printBench(rep)
+	}
+	if rep.Statuses[http.StatusOK] != *n {
+		return 1
+	}
+	return 0
+}
+
+func benchLatencies(d []time.Duration) BenchLatencies {
+	sorted := append([]time.Duration(nil), d...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	ms := func(d time.Duration) float64 { return round2(float64(d) / float64(time.Millisecond)) }
+	at := func(q float64) time.Duration { return sorted[int(q*float64(len(sorted)-1))] }
+	var sum time.Duration
+	for _, v := range sorted {
+		sum += v
+	}
+	return BenchLatencies{Mean: ms(sum / time.Duration(len(sorted))), P50: ms(at(0.5)), P95: ms(at(0.95)),
+		P99: ms(at(0.99)), Max: ms(sorted[len(sorted)-1])}
+}
+
+func printBench(r BenchReport) {
+	fmt.Printf("requests     %d (concurrency %d, provider %s) in %dms\n", r.Requests, r.Concurrency, r.Provider, r.ElapsedMS)
+	fmt.Printf("throughput   %.2f req/s\n", r.Throughput)
+	codes := make([]int, 0, len(r.Statuses))
+	for c := range r.Statuses {
+		codes = append(codes, c)
+	}
+	sort.Ints(codes)
+	var parts []string
+	for _, c := range codes {
+		parts = append(parts, fmt.Sprintf("%d×%d", r.Statuses[c], c))
+	}
+	fmt.Printf("statuses     %s\n", strings.Join(parts, " "))
+	l := r.LatencyMS
+	fmt.Printf("latency ms   mean %.2f  p50 %.2f  p95 %.2f  p99 %.2f  max %.2f\n", l.Mean, l.P50, l.P95, l.P99, l.Max)
+	if r.ProviderMS != nil {
+		fmt.Printf("provider ms  %.2f per call, handler overhead %.2f per request\n", *r.ProviderMS, *r.OverheadMS)
+	}
+	fmt.Printf("allocations  %.0f allocs, %.0f bytes per request\n", r.AllocsPerReq, r.BytesPerReq)
+	fmt.Printf("gc           %d runs, %.2fms paused\n", r.GCRuns, r.GCPauseTotalMS)
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if l := strings.TrimSpace(sc.Text()); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, sc.Err()
+}
+
+// discardStore drops runs, so storage doesn't show up in the numbers
+type discardStore struct{}
+
+func (discardStore) Append(StoredResult) error           { return nil }
+func (discardStore) Each(func(StoredResult) error) error { return nil }
+func (discardStore) All() ([]StoredResult, error)        { return nil, nil }
+
+// benchScript has the stub provider (stub.go) always answer, after latency
+func benchScript(latency time.Duration) StubScript {
+	script := StubScript{Answer: json.RawMessage(stubAnswer)}
+	if latency > 0 {
+		script.Rules = []StubRule{{Behavior: stubOK, Delay: latency.String(), delay: latency}}
+	}
+	return script
+}
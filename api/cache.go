@@ -0,0 +1,298 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Parse cache ======
+// With PARSE_CACHE_TTL set, a provider's answer is kept for that long and
+// reused for the same query (whitespace-normalized) under the same tenant,
+// prompt hash, model, pipeline, tool mode, taxonomy and sampling, so a
+// prompt, model or taxonomy change never serves stale answers. At most
+// PARSE_CACHE_SIZE answers (default 1000) are kept, least recently used
+// first out. A cached answer costs no tokens, reports "cached": true in its
+// status and is not escalated again. It is stored with its run, but
+// evaluations and the Pareto front skip it: it repeats an answer already
+// scored, with no latency or cost of its own.
+//
+// After a deploy the cache is empty and the first users wait for the LLM.
+// The warm-up parses the most frequent stored queries of every tenant
+// (PARSE_CACHE_WARMUP of them, with the providers they were last asked
+// with) against the current prompt and model, on startup or via
+// POST /v1/admin/warmup?top=<n>. Warm-up calls go through the regular
+// concurrency slots and breakers, but are not stored as runs.
+
+const parseCacheDefaultSize = 1000
+
+type parseCacheEntry struct {
+	key     string
+	call    providerCall
+	expires time.Time
+}
+
+type parseCacheLRU struct {
+	mu    sync.Mutex
+	ll    *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+var parseCache = &parseCacheLRU{ll: list.New(), items: map[string]*list.Element{}}
+
+// parseCacheTTL is how long answers are kept; 0 disables the cache
+func parseCacheTTL() time.Duration {
+	return envDuration("PARSE_CACHE_TTL", 0)
+}
+
+// parseCacheKey identifies an answer; "" when the call can't be cached
+func parseCacheKey(plan parsePlan, provider, model string) string {
+	if plan.selfTest || plan.uncached {
+		return ""
+	}
+	if model == "" {
+		model = activeModel(provider)
+	}
+	sampling, _ := json.Marshal(plan.sampling)
+	// two-stage prompt hashes include the taxonomy already; single-stage
+	// answers are mapped onto it too
+	_, taxHash := currentTaxonomy()
+	h := sha256.New()
+	for _, part := range []string{
+		plan.tenant, provider, model, escalationModel(provider), plan.pipeline, plan.promptHash,
+		strconv.FormatBool(toolsEnabled(provider)), taxHash,
+		string(sampling), strings.Join(strings.Fields(plan.query), " "),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns a copy of the cached call, or nil
+func (c *parseCacheLRU) get(key string) *providerCall {
+	if key == "" || parseCacheTTL() <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*parseCacheEntry)
+	if time.Now().After(e.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil
+	}
+	c.ll.MoveToFront(el)
+	call := e.call
+	call.res = cloneResponse(e.call.res)
+	return &call
+}
+
+// put keeps a successful call; the answer is copied, as the request goes on
+// to post-process it
+func (c *parseCacheLRU) put(key string, call *providerCall) {
+	ttl := parseCacheTTL()
+	if key == "" || ttl <= 0 || call.err != nil || call.res == nil || call.cached {
+		return
+	}
+	e := &parseCacheEntry{key: key, call: *call, expires: time.Now().Add(ttl)}
+	e.call.res = cloneResponse(call.res)
+	e.call.usage, e.call.latency, e.call.timings, e.call.cached = Usage{}, 0, nil, true
+	size := envInt("PARSE_CACHE_SIZE", parseCacheDefaultSize)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(e)
+	for c.ll.Len() > max(size, 1) {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*parseCacheEntry).key)
+	}
+}
+
+// flush drops every cached answer
+func (c *parseCacheLRU) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.ll.Len()
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+	return n
+}
+
+func cloneResponse(p *ParseResponse) *ParseResponse {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil
+	}
+	var out ParseResponse
+	if json.Unmarshal(b, &out) != nil {
+		return nil
+	}
+	return &out
+}
+
+// --- warm-up ---
+
+// warmQuery is a stored query and the providers it was last asked with
+type warmQuery struct {
+	tenant, query string
+	providers     string // "openai", "claude" or "both"
+	count         int
+	last          time.Time
+}
+
+// topQueries are the n most frequent stored queries of every tenant
+func topQueries(n int) ([]warmQuery, error) {
+	byKey := map[[2]string]*warmQuery{}
+	err := store.Each(func(run StoredResult) error {
+		if run.Aborted || run.Shadow != nil || run.Replay != nil {
+			return nil
+		}
+		q := strings.Join(strings.Fields(run.Query), " ")
+		if q == "" {
+			return nil
+		}
+		k := [2]string{run.Tenant, q}
+		w := byKey[k]
+		if w == nil {
+			w = &warmQuery{tenant: run.Tenant, query: q}
+			byKey[k] = w
+		}
+		w.count++
+		if !run.Time.Before(w.last) {
+			w.last = run.Time
+			switch {
+			case run.Response.OpenAI != nil && run.Response.Claude != nil:
+				w.providers = "both"
+			case run.Response.Claude != nil:
+				w.providers = "claude"
+			default:
+				w.providers = "openai"
+			}
+		}
+		return nil
+	})
+	perTenant := map[string][]warmQuery{}
+	for _, w := range byKey {
+		perTenant[w.tenant] = append(perTenant[w.tenant], *w)
+	}
+	var top []warmQuery
+	for _, qs := range perTenant {
+		sort.Slice(qs, func(i, j int) bool {
+			if qs[i].count != qs[j].count {
+				return qs[i].count > qs[j].count
+			}
+			return qs[i].last.After(qs[j].last)
+		})
+		top = append(top, qs[:min(len(qs), n)]...)
+	}
+	return top, err
+}
+
+var (
+	warming          sync.Mutex
+	errWarmupRunning = errors.New("a warm-up is already running")
+)
+
+// warmParseCache parses the top n queries of every tenant into the cache;
+// it returns how many answers were cached
+func warmParseCache(ctx context.Context, n int) (int, error) {
+	if !warming.TryLock() {
+		return 0, errWarmupRunning
+	}
+	defer warming.Unlock()
+	queries, err := topQueries(n)
+	if err != nil {
+		return 0, err
+	}
+	start, cached := time.Now(), 0
+	ctx = withLane(ctx, laneBatch)
+	for _, w := range queries {
+		if ctx.Err() != nil {
+			break
+		}
+		input := parseInput{Query: w.query, Provider: w.providers, tenant: w.tenant}
+		variant := pickVariant(loadVariants())
+		plan := newParsePlan(input, variant)
+		reqCtx, cancel := context.WithTimeout(ctx, requestBudget())
+		calls, _ := callProviders(reqCtx, reqCtx, plan, providersFor(input.Provider), variant)
+		cancel()
+		for _, c := range calls {
+			if c.err == nil && !c.cached {
+				cached++
+			}
+		}
+	}
+	log.Printf("[INFO] parse cache warm-up: %d answers for %d queries in %s", cached, len(queries), time.Since(start).Round(time.Millisecond))
+	return cached, nil
+}
+
+// startWarmup warms the cache in the background on startup
+func startWarmup() {
+	n := envInt("PARSE_CACHE_WARMUP", 0)
+	if n <= 0 || parseCacheTTL() <= 0 {
+		return
+	}
+	go func() {
+		if _, err := warmParseCache(context.Background(), n); err != nil {
+			log.Printf("[ERROR] parse cache warm-up: %v", err)
+		}
+	}()
+}
+
+// adminWarmupHandler: POST starts a warm-up of the top ?top= queries
+// (default PARSE_CACHE_WARMUP, else 20) and answers 202 right away
+func adminWarmupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST"))
+		return
+	}
+	if parseCacheTTL() <= 0 {
+		writeProblem(w, newProblem(http.StatusConflict, codeInvalidInput, "the parse cache is off (set PARSE_CACHE_TTL)"))
+		return
+	}
+	n := envInt("PARSE_CACHE_WARMUP", 0)
+	if n <= 0 {
+		n = 20
+	}
+	if s := r.URL.Query().Get("top"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid top "+strconv.Quote(s)))
+			return
+		}
+		n = v
+	}
+	if !warming.TryLock() {
+		writeProblem(w, newProblem(http.StatusConflict, codeInvalidInput, errWarmupRunning.Error()))
+		return
+	}
+	warming.Unlock()
+	go func() {
+		if _, err := warmParseCache(context.Background(), n); err != nil {
+			log.Printf("[ERROR] parse cache warm-up: %v", err)
+		}
+	}()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]any{"status": "started", "top": n})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Event-anchored dates ======
+// "während des Oktoberfests" or "zur IAA" fixes the dates and usually the
+// place without naming either. The event calendar lists such events with
+// their synonyms, location (none for "Karneval", which is celebrated in
+// many places) and dates per year; it is prompt/calendar.json (reloaded
+// like the prompts) or the built-in default, and needs a new year's dates
+// once they are announced. After parsing, an answer whose
+// query names an event gets the event's dates (stay from the first day to
+// the day after the last, or from the first day for the stated nights)
+// and its location, but only where the answer has none: "vom 1. bis
+// 3.10. zur Wiesn" keeps the stated dates. The event is echoed in "event"
+// either way, and unsupported_criteria entries naming it are dropped. A
+// year in the query picks that year's dates, otherwise the next ones that
+// haven't ended.
+
+const calendarFile = "prompt/calendar.json"
+
+// CalendarEvent is one event of the calendar
+type CalendarEvent struct {
+	Name     string      `json:"name"`
+	Synonyms []string    `json:"synonyms"`
+	Location string      `json:"location"`
+	Dates    []EventDays `json:"dates"`
+}
+
+// EventDays are the first and last day of one occurrence
+type EventDays struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// EventMatch is the event a query was anchored to
+type EventMatch struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+const defaultCalendar = `[
+  {"name": "Oktoberfest", "synonyms": ["Oktoberfest", "Oktoberfests", "Wiesn"], "location": "München",
+   "dates": [{"from": "2025-09-20", "to": "2025-10-05"}, {"from": "2026-09-19", "to": "2026-10-04"}, {"from": "2027-09-18", "to": "2027-10-03"}]},
+  {"name": "Kölner Karneval", "synonyms": ["Karneval in Köln", "Kölner Karneval", "Kölner Karnevals", "Karneval Köln"], "location": "Köln",
+   "dates": [{"from": "2026-02-12", "to": "2026-02-17"}, {"from": "2027-02-04", "to": "2027-02-09"}]},
+  {"name": "Karneval", "synonyms": ["Karneval", "Karnevals", "Fasching", "Faschings", "Fastnacht"], "location": "",
+   "dates": [{"from": "2026-02-12", "to": "2026-02-17"}, {"from": "2027-02-04", "to": "2027-02-09"}]},
+  {"name": "IAA Mobility", "synonyms": ["IAA", "IAA Mobility"], "location": "München",
+   "dates": [{"from": "2025-09-09", "to": "2025-09-14"}]},
+  {"name": "ITB Berlin", "synonyms": ["ITB", "ITB Berlin"], "location": "Berlin",
+   "dates": [{"from": "2026-03-03", "to": "2026-03-05"}]},
+  {"name": "Frankfurter Buchmesse", "synonyms": ["Buchmesse", "Frankfurter Buchmesse"], "location": "Frankfurt am Main",
+   "dates": [{"from": "2025-10-15", "to": "2025-10-19"}, {"from": "2026-10-07", "to": "2026-10-11"}]},
+  {"name": "Kieler Woche", "synonyms": ["Kieler Woche"], "location": "Kiel",
+   "dates": [{"from": "2026-06-20", "to": "2026-06-28"}]}
+]`
+
+var calendarMemo struct {
+	sync.Mutex
+	hash   string
+	events []CalendarEvent
+}
+
+// currentCalendar returns the event calendar
+func currentCalendar() []CalendarEvent {
+	p := cachedPrompt(calendarFile, func() string {
+		if b, err := readPrompt(calendarFile); err == nil {
+			return string(b)
+		}
+		return defaultCalendar
+	})
+	calendarMemo.Lock()
+	defer calendarMemo.Unlock()
+	if calendarMemo.hash != p.Hash {
+		var events []CalendarEvent
+		if err := json.Unmarshal([]byte(p.Text), &events); err != nil {
+			log.Printf("[ERROR] invalid %s, using the built-in calendar: %v", calendarFile, err)
+			_ = json.Unmarshal([]byte(defaultCalendar), &events)
+		}
+		calendarMemo.hash, calendarMemo.events = p.Hash, events
+	}
+	return calendarMemo.events
+}
+
+// matchEvent finds the event query names, preferring the longest synonym
+// ("Kölner Karneval" over "Karneval"), and its occurrence
+func matchEvent(events []CalendarEvent, query string, now time.Time) (*EventMatch, []string) {
+	q := " " + normalizePhrase(query) + " "
+	var best *CalendarEvent
+	var bestSyn string
+	for i, ev := range events {
+		for _, syn := range ev.Synonyms {
+			if n := normalizePhrase(syn); n != "" && strings.Contains(q, " "+n+" ") && len(n) > len(bestSyn) {
+				best, bestSyn = &events[i], n
+			}
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	days, ok := best.occurrence(q, now)
+	if !ok {
+		return nil, nil
+	}
+	return &EventMatch{Name: best.Name, Location: best.Location, From: days.From, To: days.To}, best.Synonyms
+}
+
+// occurrence is the one in a year the query names, else the next one that
+// hasn't ended
+func (ev CalendarEvent) occurrence(q string, now time.Time) (EventDays, bool) {
+	today := now.Format(time.DateOnly)
+	var next *EventDays
+	for i, d := range ev.Dates {
+		if year, _, _ := strings.Cut(d.From, "-"); strings.Contains(q, " "+year+" ") {
+			return d, true
+		}
+		if d.To >= today && (next == nil || d.From < next.From) {
+			next = &ev.Dates[i]
+		}
+	}
+	if next == nil {
+		return EventDays{}, false
+	}
+	return *next, true
+}
+
+// anchorEvent applies the event query names, if any, to p
+func (p *ParseResponse) anchorEvent(events []CalendarEvent, query string, now time.Time) {
+	ev, synonyms := matchEvent(events, query, now)
+	if ev == nil {
+		return
+	}
+	p.Event = ev
+	if p.Dates.Checkin == "" && p.Dates.Checkout == "" {
+		p.Dates.Checkin = ev.From
+		if p.Nights == 0 {
+			if to, err := time.Parse(time.DateOnly, ev.To); err == nil {
+				p.Dates.Checkout = to.AddDate(0, 0, 1).Format(time.DateOnly)
+			}
+		}
+		p.deriveDates()
+	}
+	if p.Location == "" && ev.Location != "" {
+		p.Location = ev.Location
+	}
+	kept := p.UnsupportedCriteria[:0]
+	for _, c := range p.UnsupportedCriteria {
+		if !namesEvent(c, synonyms) {
+			kept = append(kept, c)
+		}
+	}
+	p.UnsupportedCriteria = kept
+}
+
+// namesEvent is whether phrase contains one of the synonyms
+func namesEvent(phrase string, synonyms []string) bool {
+	c := " " + normalizePhrase(phrase) + " "
+	for _, syn := range synonyms {
+		if n := normalizePhrase(syn); n != "" && strings.Contains(c, " "+n+" ") {
+			return true
+		}
+	}
+	return false
+}
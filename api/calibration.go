@@ -0,0 +1,291 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ====== Calibrated confidence ======
+// With CONFIDENCE_CALIBRATION=1 every answer gets
+// status.<provider>.calibrated_confidence: the probability that it matches
+// the ground truth exactly (or an acceptable interpretation of an ambiguous
+// query), from a logistic regression over what is known when the answer
+// comes in:
+//
+//	agreement     slot Jaccard with the other provider's answer, if any
+//	unsupported   unsupported_criteria in the answer (capped at 5)
+//	logprobs      the lowest ui_filters slot confidence, if the provider
+//	              reported logprobs (OPENAI_LOGPROBS=1)
+//
+// The model is trained on the stored live runs whose query has ground
+// truth, on startup and every CONFIDENCE_CALIBRATION_INTERVAL (default 1h),
+// and needs at least calibrationMinSamples answers. One query in five (by
+// hash, so repeats of a query stay on one side) is held out; GET
+// /v1/admin/calibration shows the weights and, on the held-out answers, the
+// Brier score next to that of always predicting the base rate, and a
+// reliability diagram. POST retrains right away.
+
+const (
+	calibrationMinSamples = 100
+	calibrationEpochs     = 2000
+	calibrationRate       = 0.5
+	calibrationL2         = 1e-3
+)
+
+// the features, in order; bias first
+var calibrationFeatures = []string{"bias", "has_other", "agreement", "unsupported", "has_logprobs", "min_logprob_confidence"}
+
+// CalibrationModel is a trained model and how well it does
+type CalibrationModel struct {
+	Weights   map[string]float64 `json:"weights"`
+	Trained   time.Time          `json:"trained"`
+	Samples   int                `json:"samples"` // training answers
+	BaseRate  float64            `json:"base_rate"`
+	Holdout   int                `json:"holdout"`
+	Brier     float64            `json:"brier"`      // on the held-out answers
+	BaseBrier float64            `json:"base_brier"` // predicting the base rate
+	ECE       float64            `json:"ece"`
+	Bins      []CalibrationBin   `json:"bins"`
+	w         []float64
+}
+
+var calibration struct {
+	sync.RWMutex
+	model *CalibrationModel
+	err   string // why there is no model
+}
+
+func calibrationEnabled() bool {
+	return os.Getenv("CONFIDENCE_CALIBRATION") == "1"
+}
+
+// calibrationInput is the feature vector of one provider's answer in resp
+func calibrationInput(resp MultiParseResponse, provider string) []float64 {
+	answers := map[string]*ParseResponse{"openai": resp.OpenAI, "claude": resp.Claude}
+	own := answers[provider]
+	x := make([]float64, len(calibrationFeatures))
+	x[0] = 1
+	for name, other := range answers {
+		if name != provider && other != nil {
+			x[1], x[2] = 1, scoreAgainstGT(*own, *other).Jaccard
+		}
+	}
+	x[3] = float64(min(len(own.UnsupportedCriteria), 5)) / 5
+	if st := resp.Status[provider]; st != nil && len(st.Confidence) > 0 {
+		lowest := 1.0
+		for _, sc := range st.Confidence {
+			lowest = min(lowest, sc.Slot)
+		}
+		x[4], x[5] = 1, lowest
+	}
+	return x
+}
+
+func sigmoid(z float64) float64 { return 1 / (1 + math.Exp(-z)) }
+
+func (m *CalibrationModel) predict(x []float64) float64 {
+	z := 0.0
+	for i, v := range x {
+		z += m.w[i] * v
+	}
+	return sigmoid(z)
+}
+
+type calibrationSample struct {
+	x       []float64
+	correct bool
+}
+
+// calibrationSamples are the stored live answers with ground truth, split
+// into training and held-out ones
+func calibrationSamples() (train, holdout []calibrationSample, err error) {
+	truth := map[string]map[string]GroundTruthItem{}
+	err = store.Each(func(run StoredResult) error {
+		if run.Shadow != nil || run.Replay != nil || run.Aborted || run.Assisted {
+			return nil
+		}
+		gt, ok := truth[run.Tenant]
+		if !ok {
+			gt = map[string]GroundTruthItem{}
+			for _, item := range loadGroundTruth(run.Tenant) {
+				gt[item.Query] = item
+			}
+			truth[run.Tenant] = gt
+		}
+		item, ok := gt[run.Query]
+		if !ok {
+			return nil
+		}
+		h := fnv.New32a()
+		h.Write([]byte(run.Query))
+		held := h.Sum32()%5 == 0
+		for provider, pred := range map[string]*ParseResponse{"openai": run.Response.OpenAI, "claude": run.Response.Claude} {
+			if pred == nil {
+				continue
+			}
+			s := calibrationSample{x: calibrationInput(run.Response, provider),
+				correct: scoreAgainstGT(*pred, item.Truth).ExactMatch ||
+					item.Ambiguous && matchesAnyAcceptable(*pred, item.AcceptableInterpretation)}
+			if held {
+				holdout = append(holdout, s)
+			} else {
+				train = append(train, s)
+			}
+		}
+		return nil
+	})
+	return train, holdout, err
+}
+
+// fitCalibration is batch gradient descent on the L2-regularized log loss
+func fitCalibration(samples []calibrationSample) []float64 {
+	w := make([]float64, len(calibrationFeatures))
+	grad := make([]float64, len(w))
+	n := float64(len(samples))
+	for range calibrationEpochs {
+		clear(grad)
+		for _, s := range samples {
+			z := 0.0
+			for i, v := range s.x {
+				z += w[i] * v
+			}
+			diff := sigmoid(z)
+			if s.correct {
+				diff--
+			}
+			for i, v := range s.x {
+				grad[i] += diff * v
+			}
+		}
+		for i := range w {
+			reg := calibrationL2 * w[i]
+			if i == 0 {
+				reg = 0 // the bias isn't shrunk
+			}
+			w[i] -= calibrationRate * (grad[i]/n + reg)
+		}
+	}
+	return w
+}
+
+// trainCalibration replaces the model with one trained on the current runs
+func trainCalibration() error {
+	train, holdout, err := calibrationSamples()
+	if err != nil {
+		return err
+	}
+	if len(train) < calibrationMinSamples || len(holdout) == 0 {
+		calibration.Lock()
+		calibration.model = nil
+		calibration.err = "not enough answers with ground truth to train on"
+		calibration.Unlock()
+		log.Printf("[INFO] calibration: %d training and %d held-out answers, need %d and 1", len(train), len(holdout), calibrationMinSamples)
+		return nil
+	}
+	m := &CalibrationModel{w: fitCalibration(train), Trained: time.Now().UTC(), Samples: len(train), Holdout: len(holdout),
+		Weights: map[string]float64{}}
+	for i, name := range calibrationFeatures {
+		m.Weights[name] = math.Round(m.w[i]*1000) / 1000
+	}
+	correct := 0
+	for _, s := range train {
+		if s.correct {
+			correct++
+		}
+	}
+	base := safeDiv(correct, len(train))
+	var acc calibAcc
+	brier, baseBrier := 0.0, 0.0
+	for _, s := range holdout {
+		p, y := m.predict(s.x), 0.0
+		if s.correct {
+			y = 1
+		}
+		brier += (p - y) * (p - y)
+		baseBrier += (base - y) * (base - y)
+		b := min(int(p*10), 9)
+		acc.n[b]++
+		acc.sumConf[b] += p
+		if s.correct {
+			acc.correct[b]++
+		}
+	}
+	rep := acc.report()
+	m.BaseRate = round2(base)
+	m.Brier, m.BaseBrier = math.Round(brier/float64(len(holdout))*1000)/1000, math.Round(baseBrier/float64(len(holdout))*1000)/1000
+	m.ECE, m.Bins = rep.ECE, rep.Bins
+
+	calibration.Lock()
+	calibration.model, calibration.err = m, ""
+	calibration.Unlock()
+	log.Printf("[INFO] calibration trained on %d answers: brier %.3f on %d held out (base rate %.3f)", m.Samples, m.Brier, m.Holdout, m.BaseBrier)
+	return nil
+}
+
+// calibrate sets the calibrated confidence of each answer in r
+func (r *MultiParseResponse) calibrate() {
+	if !calibrationEnabled() {
+		return
+	}
+	calibration.RLock()
+	m := calibration.model
+	calibration.RUnlock()
+	if m == nil {
+		return
+	}
+	for provider, st := range r.Status {
+		if (provider == "openai" && r.OpenAI != nil) || (provider == "claude" && r.Claude != nil) {
+			p := round2(m.predict(calibrationInput(*r, provider)))
+			st.CalibratedConfidence = &p
+		}
+	}
+}
+
+// startCalibration trains the model now and every interval while
+// CONFIDENCE_CALIBRATION is on
+func startCalibration() {
+	every := envDuration("CONFIDENCE_CALIBRATION_INTERVAL", time.Hour)
+	go func() {
+		for {
+			if calibrationEnabled() {
+				if err := trainCalibration(); err != nil {
+					log.Printf("[ERROR] calibration: %v", err)
+				}
+			}
+			time.Sleep(every)
+		}
+	}()
+}
+
+// calibrationHandler: GET shows the model, POST retrains it
+func calibrationHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := trainCalibration(); err != nil {
+			log.Printf("[ERROR] calibration: %v", err)
+			writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not train the calibration model"))
+			return
+		}
+	default:
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET or POST"))
+		return
+	}
+	calibration.RLock()
+	defer calibration.RUnlock()
+	out := map[string]any{"enabled": calibrationEnabled(), "features": calibrationFeatures}
+	if calibration.model != nil {
+		out["model"] = calibration.model
+	} else {
+		out["model"] = nil
+		if calibration.err != "" {
+			out["detail"] = calibration.err
+		}
+	}
+	writeAdminJSON(w, out)
+}
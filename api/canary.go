@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+)
+
+// ====== Canary traffic splitting ======
+// prompt/variants.json lists weighted prompt/model variants, e.g.
+//
+//	[{"name":"v4","weight":90,"prompt":"prompt/system.txt"},
+//	 {"name":"v5","weight":10,"prompt":"prompt/system_v5.txt","models":{"openai":"gpt-5"}}]
+//
+// Each request is assigned one variant; the assignment is stored with the run.
+
+type Variant struct {
+	Name   string            `json:"name"`
+	Weight int               `json:"weight"`
+	Prompt string            `json:"prompt,omitempty"` // system prompt path, default prompt/system.txt
+	Models map[string]string `json:"models,omitempty"` // provider -> model override
+	// Pipeline overrides PARSE_PIPELINE ("single" or "two_stage")
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+func variantsFile() string {
+	if p := os.Getenv("PROMPT_VARIANTS_FILE"); p != "" {
+		return p
+	}
+	return promptPath("prompt/variants.json")
+}
+
+var variantsMemo struct {
+	sync.Mutex
+	hash     string
+	variants []Variant
+}
+
+// loadVariants returns nil when no variants are configured. The file is
+// cached and reloaded like the prompts; it is only parsed again when it
+// changed.
+func loadVariants() []Variant {
+	path := variantsFile()
+	p := cachedPrompt(path, func() string {
+		b, _ := os.ReadFile(path)
+		return string(b)
+	})
+	variantsMemo.Lock()
+	defer variantsMemo.Unlock()
+	if variantsMemo.hash != p.Hash {
+		variantsMemo.hash, variantsMemo.variants = p.Hash, parseVariants(path, p.Text)
+	}
+	return variantsMemo.variants
+}
+
+// parseVariants keeps the valid variants of a variants file
+func parseVariants(path, text string) []Variant {
+	if text == "" {
+		return nil
+	}
+	var vs []Variant
+	if err := json.Unmarshal([]byte(text), &vs); err != nil {
+		log.Printf("[ERROR] invalid %s: %v", path, err)
+		return nil
+	}
+	out := vs[:0]
+	for _, v := range vs {
+		if v.Name == "" || v.Weight <= 0 {
+			log.Printf("[WARN] skipping variant %q with weight %d", v.Name, v.Weight)
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// pickVariant chooses a variant proportionally to its weight
+func pickVariant(vs []Variant) *Variant {
+	total := 0
+	for _, v := range vs {
+		total += v.Weight
+	}
+	if total == 0 {
+		return nil
+	}
+	n := rand.Intn(total)
+	for i := range vs {
+		if n < vs[i].Weight {
+			return &vs[i]
+		}
+		n -= vs[i].Weight
+	}
+	return nil
+}
+
+func (v *Variant) promptPath() string {
+	if v == nil || v.Prompt == "" {
+		return "prompt/system.txt"
+	}
+	return v.Prompt
+}
+
+func (v *Variant) model(provider string) string {
+	if v == nil {
+		return ""
+	}
+	return v.Models[provider]
+}
+
+func (v *Variant) name() string {
+	if v == nil {
+		return ""
+	}
+	return v.Name
+}
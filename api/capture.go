@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Raw capture ======
+// Extraction keeps only the decoded answer, so a model output that failed
+// to parse (or parsed into something odd) can't be looked at afterwards.
+// With CAPTURE_RAW set, the upstream calls of a run are recorded as sent
+// and received: URL, headers, request body, status and response body, for
+// every provider call including retries, escalations and both stages of
+// the two-stage pipeline. Secrets are redacted: authorization and API key
+// headers, Proxy-* headers and key/token query parameters. Modes:
+//
+//	errors  only runs where a provider failed (default when set to 1)
+//	all     every run
+//
+// GET /v1/results/{id}/raw returns the capture, to the admin token only:
+// prompts and upstream bodies are operator data. It is stored with the run
+// ("raw", left out of searches and evaluations); requests that failed
+// completely store no run, so their capture (id from the problem's run_id)
+// is kept in memory, for the last 200 of them on this replica.
+
+const (
+	captureErrors = "errors"
+	captureAll    = "all"
+
+	captureMemory = 200
+)
+
+// RawCall is one upstream HTTP exchange
+type RawCall struct {
+	Provider  string            `json:"provider"`
+	Time      time.Time         `json:"time"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	Request   string            `json:"request"`
+	Status    int               `json:"status,omitempty"`
+	Response  string            `json:"response,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	LatencyMS int64             `json:"latency_ms"`
+}
+
+// captureMode is "", errors or all
+func captureMode() string {
+	switch v := strings.ToLower(os.Getenv("CAPTURE_RAW")); v {
+	case "", "0", "false", "off":
+		return ""
+	case captureAll:
+		return captureAll
+	default:
+		return captureErrors
+	}
+}
+
+// rawCapture collects the calls of one run
+type rawCapture struct {
+	mu    sync.Mutex
+	calls []RawCall
+}
+
+type captureKey struct{}
+
+type captureScope struct {
+	rec      *rawCapture
+	provider string
+}
+
+// withCapture records the upstream calls made with ctx into rec
+func withCapture(ctx context.Context, rec *rawCapture) context.Context {
+	if rec == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, captureKey{}, captureScope{rec: rec})
+}
+
+// withCaptureProvider labels the calls made with ctx
+func withCaptureProvider(ctx context.Context, provider string) context.Context {
+	s, ok := ctx.Value(captureKey{}).(captureScope)
+	if !ok {
+		return ctx
+	}
+	s.provider = provider
+	return context.WithValue(ctx, captureKey{}, s)
+}
+
+// keep is the capture to store for a run, or nil
+func (c *rawCapture) keep(results MultiParseResponse) []RawCall {
+	if c == nil {
+		return nil
+	}
+	failed := results.Degraded
+	for _, s := range results.Status {
+		failed = failed || s.Status == "error"
+	}
+	if !failed && captureMode() != captureAll {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]RawCall(nil), c.calls...)
+}
+
+// captureTransport records the exchanges of requests that carry a capture
+type captureTransport struct {
+	base http.RoundTripper
+}
+
+func (t captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s, ok := req.Context().Value(captureKey{}).(captureScope)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	call := RawCall{Provider: s.provider, Time: time.Now(), Method: req.Method, URL: redactURL(req), Headers: redactHeaders(req.Header)}
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		call.Request = string(b)
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		call.Error = err.Error()
+	} else {
+		b, readErr := io.ReadAll(res.Body)
+		res.Body.Close()
+		call.Status, call.Response = res.StatusCode, string(b)
+		if readErr != nil {
+			call.Error = readErr.Error()
+		}
+		res.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	call.LatencyMS = time.Since(call.Time).Milliseconds()
+	s.rec.mu.Lock()
+	s.rec.calls = append(s.rec.calls, call)
+	s.rec.mu.Unlock()
+	return res, err
+}
+
+// secretHeader is whether a header carries credentials
+func secretHeader(name string) bool {
+	n := strings.ToLower(name)
+	if strings.HasPrefix(n, "proxy-") {
+		return true
+	}
+	for _, marker := range []string{"authorization", "key", "token", "secret", "cookie"} {
+		if strings.Contains(n, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		if secretHeader(name) {
+			out[name] = "****"
+		} else {
+			out[name] = strings.Join(values, ", ")
+		}
+	}
+	return out
+}
+
+func redactURL(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	q := u.Query()
+	for name := range q {
+		if secretHeader(name) {
+			q.Set(name, "****")
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// --- failed requests ---
+
+type failedCapture struct {
+	runID, tenant string
+	calls         []RawCall
+}
+
+var failedCaptures struct {
+	sync.Mutex
+	ring []failedCapture
+}
+
+// keepFailed holds the capture of a request that stored no run
+func (c *rawCapture) keepFailed(runID, tenant string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	calls := append([]RawCall(nil), c.calls...)
+	c.mu.Unlock()
+	failedCaptures.Lock()
+	defer failedCaptures.Unlock()
+	failedCaptures.ring = append(failedCaptures.ring, failedCapture{runID: runID, tenant: tenant, calls: calls})
+	if n := len(failedCaptures.ring); n > captureMemory {
+		failedCaptures.ring = failedCaptures.ring[n-captureMemory:]
+	}
+}
+
+func findFailedCapture(runID, tenant string) []RawCall {
+	failedCaptures.Lock()
+	defer failedCaptures.Unlock()
+	for _, f := range failedCaptures.ring {
+		if f.runID == runID && f.tenant == tenant {
+			return f.calls
+		}
+	}
+	return nil
+}
+
+// RawResponse is what /v1/results/{id}/raw returns
+type RawResponse struct {
+	RunID string    `json:"run_id"`
+	Query string    `json:"query,omitempty"`
+	Calls []RawCall `json:"calls"`
+}
+
+func resultRawHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	id, tenant := r.PathValue("id"), tenantOf(r.Context())
+	run, err := findRun(id)
+	if err != nil {
+		log.Printf("[ERROR] loading run %s: %v", id, err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not load run"))
+		return
+	}
+	resp := RawResponse{RunID: id}
+	if run != nil && run.Tenant == tenant {
+		resp.Query, resp.Calls = run.Query, run.Raw
+	} else {
+		resp.Calls = findFailedCapture(id, tenant)
+	}
+	if len(resp.Calls) == 0 {
+		writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "no raw capture for run "+id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
@@ -2,27 +2,36 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
-	"time"
+	"strings"
 )
 
 type ClaudeClient struct {
 	BaseURL string
 	APIKey  string
 	Model   string
-	Client  *http.Client
+	// MaxTokens caps the answer (CLAUDE_MAX_TOKENS); an answer that stopped
+	// at the limit is retried once with RetryMaxTokens (CLAUDE_MAX_TOKENS_RETRY)
+	MaxTokens      int
+	RetryMaxTokens int
+	Sampling       Sampling          // temperature/top_p (Claude has no seed)
+	Tool           *parseTool        // answer through this tool (tool-calling mode)
+	Headers        map[string]string // extra headers, e.g. for an egress proxy
+	Client         *http.Client
 }
 
 func NewClaudeClient() (*ClaudeClient, error) {
 	key := os.Getenv("CLAUDE_API_KEY")
 	if key == "" {
-		return nil, errors.New("CLAUDE_API_KEY missing")
+		return nil, fmt.Errorf("%w: CLAUDE_API_KEY missing", errNotConfigured)
 	}
 	base := os.Getenv("CLAUDE_BASE_URL")
 	if base == "" {
@@ -32,65 +41,139 @@ func NewClaudeClient() (*ClaudeClient, error) {
 	if model == "" {
 		model = "claude-sonnet-4-20250514"
 	}
+	headers, err := extraHeaders("claude")
+	if err != nil {
+		return nil, err
+	}
+	client, err := upstreamClient("claude")
+	if err != nil {
+		return nil, err
+	}
+	maxTokens := envInt("CLAUDE_MAX_TOKENS", 2048)
+	sampling := envSampling("CLAUDE")
+	sampling.Seed = nil // the Messages API has no seed parameter
 	return &ClaudeClient{
-		BaseURL: base,
-		APIKey:  key,
-		Model:   model,
-		Client:  &http.Client{Timeout: 60 * time.Second},
+		BaseURL:        base,
+		APIKey:         key,
+		Model:          model,
+		MaxTokens:      maxTokens,
+		RetryMaxTokens: envInt("CLAUDE_MAX_TOKENS_RETRY", 2*maxTokens),
+		Sampling:       sampling,
+		Headers:        headers,
+		Client:         client,
 	}, nil
 }
 
 // Request/response types
 type claudeReq struct {
-	Model     string      `json:"model"`
-	MaxTokens int         `json:"max_tokens"`
-	System    string      `json:"system,omitempty"`
-	Messages  []claudeMsg `json:"messages"`
+	Model       string       `json:"model"`
+	MaxTokens   int          `json:"max_tokens"`
+	Temperature *float64     `json:"temperature,omitempty"`
+	TopP        *float64     `json:"top_p,omitempty"`
+	System      string       `json:"system,omitempty"`
+	Messages    []claudeMsg  `json:"messages"`
+	Tools       []claudeTool `json:"tools,omitempty"`
+	ToolChoice  any          `json:"tool_choice,omitempty"`
+}
+type claudeTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
 }
 type claudeMsg struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 type claudeResp struct {
+	Model   string `json:"model"`
 	Content []struct {
-		Text string `json:"text"`
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		Name  string          `json:"name"`  // tool_use
+		Input json.RawMessage `json:"input"` // tool_use
 	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+// CompleteJSON implements LLMClient. It retries once with RetryMaxTokens
+// when the first answer stopped at the token limit (stop_reason "max_tokens").
+func (c *ClaudeClient) CompleteJSON(ctx context.Context, systemPrompt, user string) (Completion, error) {
+	out, err := c.complete(ctx, systemPrompt, user, c.MaxTokens)
+	if err != nil || !out.Truncated || c.RetryMaxTokens <= c.MaxTokens {
+		return out, err
+	}
+	log.Printf("[WARN] Claude output truncated at %d tokens, retrying with %d", c.MaxTokens, c.RetryMaxTokens)
+	retry, err := c.complete(ctx, systemPrompt, user, c.RetryMaxTokens)
+	retry.Usage.add(out.Usage)
+	return retry, err
 }
 
-// Implements LLMClient
-func (c *ClaudeClient) CompleteJSON(ctx context.Context, systemPrompt, user string) (string, error) {
+func (c *ClaudeClient) complete(ctx context.Context, systemPrompt, user string, maxTokens int) (Completion, error) {
 	payload := claudeReq{
-		Model:     c.Model,
-		MaxTokens: 1000,
-		System:    systemPrompt, // ✅ Anthropic expects system prompt here
+		Model:       c.Model,
+		MaxTokens:   maxTokens,
+		Temperature: c.Sampling.Temperature,
+		TopP:        c.Sampling.TopP,
+		System:      systemPrompt, // ✅ Anthropic expects system prompt here
 		Messages: []claudeMsg{
 			{Role: "user", Content: user},
 		},
 	}
+	if t := c.Tool; t != nil {
+		payload.Tools = []claudeTool{{Name: t.Name, Description: t.Description, InputSchema: t.Schema}}
+		payload.ToolChoice = map[string]string{"type": "tool", "name": t.Name}
+	}
 
 	b, _ := json.Marshal(payload)
 	req, _ := http.NewRequestWithContext(ctx, "POST", c.BaseURL, bytes.NewReader(b))
 	req.Header.Set("x-api-key", c.APIKey)
 	req.Header.Set("content-type", "application/json")
 	req.Header.Set("anthropic-version", "2023-06-01")
+	setHeaders(req, c.Headers)
 
 	res, err := c.Client.Do(req)
 	if err != nil {
-		return "", err
+		return Completion{}, err
 	}
 	defer res.Body.Close()
 
 	body, _ := io.ReadAll(res.Body)
 	if res.StatusCode >= 300 {
-		return "", fmt.Errorf("claude: %s", body)
+		return Completion{}, &upstreamError{Provider: "claude", Status: res.StatusCode, Body: string(body)}
 	}
 
 	var out claudeResp
 	if err := json.Unmarshal(body, &out); err != nil {
-		return "", err
+		return Completion{}, err
 	}
-	if len(out.Content) == 0 {
-		return "", errors.New("no content")
+	usage := Usage{PromptTokens: out.Usage.InputTokens, CompletionTokens: out.Usage.OutputTokens}
+	repro := Repro{Model: cmp.Or(out.Model, c.Model), Sampling: c.Sampling}
+	if c.Tool != nil {
+		repro.ToolSchema = c.Tool.Hash
 	}
-	return out.Content[0].Text, nil
+	var text strings.Builder
+	for _, block := range out.Content {
+		switch {
+		case block.Type == "tool_use" && c.Tool != nil && block.Name == c.Tool.Name:
+			text.Reset()
+			text.Write(block.Input)
+		case block.Type == "" || block.Type == "text":
+			if c.Tool == nil {
+				text.WriteString(block.Text)
+			}
+		}
+	}
+	if text.Len() == 0 {
+		return Completion{Usage: usage, Truncated: out.StopReason == "max_tokens", Repro: repro}, errors.New("no content")
+	}
+	return Completion{
+		Text:      text.String(),
+		Usage:     usage,
+		Truncated: out.StopReason == "max_tokens",
+		Repro:     repro,
+	}, nil
 }
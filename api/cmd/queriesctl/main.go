@@ -0,0 +1,664 @@
+// queriesctl is a small CLI around the parser API for scripting and CI.
+//
+//	queriesctl parse -provider both "Hotel in Berlin mit Frühstück"
+//	queriesctl batch -provider openai -in queries.txt -out runs.jsonl
+//	queriesctl eval -per-query > snapshot.json
+//	queriesctl diff -fail-on-drop 0.02 before.json after.json
+//	queriesctl results
+//	queriesctl gt list | add -f item.json | rm -query "..." | promote -query "..." -provider openai
+//
+// By default every command talks to the HTTP API (-api). With -direct,
+// results and ground truth are read/written in the data directory instead,
+// so no server has to be running.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	apiURL  = flag.String("api", envOr("QUERIESCTL_API", "http://localhost:8080"), "base URL of the parser API")
+	token   = flag.String("token", os.Getenv("QUERIESCTL_TOKEN"), "API key or admin token, sent as a bearer token (default $QUERIESCTL_TOKEN)")
+	direct  = flag.Bool("direct", false, "use the data directory instead of the HTTP API")
+	dataDir = flag.String("data", "data", "data directory for -direct")
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	var err error
+	switch cmd {
+	case "parse":
+		err = cmdParse(args)
+	case "batch":
+		err = cmdBatch(args)
+	case "eval":
+		err = cmdEval(args)
+	case "diff":
+		err = cmdDiff(args)
+	case "results":
+		err = cmdResults(args)
+	case "gt":
+		err = cmdGroundTruth(args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "queriesctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: queriesctl [-api URL [-token KEY] | -direct [-data DIR]] <command> [flags]
+
+commands:
+  parse    parse a single query
+  batch    run a file of queries (one per line) through a provider
+  eval     dump evaluations (JSON)
+  diff     compare two evaluation snapshots
+  results  dump stored runs
+  gt       manage ground truth (list, add, rm, promote)`)
+	flag.PrintDefaults()
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// ====== parse / batch ======
+
+func cmdParse(args []string) error {
+	fs := flag.NewFlagSet("parse", flag.ExitOnError)
+	provider := fs.String("provider", "openai", "openai, claude or both")
+	seed := fs.Int64("seed", -1, "sampling seed (OpenAI; -1 = server default)")
+	fs.Parse(args)
+	query := strings.Join(fs.Args(), " ")
+	if strings.TrimSpace(query) == "" {
+		return errors.New("parse: query is required")
+	}
+	if *direct {
+		return errors.New("parse: provider calls need the API, drop -direct")
+	}
+	out, err := parseQuery(query, *provider, *seed)
+	if err != nil {
+		return err
+	}
+	return printJSON(out)
+}
+
+func cmdBatch(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	provider := fs.String("provider", "openai", "openai, claude or both")
+	in := fs.String("in", "-", "file with one query per line (- for stdin)")
+	outPath := fs.String("out", "-", "JSONL output file (- for stdout)")
+	seed := fs.Int64("seed", -1, "sampling seed for every query (OpenAI; -1 = server default)")
+	fs.Parse(args)
+	if *direct {
+		return errors.New("batch: provider calls need the API, drop -direct")
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var w io.Writer = os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	failed := 0
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		query := strings.TrimSpace(sc.Text())
+		if query == "" || strings.HasPrefix(query, "#") {
+			continue
+		}
+		start := time.Now()
+		row := map[string]any{"query": query}
+		out, err := parseQuery(query, *provider, *seed)
+		if err != nil {
+			failed++
+			row["error"] = err.Error()
+		} else {
+			row["response"] = out
+		}
+		row["latency_ms"] = time.Since(start).Milliseconds()
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("batch: %d queries failed", failed)
+	}
+	return nil
+}
+
+func parseQuery(query, provider string, seed int64) (json.RawMessage, error) {
+	req := map[string]any{"query_de": query, "provider": provider}
+	if seed >= 0 {
+		req["seed"] = seed
+	}
+	body, _ := json.Marshal(req)
+	return apiDo(http.MethodPost, "/v1/parse", body)
+}
+
+// ====== eval / diff ======
+
+func cmdEval(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	perQuery := fs.Bool("per-query", false, "include per-query scores")
+	sortBy := fs.String("sort", "", "per-query order: time, f1 or latency")
+	provider := fs.String("provider", "", "per-query scores of openai or claude (default the worse)")
+	filter := fs.String("filter", "", "per-query filters, comma-separated: mismatch, ambiguous, disagreement")
+	limit := fs.Int("limit", 0, "per-query entries to return (0 = all)")
+	group := fs.String("group", "", "group results (e.g. variant)")
+	confusion := fs.Bool("confusion", false, "include the per-slot confusion report")
+	dedup := fs.String("dedup", "", "also report metrics with one vote per query: latest, majority or weighted")
+	fs.Parse(args)
+	if *direct {
+		return errors.New("eval: metrics are computed by the server, drop -direct")
+	}
+	q := url.Values{}
+	if *perQuery {
+		q.Set("per_query", "1")
+		if *sortBy != "" {
+			q.Set("sort", *sortBy)
+		}
+		if *provider != "" {
+			q.Set("provider", *provider)
+		}
+		for _, f := range strings.Split(*filter, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				q.Add("filter", f)
+			}
+		}
+		if *limit > 0 {
+			q.Set("limit", strconv.Itoa(*limit))
+		}
+	}
+	if *group != "" {
+		q.Set("group", *group)
+	}
+	if *dedup != "" {
+		q.Set("dedup", *dedup)
+	}
+	if *confusion {
+		q.Set("confusion", "1")
+	}
+	path := "/v1/evaluations"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	out, err := apiDo(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return printJSON(out)
+}
+
+// cmdDiff prints every numeric metric that changed between two snapshots.
+// With -fail-on-drop it exits non-zero when a quality metric got worse by
+// more than the threshold, which is what CI wants.
+func cmdDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	failOnDrop := fs.Float64("fail-on-drop", -1, "fail if a quality metric drops by more than this")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("diff: need two snapshot files")
+	}
+	a, err := readNumbers(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := readNumbers(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var drops []string
+	for _, k := range sorted {
+		av, aok := a[k]
+		bv, bok := b[k]
+		switch {
+		case !aok:
+			fmt.Printf("+ %s: %g\n", k, bv)
+		case !bok:
+			fmt.Printf("- %s: %g\n", k, av)
+		case av != bv:
+			fmt.Printf("~ %s: %g -> %g (%+.3f)\n", k, av, bv, bv-av)
+			if *failOnDrop >= 0 && isQualityMetric(k) && av-bv > *failOnDrop {
+				drops = append(drops, k)
+			}
+		}
+	}
+	if len(drops) > 0 {
+		return fmt.Errorf("diff: %d metrics dropped more than %g: %s", len(drops), *failOnDrop, strings.Join(drops, ", "))
+	}
+	return nil
+}
+
+func isQualityMetric(key string) bool {
+	leaf := key[strings.LastIndexByte(key, '.')+1:]
+	switch leaf {
+	case "f1", "precision", "recall", "slot_precision", "slot_recall", "exact_match", "jaccard", "ambiguity_handling_rate":
+		return true
+	}
+	return false
+}
+
+// readNumbers flattens a snapshot into dotted paths -> numeric leaves.
+// Per-query rows are skipped; they are not comparable across snapshots.
+func readNumbers(path string) (map[string]float64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	out := map[string]float64{}
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		switch t := v.(type) {
+		case map[string]any:
+			for k, child := range t {
+				if k == "per_query" {
+					continue
+				}
+				p := k
+				if prefix != "" {
+					p = prefix + "." + k
+				}
+				walk(p, child)
+			}
+		case float64:
+			out[prefix] = t
+		}
+	}
+	walk("", v)
+	return out, nil
+}
+
+// ====== results ======
+
+func cmdResults(args []string) error {
+	fs := flag.NewFlagSet("results", flag.ExitOnError)
+	fs.Parse(args)
+	if *direct {
+		b, err := readResultsDirect()
+		if err != nil {
+			return err
+		}
+		return printJSON(b)
+	}
+	out, err := apiDo(http.MethodGet, "/v1/evaluations?raw=1", nil)
+	if err != nil {
+		return err
+	}
+	return printJSON(out)
+}
+
+// readResultsDirect returns stored runs as a JSON array. It reads the
+// SQLite database when present, else the rotated JSONL log (gzipped
+// segments first, then the active file), else the legacy results.json.
+func readResultsDirect() ([]byte, error) {
+	if dbPath := filepath.Join(*dataDir, "parser.db"); fileExists(dbPath) {
+		return readResultsSQLite(dbPath)
+	}
+	active := filepath.Join(*dataDir, "results.jsonl")
+	segments, _ := filepath.Glob(filepath.Join(*dataDir, "results-*.jsonl*"))
+	sort.Strings(segments)
+	if _, err := os.Stat(active); errors.Is(err, os.ErrNotExist) && len(segments) == 0 {
+		return os.ReadFile(filepath.Join(*dataDir, "results.json"))
+	}
+
+	runs := []json.RawMessage{}
+	for _, path := range append(segments, active) {
+		if strings.HasSuffix(path, ".tmp") {
+			continue
+		}
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(path, ".gz") {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			r = zr
+		}
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for sc.Scan() {
+			if json.Valid(sc.Bytes()) {
+				runs = append(runs, append(json.RawMessage(nil), sc.Bytes()...))
+			}
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(runs)
+}
+
+func readResultsSQLite(path string) ([]byte, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT data FROM runs ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []json.RawMessage{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		runs = append(runs, json.RawMessage(data))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(runs)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// ====== ground truth ======
+
+func cmdGroundTruth(args []string) error {
+	if len(args) == 0 {
+		return errors.New("gt: need a subcommand (list, add, rm, promote)")
+	}
+	sub, args := args[0], args[1:]
+	fs := flag.NewFlagSet("gt "+sub, flag.ExitOnError)
+	file := fs.String("f", "", "JSON file with one item or an array of items (add)")
+	query := fs.String("query", "", "query text (rm, promote)")
+	provider := fs.String("provider", "openai", "provider output to promote (promote)")
+	fs.Parse(args)
+
+	switch sub {
+	case "list":
+		items, err := gtLoad()
+		if err != nil {
+			return err
+		}
+		return printJSON(items)
+
+	case "add":
+		if *file == "" {
+			return errors.New("gt add: -f is required")
+		}
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		var incoming []json.RawMessage
+		if strings.HasPrefix(strings.TrimSpace(string(b)), "[") {
+			if err := json.Unmarshal(b, &incoming); err != nil {
+				return err
+			}
+		} else {
+			incoming = []json.RawMessage{b}
+		}
+		return gtUpsert(incoming)
+
+	case "rm":
+		if *query == "" {
+			return errors.New("gt rm: -query is required")
+		}
+		if !*direct {
+			_, err := apiDo(http.MethodDelete, "/v1/groundtruth?query="+url.QueryEscape(*query), nil)
+			return err
+		}
+		items, err := gtLoad()
+		if err != nil {
+			return err
+		}
+		kept := items[:0]
+		for _, it := range items {
+			if queryOf(it) != *query {
+				kept = append(kept, it)
+			}
+		}
+		if len(kept) == len(items) {
+			return fmt.Errorf("gt rm: %q not found", *query)
+		}
+		return gtWrite(kept)
+
+	case "promote":
+		// Use the latest stored output of a provider as the truth for a query
+		if *query == "" {
+			return errors.New("gt promote: -query is required")
+		}
+		truth, err := latestOutput(*query, *provider)
+		if err != nil {
+			return err
+		}
+		item, _ := json.Marshal(map[string]any{"query": *query, "truth": truth})
+		return gtUpsert([]json.RawMessage{item})
+	}
+	return fmt.Errorf("gt: unknown subcommand %q", sub)
+}
+
+func gtLoad() ([]json.RawMessage, error) {
+	var b []byte
+	var err error
+	if *direct {
+		b, err = os.ReadFile(filepath.Join(*dataDir, "groundtruth.json"))
+		if errors.Is(err, os.ErrNotExist) {
+			return []json.RawMessage{}, nil
+		}
+	} else {
+		b, err = apiDo(http.MethodGet, "/v1/groundtruth", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func gtUpsert(incoming []json.RawMessage) error {
+	if !*direct {
+		b, _ := json.Marshal(incoming)
+		_, err := apiDo(http.MethodPost, "/v1/groundtruth", b)
+		return err
+	}
+	items, err := gtLoad()
+	if err != nil {
+		return err
+	}
+	for _, in := range incoming {
+		q := queryOf(in)
+		if strings.TrimSpace(q) == "" {
+			return errors.New("gt add: every item needs a query")
+		}
+		replaced := false
+		for i := range items {
+			if queryOf(items[i]) == q {
+				items[i] = in
+				replaced = true
+			}
+		}
+		if !replaced {
+			items = append(items, in)
+		}
+	}
+	return gtWrite(items)
+}
+
+func gtWrite(items []json.RawMessage) error {
+	b, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dataDir, 0755); err != nil {
+		return err
+	}
+	// write-then-rename, like the server does
+	path := filepath.Join(*dataDir, "groundtruth.json")
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func queryOf(item json.RawMessage) string {
+	var q struct {
+		Query string `json:"query"`
+	}
+	_ = json.Unmarshal(item, &q)
+	return q.Query
+}
+
+func latestOutput(query, provider string) (json.RawMessage, error) {
+	var b []byte
+	var err error
+	if *direct {
+		b, err = readResultsDirect()
+	} else {
+		b, err = apiDo(http.MethodGet, "/v1/evaluations?raw=1", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []struct {
+		Query    string                     `json:"query"`
+		Response map[string]json.RawMessage `json:"response"`
+	}
+	if err := json.Unmarshal(b, &runs); err != nil {
+		return nil, err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Query != query {
+			continue
+		}
+		if out, ok := runs[i].Response[provider]; ok {
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("no stored %s output for %q", provider, query)
+}
+
+// ====== HTTP helpers ======
+
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+func apiDo(method, path string, body []byte) (json.RawMessage, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(*apiURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+func printJSON(v any) error {
+	if raw, ok := v.([]byte); ok {
+		v = json.RawMessage(raw)
+	}
+	if raw, ok := v.(json.RawMessage); ok {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, raw, "", "  "); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ====== Config file ======
+// config.yaml (or CONFIG_FILE) is an optional, validated alternative to
+// setting environment variables one by one. Every setting maps to the env
+// var the rest of the server reads; variables that are already set in the
+// environment (or .env) win over the file. See config.sample.yaml.
+
+type ProviderConfig struct {
+	APIKey  string            `yaml:"api_key"`
+	BaseURL string            `yaml:"base_url"`
+	Model   string            `yaml:"model"`
+	Timeout string            `yaml:"timeout"`
+	Headers map[string]string `yaml:"headers"` // extra headers on every call
+	// MaxConcurrency caps parallel calls (0 = unlimited); QueueTimeout is how
+	// long a call waits for a slot ("0" = reject at once)
+	MaxConcurrency *int   `yaml:"max_concurrency"`
+	QueueTimeout   string `yaml:"queue_timeout"`
+	// BatchConcurrency caps the slots batch calls may hold (default half)
+	BatchConcurrency int `yaml:"batch_concurrency"`
+	// MaxTokens caps the answer; a truncated answer is retried once with
+	// MaxTokensRetry
+	MaxTokens      int `yaml:"max_tokens"`
+	MaxTokensRetry int `yaml:"max_tokens_retry"`
+	// EscalationModel re-parses low-confidence answers (tiered routing)
+	EscalationModel string `yaml:"escalation_model"`
+	// Default sampling parameters; requests may override them. Seed is
+	// OpenAI only.
+	Seed        *int64   `yaml:"seed"`
+	Temperature *float64 `yaml:"temperature"`
+	TopP        *float64 `yaml:"top_p"`
+	// Logprobs requests token logprobs for confidence estimates (OpenAI only)
+	Logprobs bool `yaml:"logprobs"`
+	// Tools forces the answer through a tool whose schema enumerates the
+	// taxonomy's values
+	Tools bool `yaml:"tools"`
+}
+
+type Config struct {
+	Port int `yaml:"port"`
+	// Paths move the prompt and data directories off the working directory
+	Paths struct {
+		Prompts string `yaml:"prompts"`
+		Data    string `yaml:"data"`
+	} `yaml:"paths"`
+	TLS struct {
+		CertFile        string   `yaml:"cert_file"`
+		KeyFile         string   `yaml:"key_file"`
+		AutocertDomains []string `yaml:"autocert_domains"`
+		AutocertEmail   string   `yaml:"autocert_email"`
+		AutocertCache   string   `yaml:"autocert_cache"`
+		HTTPAddr        string   `yaml:"http_addr"`
+	} `yaml:"tls"`
+	Providers struct {
+		OpenAI ProviderConfig `yaml:"openai"`
+		Claude ProviderConfig `yaml:"claude"`
+	} `yaml:"providers"`
+	Upstream struct {
+		Proxy  string `yaml:"proxy"`
+		CAFile string `yaml:"ca_file"`
+	} `yaml:"upstream"`
+	Auth struct {
+		APIKeys     []APIKey `yaml:"api_keys"` // required on /v1/parse when set
+		UsagePeriod string   `yaml:"usage_period"`
+	} `yaml:"auth"`
+	Breaker struct {
+		Failures *int   `yaml:"failures"` // consecutive failures that open it (0 = off)
+		Cooldown string `yaml:"cooldown"`
+	} `yaml:"breaker"`
+	Timeouts struct {
+		Budget  string `yaml:"budget"`
+		Request string `yaml:"request"`
+		Slow    string `yaml:"slow"` // requests over this are flagged slow
+		// Adaptive derives each provider's timeout from its recent latency
+		// (see timeouts.go); AdaptiveMin is the floor
+		Adaptive    *bool  `yaml:"adaptive"`
+		AdaptiveMin string `yaml:"adaptive_min"`
+	} `yaml:"timeouts"`
+	// Cache keeps provider answers for repeated queries (see cache.go)
+	Cache struct {
+		TTL    string `yaml:"ttl"`    // off when empty
+		Size   int    `yaml:"size"`   // answers kept, default 1000
+		Warmup int    `yaml:"warmup"` // top queries per tenant parsed on startup
+	} `yaml:"cache"`
+	Limits struct {
+		MaxBodyBytes  int `yaml:"max_body_bytes"`
+		MaxQueryChars int `yaml:"max_query_chars"`
+		// ScreenInput rejects empty, gibberish and profane queries (default true)
+		ScreenInput *bool `yaml:"screen_input"`
+	} `yaml:"limits"`
+	CORS struct {
+		Origins []string `yaml:"origins"`
+	} `yaml:"cors"`
+	Prompts struct {
+		VariantsFile string `yaml:"variants_file"`
+		Pipeline     string `yaml:"pipeline"`
+		Stage2Mode   string `yaml:"stage2_mode"`
+		// Interpretation of vague terms: strict (default) or assisted
+		Interpretation string `yaml:"interpretation"`
+		// IntentGate labels queries before parsing: off (default), rules or llm
+		IntentGate  string `yaml:"intent_gate"`
+		IntentModel string `yaml:"intent_model"`
+	} `yaml:"prompts"`
+	Output struct {
+		Locale string `yaml:"locale"` // iso (default), de, en-GB or en-US
+	} `yaml:"output"`
+	// Contract is the downstream search contract answers are checked against
+	Contract struct {
+		SchemaFile string `yaml:"schema_file"`
+	} `yaml:"contract"`
+	// Simulator is the demo hotel search (see simulator.go)
+	Simulator struct {
+		HotelsFile string `yaml:"hotels_file"`
+	} `yaml:"simulator"`
+	// SearchAPI gets every stored answer for task success (see searcheval.go)
+	SearchAPI struct {
+		URL     string `yaml:"url"` // off when empty
+		Timeout string `yaml:"timeout"`
+	} `yaml:"search_api"`
+	// Suggest is how often a query must have been sent before /v1/suggest
+	// offers it to others
+	Suggest struct {
+		MinCount int `yaml:"min_count"`
+	} `yaml:"suggest"`
+	// Distances are the speeds that turn walking/driving minutes into meters
+	Distances struct {
+		WalkMetersPerMinute  int `yaml:"walk_meters_per_minute"`
+		DriveMetersPerMinute int `yaml:"drive_meters_per_minute"`
+	} `yaml:"distances"`
+	// Pricing is USD per million tokens by model name or prefix
+	Pricing  map[string]ModelPrice `yaml:"pricing"`
+	Fallback struct {
+		Rules *bool `yaml:"rules"` // rules answer when all providers fail (default true)
+	} `yaml:"fallback"`
+	Escalation struct {
+		MaxUnsupported *int `yaml:"max_unsupported"` // 0 = don't escalate on unsupported_criteria
+	} `yaml:"escalation"`
+	Shadow struct {
+		Provider   string   `yaml:"provider"`
+		Model      string   `yaml:"model"`
+		Prompt     string   `yaml:"prompt"`
+		SampleRate *float64 `yaml:"sample_rate"`
+	} `yaml:"shadow"`
+	Storage struct {
+		Backend     string `yaml:"backend"`
+		SQLitePath  string `yaml:"sqlite_path"`
+		DatabaseURL string `yaml:"database_url"`
+		PGMaxConns  int    `yaml:"pg_max_conns"`
+		RotateBytes int64  `yaml:"rotate_bytes"`
+		RotateEvery string `yaml:"rotate_every"`
+		Archive     struct {
+			Bucket    string `yaml:"bucket"`
+			Region    string `yaml:"region"`
+			Endpoint  string `yaml:"endpoint"`
+			Prefix    string `yaml:"prefix"`
+			KeepLocal int    `yaml:"keep_local"`
+			Interval  string `yaml:"interval"`
+		} `yaml:"archive"`
+	} `yaml:"storage"`
+	Events struct {
+		Backend string `yaml:"backend"`
+		URL     string `yaml:"url"`
+		Subject string `yaml:"subject"`
+	} `yaml:"events"`
+	Webhooks struct {
+		Secret       string   `yaml:"secret"`
+		AllowedHosts []string `yaml:"allowed_hosts"`
+	} `yaml:"webhooks"`
+	// Telemetry ships aggregate, anonymized statistics (see telemetry.go)
+	Telemetry struct {
+		URL        string `yaml:"url"`
+		Interval   string `yaml:"interval"`
+		Deployment string `yaml:"deployment"`
+		Secret     string `yaml:"secret"`
+	} `yaml:"telemetry"`
+	// Capture keeps the raw provider calls of runs (see capture.go)
+	Capture struct {
+		Raw string `yaml:"raw"`
+	} `yaml:"capture"`
+	// PromptSuggestions proposes prompt changes from evaluation failures
+	// (see promptsuggest.go)
+	PromptSuggestions struct {
+		Interval string `yaml:"interval"` // off when empty
+		Provider string `yaml:"provider"`
+		Model    string `yaml:"model"`
+	} `yaml:"prompt_suggestions"`
+	// Calibration learns how likely answers are right (see calibration.go)
+	Calibration struct {
+		Enabled  *bool  `yaml:"enabled"`
+		Interval string `yaml:"interval"`
+	} `yaml:"calibration"`
+}
+
+// restartOnlyEnv are read once at startup; reloading them only logs a warning
+var restartOnlyEnv = []string{
+	"PORT", "DATA_DIR", "TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_AUTOCERT_DOMAINS", "TLS_AUTOCERT_EMAIL",
+	"TLS_AUTOCERT_CACHE", "TLS_HTTP_ADDR", "UPSTREAM_PROXY", "UPSTREAM_CA_FILE", "STORAGE_BACKEND", "SQLITE_PATH", "DATABASE_URL", "PG_MAX_CONNS",
+	"RESULTS_ROTATE_BYTES", "RESULTS_ROTATE_EVERY",
+	"ARCHIVE_S3_BUCKET", "ARCHIVE_S3_REGION", "ARCHIVE_S3_ENDPOINT", "ARCHIVE_S3_PREFIX",
+	"ARCHIVE_KEEP_LOCAL", "ARCHIVE_INTERVAL",
+	"EVENTS_BACKEND", "EVENTS_URL", "EVENTS_SUBJECT",
+	"TELEMETRY_URL", "TELEMETRY_INTERVAL", "TELEMETRY_DEPLOYMENT", "PROMPT_SUGGEST_INTERVAL",
+	"CONFIDENCE_CALIBRATION_INTERVAL",
+}
+
+func configFile() (path string, explicit bool) {
+	if p := os.Getenv("CONFIG_FILE"); p != "" {
+		return p, true
+	}
+	return "config.yaml", false
+}
+
+// readConfig decodes and validates a config file. Unknown keys are errors so
+// typos don't silently fall back to defaults.
+func readConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if errs := c.validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("%s: invalid config:\n  %s", path, strings.Join(errs, "\n  "))
+	}
+	return &c, nil
+}
+
+// validate returns one message per problem, named by its YAML path
+func (c *Config) validate() []string {
+	var errs []string
+	bad := func(field, format string, args ...any) {
+		errs = append(errs, field+": "+fmt.Sprintf(format, args...))
+	}
+	duration := func(field, v string) {
+		if v == "" {
+			return
+		}
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			bad(field, "invalid duration %q (use e.g. 30s, 2m)", v)
+		}
+	}
+	httpURL := func(field, v string) {
+		if v == "" {
+			return
+		}
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad(field, "must be an http(s) URL, got %q", v)
+		}
+	}
+	oneOf := func(field, v string, allowed ...string) {
+		if v == "" {
+			return
+		}
+		for _, a := range allowed {
+			if v == a {
+				return
+			}
+		}
+		bad(field, "must be one of %s, got %q", strings.Join(allowed, ", "), v)
+	}
+
+	if c.Port < 0 || c.Port > 65535 {
+		bad("port", "must be 1..65535, got %d", c.Port)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		bad("tls", "cert_file and key_file must be set together")
+	}
+	if c.TLS.CertFile != "" && len(c.TLS.AutocertDomains) > 0 {
+		bad("tls", "use either cert_file/key_file or autocert_domains")
+	}
+	for name, p := range map[string]ProviderConfig{"openai": c.Providers.OpenAI, "claude": c.Providers.Claude} {
+		httpURL("providers."+name+".base_url", p.BaseURL)
+		duration("providers."+name+".timeout", p.Timeout)
+		if p.QueueTimeout != "0" {
+			duration("providers."+name+".queue_timeout", p.QueueTimeout)
+		}
+		if p.MaxTokens < 0 || p.MaxTokensRetry < 0 {
+			bad("providers."+name+".max_tokens", "cannot be negative")
+		}
+		if p.MaxConcurrency != nil && *p.MaxConcurrency < 0 {
+			bad("providers."+name+".max_concurrency", "cannot be negative")
+		}
+		if p.BatchConcurrency < 0 {
+			bad("providers."+name+".batch_concurrency", "cannot be negative")
+		}
+		if err := (Sampling{Seed: p.Seed, Temperature: p.Temperature, TopP: p.TopP}).validate(name); err != nil {
+			bad("providers."+name, "%v", err)
+		}
+	}
+	if c.Providers.Claude.Seed != nil {
+		bad("providers.claude.seed", "Claude has no seed parameter")
+	}
+	if c.Providers.Claude.Logprobs {
+		bad("providers.claude.logprobs", "Claude does not return logprobs")
+	}
+	if v := c.Upstream.Proxy; v != "" {
+		if u, err := url.Parse(v); err != nil || u.Host == "" {
+			bad("upstream.proxy", "must be a URL like http://proxy:3128, got %q", v)
+		}
+	}
+	if v := c.SearchAPI.URL; v != "" {
+		if u, err := url.Parse(v); err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+			bad("search_api.url", "must be an http(s) URL, got %q", v)
+		}
+	}
+	if v := c.Paths.Prompts; v != "" {
+		if fi, err := os.Stat(v); err != nil || !fi.IsDir() {
+			bad("paths.prompts", "%q is not a directory", v)
+		}
+	}
+	if v := c.Upstream.CAFile; v != "" {
+		if _, err := os.Stat(v); err != nil {
+			bad("upstream.ca_file", "%v", err)
+		}
+	}
+	seen := map[string]bool{}
+	for i, k := range c.Auth.APIKeys {
+		field := fmt.Sprintf("auth.api_keys[%d]", i)
+		switch {
+		case k.Name == "" || k.Key == "":
+			bad(field, "needs a name and a key")
+		case seen[k.Name]:
+			bad(field, "duplicate name %q", k.Name)
+		case k.Tenant != "" && !tenantName.MatchString(k.Tenant):
+			bad(field+".tenant", "use lower-case letters, digits, - and _")
+		case k.Quota.Requests < 0 || k.Quota.Tokens < 0 || k.Quota.CostUSD < 0:
+			bad(field+".quota", "cannot be negative")
+		}
+		seen[k.Name] = true
+	}
+	oneOf("auth.usage_period", c.Auth.UsagePeriod, "day", "month")
+	if n := c.Breaker.Failures; n != nil && *n < 0 {
+		bad("breaker.failures", "cannot be negative")
+	}
+	duration("breaker.cooldown", c.Breaker.Cooldown)
+	duration("timeouts.budget", c.Timeouts.Budget)
+	duration("timeouts.request", c.Timeouts.Request)
+	duration("search_api.timeout", c.SearchAPI.Timeout)
+	duration("timeouts.slow", c.Timeouts.Slow)
+	duration("timeouts.adaptive_min", c.Timeouts.AdaptiveMin)
+	duration("cache.ttl", c.Cache.TTL)
+	if c.Cache.Size < 0 || c.Cache.Warmup < 0 {
+		bad("cache", "size and warmup cannot be negative")
+	}
+	if c.Limits.MaxBodyBytes < 0 {
+		bad("limits.max_body_bytes", "cannot be negative")
+	}
+	if c.Limits.MaxQueryChars < 0 {
+		bad("limits.max_query_chars", "cannot be negative")
+	}
+	for i, o := range c.CORS.Origins {
+		httpURL(fmt.Sprintf("cors.origins[%d]", i), o)
+	}
+	oneOf("prompts.pipeline", c.Prompts.Pipeline, pipelineSingle, pipelineTwoStage)
+	oneOf("prompts.stage2_mode", c.Prompts.Stage2Mode, "rules", "llm")
+	oneOf("prompts.interpretation", c.Prompts.Interpretation, interpretStrict, interpretAssisted)
+	oneOf("prompts.intent_gate", c.Prompts.IntentGate, intentOff, intentRules, intentLLM)
+	oneOf("output.locale", c.Output.Locale, "iso", "de", "en-GB", "en-US")
+	if c.Suggest.MinCount < 0 {
+		bad("suggest.min_count", "cannot be negative")
+	}
+	if c.Distances.WalkMetersPerMinute < 0 || c.Distances.DriveMetersPerMinute < 0 {
+		bad("distances", "meters per minute cannot be negative")
+	}
+	if n := c.Escalation.MaxUnsupported; n != nil && *n < 0 {
+		bad("escalation.max_unsupported", "cannot be negative")
+	}
+	for model, p := range c.Pricing {
+		if p.Input < 0 || p.Output < 0 {
+			bad("pricing."+model, "prices cannot be negative")
+		}
+	}
+	oneOf("shadow.provider", c.Shadow.Provider, "openai", "claude")
+	if r := c.Shadow.SampleRate; r != nil && (*r < 0 || *r > 1) {
+		bad("shadow.sample_rate", "must be between 0 and 1, got %g", *r)
+	}
+	oneOf("storage.backend", c.Storage.Backend, "sqlite", "postgres", "jsonl", "json")
+	if c.Storage.Backend == "postgres" && c.Storage.DatabaseURL == "" && os.Getenv("DATABASE_URL") == "" {
+		bad("storage.database_url", "required for the postgres backend")
+	}
+	if c.Storage.PGMaxConns < 0 {
+		bad("storage.pg_max_conns", "cannot be negative")
+	}
+	if c.Storage.RotateBytes < 0 {
+		bad("storage.rotate_bytes", "cannot be negative")
+	}
+	duration("storage.rotate_every", c.Storage.RotateEvery)
+	httpURL("storage.archive.endpoint", c.Storage.Archive.Endpoint)
+	duration("storage.archive.interval", c.Storage.Archive.Interval)
+	if c.Storage.Archive.KeepLocal < 0 {
+		bad("storage.archive.keep_local", "cannot be negative")
+	}
+	oneOf("events.backend", c.Events.Backend, "nats", "kafka-rest")
+	if c.Events.Backend != "" && c.Events.URL == "" && os.Getenv("EVENTS_URL") == "" {
+		bad("events.url", "required when events.backend is set")
+	}
+	httpURL("telemetry.url", c.Telemetry.URL)
+	duration("telemetry.interval", c.Telemetry.Interval)
+	oneOf("capture.raw", c.Capture.Raw, "off", captureErrors, captureAll)
+	duration("prompt_suggestions.interval", c.PromptSuggestions.Interval)
+	oneOf("prompt_suggestions.provider", c.PromptSuggestions.Provider, "openai", "claude")
+	duration("calibration.interval", c.Calibration.Interval)
+	sort.Strings(errs)
+	return errs
+}
+
+// env flattens the config into the environment variables it stands for;
+// unset (zero) settings are left out
+func (c *Config) env() map[string]string {
+	m := map[string]string{}
+	set := func(key, v string) {
+		if v != "" {
+			m[key] = v
+		}
+	}
+	num := func(key string, v int64) {
+		if v > 0 {
+			m[key] = strconv.FormatInt(v, 10)
+		}
+	}
+	num("PORT", int64(c.Port))
+	set("PROMPT_DIR", c.Paths.Prompts)
+	set("DATA_DIR", c.Paths.Data)
+	set("TLS_CERT_FILE", c.TLS.CertFile)
+	set("TLS_KEY_FILE", c.TLS.KeyFile)
+	set("TLS_AUTOCERT_DOMAINS", strings.Join(c.TLS.AutocertDomains, ","))
+	set("TLS_AUTOCERT_EMAIL", c.TLS.AutocertEmail)
+	set("TLS_AUTOCERT_CACHE", c.TLS.AutocertCache)
+	set("TLS_HTTP_ADDR", c.TLS.HTTPAddr)
+	for prefix, p := range map[string]ProviderConfig{"OPENAI": c.Providers.OpenAI, "CLAUDE": c.Providers.Claude} {
+		set(prefix+"_API_KEY", p.APIKey)
+		set(prefix+"_BASE_URL", p.BaseURL)
+		set(prefix+"_MODEL", p.Model)
+		set(prefix+"_TIMEOUT", p.Timeout)
+		set(prefix+"_QUEUE_TIMEOUT", p.QueueTimeout)
+		num(prefix+"_BATCH_CONCURRENCY", int64(p.BatchConcurrency))
+		num(prefix+"_MAX_TOKENS", int64(p.MaxTokens))
+		num(prefix+"_MAX_TOKENS_RETRY", int64(p.MaxTokensRetry))
+		set(prefix+"_ESCALATION_MODEL", p.EscalationModel)
+		if p.Seed != nil {
+			m[prefix+"_SEED"] = strconv.FormatInt(*p.Seed, 10)
+		}
+		if p.Temperature != nil {
+			m[prefix+"_TEMPERATURE"] = strconv.FormatFloat(*p.Temperature, 'f', -1, 64)
+		}
+		if p.TopP != nil {
+			m[prefix+"_TOP_P"] = strconv.FormatFloat(*p.TopP, 'f', -1, 64)
+		}
+		if p.Logprobs {
+			m[prefix+"_LOGPROBS"] = "1"
+		}
+		if p.Tools {
+			m[prefix+"_TOOLS"] = "1"
+		}
+		if p.MaxConcurrency != nil {
+			m[prefix+"_MAX_CONCURRENCY"] = strconv.Itoa(*p.MaxConcurrency)
+		}
+		if len(p.Headers) > 0 {
+			b, _ := json.Marshal(p.Headers)
+			m[prefix+"_EXTRA_HEADERS"] = string(b)
+		}
+	}
+	set("UPSTREAM_PROXY", c.Upstream.Proxy)
+	set("UPSTREAM_CA_FILE", c.Upstream.CAFile)
+	if len(c.Auth.APIKeys) > 0 {
+		b, _ := json.Marshal(c.Auth.APIKeys)
+		m["API_KEYS"] = string(b)
+	}
+	set("USAGE_PERIOD", c.Auth.UsagePeriod)
+	if c.Breaker.Failures != nil {
+		m["PROVIDER_BREAKER_FAILURES"] = strconv.Itoa(*c.Breaker.Failures)
+	}
+	set("PROVIDER_BREAKER_COOLDOWN", c.Breaker.Cooldown)
+	set("PARSE_BUDGET", c.Timeouts.Budget)
+	set("REQUEST_TIMEOUT", c.Timeouts.Request)
+	set("SLOW_REQUEST_THRESHOLD", c.Timeouts.Slow)
+	if c.Timeouts.Adaptive != nil {
+		m["ADAPTIVE_TIMEOUT"] = "0"
+		if *c.Timeouts.Adaptive {
+			m["ADAPTIVE_TIMEOUT"] = "1"
+		}
+	}
+	set("ADAPTIVE_TIMEOUT_MIN", c.Timeouts.AdaptiveMin)
+	set("PARSE_CACHE_TTL", c.Cache.TTL)
+	num("PARSE_CACHE_SIZE", int64(c.Cache.Size))
+	num("PARSE_CACHE_WARMUP", int64(c.Cache.Warmup))
+	num("MAX_BODY_BYTES", int64(c.Limits.MaxBodyBytes))
+	num("MAX_QUERY_CHARS", int64(c.Limits.MaxQueryChars))
+	set("CORS_ORIGINS", strings.Join(c.CORS.Origins, ","))
+	set("PROMPT_VARIANTS_FILE", c.Prompts.VariantsFile)
+	set("PARSE_PIPELINE", c.Prompts.Pipeline)
+	set("STAGE2_MODE", c.Prompts.Stage2Mode)
+	set("INTERPRETATION_MODE", c.Prompts.Interpretation)
+	set("INTENT_GATE", c.Prompts.IntentGate)
+	set("INTENT_MODEL", c.Prompts.IntentModel)
+	set("OUTPUT_LOCALE", c.Output.Locale)
+	set("CONTRACT_SCHEMA_FILE", c.Contract.SchemaFile)
+	set("SIMULATOR_HOTELS_FILE", c.Simulator.HotelsFile)
+	set("SEARCH_API_URL", c.SearchAPI.URL)
+	set("SEARCH_API_TIMEOUT", c.SearchAPI.Timeout)
+	num("SUGGEST_MIN_COUNT", int64(c.Suggest.MinCount))
+	num("DISTANCE_WALK_M_PER_MIN", int64(c.Distances.WalkMetersPerMinute))
+	num("DISTANCE_DRIVE_M_PER_MIN", int64(c.Distances.DriveMetersPerMinute))
+	if c.Limits.ScreenInput != nil {
+		m["INPUT_SCREENING"] = strconv.FormatBool(*c.Limits.ScreenInput)
+	}
+	if c.Fallback.Rules != nil {
+		m["RULES_FALLBACK"] = strconv.FormatBool(*c.Fallback.Rules)
+	}
+	if c.Escalation.MaxUnsupported != nil {
+		m["ESCALATE_MAX_UNSUPPORTED"] = strconv.Itoa(*c.Escalation.MaxUnsupported)
+	}
+	if len(c.Pricing) > 0 {
+		b, _ := json.Marshal(c.Pricing)
+		m["MODEL_PRICES"] = string(b)
+	}
+	set("SHADOW_PROVIDER", c.Shadow.Provider)
+	set("SHADOW_MODEL", c.Shadow.Model)
+	set("SHADOW_PROMPT", c.Shadow.Prompt)
+	if c.Shadow.SampleRate != nil {
+		m["SHADOW_SAMPLE_RATE"] = strconv.FormatFloat(*c.Shadow.SampleRate, 'f', -1, 64)
+	}
+	set("STORAGE_BACKEND", c.Storage.Backend)
+	set("SQLITE_PATH", c.Storage.SQLitePath)
+	set("DATABASE_URL", c.Storage.DatabaseURL)
+	num("PG_MAX_CONNS", int64(c.Storage.PGMaxConns))
+	num("RESULTS_ROTATE_BYTES", c.Storage.RotateBytes)
+	set("RESULTS_ROTATE_EVERY", c.Storage.RotateEvery)
+	set("ARCHIVE_S3_BUCKET", c.Storage.Archive.Bucket)
+	set("ARCHIVE_S3_REGION", c.Storage.Archive.Region)
+	set("ARCHIVE_S3_ENDPOINT", c.Storage.Archive.Endpoint)
+	set("ARCHIVE_S3_PREFIX", c.Storage.Archive.Prefix)
+	num("ARCHIVE_KEEP_LOCAL", int64(c.Storage.Archive.KeepLocal))
+	set("ARCHIVE_INTERVAL", c.Storage.Archive.Interval)
+	set("EVENTS_BACKEND", c.Events.Backend)
+	set("EVENTS_URL", c.Events.URL)
+	set("EVENTS_SUBJECT", c.Events.Subject)
+	set("WEBHOOK_SECRET", c.Webhooks.Secret)
+	set("TELEMETRY_URL", c.Telemetry.URL)
+	set("TELEMETRY_INTERVAL", c.Telemetry.Interval)
+	set("TELEMETRY_DEPLOYMENT", c.Telemetry.Deployment)
+	set("TELEMETRY_SECRET", c.Telemetry.Secret)
+	set("CAPTURE_RAW", c.Capture.Raw)
+	set("PROMPT_SUGGEST_INTERVAL", c.PromptSuggestions.Interval)
+	set("PROMPT_SUGGEST_PROVIDER", c.PromptSuggestions.Provider)
+	set("PROMPT_SUGGEST_MODEL", c.PromptSuggestions.Model)
+	if c.Calibration.Enabled != nil {
+		m["CONFIDENCE_CALIBRATION"] = "0"
+		if *c.Calibration.Enabled {
+			m["CONFIDENCE_CALIBRATION"] = "1"
+		}
+	}
+	set("CONFIDENCE_CALIBRATION_INTERVAL", c.Calibration.Interval)
+	set("WEBHOOK_ALLOWED_HOSTS", strings.Join(c.Webhooks.AllowedHosts, ","))
+	return m
+}
+
+// configState tracks which env vars came from the file, so a reload can
+// update and remove them without touching the real environment
+var configState struct {
+	sync.Mutex
+	fromFile map[string]string
+}
+
+// applyConfig loads the config file into the environment. A missing default
+// config.yaml is fine; a missing CONFIG_FILE or an invalid file is an error.
+func applyConfig() error {
+	path, explicit := configFile()
+	c, err := readConfig(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	configState.Lock()
+	defer configState.Unlock()
+	next := c.env()
+	for key, old := range configState.fromFile {
+		if _, ok := next[key]; !ok && os.Getenv(key) == old {
+			os.Unsetenv(key)
+		}
+	}
+	applied := map[string]string{}
+	for key, v := range next {
+		if cur, set := os.LookupEnv(key); set && cur != configState.fromFile[key] {
+			continue // set in the environment: env wins
+		}
+		if configState.fromFile != nil && configState.fromFile[key] != v && isRestartOnly(key) {
+			log.Printf("[WARN] config: %s changed, restart to apply", key)
+		}
+		os.Setenv(key, v)
+		applied[key] = v
+	}
+	configState.fromFile = applied
+	log.Printf("[INFO] config: loaded %s (%d settings)", path, len(applied))
+	return nil
+}
+
+func isRestartOnly(key string) bool {
+	for _, k := range restartOnlyEnv {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadConfig re-reads everything that can change at runtime. On error the
+// previous settings stay active.
+func reloadConfig() error {
+	if err := applyConfig(); err != nil {
+		log.Printf("[ERROR] reload failed: %v", err)
+		return err
+	}
+	if p, ok := prompts.(promptPublisher); ok {
+		p.publishPrompts(promptPath("prompt"))
+	}
+	reloadPrompts()
+	buildClients()
+	buildLimiters()
+	log.Printf("[INFO] configuration reloaded")
+	return nil
+}
+
+// watchSIGHUP reloads the configuration on SIGHUP
+func watchSIGHUP() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			_ = reloadConfig()
+		}
+	}()
+}
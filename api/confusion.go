@@ -0,0 +1,87 @@
+package main
+
+import "sort"
+
+// ====== Confusion report ======
+// GET /v1/evaluations?confusion=1 adds, per provider and slot, the most
+// frequent mistakes: which value was produced in place of which correct one
+// (e.g. poolbeach: expected heated_pool, got pool). Within a slot every
+// wrong value of an answer is paired with every value it missed; a wrong
+// value with nothing missed has no "expected", a missed value with nothing
+// wrong in its place no "got". Use it to tune taxonomy synonyms and prompt
+// rules.
+
+const confusionTopN = 10 // pairs per slot
+
+type ConfusionPair struct {
+	Expected string `json:"expected,omitempty"`
+	Got      string `json:"got,omitempty"`
+	Count    int    `json:"count"`
+}
+
+type confusionAcc map[string]map[[2]string]int // slot -> {expected, got} -> count
+
+func (c confusionAcc) add(pSet, gSet map[string]bool) {
+	wrong, missed := map[string][]string{}, map[string][]string{}
+	for k := range pSet {
+		if !gSet[k] && slotNameOf(k) != "unsupported" {
+			slot := slotNameOf(k)
+			wrong[slot] = append(wrong[slot], k[len(slot)+1:])
+		}
+	}
+	for k := range gSet {
+		if !pSet[k] && slotNameOf(k) != "unsupported" {
+			slot := slotNameOf(k)
+			missed[slot] = append(missed[slot], k[len(slot)+1:])
+		}
+	}
+	count := func(slot, expected, got string) {
+		if c[slot] == nil {
+			c[slot] = map[[2]string]int{}
+		}
+		c[slot][[2]string{expected, got}]++
+	}
+	for slot, got := range wrong {
+		exp := missed[slot]
+		if len(exp) == 0 {
+			exp = []string{""}
+		}
+		for _, g := range got {
+			for _, e := range exp {
+				count(slot, e, g)
+			}
+		}
+	}
+	for slot, exp := range missed {
+		if len(wrong[slot]) == 0 {
+			for _, e := range exp {
+				count(slot, e, "")
+			}
+		}
+	}
+}
+
+// report lists the most frequent pairs of each slot
+func (c confusionAcc) report() map[string][]ConfusionPair {
+	if len(c) == 0 {
+		return nil
+	}
+	out := map[string][]ConfusionPair{}
+	for slot, pairs := range c {
+		list := make([]ConfusionPair, 0, len(pairs))
+		for p, n := range pairs {
+			list = append(list, ConfusionPair{Expected: p[0], Got: p[1], Count: n})
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			if list[i].Expected != list[j].Expected {
+				return list[i].Expected < list[j].Expected
+			}
+			return list[i].Got < list[j].Got
+		})
+		out[slot] = list[:min(len(list), confusionTopN)]
+	}
+	return out
+}
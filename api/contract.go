@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Downstream contract ======
+// Validate() only checks what the parser itself guarantees. The booking
+// search behind it has its own contract (fields it requires, enum values
+// it knows, how far ahead it can search), and a change on either side
+// used to surface only as failed searches. With CONTRACT_SCHEMA_FILE set,
+// every final answer (v1 JSON, after post-processing) is also checked
+// against that contract, a JSON Schema. The supported subset is type,
+// enum, const, required, properties, additionalProperties (false), items,
+// minimum/maximum, minLength/maxLength, pattern, minItems/maxItems and
+// format "date", plus two extensions: "x-maxDaysAhead"/"x-minDaysAhead"
+// bound a date relative to today, and "x-severity": "warning" makes
+// violations in a subschema warnings. The result is in "contract" per
+// answer (openai, claude, rules) with "valid" false on any error; it never
+// fails the request. contract.schema.sample.json is a starting point. The
+// file is reloaded like the prompts.
+
+// ContractReport is the outcome of checking one answer
+type ContractReport struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// contractSchema is the supported JSON Schema subset
+type contractSchema struct {
+	Type                 schemaTypes                `json:"type"`
+	Enum                 []any                      `json:"enum"`
+	Const                any                        `json:"const"`
+	Required             []string                   `json:"required"`
+	Properties           map[string]*contractSchema `json:"properties"`
+	AdditionalProperties *bool                      `json:"additionalProperties"`
+	Items                *contractSchema            `json:"items"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Pattern              string                     `json:"pattern"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	Format               string                     `json:"format"`
+	MaxDaysAhead         *int                       `json:"x-maxDaysAhead"`
+	MinDaysAhead         *int                       `json:"x-minDaysAhead"`
+	Severity             string                     `json:"x-severity"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaTypes is "type" as a string or a list of strings
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = many
+	return nil
+}
+
+// compile prepares the patterns of s and its subschemas
+func (s *contractSchema) compile(path string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: pattern: %w", path, err)
+		}
+		s.pattern = re
+	}
+	for name, p := range s.Properties {
+		if err := p.compile(path + "." + name); err != nil {
+			return err
+		}
+	}
+	return s.Items.compile(path + "[]")
+}
+
+var contractMemo struct {
+	sync.Mutex
+	hash   string
+	schema *contractSchema
+}
+
+// currentContract is the contract schema, nil when none is configured or
+// it doesn't parse
+func currentContract() *contractSchema {
+	path := os.Getenv("CONTRACT_SCHEMA_FILE")
+	if path == "" {
+		return nil
+	}
+	p := cachedPrompt(path, func() string {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[ERROR] contract schema: %v", err)
+			return ""
+		}
+		return string(b)
+	})
+	contractMemo.Lock()
+	defer contractMemo.Unlock()
+	if contractMemo.hash != p.Hash {
+		var s *contractSchema
+		if p.Text != "" {
+			s = &contractSchema{}
+			err := json.Unmarshal([]byte(p.Text), s)
+			if err == nil {
+				err = s.compile("$")
+			}
+			if err != nil {
+				log.Printf("[ERROR] invalid contract schema %s, not checking: %v", path, err)
+				s = nil
+			}
+		}
+		contractMemo.hash, contractMemo.schema = p.Hash, s
+	}
+	return contractMemo.schema
+}
+
+// checkContract checks p against schema
+func checkContract(schema *contractSchema, p *ParseResponse, now time.Time) *ContractReport {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return &ContractReport{Errors: []string{err.Error()}}
+	}
+	var doc any
+	_ = json.Unmarshal(b, &doc)
+	c := contractCheck{today: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+	c.check(schema, doc, "", false)
+	return &ContractReport{Valid: len(c.errors) == 0, Errors: c.errors, Warnings: c.warnings}
+}
+
+type contractCheck struct {
+	today            time.Time
+	errors, warnings []string
+}
+
+func (c *contractCheck) fail(path string, warn bool, format string, args ...any) {
+	if path == "" {
+		path = "$"
+	}
+	msg := path + ": " + fmt.Sprintf(format, args...)
+	if warn {
+		c.warnings = append(c.warnings, msg)
+	} else {
+		c.errors = append(c.errors, msg)
+	}
+}
+
+func (c *contractCheck) check(s *contractSchema, v any, path string, warn bool) {
+	if s == nil {
+		return
+	}
+	warn = warn || s.Severity == "warning"
+	if len(s.Type) > 0 && !matchesType(s.Type, v) {
+		c.fail(path, warn, "must be %s", strings.Join(s.Type, " or "))
+		return
+	}
+	if s.Enum != nil && !containsJSON(s.Enum, v) {
+		c.fail(path, warn, "%v is not one of the allowed values", v)
+	}
+	if s.Const != nil && !containsJSON([]any{s.Const}, v) {
+		c.fail(path, warn, "must be %v", s.Const)
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				c.fail(path, warn, "%s is required", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := s.Properties[name]; ok {
+				c.check(sub, v[name], joinPath(path, name), warn)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				c.fail(joinPath(path, name), warn, "is not allowed")
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			c.fail(path, warn, "needs at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			c.fail(path, warn, "allows at most %d items", *s.MaxItems)
+		}
+		for i, item := range v {
+			c.check(s.Items, item, fmt.Sprintf("%s[%d]", path, i), warn)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			c.fail(path, warn, "%v is below %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			c.fail(path, warn, "%v is above %v", v, *s.Maximum)
+		}
+	case string:
+		c.checkString(s, v, path, warn)
+	}
+}
+
+func (c *contractCheck) checkString(s *contractSchema, v, path string, warn bool) {
+	n := len([]rune(v))
+	if s.MinLength != nil && n < *s.MinLength {
+		c.fail(path, warn, "must have at least %d characters", *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		c.fail(path, warn, "must have at most %d characters", *s.MaxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(v) {
+		c.fail(path, warn, "%q does not match %s", v, s.Pattern)
+	}
+	if s.Format != "date" && s.MaxDaysAhead == nil && s.MinDaysAhead == nil {
+		return
+	}
+	d, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		if s.Format == "date" {
+			c.fail(path, warn, "%q is not a date", v)
+		}
+		return
+	}
+	ahead := int(d.Sub(c.today).Hours() / 24)
+	if s.MaxDaysAhead != nil && ahead > *s.MaxDaysAhead {
+		c.fail(path, warn, "%s is more than %d days ahead", v, *s.MaxDaysAhead)
+	}
+	if s.MinDaysAhead != nil && ahead < *s.MinDaysAhead {
+		c.fail(path, warn, "%s is less than %d days ahead", v, *s.MinDaysAhead)
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// matchesType is whether v has one of the JSON Schema types
+func matchesType(types []string, v any) bool {
+	for _, t := range types {
+		switch v := v.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case float64:
+			if t == "number" || t == "integer" && v == float64(int64(v)) {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case []any:
+			if t == "array" {
+				return true
+			}
+		case map[string]any:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsJSON is whether values has v, compared as JSON
+func containsJSON(values []any, v any) bool {
+	b, _ := json.Marshal(v)
+	for _, e := range values {
+		if eb, _ := json.Marshal(e); string(eb) == string(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkContracts checks every answer of r against schema
+func (r *MultiParseResponse) checkContracts(schema *contractSchema, now time.Time) {
+	for name, p := range map[string]*ParseResponse{"openai": r.OpenAI, "claude": r.Claude, "rules": r.Rules} {
+		if p == nil {
+			continue
+		}
+		report := checkContract(schema, p, now)
+		if !report.Valid {
+			log.Printf("[WARN] %s answer breaks the search contract: %s", name, strings.Join(report.Errors, "; "))
+		}
+		if r.Contract == nil {
+			r.Contract = map[string]*ContractReport{}
+		}
+		r.Contract[name] = report
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ====== Profiling ======
+// Admin-only views into the running process, for tracking down memory
+// growth and slow evaluations in production:
+//
+//	/v1/admin/debug/pprof/   the net/http/pprof index and profiles
+//	                         (go tool pprof -http : -H "Authorization: ..." URL)
+//	/v1/admin/runtime        goroutines, heap, GC pauses, cache sizes and
+//	                         cluster job leadership as JSON
+//
+// The pprof import also registers on http.DefaultServeMux, which is never
+// served. Profiles are exempt from REQUEST_TIMEOUT (profile?seconds=60).
+
+const pprofPrefix = "/v1/admin/debug/pprof/"
+
+var processStart = time.Now()
+
+func pprofHandler(w http.ResponseWriter, r *http.Request) {
+	switch name := strings.TrimPrefix(r.URL.Path, pprofPrefix); name {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		// Index serves named profiles (heap, goroutine, ...) below its
+		// own fixed prefix
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/debug/pprof/" + name
+		pprof.Index(w, r2)
+	}
+}
+
+type RuntimeStats struct {
+	GoVersion     string    `json:"go_version"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Goroutines    int       `json:"goroutines"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	Heap          HeapStats `json:"heap"`
+	GC            GCStats   `json:"gc"`
+	// EvalCaches is the number of tenants with evaluation accumulators
+	EvalCaches int `json:"eval_caches"`
+	// Leader is whether this replica runs the cluster jobs (see jobs.go)
+	Leader      bool       `json:"leader"`
+	LeaderSince *time.Time `json:"leader_since,omitempty"`
+}
+
+type HeapStats struct {
+	AllocBytes    uint64 `json:"alloc_bytes"`
+	InuseBytes    uint64 `json:"inuse_bytes"`
+	SysBytes      uint64 `json:"sys_bytes"`
+	Objects       uint64 `json:"objects"`
+	TotalAlloc    uint64 `json:"total_alloc_bytes"`
+	NextGCBytes   uint64 `json:"next_gc_bytes"`
+	ReleasedBytes uint64 `json:"released_bytes"`
+}
+
+type GCStats struct {
+	Runs         uint32     `json:"runs"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	PauseTotalMS float64    `json:"pause_total_ms"`
+	RecentMS     []float64  `json:"recent_pauses_ms"` // newest first, up to 16
+	CPUFraction  float64    `json:"cpu_fraction"`
+}
+
+func runtimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s := RuntimeStats{
+		GoVersion:     runtime.Version(),
+		UptimeSeconds: int64(time.Since(processStart).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		Heap: HeapStats{
+			AllocBytes:    m.HeapAlloc,
+			InuseBytes:    m.HeapInuse,
+			SysBytes:      m.HeapSys,
+			Objects:       m.HeapObjects,
+			TotalAlloc:    m.TotalAlloc,
+			NextGCBytes:   m.NextGC,
+			ReleasedBytes: m.HeapReleased,
+		},
+		GC: GCStats{
+			Runs:         m.NumGC,
+			PauseTotalMS: round2(float64(m.PauseTotalNs) / 1e6),
+			RecentMS:     []float64{},
+			CPUFraction:  m.GCCPUFraction,
+		},
+	}
+	if m.LastGC > 0 {
+		t := time.Unix(0, int64(m.LastGC))
+		s.GC.LastRun = &t
+	}
+	// PauseNs is a ring; the most recent pause is at (NumGC+255)%256
+	for i := uint32(0); i < m.NumGC && i < 16; i++ {
+		ns := m.PauseNs[(m.NumGC-1-i)%uint32(len(m.PauseNs))]
+		s.GC.RecentMS = append(s.GC.RecentMS, float64(ns)/1e6)
+	}
+	evals.mu.Lock()
+	s.EvalCaches = len(evals.m)
+	evals.mu.Unlock()
+	if leader, since, _ := leaderState(); leader {
+		s.Leader, s.LeaderSince = true, &since
+	}
+	return s
+}
+
+func runtimeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(runtimeStats())
+}
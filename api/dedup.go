@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ====== Deduplicated evaluation ======
+// A ground-truth query run 30 times counts 30 times in the regular metrics.
+// GET /v1/evaluations?dedup=<mode> adds a "dedup" block next to them in
+// which every unique query counts once:
+//
+//	latest    only the most recent run of each query
+//	majority  per provider, the most frequent answer of each query (ties go
+//	          to the most recent one)
+//	weighted  every run, weighted 1/n for a query run n times
+//
+// Weighting applies to the quality metrics (precision, recall, exact match,
+// ambiguity handling, latency); token usage, escalation, stage latency and
+// calibration are per run. Only the last dedupKeepRuns runs of a query are
+// kept for this, so the cache doesn't grow with the traffic.
+
+const (
+	dedupLatest   = "latest"
+	dedupMajority = "majority"
+	dedupWeighted = "weighted"
+
+	dedupKeepRuns = 20
+)
+
+type DedupMetrics struct {
+	Mode      string                      `json:"mode"`
+	Queries   int                         `json:"queries"` // unique queries with ground truth
+	Runs      int                         `json:"runs"`
+	OpenAI    *ProviderMetrics            `json:"openai,omitempty"`
+	Claude    *ProviderMetrics            `json:"claude,omitempty"`
+	Providers map[string]*ProviderMetrics `json:"providers,omitempty"`
+}
+
+func validDedupMode(mode string) error {
+	switch mode {
+	case "", dedupLatest, dedupMajority, dedupWeighted:
+		return nil
+	}
+	return fmt.Errorf("unknown dedup mode %q (use latest, majority or weighted)", mode)
+}
+
+// dedup scores st's runs again with one vote per query
+func (st *evalState) dedup(mode string, gtMap map[string]GroundTruthItem) *DedupMetrics {
+	d := newEvalState()
+	out := &DedupMetrics{Mode: mode, Queries: len(st.runs), Runs: st.scored}
+	for _, runs := range st.runs {
+		switch mode {
+		case dedupLatest:
+			d.addRun(latestRun(runs), gtMap)
+		case dedupMajority:
+			for _, src := range evalSources {
+				if run, ok := majorityRun(runs, src); ok {
+					if gtItem, ok := scorable(run, gtMap); ok {
+						d.addSource(run, gtItem, src)
+					}
+				}
+			}
+		case dedupWeighted:
+			for _, src := range evalSources {
+				n := 0
+				for _, run := range runs {
+					if pred, _ := src.answer(run); pred != nil {
+						n++
+					}
+				}
+				d.acc(src.name).weight = 1 / float64(max(n, 1))
+			}
+			for _, run := range runs {
+				d.addRun(run, gtMap)
+			}
+		}
+	}
+	resp := d.response(evalOpts{})
+	out.OpenAI, out.Claude, out.Providers = resp.OpenAI, resp.Claude, resp.Providers
+	return out
+}
+
+// keepRun remembers run for dedup, dropping the oldest of its query's runs
+// beyond dedupKeepRuns
+func (st *evalState) keepRun(run StoredResult) {
+	st.scored++
+	runs := st.runs[run.Query]
+	if len(runs) == dedupKeepRuns {
+		copy(runs, runs[1:])
+		runs = runs[:len(runs)-1]
+	}
+	st.runs[run.Query] = append(runs, run)
+}
+
+// latestRun is the most recent of runs; later entries win ties
+func latestRun(runs []StoredResult) StoredResult {
+	latest := runs[0]
+	for _, run := range runs[1:] {
+		if !run.Time.Before(latest.Time) {
+			latest = run
+		}
+	}
+	return latest
+}
+
+// majorityRun returns the most recent run carrying src's most frequent
+// answer
+func majorityRun(runs []StoredResult, src evalSource) (StoredResult, bool) {
+	type group struct {
+		n      int
+		latest StoredResult
+	}
+	groups := map[string]*group{}
+	var best *group
+	for _, run := range runs {
+		pred, _ := src.answer(run)
+		if pred == nil {
+			continue
+		}
+		var keys []string
+		for k := range flatten(*pred) {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		sig := strings.Join(keys, "\n")
+		g := groups[sig]
+		if g == nil {
+			g = &group{}
+			groups[sig] = g
+		}
+		g.n++
+		if g.n == 1 || !run.Time.Before(g.latest.Time) {
+			g.latest = run
+		}
+		if best == nil || g.n > best.n || (g.n == best.n && !g.latest.Time.Before(best.latest.Time)) {
+			best = g
+		}
+	}
+	if best == nil {
+		return StoredResult{}, false
+	}
+	return best.latest, true
+}
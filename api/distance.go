@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ====== Distance constraints ======
+// "maximal 10 km vom Flughafen" and "5 Gehminuten zur Altstadt" limit the
+// distance to a reference point. Each such limit is an entry in
+// "distances": the value and unit as the query states them, the kind of
+// reference point (a value of the taxonomy slot "distance_reference":
+// airport, old_town, city_center, beach, ...) and the distance in meters,
+// which normalization computes from value and unit. Minutes become meters
+// with a heuristic speed: DISTANCE_WALK_M_PER_MIN (default 80, about
+// 5 km/h) for walking, DISTANCE_DRIVE_M_PER_MIN (default 500, 30 km/h in
+// town) for driving. The meters also go into
+// ui_filters.reference_distance_max, so the search UI keeps working
+// unchanged.
+
+const distanceRefSlot = "distance_reference"
+
+// Distance units
+const (
+	unitMeters       = "m"
+	unitKilometers   = "km"
+	unitWalkMinutes  = "min_walk"
+	unitDriveMinutes = "min_drive"
+)
+
+// Distance is one limit on the distance to a reference point
+type Distance struct {
+	Value     float64 `json:"value"`     // as stated
+	Unit      string  `json:"unit"`      // m, km, min_walk or min_drive
+	Reference string  `json:"reference"` // taxonomy "distance_reference" value
+	Meters    int     `json:"meters"`    // derived from value and unit
+}
+
+// distanceUnit maps a unit as written ("Kilometer", "Gehminuten",
+// "Minuten mit dem Auto") to its canonical name, or ""
+func distanceUnit(s string) string {
+	s = normalizePhrase(s)
+	switch {
+	case s == unitMeters || s == unitKilometers || s == unitWalkMinutes || s == unitDriveMinutes:
+		return s
+	case s == "min walk":
+		return unitWalkMinutes
+	case s == "min drive":
+		return unitDriveMinutes
+	case strings.HasPrefix(s, "km") || strings.HasPrefix(s, "kilometer"):
+		return unitKilometers
+	case strings.HasPrefix(s, "meter"):
+		return unitMeters
+	case strings.Contains(s, "auto") || strings.Contains(s, "fahr"):
+		return unitDriveMinutes
+	case strings.HasPrefix(s, "min") || strings.Contains(s, "minute"):
+		return unitWalkMinutes // "10 Minuten zum Strand" is meant on foot
+	}
+	return ""
+}
+
+// metersPerMinute is the speed a minute of walking or driving stands for
+func metersPerMinute(unit string) float64 {
+	key, def := "DISTANCE_WALK_M_PER_MIN", 80.0
+	if unit == unitDriveMinutes {
+		key, def = "DISTANCE_DRIVE_M_PER_MIN", 500
+	}
+	if f, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && f > 0 {
+		return f
+	}
+	return def
+}
+
+// meters is d in meters, false for an unknown unit
+func (d Distance) meters() (int, bool) {
+	var m float64
+	switch d.Unit {
+	case unitMeters:
+		m = d.Value
+	case unitKilometers:
+		m = d.Value * 1000
+	case unitWalkMinutes, unitDriveMinutes:
+		m = d.Value * metersPerMinute(d.Unit)
+	default:
+		return 0, false
+	}
+	return int(math.Round(m)), true
+}
+
+// normalizeDistances canonicalizes the units, computes the meters and adds
+// them to ui_filters.reference_distance_max
+func (p *ParseResponse) normalizeDistances() {
+	if p.Distances == nil {
+		p.Distances = []Distance{}
+	}
+	for i := range p.Distances {
+		d := &p.Distances[i]
+		if u := distanceUnit(d.Unit); u != "" {
+			d.Unit = u
+		}
+		if m, ok := d.meters(); ok {
+			d.Meters = m
+		}
+		if d.Meters > 0 {
+			p.UiFilters.add("reference_distance_max", strconv.Itoa(d.Meters))
+		}
+	}
+}
+
+// validateDistances checks units, values and reference points
+func (p *ParseResponse) validateDistances(tax Taxonomy) error {
+	for i, d := range p.Distances {
+		if _, ok := d.meters(); !ok {
+			return fmt.Errorf("distances[%d]: unknown unit %q", i, d.Unit)
+		}
+		if d.Value <= 0 {
+			return fmt.Errorf("distances[%d]: value must be positive", i)
+		}
+		if tax[distanceRefSlot] != nil && !tax.Has(distanceRefSlot, d.Reference) {
+			return fmt.Errorf("distances[%d]: unknown reference %q", i, d.Reference)
+		}
+	}
+	return nil
+}
+
+// distanceReference is the reference point a phrase names ("Flughafen" →
+// airport), or ""
+func distanceReference(tax Taxonomy, phrase string) string {
+	for _, sv := range tax.Match(phrase) {
+		if sv.Slot == distanceRefSlot {
+			return sv.Value
+		}
+	}
+	return ""
+}
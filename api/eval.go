@@ -1,21 +1,46 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
 	"math"
 	"net/http"
-	"os"
-	"sort"
 	"strings"
 	"time"
+
+	"hotelparser/api/eval/slots"
 )
 
 type StoredResult struct {
+	ID       string             `json:"id,omitempty"`
 	Query    string             `json:"query"`
 	Response MultiParseResponse `json:"response"`
 	Latency  int64              `json:"latency_ms"`
 	Time     time.Time          `json:"time"`
+	Shadow   *ShadowInfo        `json:"shadow,omitempty"`
+	Variant  string             `json:"variant,omitempty"` // canary variant name
+	APIKey   string             `json:"api_key,omitempty"` // name of the caller's key
+	Tenant   string             `json:"tenant,omitempty"`
+	// Aborted runs lost their client mid-way: provider calls still running
+	// were cancelled, so they are kept for the record but not evaluated
+	Aborted bool `json:"aborted,omitempty"`
+	// Assisted runs had the interpretation policy applied; ground truth
+	// leaves vague terms unsupported, so they are not evaluated either
+	Assisted bool   `json:"assisted,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	// Replay links a replayed run to its original (see replay.go)
+	Replay *ReplayInfo `json:"replay,omitempty"`
+	// Raw holds the upstream calls when raw capture is on (capture.go)
+	Raw []RawCall `json:"raw,omitempty"`
+	// Slow runs took longer than SLOW_REQUEST_THRESHOLD (slowlog.go)
+	Slow bool `json:"slow,omitempty"`
+	// Search has the search API's results per answer (searcheval.go)
+	Search map[string]*SearchOutcome `json:"search,omitempty"`
+	// Schema is the format the answers were stored in, see dataSchema
+	Schema int `json:"schema,omitempty"`
 }
 
 type GroundTruthItem struct {
@@ -23,33 +48,87 @@ type GroundTruthItem struct {
 	Truth                    ParseResponse   `json:"truth"`
 	Ambiguous                bool            `json:"ambiguous,omitempty"`
 	AcceptableInterpretation []ParseResponse `json:"acceptable_interpretations,omitempty"`
+	Schema                   int             `json:"schema,omitempty"` // see dataSchema
+}
+
+// dataSchema is the format of stored answers. Runs and ground truth
+// without one are from before family_friendly could be null, when false
+// meant "not mentioned": they are read with false as null, and written
+// back in the current format. The slot indexes of SQLite and Postgres
+// drop the stale family_friendly=false keys in a migration.
+const dataSchema = 1
+
+func (r *StoredResult) UnmarshalJSON(b []byte) error {
+	type plain StoredResult
+	if err := json.Unmarshal(b, (*plain)(r)); err != nil {
+		return err
+	}
+	if r.Schema < dataSchema {
+		for _, p := range []*ParseResponse{r.Response.OpenAI, r.Response.Claude, r.Response.Rules} {
+			if p != nil {
+				p.unsetLegacyFamilyFriendly()
+			}
+		}
+		r.Schema = dataSchema
+	}
+	return nil
+}
+
+func (it *GroundTruthItem) UnmarshalJSON(b []byte) error {
+	type plain GroundTruthItem
+	if err := json.Unmarshal(b, (*plain)(it)); err != nil {
+		return err
+	}
+	if it.Schema < dataSchema {
+		it.Truth.unsetLegacyFamilyFriendly()
+		for i := range it.AcceptableInterpretation {
+			it.AcceptableInterpretation[i].unsetLegacyFamilyFriendly()
+		}
+		it.Schema = dataSchema
+	}
+	return nil
+}
+
+func (p *ParseResponse) unsetLegacyFamilyFriendly() {
+	if p.FamilyFriendly != nil && !*p.FamilyFriendly {
+		p.FamilyFriendly = nil
+	}
 }
 
 const resultsFile = "data/results.json"
 const groundFile = "data/groundtruth.json"
 
-// Append new result in JSON "db"
-func StoreResult(query string, resp MultiParseResponse, latency int64) {
-	var results []StoredResult
-	_ = os.MkdirAll("data", 0755)
-	if b, err := os.ReadFile(resultsFile); err == nil {
-		_ = json.Unmarshal(b, &results)
+// Append new result to the result store
+func StoreResult(run StoredResult) {
+	if run.ID == "" {
+		run.ID = newRunID()
+	}
+	run.Schema = dataSchema
+	if err := evals.append(run); err != nil {
+		log.Printf("[ERROR] storing result failed: %v", err)
+		return
+	}
+	run.Raw = nil
+	publishRun(run)
+	if events != nil {
+		events.publishRun(run)
+	}
+	if telemetry != nil {
+		telemetry.record(run)
 	}
-	results = append(results, StoredResult{Query: query, Response: resp, Latency: latency, Time: time.Now()})
-	b, _ := json.MarshalIndent(results, "", "  ")
-	_ = os.WriteFile(resultsFile, b, 0644)
 }
 
 // ===== Evaluation types =====
 
+// SlotStats counts are whole numbers except in weighted dedup metrics
 type SlotStats struct {
-	TP int `json:"tp"`
-	FP int `json:"fp"`
-	FN int `json:"fn"`
+	TP float64 `json:"tp"`
+	FP float64 `json:"fp"`
+	FN float64 `json:"fn"`
 }
 
 type ProviderMetrics struct {
-	SlotPrecision         float64 `json:"slot_precision"` // micro across all slots
+	SlotPrecision         float64 `json:"slot_precision"` // micro across all slots but unsupported_criteria
 	SlotRecall            float64 `json:"slot_recall"`
 	F1                    float64 `json:"f1"`          // harmonic of micro P/R
 	ExactMatch            float64 `json:"exact_match"` // mean over queries
@@ -57,13 +136,43 @@ type ProviderMetrics struct {
 	AvgLatencyMS          float64 `json:"avg_latency_ms"`
 	Count                 int     `json:"count"` // queries with ground truth
 	AmbiguityHandlingRate float64 `json:"ambiguity_handling_rate"`
-	PerSlot               map[string]struct {
+	// per-slot ambiguity handling: share of ambiguous slots answered with one
+	// of the readings, and mean share of all slots answered acceptably
+	AmbiguitySlotRate float64 `json:"ambiguity_slot_rate"`
+	AmbiguityScore    float64 `json:"ambiguity_score"`
+	// token usage, averaged over runs that reported it
+	AvgPromptTokens     float64 `json:"avg_prompt_tokens,omitempty"`
+	AvgCompletionTokens float64 `json:"avg_completion_tokens,omitempty"`
+	// share of tiered runs answered by the escalation model
+	EscalationRate float64 `json:"escalation_rate,omitempty"`
+	// latency per stage of the request, see timings.go
+	StageLatency map[string]StageLatency `json:"stage_latency,omitempty"`
+	// whether the answers found hotels in the search API, see searcheval.go
+	TaskSuccess *TaskSuccessMetrics `json:"task_success,omitempty"`
+	// share of reruns (same query, model and seed) whose output matches the
+	// first run; nil without seeded reruns
+	SeedConsistency *float64 `json:"seed_consistency,omitempty"`
+	// the unsupported_criteria channel, scored separately
+	Unsupported *UnsupportedMetrics `json:"unsupported,omitempty"`
+	// reliability diagram of logprob confidence vs. correctness
+	Calibration *CalibrationReport `json:"calibration,omitempty"`
+	// values answered wanted instead of excluded or the reverse, per query;
+	// each also costs extra false positives (see negation.go)
+	PolarityFlipRate float64 `json:"polarity_flip_rate,omitempty"`
+	// share of correctly found ui_filters values that are also marked
+	// required/preferred like the ground truth; nil before any
+	StrengthAccuracy *float64 `json:"strength_accuracy,omitempty"`
+	// most frequent wrong values per slot, when ?confusion=1
+	Confusion map[string][]ConfusionPair `json:"confusion,omitempty"`
+	// registered Metric results by name, see metrics.go
+	Custom  map[string]any `json:"custom,omitempty"`
+	PerSlot map[string]struct {
 		Precision float64 `json:"precision"`
 		Recall    float64 `json:"recall"`
 		F1        float64 `json:"f1"`
-		TP        int     `json:"tp"`
-		FP        int     `json:"fp"`
-		FN        int     `json:"fn"`
+		TP        float64 `json:"tp"`
+		FP        float64 `json:"fp"`
+		FN        float64 `json:"fn"`
 	} `json:"per_slot"`
 }
 
@@ -75,178 +184,461 @@ type QueryScores struct {
 }
 
 type PerQueryCompare struct {
-	Query     string       `json:"query"`
-	OpenAI    *QueryScores `json:"openai,omitempty"`
-	Claude    *QueryScores `json:"claude,omitempty"`
-	Ambiguous bool         `json:"ambiguous"`
-	Accepted  bool         `json:"accepted"` // if any provider matched an acceptable interpretation
-	Time      time.Time    `json:"time"`
+	Query  string       `json:"query"`
+	OpenAI *QueryScores `json:"openai,omitempty"`
+	Claude *QueryScores `json:"claude,omitempty"`
+	// Providers has every source's scores by name, as in EvalResponse
+	Providers map[string]*QueryScores `json:"providers,omitempty"`
+	Ambiguous bool                    `json:"ambiguous"`
+	Accepted  bool                    `json:"accepted"` // if any provider matched an acceptable interpretation
+	Disagree  bool                    `json:"disagree"` // both providers answered, differently
+	Time      time.Time               `json:"time"`
 }
 
 type EvalResponse struct {
-	OpenAI       *ProviderMetrics  `json:"openai,omitempty"`
-	Claude       *ProviderMetrics  `json:"claude,omitempty"`
-	PerQueryDiff []PerQueryCompare `json:"per_query,omitempty"` // when ?per_query=1
+	OpenAI        *ProviderMetrics            `json:"openai,omitempty"`
+	Claude        *ProviderMetrics            `json:"claude,omitempty"`
+	Shadow        *ProviderMetrics            `json:"shadow,omitempty"`          // shadow runs, never mixed into the live numbers
+	Providers     map[string]*ProviderMetrics `json:"providers,omitempty"`       // every source and provider/model (see evalSource)
+	Dedup         *DedupMetrics               `json:"dedup,omitempty"`           // when ?dedup=...
+	PerQueryDiff  []PerQueryCompare           `json:"per_query,omitempty"`       // when ?per_query=1
+	PerQueryTotal int                         `json:"per_query_total,omitempty"` // entries before limit/offset
+	Variants      map[string]EvalResponse     `json:"variants,omitempty"`        // when ?group=variant
 }
 
 // ===== HTTP handler =====
 
+// evalOpts are the optional parts of an evaluation response
+type evalOpts struct {
+	byVariant bool
+	perQuery  *perQueryOpts // nil = no per-query list
+	dedup     string        // "" = no dedup block
+	confusion bool
+}
+
 func evalHandler(w http.ResponseWriter, r *http.Request) {
 	// Raw mode: return stored runs exactly as logged
 	if r.URL.Query().Get("raw") == "1" {
-		b, err := os.ReadFile(resultsFile)
-		if err != nil {
-			http.Error(w, "no results yet", http.StatusNotFound)
+		results, err := tenantRuns(tenantOf(r.Context()))
+		if err != nil || results == nil {
+			writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "no results yet"))
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(b)
+		writeEvalJSON(w, r, results)
 		return
 	}
 
-	// Load results
-	var results []StoredResult
-	if b, err := os.ReadFile(resultsFile); err == nil {
-		_ = json.Unmarshal(b, &results)
+	opts := evalOpts{
+		byVariant: r.URL.Query().Get("group") == "variant",
+		dedup:     r.URL.Query().Get("dedup"),
+		confusion: r.URL.Query().Get("confusion") == "1",
+	}
+	var err error
+	if opts.perQuery, err = parsePerQuery(r.URL.Query()); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, err.Error()))
+		return
 	}
+	if err := validDedupMode(opts.dedup); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, err.Error()))
+		return
+	}
+	resp := evals.of(tenantOf(r.Context())).snapshot(opts)
+	writeEvalJSON(w, r, resp)
+}
 
-	// Load ground truth
-	var gtItems []GroundTruthItem
-	if b, err := os.ReadFile(groundFile); err == nil {
-		_ = json.Unmarshal(b, &gtItems)
+// writeEvalJSON sends an evaluation payload with an ETag, answering 304
+// when it matches If-None-Match, so polling dashboards only download
+// changes. ?compact=1 drops the indentation; gzip is negotiated by
+// gzipMiddleware. The ETag is weak because the bytes depend on the
+// encoding.
+func writeEvalJSON(w http.ResponseWriter, r *http.Request, v any) {
+	var b []byte
+	var err error
+	if r.URL.Query().Get("compact") == "1" {
+		b, err = json.Marshal(v)
+	} else {
+		b, err = json.MarshalIndent(v, "", "  ")
+	}
+	if err != nil {
+		log.Printf("[ERROR] encoding evaluation: %v", err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not encode evaluation"))
+		return
 	}
+	b = append(b, '\n')
+	sum := sha256.Sum256(b)
+	etag := `W/"` + hex.EncodeToString(sum[:12]) + `"`
 
-	// Map query -> ground truth item
-	gtMap := map[string]GroundTruthItem{}
-	for _, g := range gtItems {
-		gtMap[g.Query] = g
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "no-cache")
+	h.Add("Vary", "Authorization, X-Tenant")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// etagMatches applies If-None-Match's weak comparison
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
 	}
+	return false
+}
 
-	openAcc := newAcc()
-	claudeAcc := newAcc()
+// evalState holds the running accumulators for one group of runs, so new
+// runs can be folded in without rescoring the history.
+type evalState struct {
+	accs      map[string]*acc // by evalSource name
+	shadowAcc *acc
 
-	wantPerQuery := r.URL.Query().Get("per_query") == "1"
-	var perQuery []PerQueryCompare
+	perQuery []PerQueryCompare
+	pqIndex  map[string]int // query + time -> index in perQuery
 
-	for _, run := range results {
-		gtItem, ok := gtMap[run.Query]
-		if !ok {
-			continue // skip runs with no ground truth
+	runs   map[string][]StoredResult // last dedupKeepRuns scored live runs by query, for dedup
+	scored int                       // scored live runs, including those dropped from runs
+}
+
+func newEvalState() *evalState {
+	return &evalState{
+		accs:      map[string]*acc{},
+		shadowAcc: newAcc(),
+		pqIndex:   map[string]int{},
+		runs:      map[string][]StoredResult{},
+	}
+}
+
+// acc is the accumulator of a source, created on first use
+func (st *evalState) acc(source string) *acc {
+	a := st.accs[source]
+	if a == nil {
+		a = newAcc()
+		st.accs[source] = a
+	}
+	return a
+}
+
+// evalSource is an answer that is scored like a provider's. Besides the
+// providers there are virtual ones, derived from a stored run: the rules
+// parser's answer to the query shows how the fallback would do. A combined
+// answer (consensus, ensemble) becomes scorable by adding it here.
+type evalSource struct {
+	name    string
+	virtual bool
+	answer  func(run StoredResult) (*ParseResponse, *ProviderStatus)
+}
+
+var evalSources = []evalSource{
+	{name: "openai", answer: func(run StoredResult) (*ParseResponse, *ProviderStatus) {
+		return run.Response.OpenAI, run.Response.Status["openai"]
+	}},
+	{name: "claude", answer: func(run StoredResult) (*ParseResponse, *ProviderStatus) {
+		return run.Response.Claude, run.Response.Status["claude"]
+	}},
+	{name: "rules", virtual: true, answer: func(run StoredResult) (*ParseResponse, *ProviderStatus) {
+		if run.Response.Rules != nil {
+			return run.Response.Rules, nil
 		}
-		gt := gtItem.Truth
-
-		if run.Response.OpenAI != nil {
-			s := scoreAgainstGT(*run.Response.OpenAI, gt)
-			openAcc.add(s, run.Latency)
-			openAcc.addSlots(*run.Response.OpenAI, gt)
-			if gtItem.Ambiguous {
-				if matchesAnyAcceptable(*run.Response.OpenAI, gtItem.AcceptableInterpretation) {
-					openAcc.ambAccepted++
-				}
-				openAcc.ambTotal++
-			}
-			if wantPerQuery {
-				perQuery = upsertPerQuery(perQuery, run, "openai", s, gtItem.Ambiguous, gtItem.AcceptableInterpretation)
-			}
+		rules := ruleParse(run.Query, run.Time)
+		return &rules, nil
+	}},
+}
+
+// scorable is the ground truth of run, unless it isn't evaluated
+func scorable(run StoredResult, gtMap map[string]GroundTruthItem) (GroundTruthItem, bool) {
+	gtItem, ok := gtMap[run.Query]
+	if !ok || run.Aborted || run.Assisted {
+		return gtItem, false // skip runs with no ground truth, aborted and assisted ones
+	}
+	return gtItem, true
+}
+
+func (st *evalState) addRun(run StoredResult, gtMap map[string]GroundTruthItem) {
+	gtItem, ok := scorable(run, gtMap)
+	if !ok {
+		return
+	}
+	gt := gtItem.Truth
+
+	if run.Shadow != nil {
+		if pred := shadowPrediction(run); pred != nil {
+			st.shadowAcc.add(scoreAgainstGT(*pred, gt), run.Latency)
+			st.shadowAcc.addSlots(*pred, gt)
+			st.shadowAcc.addUsage(run.Shadow.Usage)
 		}
-		if run.Response.Claude != nil {
-			s := scoreAgainstGT(*run.Response.Claude, gt)
-			claudeAcc.add(s, run.Latency)
-			claudeAcc.addSlots(*run.Response.Claude, gt)
-			if gtItem.Ambiguous {
-				if matchesAnyAcceptable(*run.Response.Claude, gtItem.AcceptableInterpretation) {
-					claudeAcc.ambAccepted++
-				}
-				claudeAcc.ambTotal++
-			}
-			if wantPerQuery {
-				perQuery = upsertPerQuery(perQuery, run, "claude", s, gtItem.Ambiguous, gtItem.AcceptableInterpretation)
+		return
+	}
+	st.keepRun(run)
+	for _, src := range evalSources {
+		st.addSource(run, gtItem, src)
+	}
+}
+
+// addSource scores one source's answer in run
+func (st *evalState) addSource(run StoredResult, gtItem GroundTruthItem, src evalSource) {
+	pred, rs := src.answer(run)
+	if pred == nil || rs != nil && rs.Cached {
+		return // a cached answer was scored with the run that got it
+	}
+	gt := gtItem.Truth
+	s := scoreAgainstGT(*pred, gt)
+	latency := run.Latency
+	if src.virtual {
+		latency = 0 // not part of the request's time
+	}
+	// a provider's answers also count for the model that gave them, so
+	// models configured over time or per tenant are told apart
+	a := st.acc(src.name)
+	accs := []*acc{a}
+	if rs != nil && rs.Repro != nil && rs.Repro.Model != "" {
+		m := st.acc(src.name + "/" + rs.Repro.Model)
+		m.weight = a.weight
+		accs = append(accs, m)
+	}
+	for _, a := range accs {
+		a.add(s, latency)
+		a.addSlots(*pred, gt)
+		if rs != nil {
+			a.addUsage(rs.Usage)
+			a.addTier(rs)
+			a.timings.add(rs.Timings)
+			a.search.add(run.Search[src.name], a.w())
+			a.addRepeat(run.Query, rs, *pred)
+			a.calib.add(rs.Confidence, gt)
+		}
+		if gtItem.Ambiguous {
+			if matchesAnyAcceptable(*pred, gtItem.AcceptableInterpretation) {
+				a.ambAccepted += a.w()
 			}
+			a.ambTotal += a.w()
+			a.addAmbiguity(*pred, gtItem)
 		}
 	}
+	st.perQuery = upsertPerQuery(st.perQuery, st.pqIndex, run, src.name, s, gtItem.Ambiguous, gtItem.AcceptableInterpretation)
+}
 
-	resp := EvalResponse{}
-	if openAcc.n > 0 {
-		m := openAcc.metrics()
-		resp.OpenAI = &m
+func (st *evalState) response(opts evalOpts) EvalResponse {
+	metrics := func(a *acc) *ProviderMetrics {
+		if a == nil || a.n == 0 {
+			return nil
+		}
+		m := a.metrics()
+		if opts.confusion {
+			m.Confusion = a.confusion.report()
+		}
+		return &m
+	}
+	resp := EvalResponse{
+		OpenAI: metrics(st.accs["openai"]),
+		Claude: metrics(st.accs["claude"]),
+		Shadow: metrics(st.shadowAcc),
+	}
+	for name, a := range st.accs {
+		if m := metrics(a); m != nil {
+			if resp.Providers == nil {
+				resp.Providers = map[string]*ProviderMetrics{}
+			}
+			resp.Providers[name] = m
+		}
 	}
-	if claudeAcc.n > 0 {
-		m := claudeAcc.metrics()
-		resp.Claude = &m
+	if opts.perQuery != nil {
+		resp.PerQueryDiff, resp.PerQueryTotal = opts.perQuery.apply(st.perQuery)
 	}
-	if wantPerQuery {
-		sort.Slice(perQuery, func(i, j int) bool { return perQuery[i].Time.Before(perQuery[j].Time) })
-		resp.PerQueryDiff = perQuery
+	return resp
+}
+
+// runUsage is the token usage a provider reported for run (nil for older runs)
+func runUsage(run StoredResult, provider string) *Usage {
+	if st := run.Response.Status[provider]; st != nil {
+		return st.Usage
 	}
+	return nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(resp)
+func shadowPrediction(run StoredResult) *ParseResponse {
+	if run.Response.OpenAI != nil {
+		return run.Response.OpenAI
+	}
+	return run.Response.Claude
 }
 
 // ===== Internals for metrics =====
 
 type acc struct {
+	// weight of the next run's quality scores; 0 means 1 (see dedup)
+	weight float64
+
 	// micro totals
-	tp int
-	fp int
-	fn int
+	tp float64
+	fp float64
+	fn float64
 
 	// per-query aggregates
-	sumExact int
+	sumExact float64
 	sumJac   float64
 	sumF1    float64
 	sumLat   float64
-	n        int
+	n        float64
 
 	// ambiguity
-	ambAccepted int
-	ambTotal    int
+	ambAccepted float64
+	ambTotal    float64
+	ambSlotOK   float64
+	ambSlots    float64
+	ambScore    float64
+
+	// token usage
+	promptTokens     int
+	completionTokens int
+	nUsage           int
+
+	// tiered routing
+	nTiered    int
+	nEscalated int
+
+	// stage timings of the runs
+	timings timingAcc
+
+	// downstream searches of the answers, see searcheval.go
+	search searchAcc
+
+	// seeded reruns: query+model+seed -> first output
+	seeded  map[string]string
+	nRerun  int
+	nSameAs int
+
+	calib  calibAcc
+	unsup  unsupAcc
+	custom []Metric
+
+	confusion confusionAcc
+
+	// values answered with the wrong polarity, see negation.go
+	flips float64
+
+	// found values with the right required/preferred annotation
+	strengthOK float64
+	strengthN  float64
 
 	// per-slot stats
 	slot map[string]*SlotStats
 }
 
-func newAcc() *acc { return &acc{slot: map[string]*SlotStats{}} }
+func newAcc() *acc {
+	return &acc{slot: map[string]*SlotStats{}, seeded: map[string]string{}, custom: newMetrics(), confusion: confusionAcc{}, timings: timingAcc{}}
+}
+
+func (a *acc) w() float64 {
+	if a.weight == 0 {
+		return 1
+	}
+	return a.weight
+}
 
 func (a *acc) add(q QueryScores, latency int64) {
+	w := a.w()
 	if q.ExactMatch {
-		a.sumExact++
+		a.sumExact += w
+	}
+	a.sumJac += w * q.Jaccard
+	a.sumF1 += w * q.F1
+	a.sumLat += w * float64(latency)
+	a.n += w
+}
+
+func (a *acc) addAmbiguity(pred ParseResponse, item GroundTruthItem) {
+	w := a.w()
+	ok, n, score := ambiguityScore(pred, item)
+	a.ambSlotOK += w * float64(ok)
+	a.ambSlots += w * float64(n)
+	a.ambScore += w * score
+}
+
+func (a *acc) addUsage(u *Usage) {
+	if u == nil {
+		return
+	}
+	a.promptTokens += u.PromptTokens
+	a.completionTokens += u.CompletionTokens
+	a.nUsage++
+}
+
+func (a *acc) addTier(st *ProviderStatus) {
+	if st == nil || st.Tier == "" {
+		return
+	}
+	a.nTiered++
+	if st.Tier == tierEscalated {
+		a.nEscalated++
+	}
+}
+
+func (a *acc) addRepeat(query string, st *ProviderStatus, pred ParseResponse) {
+	if st == nil || st.Repro == nil || st.Repro.Seed == nil {
+		return
+	}
+	key := fmt.Sprintf("%s\x00%s\x00%d", query, st.Repro.Model, *st.Repro.Seed)
+	b, _ := json.Marshal(pred)
+	first, ok := a.seeded[key]
+	if !ok {
+		a.seeded[key] = string(b)
+		return
+	}
+	a.nRerun++
+	if first == string(b) {
+		a.nSameAs++
 	}
-	a.sumJac += q.Jaccard
-	a.sumF1 += q.F1
-	a.sumLat += float64(latency)
-	a.n++
 }
 
 func (a *acc) addSlots(pred ParseResponse, gt ParseResponse) {
 	pSet := flattenWithSlots(pred)
 	gSet := flattenWithSlots(gt)
+	w := a.w()
+	a.unsup.add(pred, gt, w)
+	a.confusion.add(pSet, gSet)
+	for _, m := range a.custom {
+		m.Accumulate(pred, gt)
+	}
 
 	for k := range pSet {
+		if slotNameOf(k) == "unsupported" {
+			continue
+		}
 		if gSet[k] {
-			a.tp++
+			a.tp += w
 			slot := slotNameOf(k)
-			incSlot(a.slot, slot, 1, 0, 0)
+			incSlot(a.slot, slot, w, 0, 0)
 		} else {
-			a.fp++
+			a.fp += w
 			slot := slotNameOf(k)
-			incSlot(a.slot, slot, 0, 1, 0)
+			incSlot(a.slot, slot, 0, w, 0)
 		}
 	}
 	for k := range gSet {
-		if !pSet[k] {
-			a.fn++
+		if !pSet[k] && slotNameOf(k) != "unsupported" {
+			a.fn += w
 			slot := slotNameOf(k)
-			incSlot(a.slot, slot, 0, 0, 1)
+			incSlot(a.slot, slot, 0, 0, w)
 		}
 	}
+	ok, n := strengthAgreement(pred, gt)
+	a.strengthOK += w * float64(ok)
+	a.strengthN += w * float64(n)
+	for _, k := range polarityFlips(pSet, gSet) {
+		a.fp += w * polarityFlipWeight
+		incSlot(a.slot, slotNameOf(k), 0, w*polarityFlipWeight, 0)
+		a.flips += w
+	}
 }
 
 func (a *acc) metrics() ProviderMetrics {
-	prec := safeDiv(a.tp, a.tp+a.fp)
-	rec := safeDiv(a.tp, a.tp+a.fn)
+	prec := fdiv(a.tp, a.tp+a.fp)
+	rec := fdiv(a.tp, a.tp+a.fn)
 	f1 := harm(prec, rec)
 
 	avgExact := 0.0
@@ -254,41 +646,58 @@ func (a *acc) metrics() ProviderMetrics {
 
 	avgLat := 0.0
 	if a.n > 0 {
-		avgExact = float64(a.sumExact) / float64(a.n)
-		avgJac = a.sumJac / float64(a.n)
+		avgExact = a.sumExact / a.n
+		avgJac = a.sumJac / a.n
 
-		avgLat = a.sumLat / float64(a.n)
+		avgLat = a.sumLat / a.n
 	}
 
 	perSlot := map[string]struct {
 		Precision float64 `json:"precision"`
 		Recall    float64 `json:"recall"`
 		F1        float64 `json:"f1"`
-		TP        int     `json:"tp"`
-		FP        int     `json:"fp"`
-		FN        int     `json:"fn"`
+		TP        float64 `json:"tp"`
+		FP        float64 `json:"fp"`
+		FN        float64 `json:"fn"`
 	}{}
 	for slot, s := range a.slot {
-		p := safeDiv(s.TP, s.TP+s.FP)
-		r := safeDiv(s.TP, s.TP+s.FN)
+		p := fdiv(s.TP, s.TP+s.FP)
+		r := fdiv(s.TP, s.TP+s.FN)
 		perSlot[slot] = struct {
 			Precision float64 `json:"precision"`
 			Recall    float64 `json:"recall"`
 			F1        float64 `json:"f1"`
-			TP        int     `json:"tp"`
-			FP        int     `json:"fp"`
-			FN        int     `json:"fn"`
+			TP        float64 `json:"tp"`
+			FP        float64 `json:"fp"`
+			FN        float64 `json:"fn"`
 		}{
 			Precision: round2(p),
 			Recall:    round2(r),
 			F1:        round2(harm(p, r)),
-			TP:        s.TP, FP: s.FP, FN: s.FN,
+			TP:        round2(s.TP), FP: round2(s.FP), FN: round2(s.FN),
 		}
 	}
 
-	ambRate := 0.0
-	if a.ambTotal > 0 {
-		ambRate = float64(a.ambAccepted) / float64(a.ambTotal)
+	ambRate := fdiv(a.ambAccepted, a.ambTotal)
+
+	var custom map[string]any
+	for _, m := range a.custom {
+		if custom == nil {
+			custom = map[string]any{}
+		}
+		custom[m.Name()] = m.Result()
+	}
+
+	var consistency *float64
+	if a.nRerun > 0 {
+		c := round2(safeDiv(a.nSameAs, a.nRerun))
+		consistency = &c
+	}
+
+	var strength *float64
+	if a.strengthN > 0 {
+		s := round2(a.strengthOK / a.strengthN)
+		strength = &s
 	}
 
 	return ProviderMetrics{
@@ -298,13 +707,26 @@ func (a *acc) metrics() ProviderMetrics {
 		ExactMatch:            round2(avgExact),
 		Jaccard:               round2(avgJac),
 		AvgLatencyMS:          round2(avgLat),
-		Count:                 a.n,
+		Count:                 int(math.Round(a.n)),
 		AmbiguityHandlingRate: round2(ambRate),
+		AmbiguitySlotRate:     round2(fdiv(a.ambSlotOK, a.ambSlots)),
+		AmbiguityScore:        round2(fdiv(a.ambScore, a.ambTotal)),
+		AvgPromptTokens:       round2(safeDiv(a.promptTokens, a.nUsage)),
+		AvgCompletionTokens:   round2(safeDiv(a.completionTokens, a.nUsage)),
+		EscalationRate:        round2(safeDiv(a.nEscalated, a.nTiered)),
+		StageLatency:          a.timings.report(),
+		TaskSuccess:           a.search.metrics(),
+		SeedConsistency:       consistency,
+		PolarityFlipRate:      round2(fdiv(a.flips, a.n)),
+		StrengthAccuracy:      strength,
+		Unsupported:           a.unsup.metrics(),
+		Calibration:           a.calib.report(),
+		Custom:                custom,
 		PerSlot:               perSlot,
 	}
 }
 
-func incSlot(m map[string]*SlotStats, slot string, tp, fp, fn int) {
+func incSlot(m map[string]*SlotStats, slot string, tp, fp, fn float64) {
 	s := m[slot]
 	if s == nil {
 		s = &SlotStats{}
@@ -326,14 +748,21 @@ func scoreAgainstGT(pred ParseResponse, gt ParseResponse) QueryScores {
 		}
 	}
 	union := len(pSet) + len(gSet) - inter
+	// a flipped value counts as polarityFlipWeight more false positives,
+	// as in acc.addSlots: precision and Jaccard pay for it, recall doesn't
+	penalty := polarityFlipWeight * len(polarityFlips(pSet, gSet))
+	union += penalty
 
-	prec := safeDiv(inter, len(pSet))
+	prec := safeDiv(inter, len(pSet)+penalty)
 	rec := safeDiv(inter, len(gSet))
 	f1 := harm(prec, rec)
 
 	jac := 0.0
 	if union > 0 {
 		jac = float64(inter) / float64(union)
+	} else {
+		// nothing expected, nothing predicted
+		jac, f1 = 1, 1
 	}
 	return QueryScores{
 		ExactMatch: setsEqual(pSet, gSet),
@@ -343,21 +772,17 @@ func scoreAgainstGT(pred ParseResponse, gt ParseResponse) QueryScores {
 	}
 }
 
-func upsertPerQuery(list []PerQueryCompare, run StoredResult, provider string, s QueryScores, ambiguous bool, acceptable []ParseResponse) []PerQueryCompare {
-	idx := -1
-	for i := range list {
-		if list[i].Query == run.Query && list[i].Time.Equal(run.Time) {
-			idx = i
-			break
-		}
-	}
-	if idx == -1 {
+func upsertPerQuery(list []PerQueryCompare, index map[string]int, run StoredResult, provider string, s QueryScores, ambiguous bool, acceptable []ParseResponse) []PerQueryCompare {
+	key := run.Query + "\x00" + run.Time.Format(time.RFC3339Nano)
+	idx, ok := index[key]
+	if !ok {
 		list = append(list, PerQueryCompare{
 			Query:     run.Query,
 			Ambiguous: ambiguous,
 			Time:      run.Time,
 		})
 		idx = len(list) - 1
+		index[key] = idx
 	}
 	q := list[idx]
 	switch provider {
@@ -368,6 +793,13 @@ func upsertPerQuery(list []PerQueryCompare, run StoredResult, provider string, s
 		s.LatencyMS = run.Latency
 		q.Claude = &s
 	}
+	if q.Providers == nil {
+		q.Providers = map[string]*QueryScores{}
+	}
+	q.Providers[provider] = &s
+	if run.Response.OpenAI != nil && run.Response.Claude != nil {
+		q.Disagree = !setsEqual(flatten(*run.Response.OpenAI), flatten(*run.Response.Claude))
+	}
 	// accepted if any provider matched an acceptable interpretation
 	if ambiguous {
 		accepted := false
@@ -398,69 +830,37 @@ func matchesAnyAcceptable(pred ParseResponse, accepts []ParseResponse) bool {
 	return false
 }
 
+// flatten keys p the way the evaluation scores it, see eval/slots
 func flatten(p ParseResponse) map[string]bool {
-	s := map[string]bool{}
-
-	if p.Location != "" {
-		s["location="+p.Location] = true
-	}
-	if p.Dates.Checkin != "" {
-		s["dates.checkin="+p.Dates.Checkin] = true
-	}
-	if p.Dates.Checkout != "" {
-		s["dates.checkout="+p.Dates.Checkout] = true
-	}
-	if p.Guests.Adults != 0 {
-		s[fmt.Sprintf("guests.adults=%d", p.Guests.Adults)] = true
-	}
-	if p.Guests.Children != 0 {
-		s[fmt.Sprintf("guests.children=%d", p.Guests.Children)] = true
-	}
-	if p.PriceMaxEUR != 0 {
-		s[fmt.Sprintf("price_max_eur=%.0f", p.PriceMaxEUR)] = true
-	}
-	if p.StarsMin != 0 {
-		s[fmt.Sprintf("stars_min=%d", p.StarsMin)] = true
-	}
-	if p.RatingMin != 0 {
-		s[fmt.Sprintf("rating_min=%.1f", p.RatingMin)] = true
-	}
-	s[fmt.Sprintf("family_friendly=%t", p.FamilyFriendly)] = true
+	return slots.Flatten(p.scored())
+}
 
-	addSlice := func(prefix string, arr []string) {
-		for _, v := range arr {
-			v = strings.TrimSpace(v)
-			if v != "" {
-				s[prefix+"="+v] = true
-			}
+// scored is the part of p the evaluation scores
+func (p ParseResponse) scored() slots.Answer {
+	a := slots.Answer{
+		Location: p.Location, DepartureAirports: p.DepartureAirports,
+		DateFlexibilityDays: p.DateFlexibilityDays, Nights: p.Nights, Transport: p.Transport,
+		GroupSize: p.GroupSize, TripPurpose: p.TripPurpose,
+		RoomTypes: p.RoomTypes, RoomViews: p.RoomViews, Accessibility: p.Accessibility,
+		PriceMaxEUR: p.PriceMaxEUR, StarsMin: p.StarsMin, StarsMax: p.StarsMax,
+		RatingMin: p.RatingMin, RatingMax: p.RatingMax, FamilyFriendly: p.FamilyFriendly,
+		UiFilters: map[string][]string{}, ExcludedFilters: map[string][]string{},
+		UnsupportedCriteria: p.UnsupportedCriteria,
+	}
+	a.Dates.Checkin, a.Dates.Checkout = p.Dates.Checkin, p.Dates.Checkout
+	a.Guests.Adults, a.Guests.Children = p.Guests.Adults, p.Guests.Children
+	for _, d := range p.Distances {
+		a.Distances = append(a.Distances, slots.Distance{Reference: d.Reference, Meters: d.Meters})
+	}
+	for _, slot := range uiFilterSlots {
+		if v := *p.UiFilters.Slot(slot); len(v) > 0 {
+			a.UiFilters[slot] = v
 		}
-	}
-	addSlice("ui.meals", p.UiFilters.Meals)
-	addSlice("ui.ratings", p.UiFilters.Ratings)
-	addSlice("ui.hotelTypes", p.UiFilters.HotelTypes)
-	addSlice("ui.hotelfacilities", p.UiFilters.Hotelfacilities)
-	addSlice("ui.poolbeach", p.UiFilters.Poolbeach)
-	addSlice("ui.distanceBeach", p.UiFilters.DistanceBeach)
-	addSlice("ui.travelGroup", p.UiFilters.TravelGroup)
-	addSlice("ui.stars", p.UiFilters.Stars)
-	addSlice("ui.wellness", p.UiFilters.Wellness)
-	addSlice("ui.reference_distance_max", p.UiFilters.ReferenceDistance)
-	addSlice("ui.flex", p.UiFilters.Flex)
-	addSlice("ui.children", p.UiFilters.Children)
-	addSlice("ui.parking", p.UiFilters.Parking)
-	addSlice("ui.freetime", p.UiFilters.Freetime)
-	addSlice("ui.certifications", p.UiFilters.Certifications)
-	addSlice("ui.hotelthemes", p.UiFilters.Hotelthemes)
-	addSlice("ui.hotelBrand", p.UiFilters.HotelBrand)
-	addSlice("ui.hotelinformation", p.UiFilters.Hotelinformation)
-
-	for _, v := range p.UnsupportedCriteria {
-		v = strings.TrimSpace(v)
-		if v != "" {
-			s["unsupported="+v] = true
+		if v := *p.ExcludedFilters.Slot(slot); len(v) > 0 {
+			a.ExcludedFilters[slot] = v
 		}
 	}
-	return s
+	return a
 }
 
 func flattenWithSlots(p ParseResponse) map[string]bool {
@@ -493,6 +893,13 @@ func safeDiv(num, den int) float64 {
 	return float64(num) / float64(den)
 }
 
+func fdiv(num, den float64) float64 {
+	if den == 0 {
+		return 0
+	}
+	return num / den
+}
+
 func harm(p, r float64) float64 {
 	if p+r == 0 {
 		return 0
@@ -0,0 +1,79 @@
+package golden
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// APIClient parses through a running parser's POST /v1/parse and returns
+// the answer of Provider ("openai" or "claude"). A response without that
+// answer, e.g. degraded to the rules parser, is an error: the suite tests
+// the model, not the fallback.
+type APIClient struct {
+	BaseURL  string // e.g. http://localhost:8080
+	Provider string
+	APIKey   string       // sent as Bearer token when set
+	HTTP     *http.Client // default http.DefaultClient
+}
+
+func (c APIClient) Parse(ctx context.Context, query string) (json.RawMessage, error) {
+	provider := c.Provider
+	if provider == "" {
+		provider = "openai"
+	}
+	body, err := json.Marshal(map[string]string{"query_de": query, "provider": provider})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.BaseURL, "/")+"/v1/parse", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(b))
+	}
+	var answers map[string]json.RawMessage
+	if err := json.Unmarshal(b, &answers); err != nil {
+		return nil, err
+	}
+	var status map[string]struct {
+		Code   string `json:"code"`
+		Detail string `json:"detail"`
+	}
+	_ = json.Unmarshal(answers["status"], &status)
+	answer, ok := answers[provider]
+	if !ok || string(answers["degraded"]) == "true" {
+		msg := "the response has no " + provider + " answer"
+		if _, degraded := answers["rules"]; degraded {
+			msg += " (degraded to the rules parser)"
+		}
+		if st, ok := status[provider]; ok && st.Code != "" {
+			msg += ": " + st.Code + ": " + st.Detail
+		}
+		return nil, errors.New(msg)
+	}
+	return answer, nil
+}
@@ -0,0 +1,255 @@
+// Package golden runs the parser's ground-truth suite as Go tests.
+//
+// Each item of a ground-truth file (the format of data/groundtruth.json) is
+// a subtest: the query is parsed by an LLMClient and the answer is
+//
+//   - checked against the item's truth slot by slot, keyed by package
+//     slots like the evaluation; ambiguous items also pass with any of
+//     their acceptable interpretations
+//   - compared with its golden file, golden/<suite>/<query>.json next to
+//     the suite; with Update set the golden files are rewritten instead
+//
+// A test in a team's own module:
+//
+//	var update = flag.Bool("update", false, "rewrite golden files")
+//
+//	func TestGroundTruth(t *testing.T) {
+//		golden.Update = *update
+//		client := golden.APIClient{BaseURL: "http://localhost:8080", Provider: "openai"}
+//		golden.RunSuite(t, client, "testdata/groundtruth.json")
+//	}
+package golden
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"hotelparser/api/eval/slots"
+)
+
+// LLMClient parses one query into the parser's answer (a ParseResponse as
+// JSON)
+type LLMClient interface {
+	Parse(ctx context.Context, query string) (json.RawMessage, error)
+}
+
+// Item is one entry of a ground-truth file
+type Item struct {
+	Query                    string            `json:"query"`
+	Truth                    json.RawMessage   `json:"truth"`
+	Ambiguous                bool              `json:"ambiguous,omitempty"`
+	AcceptableInterpretation []json.RawMessage `json:"acceptable_interpretations,omitempty"`
+}
+
+// Timeout bounds each query's Parse call
+var Timeout = 60 * time.Second
+
+// Update rewrites the golden files with the current answers instead of
+// comparing with them. A set -update flag of the test binary and
+// GOLDEN_UPDATE=1 turn it on too; the package defines no flag itself.
+var Update bool
+
+func updating() bool {
+	if Update || os.Getenv("GOLDEN_UPDATE") == "1" {
+		return true
+	}
+	f := flag.Lookup("update")
+	return f != nil && f.Value.String() == "true"
+}
+
+// RunSuite runs every item of the ground-truth file at suitePath as a
+// subtest of t
+func RunSuite(t *testing.T, client LLMClient, suitePath string) {
+	t.Helper()
+	b, err := os.ReadFile(suitePath)
+	if err != nil {
+		t.Fatalf("reading suite: %v", err)
+	}
+	var items []Item
+	if err := json.Unmarshal(b, &items); err != nil {
+		t.Fatalf("decoding suite %s: %v", suitePath, err)
+	}
+	if len(items) == 0 {
+		t.Fatalf("suite %s has no items", suitePath)
+	}
+	suite := strings.TrimSuffix(filepath.Base(suitePath), filepath.Ext(suitePath))
+	dir := filepath.Join(filepath.Dir(suitePath), "golden", suite)
+	for _, item := range items {
+		t.Run(slug(item.Query), func(t *testing.T) {
+			runItem(t, client, item, filepath.Join(dir, fileName(item.Query)))
+		})
+	}
+}
+
+func runItem(t *testing.T, client LLMClient, item Item, goldenPath string) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	raw, err := client.Parse(ctx, item.Query)
+	if err != nil {
+		t.Fatalf("parse %q: %v", item.Query, err)
+	}
+	got, err := canonical(raw)
+	if err != nil {
+		t.Fatalf("answer is not JSON: %v", err)
+	}
+
+	if missing, extra, err := compareTruth(got, item); err != nil {
+		t.Errorf("truth: %v", err)
+	} else if len(missing)+len(extra) > 0 {
+		t.Errorf("answer disagrees with the truth for %q\n  missing: %s\n  extra:   %s",
+			item.Query, strings.Join(missing, ", "), strings.Join(extra, ", "))
+	}
+
+	if updating() {
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenPath, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(goldenPath)
+	if os.IsNotExist(err) {
+		t.Fatalf("no golden file %s (run with Update set)", goldenPath)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("answer differs from %s (Update accepts it):\n%s", goldenPath, lineDiff(string(want), string(got)))
+	}
+}
+
+// canonical re-encodes JSON with sorted keys and indentation
+func canonical(raw []byte) ([]byte, error) {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// compareTruth lists the slot values the answer misses and adds; an
+// ambiguous item whose answer matches an acceptable interpretation passes
+func compareTruth(answer []byte, item Item) (missing, extra []string, err error) {
+	got, err := scoredSlots(answer)
+	if err != nil {
+		return nil, nil, err
+	}
+	truth, err := scoredSlots(item.Truth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if item.Ambiguous {
+		for _, alt := range item.AcceptableInterpretation {
+			if s, err := scoredSlots(alt); err == nil && equalSets(s, got) {
+				return nil, nil, nil
+			}
+		}
+	}
+	for k := range truth {
+		if !got[k] {
+			missing = append(missing, k)
+		}
+	}
+	for k := range got {
+		if !truth[k] {
+			extra = append(extra, k)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra, nil
+}
+
+// scoredSlots keys an answer like the evaluation
+func scoredSlots(raw []byte) (map[string]bool, error) {
+	var a slots.Answer
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return nil, err
+	}
+	return slots.Flatten(a), nil
+}
+
+func equalSets(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// lineDiff marks the lines only in want with - and only in got with +
+func lineDiff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	inWant, inGot := map[string]int{}, map[string]int{}
+	for _, l := range w {
+		inWant[l]++
+	}
+	for _, l := range g {
+		inGot[l]++
+	}
+	var b strings.Builder
+	for _, l := range w {
+		if inGot[l] > 0 {
+			inGot[l]--
+			continue
+		}
+		fmt.Fprintf(&b, "- %s\n", l)
+	}
+	for _, l := range g {
+		if inWant[l] > 0 {
+			inWant[l]--
+			continue
+		}
+		fmt.Fprintf(&b, "+ %s\n", l)
+	}
+	return b.String()
+}
+
+// slug is a readable subtest name for a query
+func slug(query string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(query) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == 'ä', r == 'ö', r == 'ü', r == 'ß':
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+		if b.Len() >= 60 {
+			break
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// fileName is the golden file of a query: its slug plus a hash, as slugs
+// of different queries can collide
+func fileName(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return slug(query) + "-" + hex.EncodeToString(sum[:4]) + ".json"
+}
@@ -0,0 +1,111 @@
+package golden
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fixedClient map[string]string
+
+func (c fixedClient) Parse(_ context.Context, query string) (json.RawMessage, error) {
+	return json.RawMessage(c[query]), nil
+}
+
+func TestCompareTruth(t *testing.T) {
+	item := Item{
+		Query: "Rom 3 Nächte ab 12.5., 4 Sterne",
+		Truth: json.RawMessage(`{"location": "Rom", "dates": {"checkin": "2026-05-12", "checkout": "2026-05-15"},
+			"nights": 3, "stars_min": 4, "ui_filters": {"meals": ["breakfast"]}}`),
+	}
+	for _, tc := range []struct {
+		name, answer   string
+		missing, extra []string
+	}{
+		// nights is implied by the dates, schema_version isn't scored
+		{"same", `{"schema_version": 1, "location": "Rom", "dates": {"checkin": "2026-05-12", "checkout": "2026-05-15"},
+			"stars_min": 4, "price_max_eur": 0, "ui_filters": {"meals": [" breakfast "], "stars": []}}`, nil, nil},
+		{"differs", `{"location": "Rom", "nights": 3, "stars_min": 5, "price_max_eur": 120.4}`,
+			[]string{"dates.checkin=2026-05-12", "dates.checkout=2026-05-15", "stars_min=4", "ui.meals=breakfast"},
+			[]string{"nights=3", "price_max_eur=120", "stars_min=5"}},
+	} {
+		missing, extra, err := compareTruth([]byte(tc.answer), item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(missing, " ") != strings.Join(tc.missing, " ") || strings.Join(extra, " ") != strings.Join(tc.extra, " ") {
+			t.Errorf("%s: missing %v, extra %v; want %v, %v", tc.name, missing, extra, tc.missing, tc.extra)
+		}
+	}
+}
+
+func TestCompareTruthAmbiguous(t *testing.T) {
+	item := Item{
+		Query:                    "Paris",
+		Truth:                    json.RawMessage(`{"location": "Paris"}`),
+		Ambiguous:                true,
+		AcceptableInterpretation: []json.RawMessage{json.RawMessage(`{"location": "Paris, Texas"}`)},
+	}
+	missing, extra, err := compareTruth([]byte(`{"location": "Paris, Texas"}`), item)
+	if err != nil || len(missing)+len(extra) > 0 {
+		t.Errorf("acceptable interpretation rejected: %v %v %v", missing, extra, err)
+	}
+}
+
+func TestRunSuiteUpdate(t *testing.T) {
+	dir := t.TempDir()
+	suite := filepath.Join(dir, "gt.json")
+	query := "Familienhotel auf Mallorca mit Pool"
+	answer := `{"location": "Mallorca", "ui_filters": {"poolbeach": ["pool"]}}`
+	items, _ := json.Marshal([]Item{{Query: query, Truth: json.RawMessage(answer)}})
+	if err := os.WriteFile(suite, items, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client := fixedClient{query: answer}
+
+	Update = true
+	RunSuite(t, client, suite)
+	Update = false
+	golden := filepath.Join(dir, "golden", "gt", fileName(query))
+	if _, err := os.Stat(golden); err != nil {
+		t.Fatalf("golden file not written: %v", err)
+	}
+	RunSuite(t, client, suite)
+}
+
+func TestAPIClientRejectsDegraded(t *testing.T) {
+	body := `{"openai": {"location": "Rom"}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	client := APIClient{BaseURL: srv.URL, Provider: "openai"}
+
+	got, err := client.Parse(context.Background(), "Rom")
+	if err != nil || string(got) != `{"location": "Rom"}` {
+		t.Fatalf("Parse = %s, %v", got, err)
+	}
+
+	body = `{"degraded": true, "rules": {"location": "Rom"},
+		"status": {"openai": {"status": "error", "code": "timeout", "detail": "no answer within 60s"}}}`
+	_, err = client.Parse(context.Background(), "Rom")
+	if err == nil || !strings.Contains(err.Error(), "degraded") || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("degraded answer accepted or unclear: %v", err)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	a, b := fileName("Rom, 2 Nächte"), fileName("Rom 2 Nächte")
+	if a == b {
+		t.Errorf("queries with the same slug share %s", a)
+	}
+	if !strings.HasPrefix(a, "rom-2-nächte-") {
+		t.Errorf("fileName = %s", a)
+	}
+}
@@ -0,0 +1,152 @@
+// Package slots keys a parse answer the way the evaluation scores it: one
+// "slot=value" key per extracted value, e.g. "location=Rom",
+// "ui.meals=all_inclusive" or "distance.beach=500". The parser's
+// evaluation and the golden test suite both compare answers by these keys.
+package slots
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UI are the ui_filters slots, in the order of the answer schema
+var UI = []string{
+	"meals", "ratings", "hotelTypes", "hotelfacilities", "poolbeach", "distanceBeach",
+	"travelGroup", "stars", "wellness", "reference_distance_max", "flex", "children",
+	"parking", "freetime", "certifications", "hotelthemes", "hotelBrand", "hotelinformation",
+}
+
+// Answer is the scored part of a parse answer; it decodes from the
+// answer's JSON
+type Answer struct {
+	Location          string   `json:"location"`
+	DepartureAirports []string `json:"departure_airports"`
+	Dates             struct {
+		Checkin  string `json:"checkin"`
+		Checkout string `json:"checkout"`
+	} `json:"dates"`
+	DateFlexibilityDays int        `json:"date_flexibility_days"`
+	Nights              int        `json:"nights"`
+	Transport           string     `json:"transport"`
+	GroupSize           int        `json:"group_size"`
+	TripPurpose         string     `json:"trip_purpose"`
+	Distances           []Distance `json:"distances"`
+	RoomTypes           []string   `json:"room_types"`
+	RoomViews           []string   `json:"room_views"`
+	Accessibility       []string   `json:"accessibility"`
+	Guests              struct {
+		Adults   int `json:"adults"`
+		Children int `json:"children"`
+	} `json:"guests"`
+	PriceMaxEUR         float64             `json:"price_max_eur"`
+	StarsMin            int                 `json:"stars_min"`
+	StarsMax            int                 `json:"stars_max"`
+	RatingMin           float64             `json:"rating_min"`
+	RatingMax           float64             `json:"rating_max"`
+	FamilyFriendly      *bool               `json:"family_friendly"`
+	UiFilters           map[string][]string `json:"ui_filters"`
+	ExcludedFilters     map[string][]string `json:"excluded_filters"`
+	UnsupportedCriteria []string            `json:"unsupported_criteria"`
+}
+
+// Distance is a limit to a reference point, in meters
+type Distance struct {
+	Reference string `json:"reference"`
+	Meters    int    `json:"meters"`
+}
+
+// Flatten returns a's keys; unset values have none
+func Flatten(a Answer) map[string]bool {
+	s := map[string]bool{}
+
+	if a.Location != "" {
+		s["location="+a.Location] = true
+	}
+	for _, v := range a.DepartureAirports {
+		s["departure_airports="+v] = true
+	}
+	if a.Dates.Checkin != "" {
+		s["dates.checkin="+a.Dates.Checkin] = true
+	}
+	if a.Dates.Checkout != "" {
+		s["dates.checkout="+a.Dates.Checkout] = true
+	}
+	if a.DateFlexibilityDays != 0 {
+		s[fmt.Sprintf("date_flexibility_days=%d", a.DateFlexibilityDays)] = true
+	}
+	// with both dates, nights is implied by them and not scored twice
+	if !datesSpanNights(a.Dates.Checkin, a.Dates.Checkout) && a.Nights != 0 {
+		s[fmt.Sprintf("nights=%d", a.Nights)] = true
+	}
+	if a.Transport != "" {
+		s["transport="+a.Transport] = true
+	}
+	if a.GroupSize != 0 {
+		s[fmt.Sprintf("group_size=%d", a.GroupSize)] = true
+	}
+	if a.TripPurpose != "" {
+		s["trip_purpose="+a.TripPurpose] = true
+	}
+	for _, d := range a.Distances {
+		s[fmt.Sprintf("distance.%s=%d", d.Reference, d.Meters)] = true
+	}
+	for _, v := range a.RoomTypes {
+		s["room_types="+v] = true
+	}
+	for _, v := range a.RoomViews {
+		s["room_views="+v] = true
+	}
+	for _, v := range a.Accessibility {
+		s["accessibility="+v] = true
+	}
+	if a.Guests.Adults != 0 {
+		s[fmt.Sprintf("guests.adults=%d", a.Guests.Adults)] = true
+	}
+	if a.Guests.Children != 0 {
+		s[fmt.Sprintf("guests.children=%d", a.Guests.Children)] = true
+	}
+	if a.PriceMaxEUR != 0 {
+		s[fmt.Sprintf("price_max_eur=%.0f", a.PriceMaxEUR)] = true
+	}
+	if a.StarsMin != 0 {
+		s[fmt.Sprintf("stars_min=%d", a.StarsMin)] = true
+	}
+	if a.StarsMax != 0 {
+		s[fmt.Sprintf("stars_max=%d", a.StarsMax)] = true
+	}
+	if a.RatingMin != 0 {
+		s[fmt.Sprintf("rating_min=%.1f", a.RatingMin)] = true
+	}
+	if a.RatingMax != 0 {
+		s[fmt.Sprintf("rating_max=%.1f", a.RatingMax)] = true
+	}
+	if a.FamilyFriendly != nil {
+		s[fmt.Sprintf("family_friendly=%t", *a.FamilyFriendly)] = true
+	}
+
+	add := func(prefix string, values []string) {
+		for _, v := range values {
+			if v = strings.TrimSpace(v); v != "" {
+				s[prefix+"="+v] = true
+			}
+		}
+	}
+	for _, slot := range UI {
+		add("excluded."+slot, a.ExcludedFilters[slot])
+		add("ui."+slot, a.UiFilters[slot])
+	}
+	add("unsupported", a.UnsupportedCriteria)
+	return s
+}
+
+// datesSpanNights reports whether checkin and checkout are dates with
+// checkout after checkin
+func datesSpanNights(checkin, checkout string) bool {
+	in, err := time.Parse(time.DateOnly, checkin)
+	if err != nil {
+		return false
+	}
+	out, err := time.Parse(time.DateOnly, checkout)
+	return err == nil && out.After(in)
+}
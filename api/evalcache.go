@@ -0,0 +1,223 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ====== Incremental evaluation cache ======
+// Accumulators are built once from the store and then updated as runs
+// arrive, so GET /v1/evaluations doesn't rescore the whole history. The
+// cache is rebuilt when the ground truth changes (via the API or on disk).
+// With a shared backend, runs stored by other replicas are pulled in by id.
+// Ids are handed out when a run is inserted, not when it commits, so a run
+// can become visible after one with a higher id was already read: the ids
+// skipped over are remembered as holes and read again until they show up,
+// for evalHoleTTL and only among the last evalHoleWindow ids: inserts still
+// in flight are that recent (ids of failed inserts never show up, and gaps
+// left by deleted runs are older). Every tenant has a cache of its own.
+
+const (
+	evalHoleTTL    = time.Minute
+	evalHoleWindow = 100
+)
+
+type evalCache struct {
+	tenant   string
+	mu       sync.Mutex
+	built    bool
+	gtStamp  string
+	gtMap    map[string]GroundTruthItem
+	all      *evalState
+	variants map[string]*evalState
+	lastID   int64               // highest run id seen, for incremental stores
+	holes    map[int64]time.Time // ids below lastID not seen yet, since when
+}
+
+type evalCaches struct {
+	mu sync.Mutex
+	m  map[string]*evalCache
+}
+
+var evals = &evalCaches{m: map[string]*evalCache{}}
+
+// of is the tenant's cache
+func (e *evalCaches) of(tenant string) *evalCache {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := e.m[tenant]
+	if c == nil {
+		c = &evalCache{tenant: tenant}
+		e.m[tenant] = c
+	}
+	return c
+}
+
+// invalidate drops every tenant's accumulators
+func (e *evalCaches) invalidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, c := range e.m {
+		c.invalidate()
+	}
+}
+
+// append stores run and folds it into its tenant's accumulators
+func (e *evalCaches) append(run StoredResult) error {
+	return e.of(run.Tenant).append(run)
+}
+
+// invalidate drops all accumulators; the next read rebuilds them
+func (c *evalCache) invalidate() {
+	c.mu.Lock()
+	c.built = false
+	c.mu.Unlock()
+}
+
+// append stores run and folds it into the accumulators. Both happen under
+// mu, so a rebuild scans the store either before the run is in it (and the
+// run is folded here) or after (and it isn't): never neither, never both.
+// Shared backends skip the lock, their runs are pulled by id on the next
+// snapshot.
+func (c *evalCache) append(run StoredResult) error {
+	if _, ok := store.(incrementalStore); ok {
+		return store.Append(run)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := store.Append(run); err != nil {
+		return err
+	}
+	if c.built {
+		run.Raw = nil
+		c.fold(run)
+	}
+	return nil
+}
+
+// snapshot returns the overall metrics, or per-variant metrics when
+// opts.byVariant is set
+func (c *evalCache) snapshot(opts evalOpts) EvalResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.built || c.gtStamp != groundTruthFor(c.tenant).GroundTruthVersion() {
+		c.rebuild()
+	} else {
+		c.catchUp()
+	}
+	if !opts.byVariant {
+		resp := c.all.response(opts)
+		if opts.dedup != "" {
+			resp.Dedup = c.all.dedup(opts.dedup, c.gtMap)
+		}
+		return resp
+	}
+	resp := EvalResponse{Variants: map[string]EvalResponse{}}
+	for name, st := range c.variants {
+		v := st.response(opts)
+		if opts.dedup != "" {
+			v.Dedup = st.dedup(opts.dedup, c.gtMap)
+		}
+		resp.Variants[name] = v
+	}
+	return resp
+}
+
+// rebuild rescans every stored run; callers hold mu
+func (c *evalCache) rebuild() {
+	c.gtStamp = groundTruthFor(c.tenant).GroundTruthVersion()
+	c.gtMap = map[string]GroundTruthItem{}
+	for _, g := range loadGroundTruth(c.tenant) {
+		c.gtMap[g.Query] = g
+	}
+	c.all = newEvalState()
+	c.variants = map[string]*evalState{}
+	c.lastID, c.holes = 0, map[int64]time.Time{}
+	c.built = true
+	if _, ok := store.(incrementalStore); ok {
+		c.catchUp()
+		return
+	}
+	err := store.Each(func(run StoredResult) error {
+		if run.Tenant != c.tenant {
+			return nil
+		}
+		c.fold(run)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] loading results failed: %v", err)
+	}
+}
+
+// catchUp folds in runs stored since the last snapshot; callers hold mu
+func (c *evalCache) catchUp() {
+	inc, ok := store.(incrementalStore)
+	if !ok {
+		return
+	}
+	now, from := time.Now(), c.lastID
+	for id, since := range c.holes {
+		if now.Sub(since) > evalHoleTTL {
+			delete(c.holes, id)
+		} else if id-1 < from {
+			from = id - 1
+		}
+	}
+	err := inc.EachSince(from, func(id int64, run StoredResult) error {
+		if id <= c.lastID {
+			if _, ok := c.holes[id]; !ok {
+				return nil // folded already
+			}
+			delete(c.holes, id)
+		} else {
+			if c.lastID > 0 {
+				for h := max(c.lastID+1, id-evalHoleWindow); h < id; h++ {
+					c.holes[h] = now
+				}
+			}
+			c.lastID = id
+		}
+		if run.Tenant != c.tenant {
+			return nil
+		}
+		c.fold(run)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ERROR] loading results failed: %v", err)
+	}
+	for id := range c.holes {
+		if id <= c.lastID-evalHoleWindow {
+			delete(c.holes, id)
+		}
+	}
+}
+
+// fold adds a run to the overall and its variant's metrics; replays only
+// count under their own variant, they are not live traffic
+func (c *evalCache) fold(run StoredResult) {
+	if run.Replay == nil {
+		c.all.addRun(run, c.gtMap)
+	}
+	c.variant(variantName(run)).addRun(run, c.gtMap)
+}
+
+func (c *evalCache) variant(name string) *evalState {
+	st := c.variants[name]
+	if st == nil {
+		st = newEvalState()
+		c.variants[name] = st
+	}
+	return st
+}
+
+// variantName is the canary variant of a run; runs stored before variants
+// existed (or without one configured) count as "default".
+func variantName(run StoredResult) string {
+	if run.Variant == "" {
+		return "default"
+	}
+	return run.Variant
+}
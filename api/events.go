@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====== Run events ======
+// With EVENTS_BACKEND set, every stored run is published as one JSON
+// message so pipelines and monitoring can consume parser activity without
+// polling. Publishing is async and best-effort: a full buffer drops events
+// rather than slowing down /v1/parse.
+//
+//	EVENTS_BACKEND=nats        EVENTS_URL=nats://localhost:4222
+//	EVENTS_BACKEND=kafka-rest  EVENTS_URL=http://kafka-rest:8082
+//	EVENTS_SUBJECT=parser.runs (NATS subject / Kafka topic)
+
+type RunEvent struct {
+	RunID     string                    `json:"run_id"`
+	Time      time.Time                 `json:"time"`
+	Query     string                    `json:"query"`
+	QueryHash string                    `json:"query_hash"`
+	Variant   string                    `json:"variant,omitempty"`
+	Shadow    bool                      `json:"shadow,omitempty"`
+	LatencyMS int64                     `json:"latency_ms"`
+	CostUSD   *float64                  `json:"cost_usd,omitempty"` // from MODEL_PRICES; left out when no call has a price
+	Outputs   map[string]*ParseResponse `json:"outputs"`
+}
+
+type EventPublisher interface {
+	Publish(ctx context.Context, subject string, payload []byte) error
+}
+
+type eventBus struct {
+	pub     EventPublisher
+	subject string
+	ch      chan []byte
+	dropped atomic.Int64 // publishRun runs on many request goroutines
+}
+
+// events is nil when publishing is disabled
+var events *eventBus
+
+func startEventBus() {
+	backend := strings.ToLower(os.Getenv("EVENTS_BACKEND"))
+	if backend == "" {
+		return
+	}
+	target := os.Getenv("EVENTS_URL")
+	var pub EventPublisher
+	switch backend {
+	case "nats":
+		if target == "" {
+			target = "nats://localhost:4222"
+		}
+		pub = &natsPublisher{url: target}
+	case "kafka-rest":
+		if target == "" {
+			log.Printf("[ERROR] EVENTS_URL missing, events disabled")
+			return
+		}
+		pub = &kafkaRESTPublisher{baseURL: strings.TrimRight(target, "/"), client: &http.Client{Timeout: 10 * time.Second}}
+	default:
+		log.Printf("[ERROR] unknown EVENTS_BACKEND %q, events disabled", backend)
+		return
+	}
+	subject := os.Getenv("EVENTS_SUBJECT")
+	if subject == "" {
+		subject = "parser.runs"
+	}
+	events = &eventBus{pub: pub, subject: subject, ch: make(chan []byte, 1024)}
+	go events.loop()
+	log.Printf("[INFO] publishing run events to %s %s (%s)", backend, target, subject)
+}
+
+func (b *eventBus) publishRun(run StoredResult) {
+	ev := RunEvent{
+		RunID:     run.ID,
+		Time:      run.Time,
+		Query:     run.Query,
+		QueryHash: queryHash(run.Query),
+		Variant:   run.Variant,
+		Shadow:    run.Shadow != nil,
+		LatencyMS: run.Latency,
+		Outputs:   map[string]*ParseResponse{},
+	}
+	if cost, ok := runCost(run.Response); ok {
+		ev.CostUSD = &cost
+	}
+	if run.Response.OpenAI != nil {
+		ev.Outputs["openai"] = run.Response.OpenAI
+	}
+	if run.Response.Claude != nil {
+		ev.Outputs["claude"] = run.Response.Claude
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	select {
+	case b.ch <- payload:
+	default:
+		if n := b.dropped.Add(1); n%100 == 1 {
+			log.Printf("[WARN] event buffer full, dropped %d events so far", n)
+		}
+	}
+}
+
+// runCost estimates what res's provider calls cost; false when none of
+// them has a price
+func runCost(res MultiParseResponse) (float64, bool) {
+	prices, err := modelPrices()
+	if err != nil {
+		return 0, false
+	}
+	cost, priced := 0.0, false
+	for _, st := range res.Status {
+		if st.Usage == nil {
+			continue
+		}
+		model := ""
+		if st.Repro != nil {
+			model = st.Repro.Model
+		}
+		if price, ok := priceFor(prices, model); ok {
+			cost += price.cost(*st.Usage)
+			priced = true
+		}
+	}
+	return math.Round(cost*1e6) / 1e6, priced
+}
+
+func (b *eventBus) loop() {
+	for payload := range b.ch {
+		for attempt := 0; attempt < 3; attempt++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := b.pub.Publish(ctx, b.subject, payload)
+			cancel()
+			if err == nil {
+				break
+			}
+			log.Printf("[ERROR] publishing event failed (attempt %d): %v", attempt+1, err)
+			time.Sleep(time.Duration(attempt+1) * time.Second)
+		}
+	}
+}
+
+// newRunID returns a random 128-bit hex id
+func newRunID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ----- NATS -----
+
+// natsPublisher speaks the plain-text NATS client protocol (CONNECT, PUB,
+// PING/PONG), which is all fire-and-forget publishing needs.
+type natsPublisher struct {
+	url  string
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (n *natsPublisher) Publish(ctx context.Context, subject string, payload []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if d, ok := ctx.Deadline(); ok {
+		_ = n.conn.SetWriteDeadline(d)
+	}
+	msg := fmt.Sprintf("PUB %s %d\r\n", subject, len(payload))
+	if _, err := n.conn.Write(append(append([]byte(msg), payload...), '\r', '\n')); err != nil {
+		n.conn.Close()
+		n.conn = nil
+		return err
+	}
+	return nil
+}
+
+// connect dials the server and answers its PINGs in the background; callers hold mu
+func (n *natsPublisher) connect(ctx context.Context) error {
+	u, err := url.Parse(n.url)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	// Server greets with INFO {...}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q: %v", line, err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "hotelparser"}
+	if u.User != nil {
+		opts["user"] = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			opts["pass"] = p
+		}
+	}
+	b, _ := json.Marshal(opts)
+	if _, err := conn.Write([]byte("CONNECT " + string(b) + "\r\n")); err != nil {
+		conn.Close()
+		return err
+	}
+	n.conn = conn
+
+	go func() {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PING"):
+				n.mu.Lock()
+				if n.conn == conn {
+					_, _ = conn.Write([]byte("PONG\r\n"))
+				}
+				n.mu.Unlock()
+			case strings.HasPrefix(line, "-ERR"):
+				log.Printf("[ERROR] nats: %s", strings.TrimSpace(line))
+			}
+		}
+	}()
+	return nil
+}
+
+// ----- Kafka REST proxy -----
+
+type kafkaRESTPublisher struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (k *kafkaRESTPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	body, _ := json.Marshal(map[string]any{
+		"records": []map[string]json.RawMessage{{"value": payload}},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.baseURL+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	res, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 2048))
+		return fmt.Errorf("kafka-rest: %s: %s", res.Status, b)
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ====== Export ======
+// GET /v1/export/runs and /v1/export/evals stream the stored history row by
+// row for offline analysis (pandas, BigQuery, ...), never holding it in
+// memory:
+//
+//	runs   one stored run per row, as logged
+//	evals  one row per scored answer: run, provider (or shadow), scores
+//
+// format=ndjson (default), csv or parquet, or Accept: text/csv; since/until
+// (RFC 3339) limit the run time. Both are scoped to the caller's tenant
+// and exempt from REQUEST_TIMEOUT; a client that disconnects, or takes
+// longer than exportWriteTimeout to accept the next exportFlushEvery
+// rows, stops the scan. CSV cells holding answers are JSON; Parquet flattens them into one
+// typed column per provider and slot (openai_location, claude_ui_meals,
+// ...), list slots joined with "|", so nothing needs to be parsed again.
+
+const (
+	exportFlushEvery   = 500 // rows between flushes
+	exportWriteTimeout = 30 * time.Second
+)
+
+// EvalRow is one scored answer
+type EvalRow struct {
+	RunID            string    `json:"run_id,omitempty"`
+	Time             time.Time `json:"time"`
+	Query            string    `json:"query"`
+	Provider         string    `json:"provider"`
+	Shadow           bool      `json:"shadow,omitempty"`
+	Variant          string    `json:"variant,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	F1               float64   `json:"f1"`
+	Jaccard          float64   `json:"jaccard"`
+	ExactMatch       bool      `json:"exact_match"`
+	Ambiguous        bool      `json:"ambiguous,omitempty"`
+	Accepted         bool      `json:"accepted,omitempty"` // matched an acceptable interpretation
+	LatencyMS        int64     `json:"latency_ms"`
+	PromptTokens     int       `json:"prompt_tokens,omitempty"`
+	CompletionTokens int       `json:"completion_tokens,omitempty"`
+}
+
+var (
+	runCSVHeader  = []string{"id", "time", "query", "variant", "latency_ms", "prompt_hash", "shadow_provider", "openai", "claude", "status", "aborted"}
+	evalCSVHeader = []string{"run_id", "time", "query", "provider", "shadow", "variant", "model", "f1", "jaccard",
+		"exact_match", "ambiguous", "accepted", "latency_ms", "prompt_tokens", "completion_tokens"}
+)
+
+type exportOpts struct {
+	csv, parquet bool
+	since, until time.Time
+}
+
+// parseExport reads format and time range; errors are client-safe
+func parseExport(r *http.Request) (exportOpts, error) {
+	var o exportOpts
+	v := r.URL.Query()
+	switch v.Get("format") {
+	case "ndjson":
+	case "csv":
+		o.csv = true
+	case "parquet":
+		o.parquet = true
+	case "":
+		o.csv = strings.Contains(r.Header.Get("Accept"), "text/csv")
+	default:
+		return o, fmt.Errorf("unknown format %q (use ndjson, csv or parquet)", v.Get("format"))
+	}
+	for name, dst := range map[string]*time.Time{"since": &o.since, "until": &o.until} {
+		if s := v.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return o, fmt.Errorf("invalid %s %q (use RFC 3339, e.g. 2025-10-01T00:00:00Z)", name, s)
+			}
+			*dst = t
+		}
+	}
+	return o, nil
+}
+
+func (o exportOpts) includes(run StoredResult) bool {
+	return (o.since.IsZero() || !run.Time.Before(o.since)) && (o.until.IsZero() || run.Time.Before(o.until))
+}
+
+// exportStream writes rows as NDJSON, CSV or Parquet and flushes
+// regularly, so the client sees data while the scan is running (Parquet:
+// after each row group)
+type exportStream struct {
+	w    http.ResponseWriter
+	rc   *http.ResponseController
+	enc  *json.Encoder
+	cw   *csv.Writer
+	pq   *parquetWriter
+	rows int
+}
+
+func newExportStream(w http.ResponseWriter, o exportOpts, name string, header []string, cols []parquetColumn) *exportStream {
+	s := &exportStream{w: w, rc: http.NewResponseController(w)}
+	_ = s.rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	if o.parquet {
+		h.Set("Content-Type", "application/vnd.apache.parquet")
+		h.Set("Content-Disposition", `attachment; filename="`+name+`.parquet"`)
+		s.pq, _ = newParquetWriter(w, cols) // a failed write shows up again on the first row
+	} else if o.csv {
+		h.Set("Content-Type", "text/csv; charset=utf-8")
+		h.Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		s.cw = csv.NewWriter(w)
+		_ = s.cw.Write(header)
+	} else {
+		h.Set("Content-Type", "application/x-ndjson")
+		h.Set("Content-Disposition", `attachment; filename="`+name+`.ndjson"`)
+		s.enc = json.NewEncoder(w)
+	}
+	return s
+}
+
+// row writes v as NDJSON, record as CSV or values as Parquet
+func (s *exportStream) row(v any, record func() []string, values func() []any) error {
+	var err error
+	switch {
+	case s.pq != nil:
+		err = s.pq.Write(values())
+		if err == nil && s.pq.rows == 0 {
+			_ = s.rc.Flush() // a row group was written
+		}
+	case s.cw != nil:
+		err = s.cw.Write(record())
+	default:
+		err = s.enc.Encode(v)
+	}
+	if err != nil {
+		return err
+	}
+	if s.rows++; s.rows%exportFlushEvery == 0 {
+		s.flush()
+		_ = s.rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	}
+	return nil
+}
+
+func (s *exportStream) flush() {
+	if s.cw != nil {
+		s.cw.Flush()
+	}
+	if s.pq != nil && s.pq.rows > 0 {
+		return // the rest of the row group is written by close
+	}
+	_ = s.rc.Flush()
+}
+
+// close finishes the export; for Parquet that is the last row group and
+// the footer, which a stopped scan leaves out, so a truncated file doesn't
+// pass for a complete one
+func (s *exportStream) close(scanErr error) {
+	defer s.rc.SetWriteDeadline(time.Time{}) // the connection may serve more requests
+	if s.pq != nil {
+		if scanErr != nil {
+			return
+		}
+		if err := s.pq.Close(); err != nil {
+			log.Printf("[WARN] export: finishing parquet: %v", err)
+		}
+		s.pq = nil
+	}
+	s.flush()
+}
+
+// jsonCell is v as compact JSON, empty for nil
+func jsonCell(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return ""
+	}
+	return string(b)
+}
+
+func exportRunsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	o, err := parseExport(r)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, err.Error()))
+		return
+	}
+	tenant := tenantOf(r.Context())
+	s := newExportStream(w, o, "runs", runCSVHeader, runParquetColumns)
+	err = store.Each(func(run StoredResult) error {
+		if run.Tenant != tenant || !o.includes(run) {
+			return nil
+		}
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		run.Raw = nil
+		return s.row(run, func() []string {
+			shadow := ""
+			if run.Shadow != nil {
+				shadow = run.Shadow.Provider
+			}
+			return []string{run.ID, run.Time.Format(time.RFC3339Nano), run.Query, run.Variant,
+				strconv.FormatInt(run.Latency, 10), run.Response.PromptHash, shadow,
+				jsonCell(run.Response.OpenAI), jsonCell(run.Response.Claude), jsonCell(run.Response.Status),
+				strconv.FormatBool(run.Aborted)}
+		}, func() []any { return runParquetRow(run) })
+	})
+	s.close(err)
+	if err != nil {
+		log.Printf("[WARN] export runs stopped after %d rows: %v", s.rows, err)
+	}
+}
+
+func exportEvalsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	o, err := parseExport(r)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, err.Error()))
+		return
+	}
+	tenant := tenantOf(r.Context())
+	gtMap := map[string]GroundTruthItem{}
+	for _, g := range loadGroundTruth(tenant) {
+		gtMap[g.Query] = g
+	}
+	s := newExportStream(w, o, "evals", evalCSVHeader, evalParquetColumns)
+	err = store.Each(func(run StoredResult) error {
+		item, ok := gtMap[run.Query]
+		if !ok || run.Tenant != tenant || run.Aborted || run.Assisted || !o.includes(run) {
+			return nil
+		}
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		for _, row := range evalRows(run, item) {
+			if err := s.row(row, row.record, row.values); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.close(err)
+	if err != nil {
+		log.Printf("[WARN] export evals stopped after %d rows: %v", s.rows, err)
+	}
+}
+
+// evalRows scores each answer of run against item
+func evalRows(run StoredResult, item GroundTruthItem) []EvalRow {
+	score := func(provider string, pred *ParseResponse, status *ProviderStatus) EvalRow {
+		sc := scoreAgainstGT(*pred, item.Truth)
+		row := EvalRow{RunID: run.ID, Time: run.Time, Query: run.Query, Provider: provider, Variant: run.Variant,
+			F1: sc.F1, Jaccard: sc.Jaccard, ExactMatch: sc.ExactMatch, Ambiguous: item.Ambiguous, LatencyMS: run.Latency}
+		if item.Ambiguous {
+			row.Accepted = matchesAnyAcceptable(*pred, item.AcceptableInterpretation)
+		}
+		if status != nil {
+			if status.LatencyMS > 0 {
+				row.LatencyMS = status.LatencyMS
+			}
+			if status.Repro != nil {
+				row.Model = status.Repro.Model
+			}
+			if u := status.Usage; u != nil {
+				row.PromptTokens, row.CompletionTokens = u.PromptTokens, u.CompletionTokens
+			}
+		}
+		return row
+	}
+
+	if sh := run.Shadow; sh != nil {
+		pred := shadowPrediction(run)
+		if pred == nil {
+			return nil
+		}
+		row := score(sh.Provider, pred, &ProviderStatus{Usage: sh.Usage})
+		row.Shadow, row.Model = true, sh.Model
+		return []EvalRow{row}
+	}
+	var rows []EvalRow
+	if p := run.Response.OpenAI; p != nil {
+		rows = append(rows, score("openai", p, run.Response.Status["openai"]))
+	}
+	if p := run.Response.Claude; p != nil {
+		rows = append(rows, score("claude", p, run.Response.Status["claude"]))
+	}
+	return rows
+}
+
+func (e EvalRow) record() []string {
+	num := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	return []string{e.RunID, e.Time.Format(time.RFC3339Nano), e.Query, e.Provider, strconv.FormatBool(e.Shadow),
+		e.Variant, e.Model, num(e.F1), num(e.Jaccard), strconv.FormatBool(e.ExactMatch),
+		strconv.FormatBool(e.Ambiguous), strconv.FormatBool(e.Accepted), strconv.FormatInt(e.LatencyMS, 10),
+		strconv.Itoa(e.PromptTokens), strconv.Itoa(e.CompletionTokens)}
+}
+
+func (e EvalRow) values() []any {
+	return []any{optString(e.RunID), e.Time, e.Query, e.Provider, e.Shadow, optString(e.Variant), optString(e.Model),
+		e.F1, e.Jaccard, e.ExactMatch, e.Ambiguous, e.Accepted, e.LatencyMS,
+		int64(e.PromptTokens), int64(e.CompletionTokens)}
+}
+
+// ----- Parquet columns -----
+
+var evalParquetColumns = []parquetColumn{
+	{"run_id", pqString, true}, {"time", pqTime, false}, {"query", pqString, false},
+	{"provider", pqString, false}, {"shadow", pqBool, false}, {"variant", pqString, true},
+	{"model", pqString, true}, {"f1", pqFloat64, false}, {"jaccard", pqFloat64, false},
+	{"exact_match", pqBool, false}, {"ambiguous", pqBool, false}, {"accepted", pqBool, false},
+	{"latency_ms", pqInt64, false}, {"prompt_tokens", pqInt64, false}, {"completion_tokens", pqInt64, false},
+}
+
+// runParquetColumns are the run's own columns, then the same block per
+// provider: call status and the answer one slot per column. A provider
+// that wasn't asked or failed has nulls in its answer columns.
+var runParquetColumns = func() []parquetColumn {
+	cols := []parquetColumn{
+		{"id", pqString, true}, {"time", pqTime, false}, {"query", pqString, false},
+		{"variant", pqString, true}, {"latency_ms", pqInt64, false}, {"prompt_hash", pqString, true},
+		{"shadow_provider", pqString, true}, {"aborted", pqBool, false},
+	}
+	for _, p := range []string{"openai", "claude"} {
+		for _, c := range []parquetColumn{
+			{"status", pqString, true}, {"code", pqString, true}, {"model", pqString, true},
+			{"latency_ms", pqInt64, true}, {"prompt_tokens", pqInt64, true}, {"completion_tokens", pqInt64, true},
+			{"location", pqString, true}, {"departure_airports", pqString, true}, {"checkin", pqString, true}, {"checkout", pqString, true},
+			{"date_flexibility_days", pqInt64, true}, {"nights", pqInt64, true}, {"transport", pqString, true},
+			{"room_types", pqString, true}, {"room_views", pqString, true}, {"accessibility", pqString, true},
+			{"adults", pqInt64, true}, {"children", pqInt64, true}, {"group_size", pqInt64, true}, {"trip_purpose", pqString, true},
+			{"distances", pqString, true}, // reference:meters|...
+			{"price_max_eur", pqFloat64, true},
+			{"stars_min", pqInt64, true}, {"stars_max", pqInt64, true},
+			{"rating_min", pqFloat64, true}, {"rating_max", pqFloat64, true}, {"family_friendly", pqBool, true},
+		} {
+			c.name = p + "_" + c.name
+			cols = append(cols, c)
+		}
+		for _, slot := range uiFilterSlots {
+			cols = append(cols, parquetColumn{p + "_ui_" + slot, pqString, true})
+		}
+		cols = append(cols, parquetColumn{p + "_excluded_filters", pqString, true}) // slot:value|...
+		cols = append(cols, parquetColumn{p + "_preferred_filters", pqString, true})
+		cols = append(cols, parquetColumn{p + "_unsupported_criteria", pqString, true})
+	}
+	return cols
+}()
+
+func runParquetRow(run StoredResult) []any {
+	shadow := ""
+	if run.Shadow != nil {
+		shadow = run.Shadow.Provider
+	}
+	row := []any{optString(run.ID), run.Time, run.Query, optString(run.Variant), run.Latency,
+		optString(run.Response.PromptHash), optString(shadow), run.Aborted}
+	answers := map[string]*ParseResponse{"openai": run.Response.OpenAI, "claude": run.Response.Claude}
+	for _, p := range []string{"openai", "claude"} {
+		row = append(row, providerParquetValues(run.Response.Status[p])...)
+		row = append(row, answerParquetValues(answers[p])...)
+	}
+	return row
+}
+
+func providerParquetValues(st *ProviderStatus) []any {
+	vals := make([]any, 6)
+	if st == nil {
+		return vals
+	}
+	vals[0], vals[1], vals[3] = st.Status, optString(st.Code), st.LatencyMS
+	if st.Repro != nil {
+		vals[2] = optString(st.Repro.Model)
+	}
+	if u := st.Usage; u != nil {
+		vals[4], vals[5] = int64(u.PromptTokens), int64(u.CompletionTokens)
+	}
+	return vals
+}
+
+func answerParquetValues(p *ParseResponse) []any {
+	if p == nil {
+		return make([]any, 21+len(uiFilterSlots)+3)
+	}
+	var family any
+	if p.FamilyFriendly != nil {
+		family = *p.FamilyFriendly
+	}
+	var distances []string
+	for _, d := range p.Distances {
+		distances = append(distances, fmt.Sprintf("%s:%d", d.Reference, d.Meters))
+	}
+	vals := []any{p.Location, strings.Join(p.DepartureAirports, "|"),
+		p.Dates.Checkin, p.Dates.Checkout, int64(p.DateFlexibilityDays), int64(p.Nights), p.Transport,
+		strings.Join(p.RoomTypes, "|"), strings.Join(p.RoomViews, "|"), strings.Join(p.Accessibility, "|"),
+		int64(p.Guests.Adults), int64(p.Guests.Children), int64(p.GroupSize), p.TripPurpose, strings.Join(distances, "|"),
+		p.PriceMaxEUR,
+		int64(p.StarsMin), int64(p.StarsMax), p.RatingMin, p.RatingMax, family}
+	var excluded, preferred []string
+	for _, slot := range uiFilterSlots {
+		vals = append(vals, strings.Join(*p.UiFilters.Slot(slot), "|"))
+		for _, v := range *p.ExcludedFilters.Slot(slot) {
+			excluded = append(excluded, slot+":"+v)
+		}
+		for _, v := range *p.PreferredFilters.Slot(slot) {
+			preferred = append(preferred, slot+":"+v)
+		}
+	}
+	return append(vals, strings.Join(excluded, "|"), strings.Join(preferred, "|"), strings.Join(p.UnsupportedCriteria, "|"))
+}
+
+// optString is s, or null when empty
+func optString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
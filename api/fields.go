@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ====== Field selection ======
+// A full answer carries every slot, most of them empty: 18 ui_filters
+// arrays three times over. Clients that render a few slots can slim the
+// answers (openai, claude, rules) of /v1/parse down:
+//
+//	?fields=location,dates,ui_filters.meals   only these fields; a dotted
+//	                                          path picks one nested field
+//	?compact=1                                leave out empty values: null,
+//	                                          "", 0, [] and {} (false stays)
+//
+// Both can be combined. Field names are those of the requested schema
+// version; unknown ones are a 400. schema_version is always kept, and the
+// rest of the response (status, prompt_hash, ...) is left as it is. Like
+// the locale, this only changes the rendering, after localization.
+
+// projection is how the answers in a response are slimmed
+type projection struct {
+	fields  map[string]bool // selected paths; nil = all
+	compact bool
+}
+
+func (p projection) none() bool { return p.fields == nil && !p.compact }
+
+// projectedAnswers are the top-level keys holding answers
+var projectedAnswers = []string{"openai", "claude", "rules"}
+
+// parseProjection reads ?fields= and ?compact=; errors are client-safe
+func parseProjection(r *http.Request, schema int) (projection, error) {
+	v := r.URL.Query()
+	var p projection
+	if s := v.Get("compact"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return p, fmt.Errorf("invalid compact %q (use 1 or 0)", s)
+		}
+		p.compact = b
+	}
+	if s := v.Get("fields"); s != "" {
+		known := answerPaths(schema)
+		p.fields = map[string]bool{}
+		for _, f := range strings.Split(s, ",") {
+			f = strings.TrimSpace(f)
+			if f == "" {
+				continue
+			}
+			if !known[f] {
+				return p, fmt.Errorf("unknown field %q", f)
+			}
+			p.fields[f] = true
+		}
+		if len(p.fields) == 0 {
+			return p, errors.New("fields is empty")
+		}
+	}
+	return p, nil
+}
+
+// answerPaths are the selectable fields of an answer in schema: the JSON
+// names of its fields and, dotted, those of nested objects
+func answerPaths(schema int) map[string]bool {
+	t := reflect.TypeOf(ParseResponse{})
+	if schema == schemaV2 {
+		t = reflect.TypeOf(ParseResponseV2{})
+	}
+	paths := map[string]bool{}
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			paths[prefix+name] = true
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				walk(ft, prefix+name+".")
+			}
+		}
+	}
+	walk(t, "")
+	return paths
+}
+
+// project applies p to the answers in response
+func project(response any, p projection) any {
+	if p.none() {
+		return response
+	}
+	b, err := json.Marshal(response)
+	if err == nil {
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if v, err = decodeOrdered(dec); err == nil {
+			if top, ok := v.(jsonObject); ok {
+				for i, f := range top {
+					if obj, ok := f.Value.(jsonObject); ok && slices.Contains(projectedAnswers, f.Key) {
+						top[i].Value = p.apply(obj, "")
+					}
+				}
+			}
+			if b, err = json.Marshal(v); err == nil {
+				return json.RawMessage(b)
+			}
+		}
+	}
+	log.Printf("[ERROR] projecting the response: %v", err)
+	return response
+}
+
+// apply selects and compacts the fields of obj, found at path prefix
+func (p projection) apply(obj jsonObject, prefix string) jsonObject {
+	out := jsonObject{}
+	for _, f := range obj {
+		path := prefix + f.Key
+		v := f.Value
+		if p.fields != nil && path != "schema_version" && !p.fields[path] {
+			nested, ok := v.(jsonObject)
+			if !ok || !p.selectsUnder(path) {
+				continue
+			}
+			v = p.apply(nested, path+".")
+		} else if nested, ok := v.(jsonObject); ok && p.compact {
+			v = projection{compact: true}.apply(nested, path+".")
+		}
+		if p.compact && emptyJSON(v) {
+			continue
+		}
+		out = append(out, jsonField{Key: f.Key, Value: v})
+	}
+	return out
+}
+
+// selectsUnder reports whether a selected path lies inside path
+func (p projection) selectsUnder(path string) bool {
+	for f := range p.fields {
+		if strings.HasPrefix(f, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// emptyJSON reports the values compact leaves out; zero numbers are "not
+// set" throughout the schema
+func emptyJSON(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case json.Number:
+		f, err := v.Float64()
+		return err == nil && f == 0
+	case []any:
+		return len(v) == 0
+	case jsonObject:
+		return len(v) == 0
+	}
+	return false
+}
+
+// jsonObject is a JSON object that keeps its field order
+type jsonObject []jsonField
+
+type jsonField struct {
+	Key   string
+	Value any
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.Key)
+		v, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// decodeOrdered reads one JSON value, objects as jsonObject
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{Key: k.(string), Value: v})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err = dec.Token()
+		return arr, err
+	case json.Delim('}'), json.Delim(']'):
+		return nil, io.ErrUnexpectedEOF
+	}
+	return tok, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fuzz targets for the path model output takes (see sanitize.go):
+//
+//	go test -run '^$' -fuzz FuzzExtractJSON -fuzztime 1m
+//	go test -run '^$' -fuzz FuzzRepairJSON -fuzztime 1m
+//	go test -run '^$' -fuzz FuzzValidate -fuzztime 1m
+//
+// Plain go test runs the seeds only.
+
+var fuzzSeeds = []string{
+	`{"location":"Berlin","guests":{"adults":2,"children":0}}`,
+	"```json\n{\"location\":\"Rom\",}\n```",
+	`Hier ist das Ergebnis: {"location": "Wien" /* Stadt */, "nights": 3} Viel Spaß!`,
+	`{"unsupported_criteria":["mit \"}\" im Text"],}`,
+	"```\nkein JSON\n```\n{\"stars_min\":4}",
+	`{"dates":{"checkin":"2025-02-30","checkout":"2025-02-01"},"nights":400}`,
+	`{"location":"‮‪Berlin​","departure_airports":["ber","MUC"]}`,
+	"{\"location\":\"\xff\xfe\",\"price_max_eur\":1e308,\"group_size\":-1}",
+	`{"distances":[{"reference":"beach","meters":-5}],"ui_filters":{"meals":["breakfast",""]}}`,
+	`{{{{`,
+	"```json\n{\"a\":",
+	`{"location":"a"}{"location":"b"}`,
+	`{"location":"Rom","guests":{"adults":2},}`,
+}
+
+func FuzzExtractJSON(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		obj, err := extractJSONObject(s)
+		if err != nil {
+			return
+		}
+		if !strings.HasPrefix(obj, "{") || !strings.HasSuffix(obj, "}") {
+			t.Fatalf("extracted %q is not an object", obj)
+		}
+		if !strings.Contains(s, obj) {
+			t.Fatalf("extracted %q is not part of the input", obj)
+		}
+	})
+}
+
+func FuzzRepairJSON(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		fixed := repairJSON(s)
+		if json.Valid([]byte(s)) && fixed != s {
+			t.Fatalf("valid JSON was changed:\n%q\n%q", s, fixed)
+		}
+		if len(fixed) > len(s) {
+			t.Fatalf("repair grew the input from %d to %d bytes", len(s), len(fixed))
+		}
+	})
+}
+
+// FuzzValidate runs a completion through decoding, post-processing and
+// validation, like parseWith does
+func FuzzValidate(f *testing.F) {
+	log.SetOutput(io.Discard)
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		var p ParseResponse
+		out := Completion{Text: s}
+		if err := decodeModelJSON("fuzz", &out, &p); err != nil {
+			return
+		}
+		checkSanitized(t, reflect.ValueOf(p))
+		p.postProcess()
+		if p.Validate() != nil {
+			return
+		}
+		flatten(p)
+		if _, err := json.Marshal(p); err != nil {
+			t.Fatalf("valid answer does not encode: %v", err)
+		}
+	})
+}
+
+// checkSanitized fails on strings sanitizeText would have changed
+func checkSanitized(t *testing.T, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			checkSanitized(t, v.Elem())
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				checkSanitized(t, v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			checkSanitized(t, v.Index(i))
+		}
+	case reflect.String:
+		s := v.String()
+		if !utf8.ValidString(s) || utf8.RuneCountInString(s) > maxModelStringRunes {
+			t.Fatalf("unsanitized string %q", s)
+		}
+		for _, r := range s {
+			if unicode.IsControl(r) || invisibleRune(r) {
+				t.Fatalf("string %q keeps %U", s, r)
+			}
+		}
+	}
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ====== GraphQL ======
+// POST /v1/graphql ({"query", "variables", "operationName"}, or GET with
+// ?query=) is a read-only view of runs, evaluations, ground truth and the
+// Pareto report. The dashboard can fetch nested data in one request, e.g.
+// the worst queries with both answers and their ground truth:
+//
+//	{ evaluation { per_query(sort: "f1", limit: 10) {
+//	    query openai { f1 } claude { f1 }
+//	    latest_run { response { openai { location } claude { location } } }
+//	    truth { truth { location } } } } }
+//
+// Types and field names are those of the JSON responses. Any field of an
+// object can be selected, as can map entries by key ("status { openai {
+// latency_ms } }"); a field without a selection set returns its whole
+// JSON value. A few fields take arguments or link objects:
+//
+//	run(id)                                   a stored run
+//	runs(limit, slow)                         as GET /v1/results
+//	search(q, filter, provider, limit)        as GET /v1/results/search
+//	evaluation(group, dedup, confusion)       as GET /v1/evaluations
+//	  per_query(sort, order, provider,        the per-query list, over all
+//	            filter, limit, offset)        runs
+//	ground_truth(query)                       labelled queries
+//	pareto                                    as GET /v1/evaluations/pareto
+//	<run>.truth, <per_query>.truth            the query's ground truth
+//	<per_query>.latest_run,                   the query's newest run
+//	<ground truth>.latest_run
+//
+// Arguments take the values of the REST parameters. Aliases and variables
+// work; fragments, directives, introspection and mutations do not. A
+// malformed query is a 400 problem; errors while resolving come back in
+// "errors" with the failing top-level field null, as GraphQL does.
+
+const maxGraphQLBody = 64 << 10
+
+// gqlField is a field in a selection set
+type gqlField struct {
+	alias, name string
+	args        map[string]any
+	sel         []gqlField
+}
+
+func (f gqlField) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// gqlResolver resolves a field that isn't read from the parent's JSON
+type gqlResolver struct {
+	args []string
+	fn   func(g *gqlRequest, parent any, args url.Values) (any, error)
+}
+
+// gqlQuery are the top-level fields
+var gqlQuery = map[string]gqlResolver{
+	"run": {[]string{"id"}, func(g *gqlRequest, _ any, v url.Values) (any, error) {
+		run, err := findRun(v.Get("id"))
+		if err != nil || run == nil || run.Tenant != g.tenant {
+			return nil, err
+		}
+		run.Raw = nil // served by /v1/results/{id}/raw
+		return run, nil
+	}},
+	"runs": {[]string{"limit", "slow"}, func(g *gqlRequest, _ any, v url.Values) (any, error) {
+		limit := searchDefaultLimit
+		if s := v.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid limit %q", s)
+			}
+			limit = min(n, searchMaxLimit)
+		}
+		return listRuns(g.tenant, v.Get("slow") == "1", limit)
+	}},
+	"search": {[]string{"q", "filter", "provider", "limit"}, func(g *gqlRequest, _ any, v url.Values) (any, error) {
+		q, err := parseSearch(v)
+		if err != nil {
+			return nil, err
+		}
+		q.Tenant = g.tenant
+		results, err := searchRuns(q)
+		for i := range results {
+			results[i].Raw = nil
+		}
+		return results, err
+	}},
+	"evaluation": {[]string{"group", "dedup", "confusion"}, func(g *gqlRequest, _ any, v url.Values) (any, error) {
+		opts := evalOpts{byVariant: v.Get("group") == "variant", dedup: v.Get("dedup"), confusion: v.Get("confusion") == "1"}
+		if err := validDedupMode(opts.dedup); err != nil {
+			return nil, err
+		}
+		return evals.of(g.tenant).snapshot(opts), nil
+	}},
+	"ground_truth": {[]string{"query"}, func(g *gqlRequest, _ any, v url.Values) (any, error) {
+		items := loadGroundTruth(g.tenant)
+		if q := v.Get("query"); q != "" {
+			items = slices.DeleteFunc(items, func(it GroundTruthItem) bool { return it.Query != q })
+		}
+		return items, nil
+	}},
+	"pareto": {nil, func(g *gqlRequest, _ any, _ url.Values) (any, error) {
+		return paretoReport(g.tenant)
+	}},
+}
+
+var (
+	gqlTruth = gqlResolver{nil, func(g *gqlRequest, parent any, _ url.Values) (any, error) {
+		return g.truthFor(gqlQueryOf(parent)), nil
+	}}
+	gqlLatestRun = gqlResolver{nil, func(g *gqlRequest, parent any, _ url.Values) (any, error) {
+		return g.latestRun(gqlQueryOf(parent))
+	}}
+)
+
+// gqlLinks are the fields resolved instead of read from the JSON, by type
+var gqlLinks = map[reflect.Type]map[string]gqlResolver{
+	reflect.TypeOf(StoredResult{}):    {"truth": gqlTruth},
+	reflect.TypeOf(PerQueryCompare{}): {"truth": gqlTruth, "latest_run": gqlLatestRun},
+	reflect.TypeOf(GroundTruthItem{}): {"latest_run": gqlLatestRun},
+	reflect.TypeOf(EvalResponse{}): {"per_query": {[]string{"sort", "order", "provider", "filter", "limit", "offset"}, func(g *gqlRequest, _ any, v url.Values) (any, error) {
+		v.Set("per_query", "1")
+		o, err := parsePerQuery(v)
+		if err != nil {
+			return nil, err
+		}
+		return evals.of(g.tenant).snapshot(evalOpts{perQuery: o}).PerQueryDiff, nil
+	}}},
+}
+
+func gqlQueryOf(parent any) string {
+	switch p := parent.(type) {
+	case StoredResult:
+		return p.Query
+	case PerQueryCompare:
+		return p.Query
+	case GroundTruthItem:
+		return p.Query
+	}
+	return ""
+}
+
+// gqlRequest is the state of one query; links load what they need once
+type gqlRequest struct {
+	tenant string
+	truth  map[string]*GroundTruthItem
+	latest map[string]*StoredResult
+}
+
+func (g *gqlRequest) truthFor(query string) *GroundTruthItem {
+	if g.truth == nil {
+		g.truth = map[string]*GroundTruthItem{}
+		for _, it := range loadGroundTruth(g.tenant) {
+			g.truth[it.Query] = &it
+		}
+	}
+	return g.truth[query]
+}
+
+func (g *gqlRequest) latestRun(query string) (*StoredResult, error) {
+	if g.latest == nil {
+		g.latest = map[string]*StoredResult{}
+		err := store.Each(func(run StoredResult) error {
+			if run.Tenant == g.tenant && run.Shadow == nil {
+				run.Raw = nil
+				g.latest[run.Query] = &run
+			}
+			return nil
+		})
+		if err != nil {
+			g.latest = nil
+			return nil, err
+		}
+	}
+	return g.latest[query], nil
+}
+
+type gqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+type gqlResponse struct {
+	Data   jsonObject `json:"data"`
+	Errors []gqlError `json:"errors,omitempty"`
+}
+
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		v := r.URL.Query()
+		req.Query, req.OperationName = v.Get("query"), v.Get("operationName")
+		if s := v.Get("variables"); s != "" {
+			if err := decodeGraphQLJSON(strings.NewReader(s), &req.Variables); err != nil {
+				writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid variables: "+err.Error()))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := decodeGraphQLJSON(http.MaxBytesReader(w, r.Body, maxGraphQLBody), &req); err != nil {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid JSON body: "+err.Error()))
+			return
+		}
+	default:
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET or POST"))
+		return
+	}
+	sel, err := parseGraphQL(req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, err.Error()))
+		return
+	}
+
+	g := &gqlRequest{tenant: tenantOf(r.Context())}
+	resp := gqlResponse{Data: jsonObject{}}
+	for _, f := range sel {
+		value, err := g.resolveRoot(f)
+		if err != nil {
+			log.Printf("[WARN] graphql %s: %v", f.name, err)
+			resp.Errors = append(resp.Errors, gqlError{Message: err.Error(), Path: []any{f.key()}})
+		}
+		resp.Data = append(resp.Data, jsonField{Key: f.key(), Value: value})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// decodeGraphQLJSON decodes JSON keeping numbers as json.Number, as
+// literals in the query are
+func decodeGraphQLJSON(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func (g *gqlRequest) resolveRoot(f gqlField) (any, error) {
+	if f.name == "__schema" || f.name == "__type" {
+		return nil, errors.New("introspection is not supported")
+	}
+	res, ok := gqlQuery[f.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q on Query", f.name)
+	}
+	return g.call(res, nil, f)
+}
+
+// call runs a resolver and completes its value with f's selection
+func (g *gqlRequest) call(res gqlResolver, parent any, f gqlField) (any, error) {
+	args, err := gqlArgs(f, res.args)
+	if err != nil {
+		return nil, err
+	}
+	value, err := res.fn(g, parent, args)
+	if err != nil {
+		return nil, err
+	}
+	return g.complete(reflect.ValueOf(value), f)
+}
+
+// gqlArgs renders f's arguments as the REST parameters they stand for
+func gqlArgs(f gqlField, allowed []string) (url.Values, error) {
+	v := url.Values{}
+	for name, arg := range f.args {
+		if !slices.Contains(allowed, name) {
+			return nil, fmt.Errorf("unknown argument %q on %s", name, f.name)
+		}
+		list, ok := arg.([]any)
+		if !ok {
+			list = []any{arg}
+		}
+		for _, a := range list {
+			switch a := a.(type) {
+			case nil:
+			case string:
+				v.Add(name, a)
+			case json.Number:
+				v.Add(name, a.String())
+			case bool:
+				v.Add(name, map[bool]string{true: "1", false: "0"}[a])
+			default:
+				return nil, fmt.Errorf("argument %q must be a scalar or a list of scalars", name)
+			}
+		}
+	}
+	return v, nil
+}
+
+// complete applies f's selection set to v
+func (g *gqlRequest) complete(v reflect.Value, f gqlField) (any, error) {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if f.sel == nil {
+		return v.Interface(), nil
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		out := make([]any, v.Len())
+		for i := range out {
+			var err error
+			if out[i], err = g.complete(v.Index(i), f); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case reflect.Struct, reflect.Map:
+		return g.selectFields(v, f.sel)
+	}
+	return nil, fmt.Errorf("field %q has no subfields", f.name)
+}
+
+func (g *gqlRequest) selectFields(v reflect.Value, sel []gqlField) (jsonObject, error) {
+	out := jsonObject{}
+	for _, f := range sel {
+		var value any
+		var err error
+		if res, ok := gqlLinks[v.Type()][f.name]; ok {
+			value, err = g.call(res, v.Interface(), f)
+		} else if len(f.args) > 0 {
+			err = fmt.Errorf("field %q takes no arguments", f.name)
+		} else if v.Kind() == reflect.Map {
+			if v.Type().Key().Kind() != reflect.String {
+				return nil, fmt.Errorf("field %q: map keys are not selectable", f.name)
+			}
+			value, err = g.complete(v.MapIndex(reflect.ValueOf(f.name).Convert(v.Type().Key())), f)
+		} else if field, ok := jsonFieldByName(v, f.name); ok {
+			value, err = g.complete(field, f)
+		} else {
+			err = fmt.Errorf("unknown field %q on %s", f.name, v.Type().Name())
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, jsonField{Key: f.key(), Value: value})
+	}
+	return out, nil
+}
+
+// jsonFieldByName finds the struct field encoding/json names name, looking into
+// embedded structs
+func jsonFieldByName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+		if sf.Anonymous && tag == "" && sf.Type.Kind() == reflect.Struct {
+			if field, ok := jsonFieldByName(v.Field(i), name); ok {
+				return field, true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = sf.Name
+		}
+		if tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// --- Parsing ---
+
+// gqlParser reads a query document. Commas are whitespace in GraphQL.
+type gqlParser struct {
+	src  string
+	pos  int
+	vars map[string]any // the operation's variables, defaults applied
+}
+
+// parseGraphQL returns the selection set of the operation to run
+func parseGraphQL(src, operation string, vars map[string]any) ([]gqlField, error) {
+	p := &gqlParser{src: src}
+	var sel []gqlField
+	found, ops := false, 0
+	for p.skip(); p.pos < len(p.src); p.skip() {
+		name := ""
+		if p.peek() != '{' {
+			kind := p.name()
+			switch kind {
+			case "query":
+			case "mutation", "subscription":
+				return nil, fmt.Errorf("%ss are not supported: the API is read-only", kind)
+			case "fragment":
+				return nil, errors.New("fragments are not supported")
+			default:
+				return nil, p.errorf("expected an operation")
+			}
+			if c := p.peek(); c != '{' && c != '(' {
+				name = p.name()
+			}
+		}
+		ops++
+		p.vars = map[string]any{}
+		for k, v := range vars {
+			p.vars[k] = v
+		}
+		if p.peek() == '(' {
+			if err := p.variableDefinitions(); err != nil {
+				return nil, err
+			}
+		}
+		s, err := p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+		if operation == "" || name == operation {
+			if found && operation == "" {
+				return nil, errors.New("the document has several operations; set operationName")
+			}
+			sel, found = s, true
+		}
+	}
+	switch {
+	case ops == 0:
+		return nil, errors.New("query is empty")
+	case !found:
+		return nil, fmt.Errorf("unknown operation %q", operation)
+	}
+	return sel, nil
+}
+
+func (p *gqlParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("syntax error at offset %d: %s", p.pos, fmt.Sprintf(format, a...))
+}
+
+// skip passes whitespace, commas and comments
+func (p *gqlParser) skip() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peek is the next significant byte, 0 at the end
+func (p *gqlParser) peek() byte {
+	p.skip()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *gqlParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+// name reads a name, "" if there is none
+func (p *gqlParser) name() string {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) && isNameByte(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// variableDefinitions reads ($name: Type = default, ...), keeping the
+// defaults of variables the request didn't set; types aren't checked
+func (p *gqlParser) variableDefinitions() error {
+	p.pos++ // (
+	for p.peek() != ')' {
+		if err := p.expect('$'); err != nil {
+			return err
+		}
+		name := p.name()
+		if name == "" {
+			return p.errorf("expected a variable name")
+		}
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if p.peek() == '=' {
+			p.pos++
+			v, err := p.value()
+			if err != nil {
+				return err
+			}
+			if _, set := p.vars[name]; !set {
+				p.vars[name] = v
+			}
+		}
+		if p.peek() == 0 {
+			return p.errorf("unterminated variable definitions")
+		}
+	}
+	p.pos++
+	return nil
+}
+
+// skipType reads a type: Name, [Type], either with a trailing !
+func (p *gqlParser) skipType() error {
+	if p.peek() == '[' {
+		p.pos++
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if err := p.expect(']'); err != nil {
+			return err
+		}
+	} else if p.name() == "" {
+		return p.errorf("expected a type")
+	}
+	if p.peek() == '!' {
+		p.pos++
+	}
+	return nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlField, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	sel := []gqlField{}
+	for p.peek() != '}' {
+		switch p.peek() {
+		case 0:
+			return nil, p.errorf("unterminated selection set")
+		case '.':
+			return nil, errors.New("fragments are not supported")
+		}
+		f := gqlField{name: p.name()}
+		if f.name == "" {
+			return nil, p.errorf("expected a field name")
+		}
+		if p.peek() == ':' {
+			p.pos++
+			f.alias, f.name = f.name, p.name()
+			if f.name == "" {
+				return nil, p.errorf("expected a field name after the alias")
+			}
+		}
+		if p.peek() == '(' {
+			p.pos++
+			f.args = map[string]any{}
+			for p.peek() != ')' {
+				name := p.name()
+				if name == "" {
+					return nil, p.errorf("expected an argument name")
+				}
+				if err := p.expect(':'); err != nil {
+					return nil, err
+				}
+				v, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				f.args[name] = v
+			}
+			p.pos++
+		}
+		if p.peek() == '@' {
+			return nil, errors.New("directives are not supported")
+		}
+		if p.peek() == '{' {
+			s, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			f.sel = s
+		}
+		sel = append(sel, f)
+	}
+	p.pos++
+	return sel, nil
+}
+
+// value reads a value: variables are replaced, numbers are json.Number and
+// enum values strings
+func (p *gqlParser) value() (any, error) {
+	switch c := p.peek(); {
+	case c == '$':
+		p.pos++
+		name := p.name()
+		if name == "" {
+			return nil, p.errorf("expected a variable name")
+		}
+		return p.vars[name], nil
+	case c == '"':
+		if strings.HasPrefix(p.src[p.pos:], `"""`) {
+			return nil, errors.New("block strings are not supported")
+		}
+		end := p.pos + 1
+		for end < len(p.src) && p.src[end] != '"' {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			return nil, p.errorf("unterminated string")
+		}
+		var s string
+		if err := json.Unmarshal([]byte(p.src[p.pos:end+1]), &s); err != nil {
+			return nil, p.errorf("invalid string")
+		}
+		p.pos = end + 1
+		return s, nil
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		n := json.Number(p.src[start:p.pos])
+		if _, err := n.Float64(); err != nil {
+			return nil, p.errorf("invalid number %q", n)
+		}
+		return n, nil
+	case c == '[':
+		p.pos++
+		list := []any{}
+		for p.peek() != ']' {
+			if p.peek() == 0 {
+				return nil, p.errorf("unterminated list")
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		p.pos++
+		return list, nil
+	case c == '{':
+		p.pos++
+		obj := map[string]any{}
+		for p.peek() != '}' {
+			name := p.name()
+			if name == "" {
+				return nil, p.errorf("expected a field name")
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			obj[name] = v
+		}
+		p.pos++
+		return obj, nil
+	case isNameByte(c, true):
+		switch name := p.name(); name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		default:
+			return name, nil
+		}
+	}
+	return nil, p.errorf("expected a value")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ====== Ground truth management ======
+
+// GroundTruthStore holds the labelled queries evaluations are scored against
+type GroundTruthStore interface {
+	GroundTruth() ([]GroundTruthItem, error)
+	// PutGroundTruth upserts items, matched by query
+	PutGroundTruth(items ...GroundTruthItem) error
+	DeleteGroundTruth(query string) (bool, error)
+	// GroundTruthVersion changes whenever the ground truth changes
+	GroundTruthVersion() string
+}
+
+// groundTruth is replaced in main() when the storage backend keeps ground
+// truth itself (e.g. Postgres)
+var groundTruth GroundTruthStore = &fileGroundTruth{path: groundFile}
+
+func loadGroundTruth(tenant string) []GroundTruthItem {
+	items, _ := groundTruthFor(tenant).GroundTruth()
+	return items
+}
+
+// fileGroundTruth keeps ground truth in data/groundtruth.json
+type fileGroundTruth struct {
+	mu   sync.Mutex // serializes read-modify-write cycles
+	path string
+}
+
+func (f *fileGroundTruth) GroundTruth() ([]GroundTruthItem, error) {
+	var items []GroundTruthItem
+	b, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (f *fileGroundTruth) PutGroundTruth(items ...GroundTruthItem) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	all, err := f.GroundTruth()
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		all = upsertGroundTruth(all, it)
+	}
+	return f.write(all)
+}
+
+func (f *fileGroundTruth) DeleteGroundTruth(query string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	all, err := f.GroundTruth()
+	if err != nil {
+		return false, err
+	}
+	kept := all[:0]
+	for _, it := range all {
+		if it.Query != query {
+			kept = append(kept, it)
+		}
+	}
+	if len(kept) == len(all) {
+		return false, nil
+	}
+	return true, f.write(kept)
+}
+
+func (f *fileGroundTruth) GroundTruthVersion() string {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return "none"
+	}
+	return fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+func (f *fileGroundTruth) write(items []GroundTruthItem) error {
+	b, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.path, b, 0644)
+}
+
+// groundTruthHandler: GET lists items, POST upserts one item or an array
+// (matched by query), DELETE ?query=... removes an item.
+func groundTruthHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r.Context())
+	gt := groundTruthFor(tenant)
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		b, err := readBody(w, r)
+		if err != nil {
+			writeProblem(w, inputProblem(err))
+			return
+		}
+		var body json.RawMessage
+		if err := json.Unmarshal(b, &body); err != nil {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid JSON body"))
+			return
+		}
+		// posted items are in the current format: an explicit false stays
+		type postedItem GroundTruthItem
+		var posted []postedItem
+		if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+			if err := json.Unmarshal(body, &posted); err != nil {
+				writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid ground truth items: "+err.Error()))
+				return
+			}
+		} else {
+			var one postedItem
+			if err := json.Unmarshal(body, &one); err != nil {
+				writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid ground truth item: "+err.Error()))
+				return
+			}
+			posted = []postedItem{one}
+		}
+		incoming := make([]GroundTruthItem, len(posted))
+		for i := range posted {
+			incoming[i] = GroundTruthItem(posted[i])
+			it := &incoming[i]
+			it.Schema = dataSchema
+			if strings.TrimSpace(it.Query) == "" {
+				writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "query is required"))
+				return
+			}
+			// labels get the same normalization as model answers (board
+			// codes, airport names, derived dates)
+			it.Truth.postProcess()
+			if err := it.Truth.Validate(); err != nil {
+				writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid truth for "+it.Query+": "+err.Error()))
+				return
+			}
+		}
+		if err := gt.PutGroundTruth(incoming...); err != nil {
+			writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not save ground truth"))
+			return
+		}
+		evals.of(tenant).invalidate()
+
+	case http.MethodDelete:
+		found, err := gt.DeleteGroundTruth(r.URL.Query().Get("query"))
+		if err != nil {
+			writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not save ground truth"))
+			return
+		}
+		if !found {
+			writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "no ground truth for this query"))
+			return
+		}
+		evals.of(tenant).invalidate()
+
+	default:
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET, POST or DELETE"))
+		return
+	}
+
+	items, err := gt.GroundTruth()
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not load ground truth"))
+		return
+	}
+	if items == nil {
+		items = []GroundTruthItem{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(items)
+}
+
+func upsertGroundTruth(items []GroundTruthItem, it GroundTruthItem) []GroundTruthItem {
+	for i := range items {
+		if items[i].Query == it.Query {
+			items[i] = it
+			return items
+		}
+	}
+	return append(items, it)
+}
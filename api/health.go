@@ -0,0 +1,301 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Provider health ======
+// Every provider call is recorded so GET /v1/providers can report whether
+// a provider is worth offering: configured, enabled, its circuit breaker,
+// the error rate over the last healthWindow calls and the last success.
+//
+// The breaker opens after PROVIDER_BREAKER_FAILURES consecutive upstream
+// failures (default 5, "0" = off): HTTP errors, timeouts and network
+// errors, not answers that fail validation. While open, calls fail at once
+// with errCircuitOpen; after PROVIDER_BREAKER_COOLDOWN (default 30s) one
+// call is let through (half-open) and its outcome closes or reopens it.
+
+const healthWindow = 50 // calls in the error rate
+
+var errCircuitOpen = errors.New("circuit breaker open")
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+type providerHealth struct {
+	outcomes    []bool // ring of the last healthWindow calls, true = failed
+	next        int
+	consecutive int
+	openUntil   time.Time
+	probing     bool // a half-open trial call is in flight
+	lastSuccess time.Time
+	lastError   time.Time
+	lastCode    string
+}
+
+var health = struct {
+	sync.Mutex
+	m map[string]*providerHealth
+}{m: map[string]*providerHealth{}}
+
+func healthOf(provider string) *providerHealth {
+	h := health.m[provider]
+	if h == nil {
+		h = &providerHealth{}
+		health.m[provider] = h
+	}
+	return h
+}
+
+func breakerThreshold() int {
+	if os.Getenv("PROVIDER_BREAKER_FAILURES") == "0" {
+		return 0
+	}
+	return envInt("PROVIDER_BREAKER_FAILURES", 5)
+}
+
+// breakerAllow reports errCircuitOpen while provider's breaker is open
+func breakerAllow(provider string) error {
+	health.Lock()
+	defer health.Unlock()
+	h := healthOf(provider)
+	if h.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(h.openUntil) || h.probing {
+		return errCircuitOpen
+	}
+	h.probing = true
+	return nil
+}
+
+// breakerFailure is whether err says something about the provider itself
+func breakerFailure(err error) bool {
+	var pe *parseError
+	switch {
+	case err == nil, errors.As(err, &pe), errors.Is(err, context.Canceled),
+		errors.Is(err, errNotConfigured), errors.Is(err, errProviderDisabled),
+		errors.Is(err, errOverloaded), errors.Is(err, errCircuitOpen):
+		return false
+	}
+	return true
+}
+
+// recordCall notes the outcome of a provider call. Calls that never reached
+// the provider only release a half-open trial.
+func recordCall(provider string, err error) {
+	health.Lock()
+	defer health.Unlock()
+	h := healthOf(provider)
+	h.probing = false
+	var pe *parseError
+	failed := breakerFailure(err)
+	if err != nil && !failed && !errors.As(err, &pe) {
+		return
+	}
+	if len(h.outcomes) < healthWindow {
+		h.outcomes = append(h.outcomes, failed)
+	} else {
+		h.outcomes[h.next] = failed
+		h.next = (h.next + 1) % healthWindow
+	}
+	now := time.Now()
+	if err != nil {
+		h.lastError, h.lastCode = now, classifyProviderError(provider, err).Code
+	} else {
+		h.lastSuccess = now
+	}
+	if !failed {
+		h.consecutive, h.openUntil = 0, time.Time{}
+		return
+	}
+	h.consecutive++
+	if n := breakerThreshold(); n > 0 && h.consecutive >= n {
+		h.openUntil = now.Add(envDuration("PROVIDER_BREAKER_COOLDOWN", 30*time.Second))
+	}
+}
+
+// ProviderHealth is one entry of GET /v1/providers
+type ProviderHealth struct {
+	Name        string     `json:"name"`
+	DisplayName string     `json:"display_name"`
+	Configured  bool       `json:"configured"`
+	Enabled     bool       `json:"enabled"`   // not switched off via the admin API
+	Available   bool       `json:"available"` // configured, enabled and breaker not open
+	Model       string     `json:"model,omitempty"`
+	Breaker     string     `json:"breaker"` // closed, open or half_open
+	RetryAt     *time.Time `json:"retry_at,omitempty"`
+	ErrorRate   float64    `json:"error_rate"`
+	RecentCalls int        `json:"recent_calls"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   *time.Time `json:"last_error,omitempty"`
+	LastCode    string     `json:"last_error_code,omitempty"`
+	TimeoutMS   int64      `json:"timeout_ms"` // of the next parse call, see ADAPTIVE_TIMEOUT
+	// Queue is the concurrency limiter's state, see limiter.go
+	Queue LimiterStats `json:"queue"`
+}
+
+// activeModel is the model a request without a variant would use
+func activeModel(provider string) string {
+	if _, model := providerOverrides(provider); model != "" {
+		return model
+	}
+	cli, err := clientFor(provider, "")
+	if err != nil {
+		return os.Getenv(strings.ToUpper(provider) + "_MODEL")
+	}
+	switch c := cli.(type) {
+	case *OpenAIClient:
+		return c.Model
+	case *ClaudeClient:
+		return c.Model
+	}
+	return ""
+}
+
+func providerHealthReport() []ProviderHealth {
+	out := []ProviderHealth{}
+	for _, p := range []string{"openai", "claude"} {
+		_, err := clientFor(p, "")
+		ph := ProviderHealth{
+			Name:        p,
+			DisplayName: providerDisplayName(p),
+			Configured:  err == nil,
+			Enabled:     isEnabled(p),
+			Model:       activeModel(p),
+			Breaker:     breakerClosed,
+			TimeoutMS:   parseTimeout(p).Milliseconds(),
+			Queue:       limiterStats(p),
+		}
+		health.Lock()
+		h := healthOf(p)
+		failed := 0
+		for _, f := range h.outcomes {
+			if f {
+				failed++
+			}
+		}
+		ph.RecentCalls = len(h.outcomes)
+		ph.ErrorRate = round2(safeDiv(failed, len(h.outcomes)))
+		if !h.openUntil.IsZero() {
+			if t := h.openUntil; time.Now().Before(t) {
+				ph.Breaker, ph.RetryAt = breakerOpen, &t
+			} else {
+				ph.Breaker = breakerHalfOpen
+			}
+		}
+		if !h.lastSuccess.IsZero() {
+			t := h.lastSuccess
+			ph.LastSuccess = &t
+		}
+		if !h.lastError.IsZero() {
+			t := h.lastError
+			ph.LastError, ph.LastCode = &t, h.lastCode
+		}
+		health.Unlock()
+		ph.Available = ph.Configured && ph.Enabled && ph.Breaker != breakerOpen
+		out = append(out, ph)
+	}
+	return out
+}
+
+func providersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(providerHealthReport())
+}
+
+// ----- self-test -----
+// POST /v1/providers/{name}/test sends selfTestQuery through the regular
+// pipeline (prompt, call, extraction, validation) and reports whether it
+// worked; nothing is stored. An optional body {"model": "..."} tests
+// another model. It needs the admin token, and it runs even while the
+// breaker is open, so a passing test closes it.
+
+const selfTestQuery = "Hotel in Berlin für 2 Erwachsene vom 12.10. bis 14.10., Frühstück inklusive"
+
+type SelfTestResult struct {
+	Provider  string         `json:"provider"`
+	Model     string         `json:"model,omitempty"`
+	OK        bool           `json:"ok"`
+	Code      string         `json:"code,omitempty"`
+	Detail    string         `json:"detail,omitempty"`
+	LatencyMS int64          `json:"latency_ms"`
+	Usage     *Usage         `json:"usage,omitempty"`
+	Answer    *ParseResponse `json:"answer,omitempty"`
+}
+
+func providerTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST"))
+		return
+	}
+	provider := r.PathValue("name")
+	if provider != "openai" && provider != "claude" {
+		writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "unknown provider "+provider))
+		return
+	}
+	var req struct {
+		Model string `json:"model"`
+	}
+	b, err := readBody(w, r)
+	if err != nil {
+		writeProblem(w, inputProblem(err))
+		return
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &req); err != nil {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid JSON body"))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestBudget())
+	defer cancel()
+	plan := parsePlan{
+		pipeline:     pipelineFor(nil),
+		systemPrompt: systemPromptFor((*Variant)(nil).promptPath()).Text,
+		query:        selfTestQuery,
+		selfTest:     true,
+	}
+	c := callProvider(ctx, plan, provider, req.Model)
+
+	res := SelfTestResult{Provider: provider, Model: cmp.Or(req.Model, activeModel(provider)), OK: c.err == nil,
+		LatencyMS: c.latency.Milliseconds(), Answer: c.res}
+	if c.repro != nil && c.repro.Model != "" {
+		res.Model = c.repro.Model
+	}
+	if c.usage != (Usage{}) {
+		res.Usage = &c.usage
+	}
+	if c.err != nil {
+		pe := classifyProviderError(providerDisplayName(provider), c.err)
+		res.Code, res.Detail = pe.Code, pe.Detail
+		log.Printf("[WARN] self-test %s failed: %v", provider, c.err)
+	} else {
+		log.Printf("[INFO] self-test %s ok in %dms", provider, res.LatencyMS)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(res)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ====== Filter impact ======
+// A query with a heated pool, a sea view and five stars may leave no hotel
+// at all. With "impact": true in the parse request, each answer gets an
+// estimate of how much every extracted filter narrows the result set, so
+// the UI can warn about over-constrained queries before searching:
+//
+//	"impact": {"openai": {"source": "dataset", "scope": 6, "matches": 0,
+//	  "over_constrained": true, "filters": [
+//	    {"filter": "ui.poolbeach=heated_pool", "remaining": 1, "removed": 0.83, "narrow": true}, ...]}}
+//
+// scope counts the hotels without any filter (location, dates and the other
+// context stay), matches the hotels with all of them. Each filter is counted
+// alone on top of the scope; removed is the share of the scope it takes
+// away, and filters removing at least impactNarrowShare are marked narrow.
+// Filters are the ones the simulator applies (see simulator.go), keyed
+// like the evaluation's slots, narrowest first.
+//
+// The counts come from the search API when SEARCH_API_URL is set (one
+// request per filter plus two, at most searchCountsParallel at a time,
+// within SEARCH_API_TIMEOUT; see searcheval.go), otherwise from the
+// simulator's dataset. Without either, impact is left out. A failing
+// search API is reported in error. Only the first impactMaxFilters filters
+// of an answer are counted, the rest are reported in omitted, so one
+// request can't fan out into hundreds of searches.
+
+const (
+	impactNarrowShare    = 0.8
+	impactMaxFilters     = 12
+	searchCountsParallel = 4
+)
+
+// FilterImpact is the impact estimate for one answer
+type FilterImpact struct {
+	Source          string         `json:"source"`  // "search_api" or "dataset"
+	Scope           int            `json:"scope"`   // hotels without the filters
+	Matches         int            `json:"matches"` // hotels with all filters
+	OverConstrained bool           `json:"over_constrained,omitempty"`
+	Filters         []FilterEffect `json:"filters"`
+	Omitted         int            `json:"omitted,omitempty"` // filters past impactMaxFilters, not counted
+	Error           string         `json:"error,omitempty"`
+}
+
+// FilterEffect is how much one filter narrows the scope
+type FilterEffect struct {
+	Filter    string  `json:"filter"`    // e.g. "ui.poolbeach=heated_pool"
+	Remaining int     `json:"remaining"` // scope hotels passing it
+	Removed   float64 `json:"removed"`   // share of the scope it removes
+	Narrow    bool    `json:"narrow,omitempty"`
+}
+
+// hotelFilter is one filter of an answer
+type hotelFilter struct {
+	key       string // like the evaluation's slots, e.g. "ui.poolbeach=heated_pool"
+	label     string // in German, e.g. "Innenpool"
+	preferred bool   // a ui_filters value that is only a nice-to-have
+	set       func(*ParseResponse)
+}
+
+// on is scope with f set
+func (f hotelFilter) on(scope ParseResponse) ParseResponse {
+	f.set(&scope)
+	return scope
+}
+
+// splitFilters returns a's scope (a without its filters) and its filters
+func splitFilters(a ParseResponse) (ParseResponse, []hotelFilter) {
+	scope := a
+	scope.PriceMaxEUR, scope.StarsMin, scope.StarsMax, scope.RatingMin, scope.RatingMax = 0, 0, 0, 0, 0
+	scope.FamilyFriendly, scope.Distances = nil, nil
+	scope.RoomTypes, scope.RoomViews, scope.Accessibility = nil, nil, nil
+	scope.UiFilters, scope.ExcludedFilters = UiFilters{}, UiFilters{}
+
+	tax, _ := currentTaxonomy()
+	de := outputLocales["de"]
+	var filters []hotelFilter
+	add := func(label string, set func(*ParseResponse)) *hotelFilter {
+		f := hotelFilter{label: label, set: set}
+		for k := range flatten(f.on(ParseResponse{})) {
+			f.key = k
+		}
+		filters = append(filters, f)
+		return &filters[len(filters)-1]
+	}
+	if a.PriceMaxEUR > 0 {
+		add(fmt.Sprintf("bis %v €", de.decimal(a.PriceMaxEUR)), func(p *ParseResponse) { p.PriceMaxEUR = a.PriceMaxEUR })
+	}
+	if a.StarsMin > 0 {
+		add(fmt.Sprintf("ab %d Sterne", a.StarsMin), func(p *ParseResponse) { p.StarsMin = a.StarsMin })
+	}
+	if a.StarsMax > 0 {
+		add(fmt.Sprintf("bis %d Sterne", a.StarsMax), func(p *ParseResponse) { p.StarsMax = a.StarsMax })
+	}
+	if a.RatingMin > 0 {
+		add(fmt.Sprintf("Bewertung ab %v", de.decimal(a.RatingMin)), func(p *ParseResponse) { p.RatingMin = a.RatingMin })
+	}
+	if a.RatingMax > 0 {
+		add(fmt.Sprintf("Bewertung bis %v", de.decimal(a.RatingMax)), func(p *ParseResponse) { p.RatingMax = a.RatingMax })
+	}
+	if a.FamilyFriendly != nil && *a.FamilyFriendly {
+		add("familienfreundlich", func(p *ParseResponse) { p.FamilyFriendly = a.FamilyFriendly })
+	}
+	for _, d := range a.Distances {
+		if d.Meters > 0 {
+			label := fmt.Sprintf("höchstens %d m bis %s", d.Meters, tax.label("distance_reference", d.Reference))
+			add(label, func(p *ParseResponse) { p.Distances = append(p.Distances, d) })
+		}
+	}
+	for _, v := range a.RoomTypes {
+		add(tax.label("room_types", v), func(p *ParseResponse) { p.RoomTypes = append(p.RoomTypes, v) })
+	}
+	for _, v := range a.RoomViews {
+		add(tax.label("room_views", v), func(p *ParseResponse) { p.RoomViews = append(p.RoomViews, v) })
+	}
+	for _, v := range a.Accessibility {
+		add(tax.label("accessibility", v), func(p *ParseResponse) { p.Accessibility = append(p.Accessibility, v) })
+	}
+	for _, slot := range uiFilterSlots {
+		for _, v := range *a.UiFilters.Slot(slot) {
+			f := add(tax.label(slot, v), func(p *ParseResponse) { p.UiFilters.add(slot, v) })
+			f.preferred = slices.Contains(*a.PreferredFilters.Slot(slot), v)
+		}
+		for _, v := range *a.ExcludedFilters.Slot(slot) {
+			add("ohne "+tax.label(slot, v), func(p *ParseResponse) { p.ExcludedFilters.add(slot, v) })
+		}
+	}
+	return scope, filters
+}
+
+// label is the first German phrase for value of slot, or value itself
+func (t Taxonomy) label(slot, value string) string {
+	if synonyms := t[slot][value]; len(synonyms) > 0 {
+		return synonyms[0]
+	}
+	return value
+}
+
+// impactCounter counts the hotels an answer finds, with the search API or
+// else the simulator's dataset; count is nil without either
+func impactCounter(parent context.Context) (source string, count func([]ParseResponse) ([]int, error)) {
+	if url := os.Getenv("SEARCH_API_URL"); url != "" {
+		return "search_api", func(answers []ParseResponse) ([]int, error) {
+			return searchCounts(parent, url, answers)
+		}
+	}
+	hotels, err := simulatorHotels()
+	if err != nil || hotels == nil {
+		return "", nil
+	}
+	return "dataset", func(answers []ParseResponse) ([]int, error) {
+		counts := make([]int, len(answers))
+		for i, a := range answers {
+			for _, h := range hotels {
+				if hotelMatches(h, a) {
+					counts[i]++
+				}
+			}
+		}
+		return counts, nil
+	}
+}
+
+// estimateImpact estimates the filter impact of every answer in results;
+// nil when there is nothing to count with
+func estimateImpact(parent context.Context, results MultiParseResponse) map[string]*FilterImpact {
+	source, count := impactCounter(parent)
+	if count == nil {
+		return nil
+	}
+
+	out := map[string]*FilterImpact{}
+	for name, p := range map[string]*ParseResponse{"openai": results.OpenAI, "claude": results.Claude, "rules": results.Rules} {
+		if p == nil {
+			continue
+		}
+		scope, filters := splitFilters(*p)
+		impact := &FilterImpact{Source: source, Filters: []FilterEffect{}}
+		if len(filters) > impactMaxFilters {
+			impact.Omitted = len(filters) - impactMaxFilters
+			filters = filters[:impactMaxFilters]
+		}
+		answers := []ParseResponse{scope, *p}
+		for _, f := range filters {
+			answers = append(answers, f.on(scope))
+		}
+		out[name] = impact
+		counts, err := count(answers)
+		if err != nil {
+			impact.Error = err.Error()
+			continue
+		}
+		impact.Scope, impact.Matches = counts[0], counts[1]
+		impact.OverConstrained = impact.Scope > 0 && impact.Matches == 0
+		for i, f := range filters {
+			e := FilterEffect{Filter: f.key, Remaining: counts[i+2]}
+			e.Removed = removedShare(e.Remaining, impact.Scope)
+			e.Narrow = e.Removed >= impactNarrowShare
+			impact.Filters = append(impact.Filters, e)
+		}
+		slices.SortStableFunc(impact.Filters, func(a, b FilterEffect) int {
+			if c := cmpFloat(b.Removed, a.Removed); c != 0 {
+				return c
+			}
+			return strings.Compare(a.Filter, b.Filter)
+		})
+	}
+	return out
+}
+
+// removedShare is the share of scope hotels a filter leaving remaining
+// takes away
+func removedShare(remaining, scope int) float64 {
+	if scope == 0 {
+		return 0
+	}
+	return round2(1 - float64(min(remaining, scope))/float64(scope))
+}
+
+// searchCounts asks the search API for the result count of every answer,
+// searchCountsParallel at a time
+func searchCounts(parent context.Context, url string, answers []ParseResponse) ([]int, error) {
+	ctx, cancel := context.WithTimeout(parent, envDuration("SEARCH_API_TIMEOUT", defaultSearchTimeout))
+	defer cancel()
+	counts := make([]int, len(answers))
+	errs := make([]error, len(answers))
+	sem := make(chan struct{}, searchCountsParallel)
+	var wg sync.WaitGroup
+	for i := range answers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			o, err := searchAnswer(ctx, url, &answers[i])
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			counts[i] = o.Count
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ====== Input limits ======
+
+const (
+	defaultMaxBodyBytes  = 64 << 10
+	defaultMaxQueryChars = 1000
+)
+
+// maxBodyBytes caps request bodies (MAX_BODY_BYTES)
+func maxBodyBytes() int64 {
+	return int64(envInt("MAX_BODY_BYTES", defaultMaxBodyBytes))
+}
+
+// maxQueryChars caps query_de in characters (MAX_QUERY_CHARS)
+func maxQueryChars() int {
+	return envInt("MAX_QUERY_CHARS", defaultMaxQueryChars)
+}
+
+// runTag is what a parse request's tag may look like
+var runTag = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// errBodyTooLarge is answered with 413 instead of 400
+var errBodyTooLarge = errors.New("request body too large")
+
+func envInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// envBool is true for "1" or "true"
+func envBool(key string) bool {
+	v := strings.ToLower(os.Getenv(key))
+	return v == "1" || v == "true"
+}
+
+// readBody reads the whole (size-limited) request body and checks that it is
+// valid UTF-8. Errors are client-safe.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	limit := maxBodyBytes()
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return nil, fmt.Errorf("%w (max %d bytes)", errBodyTooLarge, limit)
+		}
+		return nil, errors.New("could not read request body")
+	}
+	if !utf8.Valid(b) {
+		return nil, errors.New("request body is not valid UTF-8")
+	}
+	return b, nil
+}
+
+// decodeParseInput decodes and validates a /v1/parse body: exactly one JSON
+// object with known fields, a non-empty query_de within the length limit and
+// a known provider.
+func decodeParseInput(w http.ResponseWriter, r *http.Request) (parseInput, error) {
+	var input parseInput
+	b, err := readBody(w, r)
+	if err != nil {
+		return input, err
+	}
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 {
+		return input, errors.New("request body is empty")
+	}
+	if trimmed[0] != '{' {
+		return input, errors.New("request body must be a JSON object")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&input); err != nil {
+		return input, jsonInputError(err)
+	}
+	if dec.More() {
+		return input, errors.New("request body must contain a single JSON object")
+	}
+
+	if strings.TrimSpace(input.Query) == "" {
+		return input, errors.New("query_de is required")
+	}
+	if n := utf8.RuneCountInString(input.Query); n > maxQueryChars() {
+		return input, fmt.Errorf("query_de is too long (%d characters, max %d)", n, maxQueryChars())
+	}
+	for _, c := range input.Query {
+		if unicode.IsControl(c) && c != '\n' && c != '\t' && c != '\r' {
+			return input, errors.New("query_de contains control characters")
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(input.Provider)) {
+	case "", "openai", "claude", "both":
+	default:
+		return input, fmt.Errorf("unknown provider %q (use openai, claude or both)", input.Provider)
+	}
+	switch strings.ToLower(strings.TrimSpace(input.Interpretation)) {
+	case "", interpretStrict, interpretAssisted:
+	default:
+		return input, fmt.Errorf("unknown interpretation %q (use strict or assisted)", input.Interpretation)
+	}
+	input.Profile = strings.ToLower(strings.TrimSpace(input.Profile))
+	switch input.Profile {
+	case "", profileAccessibility:
+	default:
+		return input, fmt.Errorf("unknown profile %q (use accessibility or leave it out)", input.Profile)
+	}
+	input.Priority = strings.ToLower(strings.TrimSpace(input.Priority))
+	switch input.Priority {
+	case "", laneInteractive, laneBatch:
+	default:
+		return input, fmt.Errorf("unknown priority %q (use interactive or batch)", input.Priority)
+	}
+	input.Tag = strings.TrimSpace(input.Tag)
+	if input.Tag != "" && !runTag.MatchString(input.Tag) {
+		return input, fmt.Errorf("invalid tag %q (up to 64 letters, digits, ., - and _)", input.Tag)
+	}
+	if err := input.Sampling.validate(providersFor(input.Provider)...); err != nil {
+		return input, err
+	}
+	return input, nil
+}
+
+// jsonInputError turns decoder errors into messages that name the problem
+// without echoing the body
+func jsonInputError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return errors.New("request body must be a JSON object")
+		}
+		return fmt.Errorf("%s must be a %s", typeErr.Field, typeErr.Type.Kind())
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("malformed JSON at byte %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("malformed JSON: unexpected end of body")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Errorf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return errors.New("invalid JSON body")
+}
+
+// inputProblem is the 400 (or 413) problem for a rejected request body
+func inputProblem(err error) *Problem {
+	if errors.Is(err, errBodyTooLarge) {
+		return newProblem(http.StatusRequestEntityTooLarge, codeInvalidInput, err.Error())
+	}
+	return newProblem(http.StatusBadRequest, codeInvalidInput, err.Error())
+}
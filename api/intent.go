@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ====== Intent gate ======
+// Not everything typed into the search box is a hotel search: "Wie ist das
+// Wetter auf Mallorca?" or "Storniere meine Buchung" would otherwise come
+// back as a parse with location Mallorca. With INTENT_GATE set, each
+// query is labeled first:
+//
+//	hotel_search     parsed as usual
+//	booking_support  about an existing booking (cancel, change, invoice)
+//	other            anything else
+//
+// Non-search queries skip the providers. The response has no answers,
+// only the label and a German message for the UI:
+//
+//	{"intent": {"label": "booking_support", "source": "rules",
+//	  "message_de": "Bei Fragen zu einer bestehenden Buchung ..."}}
+//
+// INTENT_GATE=rules labels with the patterns below and costs nothing.
+// INTENT_GATE=llm asks the request's (first) provider with prompt/intent.txt,
+// INTENT_MODEL if set; when that call fails, the rules decide, so the gate
+// never fails a request. Searches carry the label too. Gated queries are
+// logged and counted (hotelparser_intent_gated_total), not stored: there
+// is no answer to evaluate. Replays aren't gated.
+
+const (
+	intentOff            = "off"
+	intentRules          = "rules"
+	intentLLM            = "llm"
+	intentHotelSearch    = "hotel_search"
+	intentBookingSupport = "booking_support"
+	intentOther          = "other"
+	intentPromptFile     = "prompt/intent.txt"
+)
+
+const defaultIntentPrompt = `Du ordnest Eingaben in das Suchfeld eines Hotelportals ein. Antworte nur mit JSON:
+{"intent": "hotel_search" | "booking_support" | "other"}
+
+- hotel_search: jemand sucht eine Unterkunft oder Reise, auch knapp („Rom 3 Nächte“, „Familienhotel mit Pool“). Wünsche wie „kostenlose Stornierung“ gehören zur Suche.
+- booking_support: es geht um eine bestehende Buchung (stornieren, umbuchen, Rechnung, Bestätigung, Erstattung, Kundenkonto).
+- other: alles andere (Wetter, Smalltalk, allgemeine Fragen, Unsinn).
+Im Zweifel hotel_search.`
+
+var intentMessagesDE = map[string]string{
+	intentBookingSupport: "Bei Fragen zu einer bestehenden Buchung hilft dir unser Kundenservice. Hier kannst du nach Hotels suchen.",
+	intentOther:          "Das sieht nicht nach einer Hotelsuche aus. Beschreibe bitte, was für ein Hotel du suchst, z. B. „Familienhotel auf Mallorca mit Pool“.",
+}
+
+// IntentResult is the label of a query
+type IntentResult struct {
+	Label     string `json:"label"`  // hotel_search, booking_support or other
+	Source    string `json:"source"` // rules or llm
+	MessageDE string `json:"message_de,omitempty"`
+}
+
+var (
+	// bookingSupportPattern: an existing booking and what to do with it;
+	// "kostenlose Stornierung" alone is a search filter
+	bookingSupportPattern = regexp.MustCompile(`(?i)\b(?:(?:meine[nmr]?|unsere[nmr]?)\s+(?:buchung|reservierung|bestellung)|(?:buchung|reservierung)\w*\s+(?:\w+\s+)?(?:stornieren|ändern|umbuchen|absagen|verschieben)|storniere|buchungsnummer|buchungsbestätigung|rechnung|rückerstattung|erstattung|kundenservice|kundenkonto|passwort)\b`)
+	// offTopicPattern: subjects no hotel search has, greetings and small talk
+	offTopicPattern = regexp.MustCompile(`(?i)\b(?:wetter\w*|temperatur(?:en)?|regnet|uhrzeit|wie spät|witz|rezept|nachrichten|fußball|aktie\w*|hauptstadt|hallo|guten (?:morgen|tag|abend)|wie geht|wer bist du|danke)\b`)
+	// searchPattern: words of a stay, also at the end of a compound
+	// ("Strandurlaub", "Städtereise", "Kurztrip", "Herbstferien"), so no
+	// word start
+	searchPattern = regexp.MustCompile(`(?i)(?:hotel\w*|unterkunft|unterkünfte|zimmer|urlaub|reise|trip|ferien|übernachtung|nächte|resort|pension|apartment|wohnung|all[- ]?inclusive|wellness\w*|strand|last[- ]?minute)(?:e|en|n|s|es)?\b`)
+)
+
+var gatedQueries = struct {
+	sync.Mutex
+	byIntent map[string]int64
+}{byIntent: map[string]int64{}}
+
+func intentGateMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("INTENT_GATE"))); mode {
+	case intentRules, intentLLM:
+		return mode
+	}
+	return intentOff
+}
+
+func intentPrompt() *loadedPrompt {
+	return cachedPrompt(intentPromptFile, func() string { return promptOrDefault(intentPromptFile, defaultIntentPrompt) })
+}
+
+// ruleIntent labels query with the patterns: support wording wins, then
+// off-topic subjects without any word of a stay; everything else is a
+// search, since most searches are just a destination ("Mallorca", "Ibiza
+// im August") the rules parser can't tell from anything else
+func ruleIntent(query string) string {
+	switch {
+	case bookingSupportPattern.MatchString(query):
+		return intentBookingSupport
+	case searchPattern.MatchString(query):
+		return intentHotelSearch
+	case offTopicPattern.MatchString(query):
+		return intentOther
+	}
+	return intentHotelSearch
+}
+
+// classifyIntent labels query as INTENT_GATE says; nil when the gate is off
+func classifyIntent(ctx context.Context, query, provider string) *IntentResult {
+	mode := intentGateMode()
+	if mode == intentOff {
+		return nil
+	}
+	res := &IntentResult{Label: ruleIntent(query), Source: intentRules}
+	if mode == intentLLM {
+		label, err := llmIntent(ctx, query, provider)
+		if err != nil {
+			log.Printf("[WARN] intent gate: %s failed, using the rules: %v", providerDisplayName(provider), err)
+		} else {
+			res.Label, res.Source = label, intentLLM
+		}
+	}
+	res.MessageDE = intentMessagesDE[res.Label]
+	return res
+}
+
+func llmIntent(ctx context.Context, query, provider string) (string, error) {
+	cli, err := newClientFor(provider, os.Getenv("INTENT_MODEL"))
+	if err != nil {
+		return "", err
+	}
+	release, err := acquireSlot(ctx, provider)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	callCtx, cancel := context.WithTimeout(ctx, providerTimeout(provider))
+	defer cancel()
+	out, err := cli.CompleteJSON(callCtx, intentPrompt().Text, query)
+	if err != nil {
+		return "", err
+	}
+	var answer struct {
+		Intent string `json:"intent"`
+	}
+	if err := decodeModelJSON(providerDisplayName(provider)+" intent", &out, &answer); err != nil {
+		return "", err
+	}
+	switch answer.Intent {
+	case intentHotelSearch, intentBookingSupport, intentOther:
+		return answer.Intent, nil
+	}
+	return "", fmt.Errorf("unknown intent %q", answer.Intent)
+}
+
+// countGated counts a query the gate kept from the providers
+func countGated(intent string) {
+	gatedQueries.Lock()
+	gatedQueries.byIntent[intent]++
+	gatedQueries.Unlock()
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestRuleIntent(t *testing.T) {
+	for _, tc := range []struct{ query, want string }{
+		{"Mallorca", intentHotelSearch},
+		{"Ibiza im August", intentHotelSearch},
+		{"Strandurlaub Kreta", intentHotelSearch},
+		{"Skiurlaub Ischgl", intentHotelSearch},
+		{"Städtereise Prag", intentHotelSearch},
+		{"Kurztrip Wien", intentHotelSearch},
+		{"Herbstferien Ostsee", intentHotelSearch},
+		{"Last Minute Ägypten", intentHotelSearch},
+		{"Familienhotel mit Pool an der Ostsee", intentHotelSearch},
+		{"Rom 3 Nächte ab 12.5.", intentHotelSearch},
+		{"Wellnesswochenende im Harz", intentHotelSearch},
+		{"Ferienwohnung Sylt mit Hund", intentHotelSearch},
+		{"Hotel mit kostenloser Stornierung in Paris", intentHotelSearch},
+		{"Wetter am Strand von Rimini und ein Hotel dort", intentHotelSearch},
+		{"Ölbergstraße Berlin", intentHotelSearch},
+		{"in der Nähe der Hauptstraße", intentHotelSearch},
+		{"Gardasee Anfang Juni zu zweit", intentHotelSearch},
+		{"Dubai 5 Sterne mit Frühstück", intentHotelSearch},
+		{"Deutschsprachige Kinderbetreuung", intentHotelSearch},
+		{"Kunstschnee Garantie Zugspitze", intentHotelSearch},
+		{"Storniere meine Buchung", intentBookingSupport},
+		{"Ich möchte meine Reservierung ändern", intentBookingSupport},
+		{"Wo finde ich meine Rechnung?", intentBookingSupport},
+		{"Buchungsnummer vergessen", intentBookingSupport},
+		{"Wie ist das Wetter auf Mallorca?", intentOther},
+		{"Was ist die Hauptstadt von Australien?", intentOther},
+		{"Hallo, wie geht es dir?", intentOther},
+		{"Erzähl mir einen Witz", intentOther},
+	} {
+		if got := ruleIntent(tc.query); got != tc.want {
+			t.Errorf("ruleIntent(%q) = %s, want %s", tc.query, got, tc.want)
+		}
+	}
+}
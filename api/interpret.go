@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ====== Interpretation policy ======
+// Vague terms ("günstig", "Luxus") go to unsupported_criteria: the model
+// must not guess. In assisted mode an answer's unsupported_criteria are
+// looked up in an interpretation policy instead, and a match sets what the
+// policy says (günstig → price_max_eur 100, Luxus → 5 stars) unless the
+// answer already has one of those fields: the query's own words win, and
+// half an interpretation (stars_min 4 from the query, ui stars 5 from
+// "Luxus") would contradict itself. Every value set that way is listed in
+// "assumptions", so the UI can show and undo it. The policy is
+// prompt/interpretations.json (phrase → fields, reloaded like the prompts)
+// or the built-in default. INTERPRETATION_MODE sets the default mode
+// (strict); requests can send "interpretation": "strict" | "assisted".
+
+const (
+	interpretStrict   = "strict"
+	interpretAssisted = "assisted"
+
+	interpretationsFile = "prompt/interpretations.json"
+)
+
+// Interpretation is what one vague phrase stands for
+type Interpretation struct {
+	PriceMaxEUR float64             `json:"price_max_eur,omitempty"`
+	StarsMin    int                 `json:"stars_min,omitempty"`
+	RatingMin   float64             `json:"rating_min,omitempty"`
+	UiFilters   map[string][]string `json:"ui_filters,omitempty"`
+}
+
+// Assumption is one value set from the policy rather than the query
+type Assumption struct {
+	Phrase string `json:"phrase"` // as it was in unsupported_criteria, or the replaced value
+	Field  string `json:"field"`  // e.g. "price_max_eur", "ui_filters.stars"
+	Value  string `json:"value"`
+}
+
+const defaultInterpretations = `{
+  "günstig": {"price_max_eur": 100},
+  "billig": {"price_max_eur": 80},
+  "preiswert": {"price_max_eur": 100},
+  "nicht zu teuer": {"price_max_eur": 150},
+  "Budget": {"price_max_eur": 80},
+  "Luxus": {"stars_min": 5, "ui_filters": {"stars": ["5"]}},
+  "luxuriös": {"stars_min": 5, "ui_filters": {"stars": ["5"]}},
+  "Luxushotel": {"stars_min": 5, "ui_filters": {"stars": ["5"]}},
+  "gehoben": {"stars_min": 4, "ui_filters": {"stars": ["4"]}},
+  "gut bewertet": {"rating_min": 8, "ui_filters": {"ratings": ["8"]}},
+  "top bewertet": {"rating_min": 9, "ui_filters": {"ratings": ["9"]}}
+}`
+
+var interpretationsMemo struct {
+	sync.Mutex
+	hash   string
+	policy map[string]Interpretation // by normalized phrase
+}
+
+// currentInterpretations returns the policy keyed by normalized phrase
+func currentInterpretations() map[string]Interpretation {
+	p := cachedPrompt(interpretationsFile, func() string {
+		if b, err := readPrompt(interpretationsFile); err == nil {
+			return string(b)
+		}
+		return defaultInterpretations
+	})
+	interpretationsMemo.Lock()
+	defer interpretationsMemo.Unlock()
+	if interpretationsMemo.hash != p.Hash {
+		var raw map[string]Interpretation
+		if err := json.Unmarshal([]byte(p.Text), &raw); err != nil {
+			log.Printf("[ERROR] invalid %s, using the built-in policy: %v", interpretationsFile, err)
+			_ = json.Unmarshal([]byte(defaultInterpretations), &raw)
+		}
+		policy := make(map[string]Interpretation, len(raw))
+		for phrase, in := range raw {
+			policy[normalizePhrase(phrase)] = in
+		}
+		interpretationsMemo.hash, interpretationsMemo.policy = p.Hash, policy
+	}
+	return interpretationsMemo.policy
+}
+
+// interpretationMode is the request's mode, else INTERPRETATION_MODE
+func interpretationMode(requested string) string {
+	mode := strings.ToLower(strings.TrimSpace(requested))
+	if mode == "" {
+		mode = strings.ToLower(os.Getenv("INTERPRETATION_MODE"))
+	}
+	if mode == interpretAssisted {
+		return interpretAssisted
+	}
+	return interpretStrict
+}
+
+// interpret applies the policy to p's unsupported_criteria. Phrases it
+// covers leave unsupported_criteria when their values were set; a phrase
+// whose fields the answer already has stays there.
+func (p *ParseResponse) interpret(policy map[string]Interpretation) {
+	kept := p.UnsupportedCriteria[:0]
+	for _, phrase := range p.UnsupportedCriteria {
+		in, ok := policy[normalizePhrase(phrase)]
+		if !ok || !p.assume(phrase, in) {
+			kept = append(kept, phrase)
+		}
+	}
+	p.UnsupportedCriteria = kept
+}
+
+// assume sets the fields of in, recording each, if p has none of them yet
+func (p *ParseResponse) assume(phrase string, in Interpretation) bool {
+	if in.PriceMaxEUR > 0 && p.PriceMaxEUR != 0 || in.StarsMin > 0 && p.StarsMin != 0 || in.RatingMin > 0 && p.RatingMin != 0 {
+		return false
+	}
+	for slot := range in.UiFilters {
+		if list := p.UiFilters.Slot(slot); list == nil || len(*list) > 0 {
+			return false
+		}
+	}
+	n := len(p.Assumptions)
+	note := func(field, value string) {
+		p.Assumptions = append(p.Assumptions, Assumption{Phrase: phrase, Field: field, Value: value})
+	}
+	if in.PriceMaxEUR > 0 {
+		p.PriceMaxEUR = in.PriceMaxEUR
+		note("price_max_eur", strconv.FormatFloat(in.PriceMaxEUR, 'f', -1, 64))
+	}
+	if in.StarsMin > 0 {
+		p.StarsMin = in.StarsMin
+		note("stars_min", strconv.Itoa(in.StarsMin))
+	}
+	if in.RatingMin > 0 {
+		p.RatingMin = in.RatingMin
+		note("rating_min", strconv.FormatFloat(in.RatingMin, 'f', -1, 64))
+	}
+	for slot, values := range in.UiFilters {
+		for _, v := range values {
+			if p.UiFilters.add(slot, v) {
+				note("ui_filters."+slot, v)
+			}
+		}
+	}
+	return len(p.Assumptions) > n
+}
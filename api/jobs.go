@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// ====== Cluster jobs ======
+// Background jobs that work on shared data must run once per cluster, not
+// once per replica. Replicas on Postgres elect a leader with a
+// session-level advisory lock: the replica holding it runs the scheduled
+// jobs, the others try again every 15s and take over once the leader's
+// database session is gone. With the file and SQLite stores every process
+// is a cluster of its own and leads. Jobs are registered with scheduleJob;
+// a job runs when its replica becomes leader and then every interval while
+// it leads. Whether this replica leads is shown in /v1/admin/runtime.
+
+const leaderRetry = 15 * time.Second
+
+// leaderElector is implemented by backends shared between replicas; it
+// reports leadership changes until ctx ends
+type leaderElector interface {
+	campaign(ctx context.Context, setLeader func(bool))
+}
+
+var election = struct {
+	sync.Mutex
+	leader  bool
+	since   time.Time
+	changed chan struct{} // closed on the next change
+}{changed: make(chan struct{})}
+
+func setLeader(leader bool) {
+	election.Lock()
+	defer election.Unlock()
+	if election.leader == leader {
+		return
+	}
+	election.leader, election.since = leader, time.Now()
+	close(election.changed)
+	election.changed = make(chan struct{})
+	if leader {
+		log.Printf("[INFO] this replica now runs the cluster jobs")
+	} else {
+		log.Printf("[WARN] this replica lost the cluster job lead")
+	}
+}
+
+// leaderState is whether this replica leads, since when, and a channel
+// closed on the next change
+func leaderState() (bool, time.Time, <-chan struct{}) {
+	election.Lock()
+	defer election.Unlock()
+	return election.leader, election.since, election.changed
+}
+
+// startLeaderElection campaigns for the lead on shared stores and takes it
+// right away on the others
+func startLeaderElection() {
+	if e, ok := store.(leaderElector); ok {
+		go e.campaign(context.Background(), setLeader)
+		return
+	}
+	setLeader(true)
+}
+
+// scheduleJob runs fn on the leading replica: when it takes the lead, then
+// every interval
+func scheduleJob(name string, every time.Duration, fn func(context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		due := true
+		for {
+			leader, _, changed := leaderState()
+			if leader && due {
+				ctx, cancel := context.WithTimeout(context.Background(), every)
+				start := time.Now()
+				if err := fn(ctx); err != nil {
+					log.Printf("[ERROR] job %s: %v", name, err)
+				} else {
+					log.Printf("[INFO] job %s done in %s", name, time.Since(start).Round(time.Millisecond))
+				}
+				cancel()
+				due = false
+			}
+			select {
+			case <-ticker.C:
+				due = true
+			case <-changed:
+				due = true
+			}
+		}
+	}()
+}
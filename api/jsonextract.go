@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+// ====== JSON extraction ======
+// Models sometimes wrap the object in ```json fences, add prose around it or
+// emit almost-JSON (trailing commas, comments). extractJSONObject finds the
+// object; repairJSON fixes the harmless syntax slips before strict decoding.
+
+// extractJSONObject returns the outermost balanced JSON object in s that
+// is valid JSON, or that repairJSON makes valid; the caller repairs it. Only
+// when no outer object is usable does a valid nested one win, so a trailing
+// comma in the answer doesn't reduce it to its "guests" object. Braces
+// inside strings are ignored.
+func extractJSONObject(s string) (string, error) {
+	s = stripCodeFences(s)
+	first := ""
+	for i := 0; i < len(s); i++ {
+		if s[i] != '{' {
+			continue
+		}
+		end := matchingBrace(s, i)
+		if end < 0 {
+			continue
+		}
+		candidate := s[i : end+1]
+		if json.Valid([]byte(candidate)) || jsonRepairEnabled() && json.Valid([]byte(repairJSON(candidate))) {
+			return candidate, nil
+		}
+		if first == "" {
+			first = candidate
+		}
+		i = end // the next outer object starts after this one
+	}
+	if first == "" {
+		return "", errors.New("no balanced JSON object found")
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '{' {
+			continue
+		}
+		if end := matchingBrace(s, i); end >= 0 && json.Valid([]byte(s[i:end+1])) {
+			return s[i : end+1], nil
+		}
+	}
+	return first, nil // let the decoder report what's wrong
+}
+
+// matchingBrace returns the index of the '}' closing the '{' at start, or -1
+func matchingBrace(s string, start int) int {
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// stripCodeFences returns the body of the first ``` block that contains an
+// object, or s unchanged if there is none
+func stripCodeFences(s string) string {
+	rest := s
+	for {
+		open := strings.Index(rest, "```")
+		if open < 0 {
+			return s
+		}
+		body := rest[open+3:]
+		// skip the language tag (```json)
+		if nl := strings.IndexByte(body, '\n'); nl >= 0 && !strings.Contains(body[:nl], "{") {
+			body = body[nl+1:]
+		}
+		close := strings.Index(body, "```")
+		if close < 0 {
+			close = len(body) // unterminated fence: take the rest
+		}
+		if strings.Contains(body[:close], "{") {
+			return body[:close]
+		}
+		if close == len(body) {
+			return s
+		}
+		rest = body[close+3:]
+	}
+}
+
+// jsonRepairEnabled: JSON_REPAIR=0 turns the repair pass off
+func jsonRepairEnabled() bool {
+	return os.Getenv("JSON_REPAIR") != "0"
+}
+
+// repairJSON removes // and /* */ comments and trailing commas outside of
+// strings. Anything else is left for the strict decoder to reject.
+func repairJSON(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			b.WriteByte(c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+			b.WriteByte(c)
+		case c == '/' && i+1 < len(s) && s[i+1] == '/':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			if i < len(s) {
+				b.WriteByte('\n')
+			}
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += end + 3
+			}
+		case c == ',':
+			j := i + 1
+			for j < len(s) && strings.IndexByte(" \t\r\n", s[j]) >= 0 {
+				j++
+			}
+			if j < len(s) && (s[j] == '}' || s[j] == ']') {
+				continue // trailing comma
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
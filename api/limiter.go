@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Upstream concurrency limits ======
+// Each provider has a fixed number of call slots (<PROVIDER>_MAX_CONCURRENCY,
+// default 8, "0" = unlimited). A call that finds all slots busy waits up to
+// <PROVIDER>_QUEUE_TIMEOUT (default 2s, "0" = shed immediately) and then
+// fails with errOverloaded, answered as 503 with an estimated wait (see
+// estimatedWait) in Retry-After and estimated_wait_ms.
+//
+// Calls come in two lanes. Interactive calls (parse requests, unless they
+// ask for "priority": "batch") go first: a freed slot always goes to a
+// waiting interactive call before a batch one. Batch calls (replays,
+// cache warm-up, shadow traffic, prompt suggestions and batch parse
+// requests) hold at most <PROVIDER>_BATCH_CONCURRENCY slots (default half,
+// at least 1), so interactive traffic always finds room, and they wait for
+// a slot as long as their context allows instead of the queue timeout.
+// Running calls are never interrupted.
+
+var errOverloaded = errors.New("too many concurrent calls")
+
+const (
+	laneInteractive = "interactive"
+	laneBatch       = "batch"
+)
+
+type laneKey struct{}
+
+// withLane runs the calls made with ctx in lane
+func withLane(ctx context.Context, lane string) context.Context {
+	return context.WithValue(ctx, laneKey{}, lane)
+}
+
+func laneOf(ctx context.Context) string {
+	if lane, _ := ctx.Value(laneKey{}).(string); lane == laneBatch {
+		return laneBatch
+	}
+	return laneInteractive
+}
+
+type limiter struct {
+	size      int // 0 = unlimited
+	batchSize int
+	wait      time.Duration
+
+	mu        sync.Mutex
+	busy      int
+	busyBatch int
+	waiting   []*slotWaiter // FIFO; interactive ones are served first
+	shed      int64         // calls failed with errOverloaded
+}
+
+type slotWaiter struct {
+	batch   bool
+	granted chan struct{}
+}
+
+var limiters = struct {
+	sync.RWMutex
+	m map[string]*limiter
+}{m: map[string]*limiter{}}
+
+// buildLimiters (re)creates the limiters from the environment. Calls holding
+// a slot of a replaced limiter release it there.
+func buildLimiters() {
+	m := map[string]*limiter{}
+	for _, p := range []string{"openai", "claude"} {
+		prefix := strings.ToUpper(p)
+		l := &limiter{wait: 2 * time.Second}
+		if n := envInt(prefix+"_MAX_CONCURRENCY", 8); os.Getenv(prefix+"_MAX_CONCURRENCY") != "0" {
+			l.size = n
+			l.batchSize = min(envInt(prefix+"_BATCH_CONCURRENCY", max(n/2, 1)), n)
+		}
+		if os.Getenv(prefix+"_QUEUE_TIMEOUT") == "0" {
+			l.wait = 0
+		} else {
+			l.wait = envDuration(prefix+"_QUEUE_TIMEOUT", l.wait)
+		}
+		m[p] = l
+	}
+	limiters.Lock()
+	limiters.m = m
+	limiters.Unlock()
+}
+
+// free reports whether a call in the given lane may take a slot now;
+// l.mu is held
+func (l *limiter) free(batch bool) bool {
+	return l.busy < l.size && (!batch || l.busyBatch < l.batchSize)
+}
+
+func (l *limiter) take(batch bool) {
+	l.busy++
+	if batch {
+		l.busyBatch++
+	}
+}
+
+// release frees a slot and hands free slots to waiters, interactive ones
+// first
+func (l *limiter) release(batch bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.busy--
+	if batch {
+		l.busyBatch--
+	}
+	for _, batch := range []bool{false, true} {
+		for i := 0; i < len(l.waiting); {
+			w := l.waiting[i]
+			if w.batch != batch || !l.free(batch) {
+				i++
+				continue
+			}
+			l.take(batch)
+			close(w.granted)
+			l.waiting = slices.Delete(l.waiting, i, i+1)
+		}
+	}
+}
+
+// acquireSlot blocks until provider has a free call slot in ctx's lane, the
+// queue timeout passes (interactive calls) or ctx ends. release must be
+// called when the call is done.
+func acquireSlot(ctx context.Context, provider string) (release func(), err error) {
+	limiters.RLock()
+	l := limiters.m[provider]
+	limiters.RUnlock()
+	if l == nil || l.size == 0 {
+		return func() {}, nil
+	}
+	batch := laneOf(ctx) == laneBatch
+	release = func() { l.release(batch) }
+
+	l.mu.Lock()
+	// calls waiting ahead of this one go first
+	queued := slices.ContainsFunc(l.waiting, func(w *slotWaiter) bool { return !w.batch || batch })
+	if !queued && l.free(batch) {
+		l.take(batch)
+		l.mu.Unlock()
+		return release, nil
+	}
+	if !batch && l.wait <= 0 {
+		l.shed++
+		l.mu.Unlock()
+		return nil, errOverloaded
+	}
+	w := &slotWaiter{batch: batch, granted: make(chan struct{})}
+	l.waiting = append(l.waiting, w)
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !batch {
+		timer := time.NewTimer(l.wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-w.granted:
+		return release, nil
+	case <-timeout:
+		err = errOverloaded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	l.mu.Lock()
+	if i := slices.Index(l.waiting, w); i >= 0 {
+		l.waiting = slices.Delete(l.waiting, i, i+1)
+		if err == errOverloaded {
+			l.shed++
+		}
+		l.mu.Unlock()
+		return nil, err
+	}
+	l.mu.Unlock()
+	// granted while timing out: the call still has its slot and isn't shed
+	if err == errOverloaded {
+		return release, nil
+	}
+	// granted while the client left: pass the slot on
+	release()
+	return nil, err
+}
+
+// LimiterStats is the state of one provider's limiter
+type LimiterStats struct {
+	Slots        int `json:"slots"` // 0 = unlimited
+	Busy         int `json:"busy"`
+	BusyBatch    int `json:"busy_batch"`
+	Waiting      int `json:"waiting"` // interactive calls waiting for a slot
+	WaitingBatch int `json:"waiting_batch"`
+	// Shed counts calls rejected as overloaded since startup
+	Shed            int64 `json:"shed"`
+	EstimatedWaitMS int64 `json:"estimated_wait_ms"`
+}
+
+func limiterStats(provider string) LimiterStats {
+	limiters.RLock()
+	l := limiters.m[provider]
+	limiters.RUnlock()
+	if l == nil {
+		return LimiterStats{}
+	}
+	l.mu.Lock()
+	st := LimiterStats{Slots: l.size, Busy: l.busy, BusyBatch: l.busyBatch, Shed: l.shed}
+	for _, w := range l.waiting {
+		if w.batch {
+			st.WaitingBatch++
+		} else {
+			st.Waiting++
+		}
+	}
+	l.mu.Unlock()
+	st.EstimatedWaitMS = estimatedWait(provider, st).Milliseconds()
+	return st
+}
+
+// estimatedWait is how long a new interactive call would wait for a slot:
+// the interactive calls ahead of it and itself, spread over the slots, at
+// the provider's median call latency (its queue timeout until calls are
+// on record). Zero while a slot is free.
+func estimatedWait(provider string, st LimiterStats) time.Duration {
+	if st.Slots == 0 || st.Busy < st.Slots && st.Waiting == 0 {
+		return 0
+	}
+	typical, n := latencyQuantile(provider, 0.5)
+	if n == 0 {
+		limiters.RLock()
+		if l := limiters.m[provider]; l != nil {
+			typical = l.wait
+		}
+		limiters.RUnlock()
+	}
+	return typical * time.Duration(st.Waiting+1) / time.Duration(st.Slots)
+}
+
+// overloadedProblem adds the estimated wait for the busiest of providers to
+// p, in Retry-After (seconds, at least 1) and estimated_wait_ms
+func overloadedProblem(p *Problem, providers []string) {
+	for _, provider := range providers {
+		p.EstimatedWaitMS = max(p.EstimatedWaitMS, limiterStats(provider).EstimatedWaitMS)
+	}
+	p.RetryAfter = max(int(math.Ceil(float64(p.EstimatedWaitMS)/1000)), 1)
+}
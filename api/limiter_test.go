@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// An overloaded provider must answer 503 with Retry-After even though the
+// rules fallback is on (the default): the backpressure has to reach the
+// client instead of a degraded answer.
+func TestOverloadIsNotRulesFallback(t *testing.T) {
+	t.Setenv("RULES_FALLBACK", "1")
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("OPENAI_BASE_URL", "http://127.0.0.1:1/v1") // never reached
+	t.Setenv("OPENAI_MAX_CONCURRENCY", "1")
+	t.Setenv("OPENAI_QUEUE_TIMEOUT", "0")
+	buildClients()
+	buildLimiters()
+	t.Cleanup(func() { buildClients(); buildLimiters() })
+
+	release, err := acquireSlot(context.Background(), "openai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/parse", strings.NewReader(`{"query_de":"Hotel in Rom mit Pool","provider":"openai"}`))
+	parseHandler(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503; body %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	var p Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != codeOverloaded {
+		t.Errorf("code %q, want %q", p.Code, codeOverloaded)
+	}
+}
+
+func TestFallsBackToRules(t *testing.T) {
+	t.Setenv("RULES_FALLBACK", "1")
+	for code, want := range map[string]bool{
+		codeProviderUnavailable: true,
+		codeTimeout:             true,
+		codeSchemaViolation:     true,
+		codeOverloaded:          false,
+		codeQuotaExceeded:       false,
+		codeClientClosed:        false,
+	} {
+		if got := fallsBackToRules(code); got != want {
+			t.Errorf("fallsBackToRules(%q) = %t, want %t", code, got, want)
+		}
+	}
+	t.Setenv("RULES_FALLBACK", "0")
+	if fallsBackToRules(codeProviderUnavailable) {
+		t.Error("RULES_FALLBACK=0 still falls back")
+	}
+}
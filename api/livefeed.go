@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Live feed ======
+// GET /v1/ws upgrades to a WebSocket that receives every run the tenant
+// stores from then on, scored against the ground truth as it arrives, so
+// quality can be watched during demos and prompt experiments without
+// polling. Each run is one text message:
+//
+//	{"type": "run", "run": {...stored run...},
+//	 "scores": {"openai": {"exact_match", "jaccard", "f1", "latency_ms"}, ...}}
+//
+// scores has one entry per evaluated source (providers and virtual ones)
+// and is left out when the query has no ground truth or the run isn't
+// evaluated (aborted, assisted). Shadow runs and raw captures are not
+// sent. The feed is one-way: client messages other than ping and close are
+// ignored. A client that falls liveQueueSize messages behind is closed
+// (1013) and can reconnect. Browsers are only accepted from CORS_ORIGINS.
+//
+// The RFC 6455 framing is small and written here, like the encoders in
+// wire.go, instead of pulling in a WebSocket library.
+
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	maxLiveClients = 100
+	liveQueueSize  = 64
+	livePing       = 30 * time.Second
+	liveReadLimit  = 4 << 10 // client frames are control frames in practice
+)
+
+// LiveMessage is one message of the live feed
+type LiveMessage struct {
+	Type   string                 `json:"type"`
+	Run    StoredResult           `json:"run"`
+	Scores map[string]QueryScores `json:"scores,omitempty"`
+}
+
+type liveClient struct {
+	tenant string
+	send   chan []byte
+}
+
+var liveFeed = struct {
+	sync.Mutex
+	clients map[*liveClient]bool
+}{clients: map[*liveClient]bool{}}
+
+// publishRun sends run to the tenant's live feed clients
+func publishRun(run StoredResult) {
+	if run.Shadow != nil {
+		return
+	}
+	liveFeed.Lock()
+	var to []*liveClient
+	for c := range liveFeed.clients {
+		if c.tenant == run.Tenant {
+			to = append(to, c)
+		}
+	}
+	liveFeed.Unlock()
+	if len(to) == 0 {
+		return
+	}
+
+	msg := LiveMessage{Type: "run", Run: run}
+	msg.Run.Raw = nil
+	gtMap := map[string]GroundTruthItem{}
+	for _, it := range loadGroundTruth(run.Tenant) {
+		gtMap[it.Query] = it
+	}
+	if gtItem, ok := scorable(run, gtMap); ok {
+		msg.Scores = map[string]QueryScores{}
+		for _, src := range evalSources {
+			if pred, _ := src.answer(run); pred != nil {
+				s := scoreAgainstGT(*pred, gtItem.Truth)
+				if !src.virtual {
+					s.LatencyMS = run.Latency
+				}
+				msg.Scores[src.name] = s
+			}
+		}
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[ERROR] live feed: encoding run %s: %v", run.ID, err)
+		return
+	}
+
+	liveFeed.Lock()
+	defer liveFeed.Unlock()
+	for _, c := range to {
+		if !liveFeed.clients[c] {
+			continue // gone meanwhile
+		}
+		select {
+		case c.send <- b:
+		default:
+			log.Printf("[WARN] live feed: dropping a client of tenant %q that fell behind", c.tenant)
+			delete(liveFeed.clients, c)
+			close(c.send)
+		}
+	}
+}
+
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		writeProblem(w, newProblem(http.StatusUpgradeRequired, codeInvalidInput, "connect with a WebSocket client"))
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "unsupported WebSocket handshake (version 13 required)"))
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !slices.Contains(corsOrigins(), origin) {
+		writeProblem(w, newProblem(http.StatusForbidden, codeInvalidInput, "origin not allowed"))
+		return
+	}
+
+	c := &liveClient{tenant: tenantOf(r.Context()), send: make(chan []byte, liveQueueSize)}
+	liveFeed.Lock()
+	full := len(liveFeed.clients) >= maxLiveClients
+	if !full {
+		liveFeed.clients[c] = true
+	}
+	liveFeed.Unlock()
+	if full {
+		writeProblem(w, newProblem(http.StatusServiceUnavailable, codeOverloaded, "too many live feed clients"))
+		return
+	}
+	defer leaveLiveFeed(c)
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Printf("[ERROR] live feed: hijacking the connection: %v", err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not open the live feed"))
+		return
+	}
+	defer conn.Close()
+	// the server's deadlines don't apply to a hijacked connection
+	_ = conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	log.Printf("[INFO] live feed: client connected (tenant %q)", c.tenant)
+
+	// the reader answers pings and ends the connection on close or error;
+	// control replies are handed to the writer, which owns the connection
+	pongs := make(chan []byte, 4)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readLiveFrames(conn, rw.Reader, pongs)
+	}()
+
+	ping := time.NewTicker(livePing)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				_ = writeWSFrame(conn, wsClose, wsCloseCode(1013, "fell behind"))
+				return
+			}
+			err = writeWSFrame(conn, wsText, msg)
+		case p := <-pongs:
+			err = writeWSFrame(conn, wsPong, p)
+		case <-ping.C:
+			err = writeWSFrame(conn, wsPing, nil)
+		case <-done:
+			_ = writeWSFrame(conn, wsClose, wsCloseCode(1000, ""))
+			log.Printf("[INFO] live feed: client disconnected (tenant %q)", c.tenant)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func leaveLiveFeed(c *liveClient) {
+	liveFeed.Lock()
+	if liveFeed.clients[c] {
+		delete(liveFeed.clients, c)
+		close(c.send)
+	}
+	liveFeed.Unlock()
+}
+
+// headerHas reports whether the comma-separated header name lists token
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// --- Framing ---
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// writeWSFrame writes one unfragmented, unmasked frame
+func writeWSFrame(conn net.Conn, opcode byte, payload []byte) error {
+	b := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, byte(n))
+	case n <= 0xffff:
+		b = binary.BigEndian.AppendUint16(append(b, 126), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint64(append(b, 127), uint64(n))
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := conn.Write(append(b, payload...))
+	return err
+}
+
+func wsCloseCode(code uint16, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, code), reason...)
+}
+
+// readLiveFrames reads client frames until the connection closes, goes
+// quiet for two ping intervals or sends a close frame. Ping payloads are
+// passed to pongs.
+func readLiveFrames(conn net.Conn, r *bufio.Reader, pongs chan<- []byte) {
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * livePing))
+		opcode, payload, err := readWSFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, errWSTooLarge) {
+				log.Printf("[WARN] live feed: reading from the client: %v", err)
+			}
+			return
+		}
+		switch opcode {
+		case wsClose:
+			return
+		case wsPing:
+			select {
+			case pongs <- payload:
+			default:
+			}
+		}
+	}
+}
+
+var errWSTooLarge = errors.New("frame too large")
+
+// readWSFrame reads one masked client frame
+func readWSFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = head[0] & 0x0f
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if n > liveReadLimit {
+		return 0, nil, errWSTooLarge
+	}
+	var mask [4]byte
+	if head[1]&0x80 != 0 {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ====== Output locale ======
+// Answers are parsed, validated and stored in one canonical form: dates as
+// YYYY-MM-DD, numbers as JSON numbers. Some consumers want their own
+// convention instead, so /v1/parse renders the response in an output
+// locale from ?locale= (or OUTPUT_LOCALE):
+//
+//	iso    2025-10-12, 8.5 (default, the canonical form)
+//	de     12.10.2025, "8,5"
+//	en-GB  12/10/2025, 8.5
+//	en-US  10/12/2025, 8.5
+//
+// Only the rendering changes: stored runs, evaluations and exports stay
+// canonical. Decimal commas can't be JSON numbers, so in "de" the decimal
+// fields (prices, ratings, distance values) become strings, "120" and
+// "8,5"; counts stay numbers. The locale is echoed in X-Output-Locale.
+
+// outputLocale is how dates and decimals are rendered
+type outputLocale struct {
+	name         string
+	dateLayout   string
+	decimalComma bool
+}
+
+var outputLocales = map[string]outputLocale{
+	"iso":   {name: "iso", dateLayout: time.DateOnly},
+	"de":    {name: "de", dateLayout: "02.01.2006", decimalComma: true},
+	"en-gb": {name: "en-GB", dateLayout: "02/01/2006"},
+	"en-us": {name: "en-US", dateLayout: "01/02/2006"},
+}
+
+// localeDateKeys and localeDecimalKeys are the response fields rendered in
+// the locale, wherever they are nested
+var (
+	localeDateKeys    = map[string]bool{"checkin": true, "checkout": true, "from": true, "to": true}
+	localeDecimalKeys = map[string]bool{"price_max_eur": true, "rating_min": true, "rating_max": true, "min_eur": true, "max_eur": true, "value": true}
+)
+
+// negotiateLocale picks the output locale from ?locale= or OUTPUT_LOCALE;
+// errors are client-safe
+func negotiateLocale(r *http.Request) (outputLocale, error) {
+	name, fromQuery := r.URL.Query().Get("locale"), true
+	if name == "" {
+		name, fromQuery = os.Getenv("OUTPUT_LOCALE"), false
+	}
+	if name == "" {
+		return outputLocales["iso"], nil
+	}
+	loc, ok := outputLocales[strings.ToLower(strings.ReplaceAll(name, "_", "-"))]
+	if !ok {
+		if !fromQuery {
+			return outputLocales["iso"], nil
+		}
+		return outputLocale{}, fmt.Errorf("unsupported locale %q (use iso, de, en-GB or en-US)", name)
+	}
+	return loc, nil
+}
+
+// localize renders response in loc; the iso locale returns it unchanged.
+// The JSON is rewritten token by token, so the field order stays.
+func localize(response any, loc outputLocale) any {
+	if loc.name == "iso" || loc.name == "" {
+		return response
+	}
+	b, err := json.Marshal(response)
+	if err != nil {
+		return response
+	}
+	out, err := loc.rewrite(b)
+	if err != nil {
+		log.Printf("[ERROR] localizing the response: %v", err)
+		return response
+	}
+	return json.RawMessage(out)
+}
+
+// rewrite copies the JSON in b, rendering the date and decimal fields
+func (loc outputLocale) rewrite(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	type frame struct {
+		object  bool
+		wantKey bool   // objects: the next token is a key
+		key     string // the field being written (arrays: the array's)
+		n       int    // values written
+	}
+	var out bytes.Buffer
+	stack := []frame{{}}
+	// begin writes what goes before a value: the comma and the key
+	begin := func() *frame {
+		top := &stack[len(stack)-1]
+		if top.n > 0 {
+			out.WriteByte(',')
+		}
+		top.n++
+		if top.object {
+			k, _ := json.Marshal(top.key)
+			out.Write(k)
+			out.WriteByte(':')
+		}
+		return top
+	}
+	// end marks the value of the current object field as written
+	end := func() {
+		if top := &stack[len(stack)-1]; top.object {
+			top.wantKey = true
+		}
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if k, ok := tok.(string); ok {
+			if top := &stack[len(stack)-1]; top.object && top.wantKey {
+				top.key, top.wantKey = k, false
+				continue
+			}
+		}
+		if d, ok := tok.(json.Delim); ok {
+			switch d {
+			case '{', '[':
+				key := begin().key
+				stack = append(stack, frame{object: d == '{', wantKey: d == '{', key: key})
+			default:
+				stack = stack[:len(stack)-1]
+			}
+			out.WriteString(d.String())
+			if d == '}' || d == ']' {
+				end()
+			}
+			continue
+		}
+		key := begin().key
+		var v any = tok
+		switch t := tok.(type) {
+		case string:
+			if localeDateKeys[key] {
+				v = loc.date(t)
+			}
+		case json.Number:
+			if f, err := t.Float64(); err == nil && localeDecimalKeys[key] {
+				v = loc.decimal(f)
+			}
+		}
+		vb, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(vb)
+		end()
+	}
+}
+
+// date renders a canonical date; anything else (also "") is kept
+func (loc outputLocale) date(s string) string {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return s
+	}
+	return t.Format(loc.dateLayout)
+}
+
+// decimal renders a decimal field
+func (loc outputLocale) decimal(f float64) any {
+	if !loc.decimalComma {
+		return f
+	}
+	return strings.Replace(strconv.FormatFloat(f, 'f', -1, 64), ".", ",", 1)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// ====== Logprobs + calibration ======
+// With OPENAI_LOGPROBS=1 OpenAI returns a logprob per output token. They are
+// turned into per-slot confidence estimates for ui_filters, stored with the
+// run as status.<provider>.confidence, and GET /v1/evaluations compares them
+// with the ground truth in a calibration report. Claude has no logprobs.
+
+// TokenLogprob is one output token as reported by the provider
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+// SlotConfidence is the probability the model gave a ui_filters slot
+type SlotConfidence struct {
+	Slot   float64            `json:"slot"`             // the whole array as emitted
+	Values map[string]float64 `json:"values,omitempty"` // each emitted value
+}
+
+var slotKeyRe = regexp.MustCompile(`"(\w+)"\s*:\s*\[`)
+
+// slotConfidence derives per-slot confidence from the tokens of text. It
+// returns nil when there are no logprobs or they don't line up with text.
+func slotConfidence(text string, tokens []TokenLogprob) map[string]*SlotConfidence {
+	if len(tokens) == 0 {
+		return nil
+	}
+	// byte offset at which each token starts
+	starts := make([]int, len(tokens)+1)
+	for i, t := range tokens {
+		n := len(t.Bytes)
+		if n == 0 {
+			n = len(t.Token)
+		}
+		starts[i+1] = starts[i] + n
+	}
+	if starts[len(tokens)] != len(text) {
+		return nil
+	}
+	// joint probability of the tokens overlapping text[from:to]
+	joint := func(from, to int) float64 {
+		sum := 0.0
+		for i, t := range tokens {
+			if starts[i] < to && starts[i+1] > from {
+				sum += t.Logprob
+			}
+		}
+		return round2(math.Exp(sum))
+	}
+
+	ui := strings.Index(text, `"ui_filters"`)
+	if ui < 0 {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, s := range uiFilterSlots {
+		known[s] = true
+	}
+	out := map[string]*SlotConfidence{}
+	for _, m := range slotKeyRe.FindAllStringSubmatchIndex(text[ui:], -1) {
+		slot := text[ui+m[2] : ui+m[3]]
+		if !known[slot] || out[slot] != nil {
+			continue
+		}
+		from := ui + m[1] - 1 // the '['
+		end := strings.IndexByte(text[from:], ']')
+		if end < 0 {
+			break
+		}
+		to := from + end + 1
+		sc := &SlotConfidence{Slot: joint(from, to)}
+		for i := from + 1; i < to; {
+			q := strings.IndexByte(text[i:to], '"')
+			if q < 0 {
+				break
+			}
+			start, stop := i+q, i+q+1
+			for stop < to && text[stop] != '"' {
+				if text[stop] == '\\' {
+					stop++
+				}
+				stop++
+			}
+			if stop >= to {
+				break
+			}
+			var value string
+			if json.Unmarshal([]byte(text[start:stop+1]), &value) == nil {
+				if sc.Values == nil {
+					sc.Values = map[string]float64{}
+				}
+				sc.Values[value] = joint(start, stop+1)
+			}
+			i = stop + 1
+		}
+		out[slot] = sc
+	}
+	return out
+}
+
+// ----- calibration report -----
+
+// CalibrationBin is one bar of a reliability diagram
+type CalibrationBin struct {
+	Lower         float64 `json:"lower"`
+	Upper         float64 `json:"upper"`
+	Count         int     `json:"count"`
+	AvgConfidence float64 `json:"avg_confidence"`
+	Accuracy      float64 `json:"accuracy"` // share of values that are in the ground truth
+}
+
+// CalibrationReport compares predicted ui_filters values' confidence with
+// how often they were correct
+type CalibrationReport struct {
+	Count int              `json:"count"` // scored values
+	ECE   float64          `json:"ece"`   // expected calibration error
+	Bins  []CalibrationBin `json:"bins"`  // 10 equal-width bins
+}
+
+type calibAcc struct {
+	n       [10]int
+	correct [10]int
+	sumConf [10]float64
+}
+
+// add scores each value with a confidence against the ground truth
+func (c *calibAcc) add(conf map[string]*SlotConfidence, gt ParseResponse) {
+	if len(conf) == 0 {
+		return
+	}
+	truth := flatten(gt)
+	for slot, sc := range conf {
+		for value, p := range sc.Values {
+			b := min(int(p*10), 9)
+			c.n[b]++
+			c.sumConf[b] += p
+			if truth["ui."+slot+"="+strings.TrimSpace(value)] {
+				c.correct[b]++
+			}
+		}
+	}
+}
+
+func (c *calibAcc) report() *CalibrationReport {
+	total := 0
+	for _, n := range c.n {
+		total += n
+	}
+	if total == 0 {
+		return nil
+	}
+	r := &CalibrationReport{Count: total}
+	ece := 0.0
+	for b := range c.n {
+		bin := CalibrationBin{Lower: float64(b) / 10, Upper: float64(b+1) / 10, Count: c.n[b]}
+		if c.n[b] > 0 {
+			bin.AvgConfidence = c.sumConf[b] / float64(c.n[b])
+			bin.Accuracy = safeDiv(c.correct[b], c.n[b])
+			ece += float64(c.n[b]) / float64(total) * math.Abs(bin.Accuracy-bin.AvgConfidence)
+			bin.AvgConfidence, bin.Accuracy = round2(bin.AvgConfidence), round2(bin.Accuracy)
+		}
+		r.Bins = append(r.Bins, bin)
+	}
+	r.ECE = round2(ece)
+	return r
+}
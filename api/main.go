@@ -8,7 +8,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -44,15 +47,35 @@ type UiFilters struct {
 	Hotelinformation  []string `json:"hotelinformation"`
 }
 type ParseResponse struct {
-	Location            string    `json:"location"`
-	Dates               Dates     `json:"dates"`
-	Guests              Guests    `json:"guests"`
-	PriceMaxEUR         float64   `json:"price_max_eur"`
-	StarsMin            int       `json:"stars_min"`
-	RatingMin           float64   `json:"rating_min"`
-	FamilyFriendly      bool      `json:"family_friendly"`
-	UiFilters           UiFilters `json:"ui_filters"`
-	UnsupportedCriteria []string  `json:"unsupported_criteria"`
+	SchemaVersion       int        `json:"schema_version,omitempty"` // 1; set on responses, see schema.go
+	Location            string     `json:"location"`
+	DepartureAirports   []string   `json:"departure_airports"` // IATA codes
+	Dates               Dates      `json:"dates"`
+	DateFlexibilityDays int        `json:"date_flexibility_days"` // ± days around the dates; 0 = exact
+	Nights              int        `json:"nights"`
+	Transport           string     `json:"transport"` // taxonomy "transport" value; "" = not mentioned
+	RoomTypes           []string   `json:"room_types"`
+	RoomViews           []string   `json:"room_views"`    // views and balcony/terrace
+	Accessibility       []string   `json:"accessibility"` // taxonomy "accessibility" values, see accessibility.go
+	Guests              Guests     `json:"guests"`
+	GroupSize           int        `json:"group_size"`   // travellers in total when the query names it ("Gruppe von 8")
+	TripPurpose         string     `json:"trip_purpose"` // taxonomy "trip_purpose" value: business, leisure or ""
+	Distances           []Distance `json:"distances"`    // limits to reference points, see distance.go
+	PriceMaxEUR         float64    `json:"price_max_eur"`
+	StarsMin            int        `json:"stars_min"`
+	StarsMax            int        `json:"stars_max"` // 0 = open
+	RatingMin           float64    `json:"rating_min"`
+	RatingMax           float64    `json:"rating_max"`      // 0 = open
+	FamilyFriendly      *bool      `json:"family_friendly"` // nil = not mentioned
+	UiFilters           UiFilters  `json:"ui_filters"`
+	ExcludedFilters     UiFilters  `json:"excluded_filters"`  // values the query rules out, see negation.go
+	PreferredFilters    UiFilters  `json:"preferred_filters"` // ui_filters values that are nice-to-haves, see preference.go
+	UnsupportedCriteria []string   `json:"unsupported_criteria"`
+	// Assumptions are values set by the interpretation policy (assisted
+	// mode), see interpret.go, or nights recomputed from the dates
+	Assumptions []Assumption `json:"assumptions,omitempty"`
+	// Event is the event the dates were anchored to, see calendar.go
+	Event *EventMatch `json:"event,omitempty"`
 }
 
 // Basic range checks
@@ -63,23 +86,162 @@ func (p *ParseResponse) Validate() error {
 	if p.RatingMin < 0 || p.RatingMin > 10 {
 		return errors.New("rating_min must be 0..10")
 	}
+	if p.StarsMax < 0 || p.StarsMax > 5 {
+		return errors.New("stars_max must be 0..5")
+	}
+	if p.StarsMax > 0 && p.StarsMin > p.StarsMax {
+		return errors.New("stars_min must not exceed stars_max")
+	}
+	if p.RatingMax < 0 || p.RatingMax > 10 {
+		return errors.New("rating_max must be 0..10")
+	}
+	if p.RatingMax > 0 && p.RatingMin > p.RatingMax {
+		return errors.New("rating_min must not exceed rating_max")
+	}
 	if p.Guests.Adults < 0 || p.Guests.Children < 0 {
 		return errors.New("guest counts cannot be negative")
 	}
+	if p.GroupSize < 0 || p.GroupSize > 99 {
+		return errors.New("group_size must be 0..99")
+	}
+	if guests := p.Guests.Adults + p.Guests.Children; p.GroupSize > 0 && p.GroupSize < guests {
+		return fmt.Errorf("group_size is %d but guests count %d", p.GroupSize, guests)
+	}
+	if p.DateFlexibilityDays < 0 || p.DateFlexibilityDays > 30 {
+		return errors.New("date_flexibility_days must be 0..30")
+	}
+	if p.Nights < 0 || p.Nights > 365 {
+		return errors.New("nights must be 0..365")
+	}
+	for _, a := range p.DepartureAirports {
+		if len(a) != 3 || strings.ToUpper(a) != a {
+			return fmt.Errorf("departure_airports: %q is not an IATA code", a)
+		}
+	}
+
+	// taxonomy-backed values
+	tax, _ := currentTaxonomy()
+	if p.Transport != "" && tax[transportSlot] != nil && !tax.Has(transportSlot, p.Transport) {
+		return fmt.Errorf("transport: unknown value %q", p.Transport)
+	}
+	if p.TripPurpose != "" && tax[tripPurposeSlot] != nil && !tax.Has(tripPurposeSlot, p.TripPurpose) {
+		return fmt.Errorf("trip_purpose: unknown value %q", p.TripPurpose)
+	}
+	for _, slot := range listSlots {
+		for _, v := range *p.listField(slot) {
+			if tax[slot] != nil && !tax.Has(slot, v) {
+				return fmt.Errorf("%s: unknown value %q", slot, v)
+			}
+		}
+	}
+	if err := p.validateDistances(tax); err != nil {
+		return err
+	}
 	return nil
 }
 
+// postProcess completes and normalizes a decoded answer before validation
+func (p *ParseResponse) postProcess() {
+	p.deriveDates()
+	p.normalizeAirports()
+	p.normalizeMeals()
+	p.normalizeDistances()
+	p.normalizeExcluded()
+	p.normalizePreferred()
+	if p.Transport == "" && len(p.DepartureAirports) > 0 {
+		p.Transport = "flight"
+	}
+	if p.GroupSize >= groupMinSize {
+		p.UiFilters.add("travelGroup", "group")
+	}
+	for _, slot := range listSlots {
+		if list := p.listField(slot); *list == nil {
+			*list = []string{}
+		}
+	}
+}
+
+// addRangeFilters adds the ui_filters stars and ratings the numeric fields
+// imply, as the single-call prompt asks for: every star count from
+// stars_min to stars_max, the rating_min threshold
+func (p *ParseResponse) addRangeFilters() {
+	if p.StarsMin > 0 || p.StarsMax > 0 {
+		hi := p.StarsMax
+		if hi == 0 {
+			hi = p.StarsMin
+		}
+		for s := max(p.StarsMin, 1); s <= hi; s++ {
+			p.UiFilters.add("stars", strconv.Itoa(s))
+		}
+	}
+	if p.RatingMin > 0 {
+		p.UiFilters.add("ratings", strconv.FormatFloat(p.RatingMin, 'f', -1, 64))
+	}
+}
+
+// deriveDates fills in what follows from the others: checkout from checkin
+// + nights, checkin from checkout - nights, nights from both dates. Nights
+// that disagree with both dates are replaced, the dates win, and the
+// replacement is listed as an assumption.
+func (p *ParseResponse) deriveDates() {
+	in, inErr := time.Parse(time.DateOnly, p.Dates.Checkin)
+	out, outErr := time.Parse(time.DateOnly, p.Dates.Checkout)
+	switch {
+	case inErr == nil && outErr == nil:
+		n, ok := p.Dates.nights()
+		if !ok || n == p.Nights {
+			break
+		}
+		if p.Nights > 0 {
+			p.Assumptions = append(p.Assumptions, Assumption{
+				Phrase: fmt.Sprintf("%d Nächte", p.Nights), Field: "nights", Value: strconv.Itoa(n),
+			})
+		}
+		p.Nights = n
+	case p.Nights <= 0:
+	case inErr == nil && p.Dates.Checkout == "":
+		p.Dates.Checkout = in.AddDate(0, 0, p.Nights).Format(time.DateOnly)
+	case outErr == nil && p.Dates.Checkin == "":
+		p.Dates.Checkin = out.AddDate(0, 0, -p.Nights).Format(time.DateOnly)
+	}
+}
+
+// nights is the length of the stay, if both dates are set and in order
+func (d Dates) nights() (int, bool) {
+	in, err := time.Parse(time.DateOnly, d.Checkin)
+	if err != nil {
+		return 0, false
+	}
+	out, err := time.Parse(time.DateOnly, d.Checkout)
+	if err != nil || !out.After(in) {
+		return 0, false
+	}
+	return int(out.Sub(in).Hours()+12) / 24, true
+}
+
 // A safe default prompt if prompt/system.txt isn't present
 const defaultSystemPrompt = `Du bist ein Parser. Analysiere eine deutsche Hotelsuchanfrage
 und gib ausschließlich ein einziges JSON-Objekt gemäß diesem Schema aus (keine Erklärungen):
 {
   "location": string,
+  "departure_airports": string[],
   "dates": { "checkin": string, "checkout": string },
+  "date_flexibility_days": number,
+  "nights": number,
+  "transport": "flight" | "own_arrival" | "train" | "bus" | "",
+  "room_types": string[],
+  "room_views": string[],
+  "accessibility": string[],
   "guests": { "adults": number, "children": number },
+  "group_size": number,
+  "trip_purpose": "business" | "leisure" | "",
+  "distances": [{ "value": number, "unit": "m" | "km" | "min_walk" | "min_drive", "reference": string, "meters": number }],
   "price_max_eur": number,
   "stars_min": number,
+  "stars_max": number,
   "rating_min": number,
-  "family_friendly": boolean,
+  "rating_max": number,
+  "family_friendly": boolean | null,
   "ui_filters": {
     "meals": string[],
     "ratings": string[],
@@ -100,198 +262,742 @@ und gib ausschließlich ein einziges JSON-Objekt gemäß diesem Schema aus (kein
     "hotelBrand": string[],
     "hotelinformation": string[]
   },
+  "excluded_filters": { gleiche Slots wie ui_filters },
+  "preferred_filters": { gleiche Slots wie ui_filters },
   "unsupported_criteria": string[]
 }
   
 Regeln:
 - Preis als EUR-Zahl in price_max_eur (z. B. „unter 150€“ → 150). Keine Buckets.
-- Synonyme mappen: WLAN→hotelfacilities.free_hotel_wifi; Frühstück→meals.breakfast; All-inclusive/AI→meals.only_all_inclusive (+hotelthemes.allInclusiveHotel wenn Thema); Wellness/Spa→wellness.spa; Innenpool→poolbeach.heated_pool; Außenpool→poolbeach.pool; „am Strand“→distanceBeach:["500"]; Adults-only→travelGroup.adultsOnly (+hotelthemes.adultsOnly).
+- Verpflegung (meals), nur diese Werte: Frühstück→breakfast; Halbpension/HP→half_board; Vollpension/VP→full_board; All-inclusive/AI→only_all_inclusive (+hotelthemes.allInclusiveHotel wenn Thema); „All inclusive plus“→all_inclusive_plus; „Ultra All inclusive“→ultra_all_inclusive; Selbstverpflegung→self_catering; „nur Übernachtung“/„ohne Verpflegung“→room_only.
+- Synonyme mappen: WLAN→hotelfacilities.free_hotel_wifi; Wellness/Spa→wellness.spa; Innenpool→poolbeach.heated_pool; Außenpool→poolbeach.pool; „am Strand“→distanceBeach:["500"]; Adults-only→travelGroup.adultsOnly (+hotelthemes.adultsOnly).
+- Nachhaltigkeit in certifications: „nachhaltig“/„umweltfreundlich“/„Öko-Hotel“/„klimaneutral“ → sustainable; Bio-Hotel → bio_hotel; genannte Siegel → green_key, eu_ecolabel, green_globe, travelife, gstc, viabono, tourcert. Andere Nachhaltigkeitswünsche („plastikfrei“, „mit Solarstrom“) wortwörtlich in unsupported_criteria.
 - Sterne/Bewertung: „mind. 4 Sterne“ → stars_min:4 UND ui_filters.stars:["4"]; „8+“ → rating_min:8 UND ui_filters.ratings:["8"].
+- Bereiche: „3 bis 4 Sterne“ → stars_min:3, stars_max:4, ui_filters.stars:["3","4"]; „Bewertung zwischen 7 und 9“ → rating_min:7, rating_max:9, ui_filters.ratings:["7"]. stars_max/rating_max nur bei ausdrücklicher Obergrenze („höchstens 3 Sterne“, „bis 4 Sterne“), sonst 0.
 - Nur explizit genannte Daten/Gäste setzen; sonst leer lassen.
+- Flexible Daten: „±3 Tage flexibel“ / „+/- 3 Tage“ → date_flexibility_days:3; „um den 15. Juli herum“ → Datum setzen und date_flexibility_days:3. Flexibilität nie in ui_filters.flex; sonst 0.
+- Abflughafen bei Pauschalreisen („ab Frankfurt“, „Abflug ab MUC“) als IATA-Code in departure_airports (FRA, MUC); Städte mit mehreren Flughäfen nennen, nicht raten. Nicht in location oder unsupported_criteria.
+- Anreise in transport: „mit Flug“/„inkl. Flug“ → flight; „eigene Anreise“/„mit dem Auto“ → own_arrival; „Bahn-Anreise“/„mit dem Zug“ → train; „Busreise“ → bus; nicht genannt → "". Ein Abflughafen bedeutet flight.
+- Zimmer: Einzelzimmer→room_types.single_room; Doppelzimmer/DZ→double_room; Familienzimmer→family_room; Suite→suite; Apartment/Ferienwohnung→apartment; Bungalow→bungalow. Aussicht/Außenbereich: Meerblick→room_views.sea_view; Seeblick→lake_view; Bergblick→mountain_view; Balkon→balcony; Terrasse→terrace. Nicht in unsupported_criteria.
+- Barrierefreiheit in accessibility: stufenfrei/barrierefrei → step_free; Aufzug → elevator; rollstuhlgerecht → wheelchair_accessible; „rollstuhlgerechtes Bad“/„bodengleiche Dusche“ → accessible_bathroom; Allergiker-Zimmer → allergy_friendly_room. Nicht in unsupported_criteria.
+- Dauer in nights: „3 Nächte“ → 3, „eine Woche“ → 7, „übers Wochenende“ nicht raten. Bei Dauer und Startdatum („eine Woche ab 12.8.“) checkin setzen, checkout = checkin + nights; sonst 0.
+- Reisegruppe in ui_filters.travelGroup: „alleinreisend“ → solo; Paar/„zu zweit“/Hochzeitsreise → couple; Familie → family; „mit Freunden“/Mädelstrip/Junggesellenabschied → friends; Gruppe/Betriebsausflug/Klassenfahrt → group; „mit Hund“ → withDog. Gesamtzahl der Reisenden („Gruppe von 8“, „zu acht“, „8 Personen“) in group_size, sonst 0; ab 6 Personen zusätzlich travelGroup.group. Gäste nur bei ausdrücklich genannten Erwachsenen/Kindern setzen.
+- Entfernungen zu einem Bezugspunkt in distances: „maximal 10 km vom Flughafen“ → {"value":10,"unit":"km","reference":"airport","meters":10000}; „5 Gehminuten zur Altstadt“ → {"value":5,"unit":"min_walk","reference":"old_town"}; „10 Autominuten zum Zentrum“ → unit min_drive, reference city_center. Bezugspunkte: airport, city_center, old_town, beach, station, trade_fair, ski_lift. „am Strand“ ohne Zahl bleibt distanceBeach. Nicht in unsupported_criteria.
+- Veranstaltungen als Zeitangabe („während des Oktoberfests“, „zur IAA“, „an Karneval“): Daten und Ort nicht raten, sondern leer lassen (der Server ergänzt sie aus dem Veranstaltungskalender); nicht in unsupported_criteria.
+- Reiseanlass in trip_purpose: Geschäftsreise/Dienstreise/Tagung/Messe/Betriebsausflug → business; Urlaub/Ferien/Kurzurlaub → leisure; nicht erkennbar → "".
+- family_friendly nur setzen, wenn die Anfrage Familien/Kinder ausdrücklich wünscht (true) oder ausschließt (false); sonst null.
+- Verneinungen („kein/keine“, „nicht“, „ohne“, „bitte kein“, „außer“) → Wert in excluded_filters statt ui_filters, z. B. „nicht all-inclusive“ → excluded_filters.meals:["only_all_inclusive"], „kein Club-Hotel“ → excluded_filters.hotelTypes. Ein Wert steht nie in beiden. Ausnahme: „ohne Verpflegung“/„nur Übernachtung“ ist meals.room_only.
+- Wunschstärke: Werte, die nur erwünscht sind („gerne mit Spa“, „idealerweise“, „wenn möglich“, „am liebsten“), zusätzlich in preferred_filters (gleicher Slot/Wert, bleibt auch in ui_filters). Muss-Kriterien („muss“, „unbedingt“, „zwingend“) und alles ohne Einschränkung nur in ui_filters.
 - Mehrdeutiges (z. B. „günstig“, „nahe“, „ruhig“) nicht raten → wortwörtlich in unsupported_criteria.
 - Antworte nur mit dem JSON-Objekt (keine Erklärungen).
 `
 
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		if origin == "http://localhost:5173" || origin == "http://127.0.0.1:5173" {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Vary", "Origin")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			// Allow GET for /v1/evaluations and POST for /v1/parse
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		}
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 // ====== Input + Output types ======
 type parseInput struct {
-	Query    string `json:"query_de"`
-	Provider string `json:"provider"` // "openai", "claude", "both"
+	Query       string `json:"query_de"`
+	Provider    string `json:"provider"`               // "openai", "claude", "both"
+	CallbackURL string `json:"callback_url,omitempty"` // respond 202 and POST the result here
+	// Interpretation is "strict" (vague terms stay unsupported) or
+	// "assisted" (see interpret.go); default INTERPRETATION_MODE
+	Interpretation string `json:"interpretation,omitempty"`
+	// Profile is a parsing profile ("accessibility", see accessibility.go)
+	Profile string `json:"profile,omitempty"`
+	// Tag labels the run for later selection, e.g. by POST /v1/replay
+	Tag string `json:"tag,omitempty"`
+	// Priority is "interactive" (default) or "batch", the limiter lane of
+	// the provider calls (see limiter.go)
+	Priority string `json:"priority,omitempty"`
+	// Impact asks for the filter impact estimate (see impact.go)
+	Impact   bool   `json:"impact,omitempty"`
+	Sampling        // optional seed, temperature, top_p
+	apiKey   string // name of the caller's API key
+	tenant   string
+	variant  *Variant    // set by replays instead of the canary pick
+	replay   *ReplayInfo // set by replays
 }
 
 type MultiParseResponse struct {
-	OpenAI *ParseResponse `json:"openai,omitempty"`
-	Claude *ParseResponse `json:"claude,omitempty"`
+	SchemaVersion int            `json:"schema_version,omitempty"`
+	OpenAI        *ParseResponse `json:"openai,omitempty"`
+	Claude        *ParseResponse `json:"claude,omitempty"`
+	// Status has one entry per requested provider, so "failed" and "not
+	// requested" can be told apart
+	Status map[string]*ProviderStatus `json:"status,omitempty"`
+	// PromptHash identifies the system prompt (incl. examples) that was used
+	PromptHash string `json:"prompt_hash,omitempty"`
+	// Pipeline is set for two_stage runs; Stages holds their intermediate
+	// output per provider
+	Pipeline string                 `json:"pipeline,omitempty"`
+	Stages   map[string]*StageTrace `json:"stages,omitempty"`
+	// Degraded is set when every provider failed and Rules holds the
+	// rules fallback's answer instead (see rules.go)
+	Degraded bool           `json:"degraded,omitempty"`
+	Rules    *ParseResponse `json:"rules,omitempty"`
+	// Contract has the downstream contract check per answer, when
+	// CONTRACT_SCHEMA_FILE is set (see contract.go)
+	Contract map[string]*ContractReport `json:"contract,omitempty"`
+	// Impact has how much each filter narrows the result set, per answer,
+	// when the request asked for it (see impact.go)
+	Impact map[string]*FilterImpact `json:"impact,omitempty"`
+	// Intent is the query's label when INTENT_GATE is set; for non-search
+	// intents it is the whole response (see intent.go)
+	Intent *IntentResult `json:"intent,omitempty"`
+}
+
+type ProviderStatus struct {
+	Status    string `json:"status"` // "ok" or "error"
+	Code      string `json:"code,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	Stage     string `json:"stage,omitempty"` // failing stage of a two_stage run
+	LatencyMS int64  `json:"latency_ms"`
+	Usage     *Usage `json:"usage,omitempty"` // tokens, when the provider reports them
+	Repro     *Repro `json:"repro,omitempty"` // model, sampling parameters, system_fingerprint
+	// Confidence per ui_filters slot, derived from token logprobs
+	Confidence map[string]*SlotConfidence `json:"confidence,omitempty"`
+	// Tier is "base" or "escalated" when an escalation model is configured
+	Tier       string          `json:"tier,omitempty"`
+	Escalation *EscalationInfo `json:"escalation,omitempty"`
+	Cached     bool            `json:"cached,omitempty"` // answered from the parse cache
+	// Timings are microseconds per stage of the request, see timings.go
+	Timings StageTimings `json:"timings_us,omitempty"`
+	// CalibratedConfidence is the probability the answer is right, see
+	// calibration.go
+	CalibratedConfidence *float64 `json:"calibrated_confidence,omitempty"`
+}
+
+func providerDisplayName(provider string) string {
+	switch provider {
+	case "openai":
+		return "OpenAI"
+	case "claude":
+		return "Claude"
+	}
+	return provider
+}
+
+// newClientFor returns the client for the named provider, optionally
+// overriding the model configured in the environment (or via the admin API).
+func newClientFor(provider, model string) (LLMClient, error) {
+	enabled, adminModel := providerOverrides(provider)
+	if !enabled {
+		return nil, errProviderDisabled
+	}
+	if model == "" {
+		model = adminModel
+	}
+	return clientFor(provider, model)
 }
 
 // ====== Parse handler ======
 func parseHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST"))
 		return
 	}
 
-	var input parseInput
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		log.Printf("[ERROR] invalid JSON body: %v", err)
-		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+	key, p := authenticate(r)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	if p := checkQuota(key); p != nil {
+		log.Printf("[WARN] %s: quota exceeded", key.Name)
+		writeProblem(w, p)
 		return
 	}
-	if strings.TrimSpace(input.Query) == "" {
-		log.Printf("[WARN] empty query from client")
-		http.Error(w, "query_de is required", http.StatusBadRequest)
+
+	input, err := decodeParseInput(w, r)
+	if err != nil {
+		log.Printf("[WARN] rejected parse request: %v", err)
+		writeProblem(w, inputProblem(err))
+		return
+	}
+	input.apiKey, input.tenant = key.Name, tenantOf(r.Context())
+
+	schema, err := negotiateSchema(r)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusNotAcceptable, codeInvalidInput, err.Error()))
+		return
+	}
+	locale, err := negotiateLocale(r)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, err.Error()))
+		return
+	}
+	proj, err := parseProjection(r, schema)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, err.Error()))
+		return
+	}
+	encoding, contentType, err := negotiateEncoding(r, schema, locale)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusNotAcceptable, codeInvalidInput, err.Error()))
 		return
 	}
 
 	log.Printf("[INFO] Request: provider=%s query=%q", input.Provider, input.Query)
 
-	_ = godotenv.Load()
+	runID := newRunID()
 
-	// Load system prompt (allow local overrides)
-	systemPrompt := defaultSystemPrompt
-	if b, err := os.ReadFile("prompt/system.txt"); err == nil {
-		systemPrompt = string(b)
+	// Fire-and-forget: answer with the run ID, deliver the result later
+	if input.CallbackURL != "" {
+		if err := validateCallbackURL(r.Context(), input.CallbackURL); err != nil {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid callback_url: "+err.Error()))
+			return
+		}
+		go func() {
+			results, fail := runParse(context.Background(), input, runID)
+			recordUsage(input.apiKey, time.Now(), results)
+			deliverCallback(input.CallbackURL, runID, project(localize(responseFor(results, schema), locale), proj), fail)
+		}()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]string{"run_id": runID})
+		return
 	}
-	if b, err := os.ReadFile("prompt/examples.json"); err == nil {
-		systemPrompt += "\n\nBeispiele (nur zur Steuerung, nicht ausgeben):\n" + string(b)
+
+	results, fail := runParse(r.Context(), input, runID)
+	recordUsage(input.apiKey, time.Now(), results)
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		log.Printf("[INFO] run %s: client closed the request", runID)
+		writeProblem(w, newProblem(statusClientClosed, codeClientClosed, "client closed the request"))
+		return
+	}
+	if fail != nil {
+		fail.RunID = runID
+		writeProblem(w, fail)
+		return
+	}
+
+	body, err := encodeResponse(project(localize(responseFor(results, schema), locale), proj), encoding)
+	if err != nil {
+		log.Printf("[ERROR] run %s: encoding the response as %s: %v", runID, encoding, err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not encode the response"))
+		return
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("X-Schema-Version", strconv.Itoa(schema))
+	w.Header().Set("X-Run-ID", runID)
+	w.Header().Set("X-Prompt-Hash", results.PromptHash)
+	w.Header().Set("X-Output-Locale", locale.name)
+	_, _ = w.Write(body)
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 45*time.Second)
+// runParse calls the requested provider(s) and stores the run
+func runParse(parent context.Context, input parseInput, runID string) (results MultiParseResponse, fail *Problem) {
+	// callbacks and replays run this off the handler goroutine, where a panic
+	// would take the process down
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("[ERROR] run %s: panic: %v\n%s", runID, p, debug.Stack())
+			results, fail = MultiParseResponse{}, newProblem(http.StatusInternalServerError, codeInternal, "internal error")
+		}
+	}()
+	// Input screening: no provider call for junk
+	if input.replay == nil && inputScreening() {
+		if reason := screenQuery(input.Query, time.Now()); reason != "" {
+			return results, rejectInput(reason, input.apiKey)
+		}
+	}
+
+	// Canary: pick a prompt/model variant for this request (if configured)
+	variant := input.variant
+	if variant == nil {
+		variant = pickVariant(loadVariants())
+	}
+	if input.replay != nil || input.Priority == laneBatch {
+		parent = withLane(parent, laneBatch)
+	}
+
+	promptStart := time.Now()
+	plan := newParsePlan(input, variant)
+	promptTime := time.Since(promptStart)
+
+	ctx, cancel := context.WithTimeout(parent, requestBudget())
 	defer cancel()
+	var capture *rawCapture
+	if captureMode() != "" {
+		capture = &rawCapture{}
+		ctx = withCapture(ctx, capture)
+	}
 
-	results := MultiParseResponse{}
+	results = MultiParseResponse{PromptHash: plan.promptHash}
+	if plan.pipeline == pipelineTwoStage {
+		results.Pipeline = plan.pipeline
+		results.Stages = map[string]*StageTrace{}
+	}
 	requestStart := time.Now()
 
-	run := func(cli LLMClient, provider string) (*ParseResponse, error) {
-		start := time.Now()
-		raw, err := cli.CompleteJSON(ctx, systemPrompt, input.Query)
-		if err != nil {
-			log.Printf("[ERROR] %s completion failed: %v", provider, err)
-			return nil, err
-		}
-		jsonPart, err := extractJSONObject(raw)
-		if err != nil {
-			log.Printf("[ERROR] %s no JSON found: %s", provider, raw)
-			return nil, fmt.Errorf("no JSON found in output: %s", raw)
-		}
-		var parsed ParseResponse
-		dec := json.NewDecoder(strings.NewReader(jsonPart))
-		dec.DisallowUnknownFields()
-		if err := dec.Decode(&parsed); err != nil {
-			log.Printf("[ERROR] %s schema violation: %v", provider, err)
-			return nil, err
+	// Intent gate: non-search queries get no parse
+	if input.replay == nil {
+		results.Intent = classifyIntent(ctx, input.Query, providersFor(input.Provider)[0])
+		if results.Intent != nil && results.Intent.Label != intentHotelSearch {
+			log.Printf("[INFO] run %s: %s query, not parsed (%s)", runID, results.Intent.Label, results.Intent.Source)
+			countGated(results.Intent.Label)
+			return results, nil
 		}
-		if err := parsed.Validate(); err != nil {
-			log.Printf("[ERROR] %s validation failed: %v", provider, err)
-			return nil, err
-		}
-		log.Printf("[INFO] %s parsed successfully in %s", provider, time.Since(start))
-		return &parsed, nil
 	}
 
-	switch strings.ToLower(strings.TrimSpace(input.Provider)) {
-	case "claude":
-		cli, err := NewClaudeClient()
-		if err != nil {
-			http.Error(w, "Claude client error: "+err.Error(), http.StatusInternalServerError)
-			return
+	// Shadow traffic: sampled, async, never part of the response
+	if shadow := loadShadowConfig(); shadow != nil && input.replay == nil && shadow.sampled() {
+		go runShadow(*shadow, plan.systemPrompt, input.Query, input.tenant)
+	}
+
+	providers := providersFor(input.Provider)
+	calls, aborted := callProviders(ctx, parent, plan, providers, variant)
+
+	var policy map[string]Interpretation
+	if interpretationMode(input.Interpretation) == interpretAssisted {
+		policy = currentInterpretations()
+	}
+	calendar := currentCalendar()
+	contract := currentContract()
+
+	results.Status = map[string]*ProviderStatus{}
+	errs := map[string]*ProviderError{}
+	for _, c := range calls {
+		status := &ProviderStatus{Status: "ok", LatencyMS: c.latency.Milliseconds(), Repro: c.repro, Confidence: c.confidence, Tier: c.tier, Escalation: c.escalation, Cached: c.cached, Timings: c.timings}
+		if status.Timings == nil {
+			status.Timings = StageTimings{}
 		}
-		if res, err := run(cli, "Claude"); err == nil {
-			results.Claude = res
-		} else {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-			return
+		status.Timings.add(timingPrompt, promptTime)
+		if c.usage != (Usage{}) {
+			status.Usage = &c.usage
 		}
-
-	case "both":
-		if cli, err := NewOpenAIClient(); err == nil {
-			if res, err := run(cli, "OpenAI"); err == nil {
-				results.OpenAI = res
+		if c.err != nil {
+			pe := classifyProviderError(providerDisplayName(c.provider), c.err)
+			if c.trace != nil {
+				pe.Stage = c.trace.FailedStage
 			}
+			errs[c.provider] = pe
+			status.Status, status.Code, status.Detail, status.Stage = "error", pe.Code, pe.Detail, pe.Stage
+		}
+		results.Status[c.provider] = status
+		if c.trace != nil {
+			results.Stages[c.provider] = c.trace
 		}
-		if cli, err := NewClaudeClient(); err == nil {
-			if res, err := run(cli, "Claude"); err == nil {
-				results.Claude = res
+		if c.res != nil {
+			start := time.Now()
+			c.res.SchemaVersion = schemaV1
+			c.res.anchorEvent(calendar, input.Query, requestStart)
+			if policy != nil {
+				c.res.interpret(policy)
 			}
+			status.Timings.add(timingPostprocess, time.Since(start))
 		}
-		if results.OpenAI == nil && results.Claude == nil {
-			http.Error(w, "both calls failed", http.StatusBadGateway)
-			return
+		switch c.provider {
+		case "openai":
+			results.OpenAI = c.res
+		case "claude":
+			results.Claude = c.res
 		}
+	}
 
-	default: // "openai" (or empty)
-		cli, err := NewOpenAIClient()
-		if err != nil {
-			http.Error(w, "OpenAI client error: "+err.Error(), http.StatusInternalServerError)
-			return
+	if len(errs) == len(providers) {
+		code, detail := "", "both calls failed"
+		for _, pe := range errs {
+			if code != "" && code != pe.Code {
+				code = codeProviderUnavailable
+			} else {
+				code = pe.Code
+			}
+			if len(providers) == 1 {
+				detail = pe.Detail
+			}
 		}
-		if res, err := run(cli, "OpenAI"); err == nil {
-			results.OpenAI = res
-		} else {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-			return
+		if fallsBackToRules(code) {
+			log.Printf("[WARN] all providers failed (%s); answering from the rules", code)
+			rules := ruleParse(input.Query, time.Now())
+			rules.SchemaVersion = schemaV1
+			rules.anchorEvent(calendar, input.Query, requestStart)
+			if policy != nil {
+				rules.interpret(policy)
+			}
+			results.Rules, results.Degraded = &rules, true
+			if contract != nil {
+				results.checkContracts(contract, requestStart)
+			}
+			if input.Impact {
+				results.Impact = estimateImpact(parent, results)
+			}
+			capture.keepFailed(runID, input.tenant)
+			return results, nil
+		}
+		capture.keepFailed(runID, input.tenant)
+		p := newProblem(problemStatus(code), code, detail)
+		p.Providers = errs
+		if code == codeOverloaded {
+			overloadedProblem(p, providers)
 		}
+		return results, p
+	}
+	if contract != nil {
+		results.checkContracts(contract, requestStart)
+	}
+	results.calibrate()
+	if input.Impact {
+		results.Impact = estimateImpact(parent, results)
 	}
 
 	// Persist the run for evaluations
 	totalLatency := time.Since(requestStart).Milliseconds()
-	StoreResult(input.Query, results, totalLatency)
+	run := StoredResult{
+		ID:       runID,
+		Query:    input.Query,
+		Response: results,
+		Latency:  totalLatency,
+		Time:     time.Now(),
+		Variant:  variant.name(),
+		APIKey:   input.apiKey,
+		Tenant:   input.tenant,
+		Aborted:  aborted,
+		Assisted: policy != nil,
+		Tag:      input.Tag,
+		Replay:   input.replay,
+		Raw:      capture.keep(results),
+	}
+	flagSlow(&run)
+	if !aborted && searchEnabled() {
+		// the searches would hold up the response: the run is stored
+		// once they are done
+		go func() {
+			run.Search = searchAnswers(parent, results)
+			StoreResult(run)
+		}()
+		return results, nil
+	}
+	StoreResult(run)
+	return results, nil
+}
+
+// parsePlan is what all provider calls of one request share
+type parsePlan struct {
+	pipeline     string
+	systemPrompt string
+	promptHash   string // of the system prompt, or of the two-stage prompts
+	query        string
+	sampling     Sampling
+	tenant       string
+	selfTest     bool // bypasses an open circuit breaker
+	uncached     bool // replays always ask the provider
+}
+
+// newParsePlan is how input is parsed under variant
+func newParsePlan(input parseInput, variant *Variant) parsePlan {
+	// System prompt (cached, reloaded when the files change)
+	prompt := withProfile(systemPromptForTenant(input.tenant, variant.promptPath()), input.Profile)
+	plan := parsePlan{pipeline: pipelineFor(variant), systemPrompt: prompt.Text, promptHash: prompt.Hash, query: input.Query, sampling: input.Sampling, tenant: input.tenant, uncached: input.replay != nil}
+	if plan.pipeline == pipelineTwoStage {
+		plan.promptHash = twoStagePromptHash()
+	}
+	return plan
+}
+
+// providersFor are the providers a request's "provider" asks for
+func providersFor(provider string) []string {
+	switch strings.ToLower(strings.TrimSpace(provider)) {
+	case "claude":
+		return []string{"claude"}
+	case "both":
+		return []string{"openai", "claude"}
+	default: // "openai" (or empty)
+		return []string{"openai"}
+	}
+}
+
+// callProviders runs the providers concurrently, answering from the parse
+// cache where it can; each call has its own timeout inside the overall
+// budget, so a slow provider can't take the other one down. A client that
+// went away cancels parent (and with it the calls still running); what
+// finished is returned with aborted set, and nothing more is spent on it.
+func callProviders(ctx, parent context.Context, plan parsePlan, providers []string, variant *Variant) (calls []*providerCall, aborted bool) {
+	calls = make([]*providerCall, len(providers))
+	keys := make([]string, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		keys[i] = parseCacheKey(plan, provider, variant.model(provider))
+		if c := parseCache.get(keys[i]); c != nil {
+			calls[i] = c
+			continue
+		}
+		wg.Add(1)
+		go func(i int, provider string) {
+			defer wg.Done()
+			calls[i] = callProvider(ctx, plan, provider, variant.model(provider))
+		}(i, provider)
+	}
+	wg.Wait()
+
+	aborted = errors.Is(parent.Err(), context.Canceled)
+	if aborted {
+		return calls, aborted
+	}
+	// Tiered routing: low-confidence answers go to the escalation model
+	escalate(ctx, calls, plan)
+	for i, c := range calls {
+		parseCache.put(keys[i], c)
+	}
+	return calls, aborted
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(results)
+// providerCall is the outcome of one provider's part of a parse request
+type providerCall struct {
+	provider   string
+	res        *ParseResponse
+	usage      Usage // summed over all calls, incl. escalation
+	repaired   bool  // the answer needed JSON repair
+	repro      *Repro
+	confidence map[string]*SlotConfidence
+	trace      *StageTrace
+	timings    StageTimings
+	err        error
+	latency    time.Duration
+	tier       string
+	escalation *EscalationInfo
+	cached     bool // answered from the parse cache
+}
+
+// callProvider runs one provider through the pipeline, holding one of its
+// concurrency slots for the duration of the call
+func callProvider(ctx context.Context, plan parsePlan, provider, model string) (c *providerCall) {
+	c = &providerCall{provider: provider, timings: StageTimings{}}
+	name := providerDisplayName(provider)
+	ctx = withTimings(withCaptureProvider(ctx, provider), c.timings)
+	start := time.Now()
+	defer func() { c.latency = time.Since(start) }()
+
+	cli, err := newClientFor(provider, model)
+	if err != nil {
+		log.Printf("[ERROR] %s client error: %v", name, err)
+		c.err = err
+		return c
+	}
+	cli = withSampling(cli, plan.sampling)
+	release, err := acquireSlot(ctx, provider)
+	if err != nil {
+		log.Printf("[WARN] %s call rejected: %v", name, err)
+		c.err = err
+		return c
+	}
+	defer release()
+	if err := breakerAllow(provider); err != nil && !plan.selfTest {
+		log.Printf("[WARN] %s call rejected: %v", name, err)
+		c.err = err
+		return c
+	}
+	defer func() { recordCall(provider, c.err) }()
+	defer guardModelPanic(name, func(err error) { c.res, c.trace, c.err = nil, nil, err })
+
+	timeout := parseTimeout(provider)
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	callStart := time.Now()
+	var out Completion
+	if plan.pipeline == pipelineTwoStage {
+		c.res, out, c.trace, c.err = parseTwoStage(callCtx, cli, name, plan.query)
+	} else {
+		c.res, out, c.err = parseWith(callCtx, cli, name, plan.systemPrompt, plan.query)
+	}
+	switch {
+	case c.err == nil:
+		recordLatency(provider, time.Since(callStart))
+	case errors.Is(callCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		recordLatency(provider, timeout) // cut off by its own timeout, not the budget
+	}
+	c.usage, c.repaired = out.Usage, out.Repaired
+	if out.Repro != (Repro{}) {
+		c.repro = &out.Repro
+	}
+	if c.err == nil && c.trace == nil {
+		c.confidence = slotConfidence(out.Text, out.Tokens)
+	}
+	return c
 }
 
 func main() {
 	_ = godotenv.Load()
+	if err := applyConfig(); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	groundTruth = &fileGroundTruth{path: dataPath(groundFile)}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "stub" {
+		os.Exit(runStub(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ablate" {
+		os.Exit(runAblation(os.Args[2:]))
+	}
+	if err := useStubProvider(); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	watchSIGHUP()
+	if _, err := upstreamTransport(); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	buildClients()
+	buildLimiters()
+	watchPrompts()
+	store = newResultStore()
+	if gs, ok := store.(GroundTruthStore); ok {
+		groundTruth = gs
+	}
+	if ps, ok := store.(PromptStore); ok {
+		prompts = ps
+	}
+	loadUsage()
+	startLeaderElection()
+	startEventBus()
+	startTelemetry()
+	startPromptSuggestions()
+	startCalibration()
+	startWarmup()
 	addr := ":8080"
 	if p := os.Getenv("PORT"); p != "" {
 		addr = ":" + p
 	}
 
+	log.Fatal(serve(addr, newHandler()))
+}
+
+// newHandler is the API with its middleware
+func newHandler() http.Handler {
 	mux := http.NewServeMux()
-	mux.Handle("/v1/parse", corsMiddleware(http.HandlerFunc(parseHandler)))
-	mux.Handle("/v1/evaluations", corsMiddleware(http.HandlerFunc(evalHandler)))
+	mux.HandleFunc("/v1/parse", parseHandler)
+	mux.HandleFunc("/v1/proto/parse.proto", protoDocHandler)
+	mux.HandleFunc("/v1/evaluations", requireKey(evalHandler))
+	mux.HandleFunc("/v1/evaluations/pareto", requireKey(paretoHandler))
+	mux.HandleFunc("/v1/groundtruth", requireKey(groundTruthHandler))
+	mux.HandleFunc("/v1/providers", providersHandler)
+	mux.HandleFunc("/v1/usage", usageHandler)
+	mux.HandleFunc("/v1/providers/{name}/test", requireAdmin(providerTestHandler))
+	mux.HandleFunc("/v1/results", requireKey(resultsHandler))
+	mux.HandleFunc("/v1/results/search", requireKey(resultSearchHandler))
+	mux.HandleFunc("/v1/graphql", requireKey(graphqlHandler))
+	mux.HandleFunc("/v1/ws", requireKey(liveHandler))
+	mux.HandleFunc("/v1/simulator/search", simulatorHandler)
+	mux.HandleFunc("/v1/relax", relaxHandler)
+	mux.HandleFunc("/v1/suggest", requireKey(suggestHandler))
+	mux.HandleFunc("/v1/export/runs", requireKey(exportRunsHandler))
+	mux.HandleFunc("/v1/export/evals", requireKey(exportEvalsHandler))
+	mux.HandleFunc("/v1/results/{id}/diff", requireKey(resultDiffHandler))
+	mux.HandleFunc("/v1/results/{id}/raw", requireAdmin(resultRawHandler))
+	mux.HandleFunc("/v1/replay", requireAdmin(replayHandler))
+	mux.HandleFunc("/v1/replay/{id}", requireAdmin(replayStatusHandler))
+	mux.HandleFunc("/v1/admin/config", requireAdmin(adminConfigHandler))
+	mux.HandleFunc("/v1/admin/providers", requireAdmin(adminProvidersHandler))
+	mux.HandleFunc("/v1/admin/flush", requireAdmin(adminFlushHandler))
+	mux.HandleFunc("/v1/admin/warmup", requireAdmin(adminWarmupHandler))
+	mux.HandleFunc("/v1/admin/reload", requireAdmin(adminReloadHandler))
+	mux.HandleFunc("/v1/admin/runtime", requireAdmin(runtimeHandler))
+	mux.HandleFunc("/v1/admin/calibration", requireAdmin(calibrationHandler))
+	mux.HandleFunc("/v1/admin/prompt-suggestions", requireAdmin(promptSuggestionsHandler))
+	mux.HandleFunc("/v1/admin/prompt-suggestions/{id}", requireAdmin(promptSuggestionHandler))
+	mux.HandleFunc(pprofPrefix, requireAdmin(pprofHandler))
+	mux.HandleFunc("/metrics", metricsHandler)
 
-	log.Println("Server on " + addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	return chain(mux,
+		loggingMiddleware,
+		corsMiddleware,
+		gzipMiddleware,
+		timeoutMiddleware,
+		recoverMiddleware,
+		tenantMiddleware,
+	)
 }
 
-// extractJSONObject scans for the first balanced JSON object
-func extractJSONObject(s string) (string, error) {
-	start := -1
-	depth := 0
-	for i, r := range s {
-		if r == '{' {
-			if depth == 0 {
-				start = i
-			}
-			depth++
-		} else if r == '}' {
-			if depth > 0 {
-				depth--
-				if depth == 0 && start != -1 {
-					return s[start : i+1], nil
-				}
-			}
+const examplesFile = "prompt/examples.json"
+
+// loadSystemPrompt reads the system prompt from path (falling back to the
+// built-in default) and appends the few-shot examples.
+func loadSystemPrompt(path, examples string) string {
+	systemPrompt := defaultSystemPrompt
+	if b, err := readPrompt(path); err == nil {
+		systemPrompt = string(b)
+	}
+	if b, err := readPrompt(examples); err == nil {
+		systemPrompt += "\n\nBeispiele (nur zur Steuerung, nicht ausgeben):\n" + string(b)
+	}
+	return systemPrompt
+}
+
+// parseWith runs one provider call and decodes + validates its output. The
+// completion (usage, repair flag) is returned even when the output is
+// unusable.
+func parseWith(ctx context.Context, cli LLMClient, provider, systemPrompt, query string) (res *ParseResponse, out Completion, err error) {
+	defer guardModelPanic(provider, func(e error) { res, err = nil, e })
+	start := time.Now()
+	stop := timeStage(ctx, timingUpstream)
+	out, err = withParseTool(cli).CompleteJSON(ctx, systemPrompt, query)
+	stop()
+	if err != nil {
+		log.Printf("[ERROR] %s completion failed: %v", provider, err)
+		return nil, out, err
+	}
+	var parsed ParseResponse
+	stop = timeStage(ctx, timingDecode)
+	err = decodeModelJSON(provider, &out, &parsed)
+	stop()
+	if err != nil {
+		return nil, out, err
+	}
+	stop = timeStage(ctx, timingPostprocess)
+	parsed.postProcess()
+	stop()
+	stop = timeStage(ctx, timingValidate)
+	err = parsed.Validate()
+	stop()
+	if err != nil {
+		log.Printf("[ERROR] %s validation failed: %v", provider, err)
+		return nil, out, &parseError{Code: codeSchemaViolation, Detail: "model output failed validation: " + err.Error(), Err: err}
+	}
+	log.Printf("[INFO] %s parsed successfully in %s", provider, time.Since(start))
+	return &parsed, out, nil
+}
+
+// decodeModelJSON extracts the JSON object from a model answer and decodes
+// it strictly into v, repairing harmless syntax slips if enabled (which sets
+// out.Repaired)
+func decodeModelJSON(provider string, out *Completion, v any) error {
+	raw := out.Text
+	jsonPart, err := extractJSONObject(raw)
+	if err != nil {
+		if out.Truncated {
+			log.Printf("[ERROR] %s output truncated: %s", provider, raw)
+			return &parseError{Code: codeSchemaViolation, Detail: "model output was cut off at max_tokens", Err: err}
+		}
+		log.Printf("[ERROR] %s no JSON found: %s", provider, raw)
+		return &parseError{Code: codeSchemaViolation, Detail: "no JSON object in model output", Err: err}
+	}
+	err = decodeStrict(jsonPart, v)
+	var syntaxErr *json.SyntaxError
+	if err != nil && errors.As(err, &syntaxErr) && jsonRepairEnabled() {
+		if fixed := repairJSON(jsonPart); fixed != jsonPart && decodeStrict(fixed, v) == nil {
+			log.Printf("[WARN] %s output needed JSON repair", provider)
+			out.Repaired = true
+			err = nil
 		}
 	}
-	return "", errors.New("no balanced JSON object found")
+	if err != nil {
+		log.Printf("[ERROR] %s schema violation: %v", provider, err)
+		return &parseError{Code: codeSchemaViolation, Detail: "model output does not match the schema", Err: err}
+	}
+	sanitizeStrings(v)
+	return nil
+}
+
+// decodeStrict decodes one JSON object into v: unknown fields and trailing
+// data are errors
+func decodeStrict(s string, v any) error {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after the JSON object")
+	}
+	return nil
 }
@@ -0,0 +1,74 @@
+package main
+
+import "sync"
+
+// ====== Custom evaluation metrics ======
+// Teams add metrics by implementing Metric in a file of their own and
+// registering a constructor from init():
+//
+//	func init() { RegisterMetric(func() Metric { return &myMetric{} }) }
+//
+// Every provider (and the shadow runs) gets a fresh instance per evaluation
+// group; it sees each scored prediction with its ground truth and its
+// Result is reported under custom.<Name> in ProviderMetrics. Accumulate is
+// called with the evaluation lock held, so instances need no locking. In
+// weighted dedup mode custom metrics still see every run once.
+
+// Metric is a custom evaluation metric
+type Metric interface {
+	Name() string
+	Accumulate(pred, truth ParseResponse)
+	Result() any // JSON-encodable
+}
+
+var metricRegistry struct {
+	sync.Mutex
+	factories []func() Metric
+}
+
+// RegisterMetric adds a metric to every evaluation
+func RegisterMetric(newMetric func() Metric) {
+	metricRegistry.Lock()
+	metricRegistry.factories = append(metricRegistry.factories, newMetric)
+	metricRegistry.Unlock()
+	evals.invalidate()
+}
+
+// newMetrics instantiates every registered metric
+func newMetrics() []Metric {
+	metricRegistry.Lock()
+	defer metricRegistry.Unlock()
+	ms := make([]Metric, 0, len(metricRegistry.factories))
+	for _, f := range metricRegistry.factories {
+		ms = append(ms, f())
+	}
+	return ms
+}
+
+// ----- built-in: booking-critical slot accuracy -----
+
+func init() { RegisterMetric(func() Metric { return &criticalSlotAccuracy{} }) }
+
+// criticalSlots decide whether a search can be run at all
+var criticalSlots = []string{"location", "dates.checkin", "dates.checkout", "guests.adults", "guests.children"}
+
+// criticalSlotAccuracy is the share of predictions that get every
+// booking-critical slot right
+type criticalSlotAccuracy struct {
+	n, correct int
+}
+
+func (m *criticalSlotAccuracy) Name() string { return "booking_critical_accuracy" }
+
+func (m *criticalSlotAccuracy) Accumulate(pred, truth ParseResponse) {
+	got, want := slotValues(pred), slotValues(truth)
+	m.n++
+	for _, slot := range criticalSlots {
+		if !setsEqual(got[slot], want[slot]) {
+			return
+		}
+	}
+	m.correct++
+}
+
+func (m *criticalSlotAccuracy) Result() any { return round2(safeDiv(m.correct, m.n)) }
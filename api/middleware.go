@@ -0,0 +1,208 @@
+package main
+
+import (
+	"compress/gzip"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ====== Middleware ======
+
+// middleware wraps a handler; chain applies them outermost first
+type middleware func(http.Handler) http.Handler
+
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// statusRecorder remembers the status code and body size for logging and
+// whether a response has been started
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// loggingMiddleware logs one line per request
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		log.Printf("[INFO] %s %s -> %d (%d bytes) in %s", r.Method, r.URL.Path, rec.status, rec.bytes, time.Since(start))
+	})
+}
+
+// recoverMiddleware turns a panicking handler into a 500 problem response and
+// logs the stack trace. It sits inside the timeout so the trace points at the
+// handler, not at the timeout wrapper re-panicking.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			log.Printf("[ERROR] panic serving %s %s: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+			if rec.status == 0 {
+				writeProblem(rec, newProblem(http.StatusInternalServerError, codeInternal, "internal error"))
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// timeoutMiddleware answers 503 when a handler runs longer than
+// REQUEST_TIMEOUT (default: the parse budget plus 15s). http.TimeoutHandler
+// buffers the whole response, so streamed exports and the live feed
+// bypass it.
+func timeoutMiddleware(next http.Handler) http.Handler {
+	d := envDuration("REQUEST_TIMEOUT", requestBudget()+15*time.Second)
+	body := `{"type":"urn:hotelparser:problem:timeout","title":"Timeout","status":503,"detail":"request took too long","code":"timeout","message_de":"` + problemMessagesDE[codeTimeout] + `"}`
+	th := http.TimeoutHandler(next, d, body)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/export/") || strings.HasPrefix(r.URL.Path, pprofPrefix) || r.URL.Path == "/v1/ws" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Used for the timeout body only; handler headers replace it otherwise
+		w.Header().Set("Content-Type", "application/problem+json")
+		th.ServeHTTP(w, r)
+	})
+}
+
+// gzipMiddleware compresses responses for clients that accept gzip
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(enc), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipWriter starts compressing on the first write unless the handler set its
+// own Content-Encoding or the status has no body
+type gzipWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	started bool
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if !g.started {
+		g.started = true
+		h := g.Header()
+		if h.Get("Content-Encoding") == "" && code != http.StatusNoContent && code != http.StatusNotModified && code >= 200 {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			g.gz = gzip.NewWriter(g.ResponseWriter)
+		}
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipWriter) Write(b []byte) (int, error) {
+	if !g.started {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+func (g *gzipWriter) Close() {
+	if g.gz != nil {
+		_ = g.gz.Close()
+	}
+}
+
+// Flush sends what has been compressed so far (streamed exports)
+func (g *gzipWriter) Flush() {
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	}
+	_ = http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+func (g *gzipWriter) Unwrap() http.ResponseWriter { return g.ResponseWriter }
+
+// corsOrigins are the allowed browser origins (CORS_ORIGINS, comma separated);
+// the default allows the Vite dev server
+func corsOrigins() []string {
+	if v := os.Getenv("CORS_ORIGINS"); v != "" {
+		var out []string
+		for _, o := range strings.Split(v, ",") {
+			out = append(out, strings.TrimSpace(o))
+		}
+		return out
+	}
+	return []string{"http://localhost:5173", "http://127.0.0.1:5173"}
+}
+
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && slices.Contains(corsOrigins(), origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Tenant")
+			// Allow GET for /v1/evaluations, POST for /v1/parse, DELETE for /v1/groundtruth
+			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, DELETE, OPTIONS")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ====== Excluded criteria ======
+// "Kein Club-Hotel, nicht all-inclusive, ohne Animation" names filters the
+// guest does not want. Such values go into excluded_filters, which has the
+// same slots as ui_filters; a value may not be in both. The evaluation
+// scores them as "excluded.<slot>=<value>". A polarity flip - the value
+// expected in one and answered in the other - is worse than a miss: the
+// search would show exactly what was ruled out, so each flip costs
+// polarityFlipWeight extra false positives on top of the FP and FN it
+// already causes.
+
+const polarityFlipWeight = 2
+
+// negationWord starts the negated part of a clause
+var negationWord = regexp.MustCompile(`(?i)\b(?:kein(?:e[nmrs]?)?|nicht|ohne|außer)\b`)
+
+// clauseSplit separates clauses a negation doesn't reach past
+var clauseSplit = regexp.MustCompile(`(?i)[,;.!?]|\s(?:aber|sondern|jedoch)\s`)
+
+// negationEnd ends a negation inside a clause: "ohne Animation mit Pool"
+var negationEnd = regexp.MustCompile(`(?i)\s(?:mit|dafür|und mit)\s`)
+
+// matchPolarity matches the taxonomy against query clause by clause: what
+// follows a negation word in its clause is excluded, the rest wanted.
+// Synonyms that contain the negation themselves ("ohne Verpflegung" for
+// room_only) still count as wanted.
+func matchPolarity(tax Taxonomy, query string) (wanted, excluded []SlotValue) {
+	for _, clause := range clauseSplit.Split(query, -1) {
+		loc := negationWord.FindStringIndex(clause)
+		if loc == nil {
+			wanted = append(wanted, tax.Match(clause)...)
+			continue
+		}
+		before, scope, after := clause[:loc[0]], clause[loc[0]:], ""
+		if end := negationEnd.FindStringIndex(scope); end != nil {
+			scope, after = scope[:end[0]], scope[end[0]:]
+		}
+		wanted = append(wanted, tax.Match(before+" "+after)...)
+		negated := tax.Match(scope[loc[1]-loc[0]:])
+		excluded = append(excluded, negated...)
+		for _, sv := range tax.Match(scope) {
+			if !slices.Contains(negated, sv) {
+				wanted = append(wanted, sv)
+			}
+		}
+	}
+	return wanted, excluded
+}
+
+// normalizeExcluded gives every excluded_filters slot a list and drops
+// values that are also wanted (the positive reading wins over a model
+// that put a value in both)
+func (p *ParseResponse) normalizeExcluded() {
+	for _, slot := range uiFilterSlots {
+		list := p.ExcludedFilters.Slot(slot)
+		if *list == nil {
+			*list = []string{}
+			continue
+		}
+		wanted := *p.UiFilters.Slot(slot)
+		kept := (*list)[:0]
+		for _, v := range *list {
+			if v = strings.TrimSpace(v); v != "" && !slices.Contains(wanted, v) {
+				kept = append(kept, v)
+			}
+		}
+		*list = kept
+	}
+}
+
+// polarityFlips are the keys of pred whose value gt has with the other
+// polarity (ui.meals=x against excluded.meals=x, or the reverse)
+func polarityFlips(pred, gt map[string]bool) []string {
+	var flips []string
+	for k := range pred {
+		var other string
+		switch {
+		case strings.HasPrefix(k, "ui."):
+			other = "excluded." + strings.TrimPrefix(k, "ui.")
+		case strings.HasPrefix(k, "excluded."):
+			other = "ui." + strings.TrimPrefix(k, "excluded.")
+		default:
+			continue
+		}
+		if gt[other] {
+			flips = append(flips, k)
+		}
+	}
+	return flips
+}
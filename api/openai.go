@@ -2,27 +2,36 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
-	"time"
 )
 
 type OpenAIClient struct {
 	BaseURL string
 	APIKey  string
 	Model   string
-	Client  *http.Client
+	// MaxTokens caps the completion (OPENAI_MAX_TOKENS); a truncated answer
+	// is retried once with RetryMaxTokens (OPENAI_MAX_TOKENS_RETRY)
+	MaxTokens      int
+	RetryMaxTokens int
+	Sampling       Sampling          // seed/temperature/top_p sent with every call
+	Logprobs       bool              // request token logprobs (OPENAI_LOGPROBS)
+	Tool           *parseTool        // answer through this function (tool-calling mode)
+	Headers        map[string]string // extra headers, e.g. for an egress proxy
+	Client         *http.Client
 }
 
 func NewOpenAIClient() (*OpenAIClient, error) {
 	key := os.Getenv("OPENAI_API_KEY")
 	if key == "" {
-		return nil, errors.New("OPENAI_API_KEY missing")
+		return nil, fmt.Errorf("%w: OPENAI_API_KEY missing", errNotConfigured)
 	}
 	base := os.Getenv("OPENAI_BASE_URL")
 	if base == "" {
@@ -32,11 +41,25 @@ func NewOpenAIClient() (*OpenAIClient, error) {
 	if model == "" {
 		model = "gpt-5-mini"
 	}
+	headers, err := extraHeaders("openai")
+	if err != nil {
+		return nil, err
+	}
+	client, err := upstreamClient("openai")
+	if err != nil {
+		return nil, err
+	}
+	maxTokens := envInt("OPENAI_MAX_TOKENS", 2048)
 	return &OpenAIClient{
-		BaseURL: base,
-		APIKey:  key,
-		Model:   model,
-		Client:  &http.Client{Timeout: 60 * time.Second},
+		BaseURL:        base,
+		APIKey:         key,
+		Model:          model,
+		MaxTokens:      maxTokens,
+		RetryMaxTokens: envInt("OPENAI_MAX_TOKENS_RETRY", 2*maxTokens),
+		Sampling:       envSampling("OPENAI"),
+		Logprobs:       envBool("OPENAI_LOGPROBS"),
+		Headers:        headers,
+		Client:         client,
 	}, nil
 }
 
@@ -46,62 +69,160 @@ type chatMessage struct {
 }
 
 type chatReq struct {
-	Model       string        `json:"model"`
-	Messages    []chatMessage `json:"messages"`
-	Temperature float64       `json:"temperature"`
+	Model               string        `json:"model"`
+	Messages            []chatMessage `json:"messages"`
+	Temperature         *float64      `json:"temperature,omitempty"`
+	TopP                *float64      `json:"top_p,omitempty"`
+	Seed                *int64        `json:"seed,omitempty"`
+	MaxCompletionTokens int           `json:"max_completion_tokens,omitempty"`
+	Logprobs            bool          `json:"logprobs,omitempty"`
+	Tools               []chatTool    `json:"tools,omitempty"`
+	ToolChoice          any           `json:"tool_choice,omitempty"`
+}
+
+type chatTool struct {
+	Type     string       `json:"type"` // "function"
+	Function chatFunction `json:"function"`
+}
+
+type chatFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+	Strict      bool           `json:"strict,omitempty"`
 }
 
 type chatResp struct {
-	Choices []struct {
+	Model             string `json:"model"`
+	SystemFingerprint string `json:"system_fingerprint"`
+	Choices           []struct {
 		Message struct {
-			Content string `json:"content"`
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"` // JSON text
+				} `json:"function"`
+			} `json:"tool_calls"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+		Logprobs     *struct {
+			Content []TokenLogprob `json:"content"`
+		} `json:"logprobs"`
 	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
 }
 
 // LLMClient interface
 type LLMClient interface {
-	CompleteJSON(ctx context.Context, systemPrompt, user string) (string, error)
+	CompleteJSON(ctx context.Context, systemPrompt, user string) (Completion, error)
+}
+
+// Completion is the model's answer plus what the provider reported about it
+type Completion struct {
+	Text      string
+	Usage     Usage
+	Truncated bool // the answer hit the max_tokens limit
+	Repaired  bool // the JSON needed repair (set while decoding)
+	Repro     Repro
+	Tokens    []TokenLogprob // per-token logprobs, when requested and supported
+}
+
+// Usage is the token count of one provider call (summed over retries)
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u *Usage) add(o Usage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
 }
 
-func (c *OpenAIClient) CompleteJSON(ctx context.Context, systemPrompt, user string) (string, error) {
+// CompleteJSON retries once with RetryMaxTokens when the first answer was
+// cut off by the token limit (finish_reason "length")
+func (c *OpenAIClient) CompleteJSON(ctx context.Context, systemPrompt, user string) (Completion, error) {
 	if systemPrompt != "" && !containsJSONWord(systemPrompt) {
 		systemPrompt += "\n\n(Hinweis: Antworte ausschließlich mit einem einzigen JSON-Objekt passend zum Schema.)"
 	}
+	out, err := c.complete(ctx, systemPrompt, user, c.MaxTokens)
+	if err != nil || !out.Truncated || c.RetryMaxTokens <= c.MaxTokens {
+		return out, err
+	}
+	log.Printf("[WARN] OpenAI output truncated at %d tokens, retrying with %d", c.MaxTokens, c.RetryMaxTokens)
+	retry, err := c.complete(ctx, systemPrompt, user, c.RetryMaxTokens)
+	retry.Usage.add(out.Usage)
+	return retry, err
+}
 
+func (c *OpenAIClient) complete(ctx context.Context, systemPrompt, user string, maxTokens int) (Completion, error) {
 	payload := chatReq{
-		Model:       c.Model,
-		Temperature: 0,
+		Model:               c.Model,
+		Temperature:         c.Sampling.Temperature,
+		TopP:                c.Sampling.TopP,
+		Seed:                c.Sampling.Seed,
+		MaxCompletionTokens: maxTokens,
+		Logprobs:            c.Logprobs,
 		Messages: []chatMessage{
 			{Role: "system", Content: systemPrompt},
 			{Role: "user", Content: user},
 		},
 	}
+	if t := c.Tool; t != nil {
+		payload.Tools = []chatTool{{Type: "function", Function: chatFunction{
+			Name: t.Name, Description: t.Description, Parameters: t.Schema, Strict: true}}}
+		payload.ToolChoice = map[string]any{"type": "function", "function": map[string]string{"name": t.Name}}
+	}
 
 	b, _ := json.Marshal(payload)
 	req, _ := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/chat/completions", bytes.NewReader(b))
 	req.Header.Set("Authorization", "Bearer "+c.APIKey)
 	req.Header.Set("Content-Type", "application/json")
+	setHeaders(req, c.Headers)
 
 	res, err := c.Client.Do(req)
 	if err != nil {
-		return "", err
+		return Completion{}, err
 	}
 	defer res.Body.Close()
 
 	body, _ := io.ReadAll(res.Body)
 	if res.StatusCode >= 300 {
-		return "", fmt.Errorf("openai: %s", body)
+		return Completion{}, &upstreamError{Provider: "openai", Status: res.StatusCode, Body: string(body)}
 	}
 
 	var out chatResp
 	if err := json.Unmarshal(body, &out); err != nil {
-		return "", err
+		return Completion{}, err
+	}
+	usage := Usage{PromptTokens: out.Usage.PromptTokens, CompletionTokens: out.Usage.CompletionTokens}
+	repro := Repro{Model: cmp.Or(out.Model, c.Model), Sampling: c.Sampling, SystemFingerprint: out.SystemFingerprint}
+	if c.Tool != nil {
+		repro.ToolSchema = c.Tool.Hash
 	}
 	if len(out.Choices) == 0 {
-		return "", errors.New("no choices")
+		return Completion{Usage: usage, Repro: repro}, errors.New("no choices")
+	}
+	msg := out.Choices[0].Message
+	text := msg.Content
+	for _, call := range msg.ToolCalls {
+		if c.Tool != nil && call.Function.Name == c.Tool.Name {
+			text = call.Function.Arguments
+		}
+	}
+	completion := Completion{
+		Text:      text,
+		Usage:     usage,
+		Truncated: out.Choices[0].FinishReason == "length",
+		Repro:     repro,
+	}
+	if lp := out.Choices[0].Logprobs; lp != nil {
+		completion.Tokens = lp.Content
 	}
-	return out.Choices[0].Message.Content, nil
+	return completion, nil
 }
 
 func containsJSONWord(s string) bool {
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ====== Cost/quality Pareto report ======
+// GET /v1/evaluations/pareto groups the scored runs (live and shadow) by
+// provider, model and prompt and reports F1, cost per 1k queries and p95
+// latency for each combination. pareto marks the combinations no other one
+// beats on all three. Cost comes from MODEL_PRICES, a JSON object of USD
+// per million tokens by model name or prefix:
+//
+//	MODEL_PRICES={"gpt-4o-mini":{"input":0.15,"output":0.6},"claude-3-5-haiku":{"input":0.8,"output":4}}
+//
+// Combinations without a price or token usage have no cost and are compared
+// on F1 and latency only.
+
+// ModelPrice is USD per million tokens
+type ModelPrice struct {
+	Input  float64 `json:"input" yaml:"input"`
+	Output float64 `json:"output" yaml:"output"`
+}
+
+type ParetoPoint struct {
+	Provider     string   `json:"provider"`
+	Model        string   `json:"model,omitempty"`
+	Prompt       string   `json:"prompt,omitempty"` // prompt hash, or the shadow prompt file
+	Shadow       bool     `json:"shadow,omitempty"`
+	Count        int      `json:"count"`
+	F1           float64  `json:"f1"`
+	CostPer1kUSD *float64 `json:"cost_per_1k_usd,omitempty"`
+	P95LatencyMS int64    `json:"p95_latency_ms"`
+	Pareto       bool     `json:"pareto"`
+}
+
+func (p ModelPrice) cost(u Usage) float64 {
+	return (float64(u.PromptTokens)*p.Input + float64(u.CompletionTokens)*p.Output) / 1e6
+}
+
+// modelPrices parses MODEL_PRICES; invalid JSON disables pricing
+func modelPrices() (map[string]ModelPrice, error) {
+	v := os.Getenv("MODEL_PRICES")
+	if v == "" {
+		return nil, nil
+	}
+	var prices map[string]ModelPrice
+	if err := json.Unmarshal([]byte(v), &prices); err != nil {
+		return nil, fmt.Errorf("MODEL_PRICES: %w", err)
+	}
+	return prices, nil
+}
+
+// priceFor finds model's price by exact name, else by the longest prefix
+// (so "gpt-4o-mini" covers "gpt-4o-mini-2024-07-18")
+func priceFor(prices map[string]ModelPrice, model string) (ModelPrice, bool) {
+	if p, ok := prices[model]; ok {
+		return p, true
+	}
+	best, found := "", false
+	for name := range prices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best, found = name, true
+		}
+	}
+	return prices[best], found
+}
+
+type paretoAcc struct {
+	point     ParetoPoint
+	acc       *acc
+	latencies []int64
+	cost      float64
+	costed    int
+}
+
+// paretoReport scores every stored run with ground truth per combination
+func paretoReport(tenant string) ([]ParetoPoint, error) {
+	prices, err := modelPrices()
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	gtMap := map[string]GroundTruthItem{}
+	for _, g := range loadGroundTruth(tenant) {
+		gtMap[g.Query] = g
+	}
+	groups := map[string]*paretoAcc{}
+	add := func(p ParetoPoint, pred *ParseResponse, gt ParseResponse, latency int64, u *Usage) {
+		if pred == nil {
+			return
+		}
+		key := strings.Join([]string{p.Provider, p.Model, p.Prompt, fmt.Sprint(p.Shadow)}, "\x00")
+		g := groups[key]
+		if g == nil {
+			g = &paretoAcc{point: p, acc: newAcc()}
+			groups[key] = g
+		}
+		g.acc.add(scoreAgainstGT(*pred, gt), latency)
+		g.acc.addSlots(*pred, gt)
+		g.latencies = append(g.latencies, latency)
+		if price, ok := priceFor(prices, p.Model); ok && u != nil {
+			g.cost += price.cost(*u)
+			g.costed++
+		}
+	}
+
+	err = store.Each(func(run StoredResult) error {
+		item, ok := gtMap[run.Query]
+		if !ok || run.Tenant != tenant || run.Aborted || run.Assisted {
+			return nil
+		}
+		if sh := run.Shadow; sh != nil {
+			add(ParetoPoint{Provider: sh.Provider, Model: sh.Model, Prompt: sh.Prompt, Shadow: true},
+				shadowPrediction(run), item.Truth, run.Latency, sh.Usage)
+			return nil
+		}
+		for provider, pred := range map[string]*ParseResponse{"openai": run.Response.OpenAI, "claude": run.Response.Claude} {
+			p := ParetoPoint{Provider: provider, Prompt: run.Response.PromptHash}
+			latency, usage := run.Latency, (*Usage)(nil)
+			if st := run.Response.Status[provider]; st != nil {
+				if st.Cached {
+					continue // no latency or cost of its own
+				}
+				if st.Repro != nil {
+					p.Model = st.Repro.Model
+				}
+				if st.LatencyMS > 0 {
+					latency = st.LatencyMS
+				}
+				usage = st.Usage
+			}
+			add(p, pred, item.Truth, latency, usage)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]ParetoPoint, 0, len(groups))
+	for _, g := range groups {
+		p := g.point
+		m := g.acc.metrics()
+		p.Count, p.F1 = m.Count, m.F1
+		p.P95LatencyMS = p95(g.latencies)
+		// only priced when every run reported usage
+		if g.costed > 0 && g.costed == len(g.latencies) {
+			c := math.Round(g.cost/float64(g.costed)*1000*10000) / 10000
+			p.CostPer1kUSD = &c
+		}
+		points = append(points, p)
+	}
+	for i := range points {
+		points[i].Pareto = true
+		for j := range points {
+			if i != j && dominates(points[j], points[i]) {
+				points[i].Pareto = false
+				break
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].F1 != points[j].F1 {
+			return points[i].F1 > points[j].F1
+		}
+		return points[i].P95LatencyMS < points[j].P95LatencyMS
+	})
+	return points, nil
+}
+
+// dominates reports whether a is at least as good as b everywhere and
+// better somewhere; cost only counts when both have one
+func dominates(a, b ParetoPoint) bool {
+	geq := a.F1 >= b.F1 && a.P95LatencyMS <= b.P95LatencyMS
+	better := a.F1 > b.F1 || a.P95LatencyMS < b.P95LatencyMS
+	if a.CostPer1kUSD != nil && b.CostPer1kUSD != nil {
+		geq = geq && *a.CostPer1kUSD <= *b.CostPer1kUSD
+		better = better || *a.CostPer1kUSD < *b.CostPer1kUSD
+	}
+	return geq && better
+}
+
+func p95(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	s := append([]int64(nil), values...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s[int(math.Ceil(0.95*float64(len(s))))-1]
+}
+
+func paretoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	points, err := paretoReport(tenantOf(r.Context()))
+	if err != nil {
+		log.Printf("[ERROR] pareto report: %v", err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not load runs"))
+		return
+	}
+	writeEvalJSON(w, r, points)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// ====== Parquet writer ======
+// A small writer for flat tables, enough for the exports: required or
+// optional columns of strings, int64, float64, bool and timestamps. Rows
+// are buffered per row group (parquetRowGroupRows) and written as one
+// PLAIN-encoded, GZIP-compressed data page per column, so memory stays
+// bounded however long the export runs. The footer is Thrift compact
+// protocol as in parquet.thrift; only the fields readers require are
+// written.
+
+const parquetRowGroupRows = 10000
+
+type parquetKind int
+
+const (
+	pqString parquetKind = iota
+	pqInt64
+	pqFloat64
+	pqBool
+	pqTime // stored as INT64 microseconds (TIMESTAMP_MICROS)
+)
+
+type parquetColumn struct {
+	name     string
+	kind     parquetKind
+	optional bool // nil values allowed
+}
+
+// parquet.thrift enum values
+const (
+	pqTypeBoolean   = 0
+	pqTypeInt64     = 2
+	pqTypeDouble    = 5
+	pqTypeByteArray = 6
+
+	pqRequired = 0
+	pqOptional = 1
+
+	pqConvertedUTF8   = 0
+	pqConvertedMicros = 10
+
+	pqEncodingPlain = 0
+	pqEncodingRLE   = 3
+
+	pqCodecGzip = 2
+)
+
+func (k parquetKind) physical() int32 {
+	switch k {
+	case pqString:
+		return pqTypeByteArray
+	case pqFloat64:
+		return pqTypeDouble
+	case pqBool:
+		return pqTypeBoolean
+	}
+	return pqTypeInt64
+}
+
+type pqChunkMeta struct {
+	offset, uncompressed, compressed, values int64
+}
+
+type pqRowGroup struct {
+	chunks []pqChunkMeta
+	rows   int64
+	bytes  int64
+}
+
+type parquetWriter struct {
+	w      io.Writer
+	offset int64
+	cols   []parquetColumn
+	buf    [][]any // per column
+	rows   int
+	groups []pqRowGroup
+	total  int64
+}
+
+func newParquetWriter(w io.Writer, cols []parquetColumn) (*parquetWriter, error) {
+	p := &parquetWriter{w: w, cols: cols, buf: make([][]any, len(cols))}
+	return p, p.write([]byte("PAR1"))
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// Write adds one row; values are in column order and nil, string, int64,
+// float64, bool or time.Time
+func (p *parquetWriter) Write(row []any) error {
+	if len(row) != len(p.cols) {
+		return fmt.Errorf("parquet: row has %d values, want %d", len(row), len(p.cols))
+	}
+	for i, v := range row {
+		if v == nil && !p.cols[i].optional {
+			return fmt.Errorf("parquet: column %s is required", p.cols[i].name)
+		}
+		p.buf[i] = append(p.buf[i], v)
+	}
+	if p.rows++; p.rows >= parquetRowGroupRows {
+		return p.flushGroup()
+	}
+	return nil
+}
+
+// Close writes the last row group and the footer
+func (p *parquetWriter) Close() error {
+	if err := p.flushGroup(); err != nil {
+		return err
+	}
+	footer := p.footer()
+	if err := p.write(footer); err != nil {
+		return err
+	}
+	var tail [8]byte
+	binary.LittleEndian.PutUint32(tail[:4], uint32(len(footer)))
+	copy(tail[4:], "PAR1")
+	return p.write(tail[:])
+}
+
+func (p *parquetWriter) flushGroup() error {
+	if p.rows == 0 {
+		return nil
+	}
+	g := pqRowGroup{rows: int64(p.rows)}
+	for i, col := range p.cols {
+		meta, err := p.writeChunk(col, p.buf[i])
+		if err != nil {
+			return err
+		}
+		g.chunks = append(g.chunks, meta)
+		g.bytes += meta.uncompressed
+		p.buf[i] = p.buf[i][:0]
+	}
+	p.groups = append(p.groups, g)
+	p.total += g.rows
+	p.rows = 0
+	return nil
+}
+
+// writeChunk writes one column of the row group as a single data page
+func (p *parquetWriter) writeChunk(col parquetColumn, values []any) (pqChunkMeta, error) {
+	var page bytes.Buffer
+	if col.optional {
+		levels := rleBits(values)
+		_ = binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+		page.Write(levels)
+	}
+	var bits []bool
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			_ = binary.Write(&page, binary.LittleEndian, uint32(len(v)))
+			page.WriteString(v)
+		case int64:
+			_ = binary.Write(&page, binary.LittleEndian, v)
+		case float64:
+			_ = binary.Write(&page, binary.LittleEndian, math.Float64bits(v))
+		case time.Time:
+			_ = binary.Write(&page, binary.LittleEndian, v.UnixMicro())
+		case bool:
+			bits = append(bits, v)
+		default:
+			return pqChunkMeta{}, fmt.Errorf("parquet: column %s: unsupported value %T", col.name, v)
+		}
+	}
+	if col.kind == pqBool {
+		packed := make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		page.Write(packed)
+	}
+
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	_, _ = zw.Write(page.Bytes())
+	if err := zw.Close(); err != nil {
+		return pqChunkMeta{}, err
+	}
+
+	var h thriftWriter
+	h.i32(1, 0) // DATA_PAGE
+	h.i32(2, int32(page.Len()))
+	h.i32(3, int32(zipped.Len()))
+	h.beginStruct(5)
+	h.i32(1, int32(len(values)))
+	h.i32(2, pqEncodingPlain)
+	h.i32(3, pqEncodingRLE)
+	h.i32(4, pqEncodingRLE)
+	h.endStruct()
+	h.stop()
+
+	meta := pqChunkMeta{
+		offset:       p.offset,
+		uncompressed: int64(h.buf.Len() + page.Len()),
+		compressed:   int64(h.buf.Len() + zipped.Len()),
+		values:       int64(len(values)),
+	}
+	if err := p.write(h.buf.Bytes()); err != nil {
+		return meta, err
+	}
+	return meta, p.write(zipped.Bytes())
+}
+
+// rleBits encodes definition levels (1 = value present) as bit-width-1
+// RLE runs
+func rleBits(values []any) []byte {
+	var out []byte
+	for i := 0; i < len(values); {
+		present := values[i] != nil
+		n := 0
+		for i < len(values) && (values[i] != nil) == present {
+			i++
+			n++
+		}
+		out = binary.AppendUvarint(out, uint64(n)<<1)
+		if present {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+func (p *parquetWriter) footer() []byte {
+	var t thriftWriter
+	t.i32(1, 1) // version
+	t.beginList(2, thriftStruct, len(p.cols)+1)
+	t.beginElem()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.cols)))
+	t.endElem()
+	for _, c := range p.cols {
+		t.beginElem()
+		t.i32(1, c.kind.physical())
+		if c.optional {
+			t.i32(3, pqOptional)
+		} else {
+			t.i32(3, pqRequired)
+		}
+		t.binary(4, c.name)
+		switch c.kind {
+		case pqString:
+			t.i32(6, pqConvertedUTF8)
+		case pqTime:
+			t.i32(6, pqConvertedMicros)
+		}
+		t.endElem()
+	}
+	t.i64(3, p.total)
+	t.beginList(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		t.beginElem()
+		t.beginList(1, thriftStruct, len(g.chunks))
+		for i, ch := range g.chunks {
+			c := p.cols[i]
+			t.beginElem()
+			t.i64(2, ch.offset)
+			t.beginStruct(3)
+			t.i32(1, c.kind.physical())
+			t.i32List(2, pqEncodingPlain, pqEncodingRLE)
+			t.binaryList(3, c.name)
+			t.i32(4, pqCodecGzip)
+			t.i64(5, ch.values)
+			t.i64(6, ch.uncompressed)
+			t.i64(7, ch.compressed)
+			t.i64(9, ch.offset)
+			t.endStruct()
+			t.endElem()
+		}
+		t.i64(2, g.bytes)
+		t.i64(3, g.rows)
+		t.endElem()
+	}
+	t.binary(6, "hotelparser")
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// ----- Thrift compact protocol -----
+
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes one struct; last holds the previous field id of
+// each open struct, as field ids are delta-encoded
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if len(t.last) == 0 {
+		t.last = append(t.last, 0)
+	}
+	prev := &t.last[len(t.last)-1]
+	if d := id - *prev; d > 0 && d <= 15 {
+		t.buf.WriteByte(byte(d)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	*prev = id
+}
+
+func (t *thriftWriter) varint(v int64) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64((v<<1)^(v>>63))))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) { t.field(id, thriftI32); t.varint(int64(v)) }
+func (t *thriftWriter) i64(id int16, v int64) { t.field(id, thriftI64); t.varint(v) }
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+func (t *thriftWriter) str(s string) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) listHeader(elem byte, n int) {
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	t.buf.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func (t *thriftWriter) beginList(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	t.listHeader(elem, n)
+}
+
+func (t *thriftWriter) i32List(id int16, vs ...int32) {
+	t.beginList(id, thriftI32, len(vs))
+	for _, v := range vs {
+		t.varint(int64(v))
+	}
+}
+
+func (t *thriftWriter) binaryList(id int16, vs ...string) {
+	t.beginList(id, thriftBinary, len(vs))
+	for _, v := range vs {
+		t.str(v)
+	}
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// beginElem / endElem wrap a struct inside a list
+func (t *thriftWriter) beginElem() { t.last = append(t.last, 0) }
+func (t *thriftWriter) endElem()   { t.endStruct() }
+
+func (t *thriftWriter) stop() { t.buf.WriteByte(0) }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ====== Per-query listing ======
+// GET /v1/evaluations?per_query=1 lists every scored query. The list can be
+// narrowed and paged so the dashboard can ask for e.g. the worst 20 queries
+// for Claude (sort=f1&provider=claude&limit=20):
+//
+//	sort=time|f1|latency    default time
+//	order=asc|desc          default asc (worst F1 / oldest first); desc for latency
+//	provider=openai|claude  whose scores sort and mismatch use; default the
+//	                        worse of the two. A virtual provider (rules)
+//	                        works too
+//	filter=mismatch         no exact match
+//	filter=ambiguous        ambiguous ground truth
+//	filter=disagreement     the providers answered differently
+//	limit, offset           paging; per_query_total is the count before paging
+//
+// Filters are repeatable and must all hold.
+
+type perQueryOpts struct {
+	sort     string
+	desc     bool
+	provider string
+	filters  []string
+	limit    int // 0 = all
+	offset   int
+}
+
+// parsePerQuery reads the listing options; nil when per_query isn't set.
+// Errors are client-safe.
+func parsePerQuery(v url.Values) (*perQueryOpts, error) {
+	if v.Get("per_query") != "1" {
+		return nil, nil
+	}
+	o := &perQueryOpts{sort: "time", provider: v.Get("provider")}
+	if s := v.Get("sort"); s != "" {
+		if !slices.Contains([]string{"time", "f1", "latency"}, s) {
+			return nil, fmt.Errorf("unknown sort %q (use time, f1 or latency)", s)
+		}
+		o.sort = s
+	}
+	switch v.Get("order") {
+	case "":
+		o.desc = o.sort == "latency"
+	case "asc":
+	case "desc":
+		o.desc = true
+	default:
+		return nil, fmt.Errorf("unknown order %q (use asc or desc)", v.Get("order"))
+	}
+	known := o.provider == ""
+	var names []string
+	for _, src := range evalSources {
+		known = known || src.name == o.provider
+		names = append(names, src.name)
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown provider %q (use %s)", o.provider, strings.Join(names, ", "))
+	}
+	for _, f := range v["filter"] {
+		if !slices.Contains([]string{"mismatch", "ambiguous", "disagreement"}, f) {
+			return nil, fmt.Errorf("unknown filter %q (use mismatch, ambiguous or disagreement)", f)
+		}
+		o.filters = append(o.filters, f)
+	}
+	for name, dst := range map[string]*int{"limit": &o.limit, "offset": &o.offset} {
+		if s := v.Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*dst = n
+		}
+	}
+	return o, nil
+}
+
+// scores picks the scores o looks at: the chosen provider's, or the worse
+// of the two
+func (o *perQueryOpts) scores(q PerQueryCompare) *QueryScores {
+	switch {
+	case o.provider == "openai":
+		return q.OpenAI
+	case o.provider == "claude":
+		return q.Claude
+	case o.provider != "":
+		return q.Providers[o.provider]
+	case q.OpenAI == nil:
+		return q.Claude
+	case q.Claude == nil:
+		return q.OpenAI
+	case q.Claude.F1 < q.OpenAI.F1:
+		return q.Claude
+	}
+	return q.OpenAI
+}
+
+func (o *perQueryOpts) keep(q PerQueryCompare) bool {
+	s := o.scores(q)
+	if s == nil {
+		return false
+	}
+	for _, f := range o.filters {
+		switch f {
+		case "mismatch":
+			if s.ExactMatch {
+				return false
+			}
+		case "ambiguous":
+			if !q.Ambiguous {
+				return false
+			}
+		case "disagreement":
+			if !q.Disagree {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// apply filters, sorts and pages list; it returns the page and the number
+// of entries before paging
+func (o *perQueryOpts) apply(list []PerQueryCompare) ([]PerQueryCompare, int) {
+	out := make([]PerQueryCompare, 0, len(list))
+	for _, q := range list {
+		if o.keep(q) {
+			out = append(out, q)
+		}
+	}
+	less := func(a, b PerQueryCompare) bool { return a.Time.Before(b.Time) }
+	switch o.sort {
+	case "f1":
+		less = func(a, b PerQueryCompare) bool { return o.scores(a).F1 < o.scores(b).F1 }
+	case "latency":
+		less = func(a, b PerQueryCompare) bool { return o.scores(a).LatencyMS < o.scores(b).LatencyMS }
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if o.desc {
+			return less(out[j], out[i])
+		}
+		return less(out[i], out[j])
+	})
+
+	total := len(out)
+	out = out[min(o.offset, total):]
+	if o.limit > 0 && len(out) > o.limit {
+		out = out[:o.limit]
+	}
+	return out, total
+}
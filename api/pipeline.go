@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+)
+
+// ====== Two-stage pipeline ======
+// PARSE_PIPELINE=two_stage splits parsing in two:
+//
+//	extract: the model returns the plain facts (location, dates, guests,
+//	         price, stars, rating) and the feature phrases as written
+//	map:     the phrases are mapped to canonical ui_filters values via the
+//	         taxonomy; with STAGE2_MODE=llm phrases the rules don't know are
+//	         sent to a second, taxonomy-constrained model call
+//
+// The intermediate output is stored per run in "stages", and a failure
+// names the stage it happened in.
+
+const (
+	pipelineSingle   = "single"
+	pipelineTwoStage = "two_stage"
+
+	stageExtract = "extract"
+	stageMap     = "map"
+)
+
+// pipelineFor returns the pipeline for a request: the variant's, else
+// PARSE_PIPELINE (default single)
+func pipelineFor(v *Variant) string {
+	p := os.Getenv("PARSE_PIPELINE")
+	if v != nil && v.Pipeline != "" {
+		p = v.Pipeline
+	}
+	if strings.EqualFold(p, pipelineTwoStage) {
+		return pipelineTwoStage
+	}
+	return pipelineSingle
+}
+
+// stage2UsesLLM: STAGE2_MODE=llm (default "rules" maps without a model call)
+func stage2UsesLLM() bool {
+	return strings.EqualFold(os.Getenv("STAGE2_MODE"), "llm")
+}
+
+// Extraction is the stage-1 output
+type Extraction struct {
+	Location       string     `json:"location"`
+	Departure      []string   `json:"departure_airports"`
+	Dates          Dates      `json:"dates"`
+	DateFlex       int        `json:"date_flexibility_days"`
+	Nights         int        `json:"nights"`
+	Transport      string     `json:"transport"`
+	Guests         Guests     `json:"guests"`
+	GroupSize      int        `json:"group_size"`
+	TripPurpose    string     `json:"trip_purpose"`
+	Distances      []Distance `json:"distances"`
+	PriceMaxEUR    float64    `json:"price_max_eur"`
+	StarsMin       int        `json:"stars_min"`
+	StarsMax       int        `json:"stars_max"`
+	RatingMin      float64    `json:"rating_min"`
+	RatingMax      float64    `json:"rating_max"`
+	FamilyFriendly *bool      `json:"family_friendly"`
+	// Mentions are concrete feature phrases, verbatim from the query
+	Mentions []string `json:"mentions"`
+	// ExcludedMentions are the features the query rules out ("ohne
+	// Animation" -> "Animation")
+	ExcludedMentions []string `json:"excluded_mentions"`
+	// PreferredMentions are the mentions that are only nice-to-haves
+	PreferredMentions []string `json:"preferred_mentions"`
+	// Vague criteria ("günstig", "ruhig") that must not be mapped
+	UnsupportedCriteria []string `json:"unsupported_criteria"`
+}
+
+// MentionMapping is one mention mapped to a ui_filters value
+type MentionMapping struct {
+	Mention string `json:"mention"`
+	SlotValue
+	Source    string `json:"source"` // "rules" or "llm"
+	Excluded  bool   `json:"excluded,omitempty"`
+	Preferred bool   `json:"preferred,omitempty"`
+}
+
+// StageTrace is the intermediate output of a two-stage run
+type StageTrace struct {
+	Extraction  *Extraction      `json:"extraction,omitempty"`
+	Mapped      []MentionMapping `json:"mapped,omitempty"`
+	Unmapped    []string         `json:"unmapped,omitempty"` // ended up in unsupported_criteria
+	FailedStage string           `json:"failed_stage,omitempty"`
+}
+
+const extractPromptFile = "prompt/extract.txt"
+const mapPromptFile = "prompt/map.txt"
+
+const defaultExtractPrompt = `Du bist ein Extraktor. Analysiere eine deutsche Hotelsuchanfrage
+und gib ausschließlich ein einziges JSON-Objekt gemäß diesem Schema aus (keine Erklärungen):
+{
+  "location": string,
+  "departure_airports": string[],
+  "dates": { "checkin": string, "checkout": string },
+  "date_flexibility_days": number,
+  "nights": number,
+  "transport": "flight" | "own_arrival" | "train" | "bus" | "",
+  "guests": { "adults": number, "children": number },
+  "group_size": number,
+  "trip_purpose": "business" | "leisure" | "",
+  "distances": [{ "value": number, "unit": "m" | "km" | "min_walk" | "min_drive", "reference": string, "meters": number }],
+  "price_max_eur": number,
+  "stars_min": number,
+  "stars_max": number,
+  "rating_min": number,
+  "rating_max": number,
+  "family_friendly": boolean | null,
+  "mentions": string[],
+  "excluded_mentions": string[],
+  "preferred_mentions": string[],
+  "unsupported_criteria": string[]
+}
+
+Regeln:
+- Preis als EUR-Zahl in price_max_eur (z. B. „unter 150€“ → 150).
+- „mind. 4 Sterne“ → stars_min:4; „8+“ → rating_min:8; „3 bis 4 Sterne“ → stars_min:3, stars_max:4; „Bewertung zwischen 7 und 9“ → rating_min:7, rating_max:9. Obergrenzen nur, wenn genannt; sonst 0.
+- Nur explizit genannte Daten/Gäste setzen; sonst leer lassen.
+- Flexible Daten: „±3 Tage flexibel“ / „+/- 3 Tage“ → date_flexibility_days:3; „um den 15. Juli herum“ → Datum setzen und date_flexibility_days:3. Flexibilität nie in ui_filters.flex; sonst 0.
+- Abflughafen bei Pauschalreisen („ab Frankfurt“, „Abflug ab MUC“) als IATA-Code in departure_airports (FRA, MUC); Städte mit mehreren Flughäfen nennen, nicht raten. Nicht in location oder unsupported_criteria.
+- Anreise in transport: „mit Flug“/„inkl. Flug“ → flight; „eigene Anreise“/„mit dem Auto“ → own_arrival; „Bahn-Anreise“/„mit dem Zug“ → train; „Busreise“ → bus; nicht genannt → "". Ein Abflughafen bedeutet flight.
+- Dauer in nights: „3 Nächte“ → 3, „eine Woche“ → 7, „übers Wochenende“ nicht raten. Bei Dauer und Startdatum („eine Woche ab 12.8.“) checkin setzen, checkout = checkin + nights; sonst 0.
+- Reisegruppe in ui_filters.travelGroup: „alleinreisend“ → solo; Paar/„zu zweit“/Hochzeitsreise → couple; Familie → family; „mit Freunden“/Mädelstrip/Junggesellenabschied → friends; Gruppe/Betriebsausflug/Klassenfahrt → group; „mit Hund“ → withDog. Gesamtzahl der Reisenden („Gruppe von 8“, „zu acht“, „8 Personen“) in group_size, sonst 0; ab 6 Personen zusätzlich travelGroup.group. Gäste nur bei ausdrücklich genannten Erwachsenen/Kindern setzen.
+- Entfernungen zu einem Bezugspunkt in distances: „maximal 10 km vom Flughafen“ → {"value":10,"unit":"km","reference":"airport","meters":10000}; „5 Gehminuten zur Altstadt“ → {"value":5,"unit":"min_walk","reference":"old_town"}; „10 Autominuten zum Zentrum“ → unit min_drive, reference city_center. Bezugspunkte: airport, city_center, old_town, beach, station, trade_fair, ski_lift. „am Strand“ ohne Zahl bleibt distanceBeach. Nicht in unsupported_criteria.
+- Veranstaltungen als Zeitangabe („während des Oktoberfests“, „zur IAA“, „an Karneval“): Daten und Ort nicht raten, sondern leer lassen (der Server ergänzt sie aus dem Veranstaltungskalender); nicht in unsupported_criteria.
+- Reiseanlass in trip_purpose: Geschäftsreise/Dienstreise/Tagung/Messe/Betriebsausflug → business; Urlaub/Ferien/Kurzurlaub → leisure; nicht erkennbar → "".
+- family_friendly nur setzen, wenn die Anfrage Familien/Kinder ausdrücklich wünscht (true) oder ausschließt (false); sonst null.
+- Jede konkrete Ausstattung oder Eigenschaft (z. B. „Frühstück“, „Innenpool“, „am Strand“) und jeder Zimmerwunsch (z. B. „Doppelzimmer“, „Meerblick“, „Balkon“) wortwörtlich als eigener Eintrag in mentions.
+- Verneinte Wünsche („kein Club-Hotel“, „nicht all-inclusive“, „ohne Animation“, „bitte keine Kinder“) ohne das Verneinungswort in excluded_mentions („Club-Hotel“, „all-inclusive“, „Animation“), nie in mentions.
+- Nur erwünschte Wünsche („gerne mit Spa“, „idealerweise Meerblick“) zusätzlich wortwörtlich in preferred_mentions; Muss-Kriterien nicht.
+- Mehrdeutiges (z. B. „günstig“, „nahe“, „ruhig“) nicht raten → wortwörtlich in unsupported_criteria.
+- Antworte nur mit dem JSON-Objekt (keine Erklärungen).
+`
+
+const defaultMapPrompt = `Du ordnest Ausstattungswünsche aus Hotelsuchen kanonischen Filterwerten zu.
+Die Eingabe ist ein JSON-Objekt mit "mentions" (Liste von Phrasen) und "taxonomy"
+(Slot → kanonischer Wert → Synonyme). Gib ausschließlich dieses JSON-Objekt aus:
+{ "mappings": [ { "mention": string, "slot": string, "value": string } ] }
+
+Regeln:
+- Nur Slots und Werte aus der Taxonomie verwenden.
+- Eine Phrase darf mehreren Werten zugeordnet werden; passt keiner, weglassen.
+- Antworte nur mit dem JSON-Objekt (keine Erklärungen).
+`
+
+func extractPrompt() *loadedPrompt {
+	return cachedPrompt(extractPromptFile, func() string { return promptOrDefault(extractPromptFile, defaultExtractPrompt) })
+}
+
+func mapPrompt() *loadedPrompt {
+	return cachedPrompt(mapPromptFile, func() string { return promptOrDefault(mapPromptFile, defaultMapPrompt) })
+}
+
+func promptOrDefault(path, def string) string {
+	if b, err := readPrompt(path); err == nil {
+		return string(b)
+	}
+	return def
+}
+
+// twoStagePromptHash identifies the extract prompt, the map prompt (when
+// used) and the taxonomy together
+func twoStagePromptHash() string {
+	_, taxHash := currentTaxonomy()
+	parts := extractPrompt().Hash + "+" + taxHash
+	if stage2UsesLLM() {
+		parts += "+" + mapPrompt().Hash
+	}
+	sum := sha256.Sum256([]byte(parts))
+	return hex.EncodeToString(sum[:6])
+}
+
+// parseTwoStage runs both stages with one client. The returned completion
+// is the extraction's, with usage summed over both stages. The trace is
+// returned even on failure, with FailedStage set.
+func parseTwoStage(ctx context.Context, cli LLMClient, provider, query string) (*ParseResponse, Completion, *StageTrace, error) {
+	trace := &StageTrace{}
+
+	// Stage 1: extraction
+	stop := timeStage(ctx, timingUpstream)
+	out, err := cli.CompleteJSON(ctx, extractPrompt().Text, query)
+	stop()
+	if err != nil {
+		log.Printf("[ERROR] %s extract stage failed: %v", provider, err)
+		trace.FailedStage = stageExtract
+		return nil, out, trace, err
+	}
+	var ex Extraction
+	stop = timeStage(ctx, timingDecode)
+	err = decodeModelJSON(provider+" extract", &out, &ex)
+	stop()
+	if err != nil {
+		trace.FailedStage = stageExtract
+		return nil, out, trace, err
+	}
+	trace.Extraction = &ex
+
+	res := &ParseResponse{
+		Location:            ex.Location,
+		DepartureAirports:   ex.Departure,
+		Dates:               ex.Dates,
+		DateFlexibilityDays: ex.DateFlex,
+		Nights:              ex.Nights,
+		Transport:           ex.Transport,
+		Guests:              ex.Guests,
+		GroupSize:           ex.GroupSize,
+		TripPurpose:         ex.TripPurpose,
+		Distances:           ex.Distances,
+		PriceMaxEUR:         ex.PriceMaxEUR,
+		StarsMin:            ex.StarsMin,
+		StarsMax:            ex.StarsMax,
+		RatingMin:           ex.RatingMin,
+		RatingMax:           ex.RatingMax,
+		FamilyFriendly:      ex.FamilyFriendly,
+		UiFilters:           emptyUiFilters(),
+		ExcludedFilters:     emptyUiFilters(),
+		PreferredFilters:    emptyUiFilters(),
+		UnsupportedCriteria: append([]string{}, ex.UnsupportedCriteria...),
+	}
+	stop = timeStage(ctx, timingPostprocess)
+	res.postProcess()
+	stop()
+	stop = timeStage(ctx, timingValidate)
+	err = res.Validate()
+	stop()
+	if err != nil {
+		log.Printf("[ERROR] %s extraction failed validation: %v", provider, err)
+		trace.FailedStage = stageExtract
+		return nil, out, trace, &parseError{Code: codeSchemaViolation, Detail: "extraction failed validation: " + err.Error(), Err: err}
+	}
+
+	// Stage 2: mapping
+	tax, _ := currentTaxonomy()
+	res.addRangeFilters()
+	var unmapped []string
+	excluded, preferred := map[string]bool{}, map[string]bool{}
+	for _, m := range ex.ExcludedMentions {
+		excluded[m] = true
+	}
+	for _, m := range ex.PreferredMentions {
+		preferred[m] = true
+	}
+	use := func(mm MentionMapping) {
+		mm.Excluded = excluded[mm.Mention]
+		switch {
+		case mm.Excluded:
+			res.ExcludedFilters.add(mm.Slot, mm.Value)
+		case res.apply(mm.SlotValue) && preferred[mm.Mention]:
+			mm.Preferred = res.PreferredFilters.add(mm.Slot, mm.Value)
+		}
+		trace.Mapped = append(trace.Mapped, mm)
+	}
+	for _, m := range append(ex.Mentions, ex.ExcludedMentions...) {
+		matches := tax.Match(m)
+		if len(matches) == 0 {
+			unmapped = append(unmapped, m)
+			continue
+		}
+		for _, sv := range matches {
+			use(MentionMapping{Mention: m, SlotValue: sv, Source: "rules"})
+		}
+	}
+	if len(unmapped) > 0 && stage2UsesLLM() {
+		mapped, u, err := mapWithLLM(ctx, cli, provider, tax, unmapped)
+		out.Usage.add(u)
+		if err != nil {
+			trace.FailedStage = stageMap
+			return nil, out, trace, err
+		}
+		done := map[string]bool{}
+		for _, mm := range mapped {
+			use(mm)
+			done[mm.Mention] = true
+		}
+		rest := unmapped[:0]
+		for _, m := range unmapped {
+			if !done[m] {
+				rest = append(rest, m)
+			}
+		}
+		unmapped = rest
+	}
+	res.normalizeExcluded()
+	// Whatever has no canonical value is reported, not dropped
+	for _, m := range unmapped {
+		if excluded[m] {
+			m = "nicht " + m
+		}
+		res.UnsupportedCriteria = append(res.UnsupportedCriteria, m)
+	}
+	trace.Unmapped = unmapped
+	return res, out, trace, nil
+}
+
+// mapWithLLM asks the model to map mentions onto the taxonomy. Answers
+// outside the taxonomy or for mentions that weren't asked about are dropped.
+func mapWithLLM(ctx context.Context, cli LLMClient, provider string, tax Taxonomy, mentions []string) ([]MentionMapping, Usage, error) {
+	in, _ := json.Marshal(map[string]any{"mentions": mentions, "taxonomy": tax})
+	stop := timeStage(ctx, timingUpstream)
+	out, err := cli.CompleteJSON(ctx, mapPrompt().Text, string(in))
+	stop()
+	if err != nil {
+		log.Printf("[ERROR] %s map stage failed: %v", provider, err)
+		return nil, out.Usage, err
+	}
+	var answer struct {
+		Mappings []struct {
+			Mention string `json:"mention"`
+			Slot    string `json:"slot"`
+			Value   string `json:"value"`
+		} `json:"mappings"`
+	}
+	stop = timeStage(ctx, timingDecode)
+	err = decodeModelJSON(provider+" map", &out, &answer)
+	stop()
+	if err != nil {
+		return nil, out.Usage, err
+	}
+	asked := map[string]bool{}
+	for _, m := range mentions {
+		asked[m] = true
+	}
+	var mapped []MentionMapping
+	for _, a := range answer.Mappings {
+		if !asked[a.Mention] || !tax.Has(a.Slot, a.Value) {
+			log.Printf("[WARN] %s map stage: dropping %q -> %s.%s", provider, a.Mention, a.Slot, a.Value)
+			continue
+		}
+		mapped = append(mapped, MentionMapping{Mention: a.Mention, SlotValue: SlotValue{Slot: a.Slot, Value: a.Value}, Source: "llm"})
+	}
+	return mapped, out.Usage, nil
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ====== PostgreSQL store ======
+// For multi-instance deployments: all replicas share runs, ground truth
+// and prompts. Migrations run on startup under an advisory lock so
+// replicas starting together don't race; another one elects the replica
+// that runs the cluster jobs (see jobs.go).
+
+var postgresMigrations = []string{
+	// 1: runs, ground truth, prompts
+	`CREATE TABLE runs (
+		id         BIGSERIAL PRIMARY KEY,
+		query      TEXT        NOT NULL,
+		query_hash TEXT        NOT NULL,
+		time       TIMESTAMPTZ NOT NULL,
+		latency_ms BIGINT      NOT NULL,
+		variant    TEXT        NOT NULL DEFAULT '',
+		shadow     BOOLEAN     NOT NULL DEFAULT FALSE,
+		data       JSONB       NOT NULL
+	);
+	CREATE INDEX idx_runs_query_hash ON runs(query_hash);
+	CREATE INDEX idx_runs_time ON runs(time);
+	CREATE TABLE run_providers (
+		run_id   BIGINT NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+		provider TEXT   NOT NULL,
+		PRIMARY KEY (run_id, provider)
+	);
+	CREATE INDEX idx_run_providers_provider ON run_providers(provider, run_id);
+	CREATE TABLE ground_truth (
+		query      TEXT        PRIMARY KEY,
+		item       JSONB       NOT NULL,
+		position   BIGSERIAL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE ground_truth_log (
+		id      BIGSERIAL PRIMARY KEY,
+		changed TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE prompts (
+		name       TEXT        PRIMARY KEY,
+		content    TEXT        NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`,
+	// 2: look runs up by their public run ID
+	`ALTER TABLE runs ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+	UPDATE runs SET uid = COALESCE(data->>'id', '');
+	CREATE INDEX idx_runs_uid ON runs(uid);`,
+	// 3: search: full-text index on the query, slot values per provider
+	`CREATE INDEX idx_runs_query_fts ON runs USING GIN (to_tsvector('german', query));
+	CREATE TABLE run_slots (
+		run_id   BIGINT NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+		provider TEXT   NOT NULL,
+		key      TEXT   NOT NULL,
+		PRIMARY KEY (run_id, provider, key)
+	);
+	CREATE INDEX idx_run_slots_key ON run_slots(key, run_id);`,
+	// 4: tenants
+	`ALTER TABLE runs ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+	CREATE INDEX idx_runs_tenant ON runs(tenant, id);
+	ALTER TABLE ground_truth ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ground_truth DROP CONSTRAINT ground_truth_pkey;
+	ALTER TABLE ground_truth ADD PRIMARY KEY (tenant, query);`,
+	// 5: runs from before family_friendly could be null are read with
+	// false as null (see dataSchema); their index entries go too
+	`DELETE FROM run_slots s USING runs r
+		WHERE s.run_id = r.id AND s.key = 'family_friendly=false' AND r.data->'schema' IS NULL;`,
+}
+
+// arbitrary constants shared by all replicas
+const (
+	pgMigrationLock = 7464523001
+	pgLeaderLock    = 7464523002
+)
+
+type postgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func openPostgresStore(url string) (*postgresStore, error) {
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("PG_MAX_CONNS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.MaxConns = int32(n)
+		}
+	}
+	cfg.MaxConnIdleTime = 5 * time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	s := &postgresStore{pool: pool}
+	if err := s.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("postgres migrate: %w", err)
+	}
+	scheduleJob("slot index", time.Hour, s.indexSlots)
+	return s, nil
+}
+
+// campaign holds the leader lock on a session of its own while it can,
+// and otherwise retries every leaderRetry
+func (s *postgresStore) campaign(ctx context.Context, setLeader func(bool)) {
+	for ctx.Err() == nil {
+		conn, err := s.pool.Acquire(ctx)
+		if err == nil {
+			var won bool
+			err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, pgLeaderLock).Scan(&won)
+			if err != nil || !won {
+				conn.Release()
+			} else {
+				setLeader(true)
+				// the lock lives as long as the session: lead while it answers
+				for err == nil {
+					time.Sleep(leaderRetry)
+					err = conn.Ping(ctx)
+				}
+				setLeader(false)
+				// never hand a session that may still hold the lock back to the pool
+				_ = conn.Hijack().Close(context.Background())
+			}
+		}
+		if err != nil {
+			log.Printf("[WARN] postgres: leader election: %v", err)
+		}
+		time.Sleep(leaderRetry)
+	}
+}
+
+func (s *postgresStore) migrate(ctx context.Context) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, pgMigrationLock); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, pgMigrationLock)
+
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := conn.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for i := current; i < len(postgresMigrations); i++ {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, postgresMigrations[i]); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		log.Printf("[INFO] postgres: applied migration %d", i+1)
+	}
+	return nil
+}
+
+// --- results ---
+
+func (s *postgresStore) Append(run StoredResult) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, `INSERT INTO runs (uid, tenant, query, query_hash, time, latency_ms, variant, shadow, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		run.ID, run.Tenant, run.Query, queryHash(run.Query), run.Time, run.Latency, run.Variant, run.Shadow != nil, data).Scan(&id)
+	if err != nil {
+		return err
+	}
+	for _, p := range runProviders(run) {
+		if _, err := tx.Exec(ctx, `INSERT INTO run_providers (run_id, provider) VALUES ($1, $2)`, id, p); err != nil {
+			return err
+		}
+	}
+	if err := pgInsertSlots(ctx, tx, id, run); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func pgInsertSlots(ctx context.Context, tx pgx.Tx, id int64, run StoredResult) error {
+	for p, keys := range slotKeys(run) {
+		for k := range keys {
+			if _, err := tx.Exec(ctx, `INSERT INTO run_slots (run_id, provider, key) VALUES ($1, $2, $3)
+				ON CONFLICT DO NOTHING`, id, p, k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexSlots fills run_slots for runs stored before migration 3. Replicas
+// starting together may both do it; inserts are idempotent.
+func (s *postgresStore) indexSlots(ctx context.Context) error {
+	rows, err := s.pool.Query(ctx, `SELECT id, data FROM runs r
+		WHERE EXISTS (SELECT 1 FROM run_providers p WHERE p.run_id = r.id)
+		AND NOT EXISTS (SELECT 1 FROM run_slots k WHERE k.run_id = r.id)`)
+	if err != nil {
+		return err
+	}
+	pending := map[int64]StoredResult{}
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		var run StoredResult
+		if json.Unmarshal(data, &run) == nil {
+			pending[id] = run
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(pending) == 0 {
+		return err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for id, run := range pending {
+		if err := pgInsertSlots(ctx, tx, id, run); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	log.Printf("[INFO] postgres: indexed slot values of %d runs", len(pending))
+	return nil
+}
+
+func (s *postgresStore) Each(fn func(StoredResult) error) error {
+	return s.EachSince(0, func(_ int64, run StoredResult) error { return fn(run) })
+}
+
+func (s *postgresStore) EachSince(afterID int64, fn func(id int64, run StoredResult) error) error {
+	ctx := context.Background()
+	rows, err := s.pool.Query(ctx, `SELECT id, data FROM runs WHERE id > $1 ORDER BY id`, afterID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return err
+		}
+		var run StoredResult
+		if err := json.Unmarshal(data, &run); err != nil {
+			log.Printf("[WARN] postgres: skipping unreadable run %d: %v", id, err)
+			continue
+		}
+		if err := fn(id, run); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *postgresStore) All() ([]StoredResult, error) {
+	var results []StoredResult
+	err := s.Each(func(run StoredResult) error {
+		results = append(results, run)
+		return nil
+	})
+	return results, err
+}
+
+func (s *postgresStore) Run(id string) (*StoredResult, error) {
+	var data []byte
+	err := s.pool.QueryRow(context.Background(), `SELECT data FROM runs WHERE uid = $1 ORDER BY id DESC LIMIT 1`, id).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var run StoredResult
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (s *postgresStore) Search(q SearchQuery) ([]StoredResult, error) {
+	where, args := []string{}, []any{}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	where = append(where, `r.tenant = `+arg(q.Tenant))
+	if q.Text != "" {
+		where = append(where, `to_tsvector('german', r.query) @@ plainto_tsquery('german', `+arg(q.Text)+`)`)
+	}
+	if len(q.Filters) > 0 {
+		cond := `key = ANY(` + arg(q.Filters) + `)`
+		if q.Provider != "" {
+			cond += ` AND provider = ` + arg(q.Provider)
+		}
+		where = append(where, `r.id IN (SELECT run_id FROM run_slots WHERE `+cond+`
+			GROUP BY run_id, provider HAVING COUNT(*) = `+arg(len(q.Filters))+`)`)
+	} else if q.Provider != "" {
+		where = append(where, `r.id IN (SELECT run_id FROM run_providers WHERE provider = `+arg(q.Provider)+`)`)
+	}
+	sql := `SELECT r.data FROM runs r WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY r.id DESC LIMIT ` + arg(q.Limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []StoredResult
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var run StoredResult
+		if err := json.Unmarshal(data, &run); err != nil {
+			continue
+		}
+		results = append(results, run)
+	}
+	return results, rows.Err()
+}
+
+// --- ground truth ---
+// The store itself serves the default tenant; GroundTruthFor the others.
+// Versions are shared, so an edit for any tenant rebuilds every cache.
+
+// pgGroundTruth is one tenant's ground truth
+type pgGroundTruth struct {
+	s      *postgresStore
+	tenant string
+}
+
+func (s *postgresStore) GroundTruthFor(tenant string) GroundTruthStore {
+	return &pgGroundTruth{s: s, tenant: tenant}
+}
+
+func (s *postgresStore) GroundTruth() ([]GroundTruthItem, error) {
+	return s.GroundTruthFor("").GroundTruth()
+}
+
+func (s *postgresStore) PutGroundTruth(items ...GroundTruthItem) error {
+	return s.GroundTruthFor("").PutGroundTruth(items...)
+}
+
+func (s *postgresStore) DeleteGroundTruth(query string) (bool, error) {
+	return s.GroundTruthFor("").DeleteGroundTruth(query)
+}
+
+func (g *pgGroundTruth) GroundTruthVersion() string { return g.s.GroundTruthVersion() }
+
+func (g *pgGroundTruth) GroundTruth() ([]GroundTruthItem, error) {
+	rows, err := g.s.pool.Query(context.Background(), `SELECT item FROM ground_truth WHERE tenant = $1 ORDER BY position`, g.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GroundTruthItem
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var it GroundTruthItem
+		if err := json.Unmarshal(data, &it); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+func (g *pgGroundTruth) PutGroundTruth(items ...GroundTruthItem) error {
+	ctx := context.Background()
+	tx, err := g.s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for _, it := range items {
+		data, err := json.Marshal(it)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ground_truth (tenant, query, item) VALUES ($1, $2, $3)
+			ON CONFLICT (tenant, query) DO UPDATE SET item = EXCLUDED.item, updated_at = now()`, g.tenant, it.Query, data); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO ground_truth_log DEFAULT VALUES`); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (g *pgGroundTruth) DeleteGroundTruth(query string) (bool, error) {
+	ctx := context.Background()
+	tx, err := g.s.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, `DELETE FROM ground_truth WHERE tenant = $1 AND query = $2`, g.tenant, query)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO ground_truth_log DEFAULT VALUES`); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// GroundTruthVersion is the latest change id, so every replica notices
+// edits made through any other replica
+func (s *postgresStore) GroundTruthVersion() string {
+	var id int64
+	err := s.pool.QueryRow(context.Background(), `SELECT COALESCE(MAX(id), 0) FROM ground_truth_log`).Scan(&id)
+	if err != nil {
+		return "error"
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// importGroundTruth seeds an empty table from data/groundtruth.json
+func (s *postgresStore) importGroundTruth(src GroundTruthStore) {
+	var n int
+	if err := s.pool.QueryRow(context.Background(), `SELECT COUNT(*) FROM ground_truth`).Scan(&n); err != nil || n > 0 {
+		return
+	}
+	items, err := src.GroundTruth()
+	if err != nil || len(items) == 0 {
+		return
+	}
+	if err := s.PutGroundTruth(items...); err != nil {
+		log.Printf("[WARN] postgres: ground truth import failed: %v", err)
+		return
+	}
+	log.Printf("[INFO] postgres: imported %d ground truth items", len(items))
+}
+
+// --- prompts ---
+
+func (s *postgresStore) Prompt(name string) ([]byte, error) {
+	var content string
+	err := s.pool.QueryRow(context.Background(), `SELECT content FROM prompts WHERE name = $1`, name).Scan(&content)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+// publishPrompts writes the local prompt files into the table where they
+// are missing or differ, so a prompt edited on disk takes effect for every
+// replica. It runs at startup and on an explicit reload (SIGHUP, POST
+// /v1/admin/reload), not on the periodic poll, so replicas with different
+// files don't overwrite each other in a loop.
+func (s *postgresStore) publishPrompts(dir string) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		tag, err := s.pool.Exec(context.Background(), `INSERT INTO prompts (name, content) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET content = EXCLUDED.content, updated_at = now()
+			WHERE prompts.content <> EXCLUDED.content`, "prompt/"+filepath.Base(f), string(b))
+		if err != nil {
+			log.Printf("[WARN] postgres: publishing prompt %s failed: %v", f, err)
+			continue
+		}
+		if tag.RowsAffected() > 0 {
+			log.Printf("[INFO] postgres: published prompt %s", f)
+		}
+	}
+}
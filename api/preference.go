@@ -0,0 +1,63 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+)
+
+// ====== Preference strength ======
+// "muss einen Pool haben" and "gerne mit Spa" both end up in ui_filters,
+// but a ranking should drop hotels without the pool and only prefer those
+// with the spa. preferred_filters (same slots as ui_filters) marks the
+// ui_filters values that are nice-to-haves; every other value is required.
+// A preferred value is always in ui_filters too, normalization adds it
+// there if the model forgot. The evaluation scores the strength
+// separately, over the values both sides found: strength_accuracy is the
+// share of them with the same annotation, so it doesn't move precision or
+// recall. Ground truth without preferred_filters means all required.
+
+// softMarker makes a clause a nice-to-have
+var softMarker = regexp.MustCompile(`(?i)\b(?:gerne?|am liebsten|idealerweise|wenn möglich|möglichst|optional|wäre schön|nice to have|bevorzugt|vielleicht)\b`)
+
+// matchPreferred are the taxonomy matches in clauses with a soft marker
+func matchPreferred(tax Taxonomy, query string) []SlotValue {
+	var out []SlotValue
+	for _, clause := range clauseSplit.Split(query, -1) {
+		if softMarker.MatchString(clause) {
+			out = append(out, tax.Match(clause)...)
+		}
+	}
+	return out
+}
+
+// normalizePreferred gives every preferred_filters slot a list and adds
+// preferred values missing from ui_filters there
+func (p *ParseResponse) normalizePreferred() {
+	for _, slot := range uiFilterSlots {
+		list := p.PreferredFilters.Slot(slot)
+		if *list == nil {
+			*list = []string{}
+		}
+		for _, v := range *list {
+			p.UiFilters.add(slot, v)
+		}
+	}
+}
+
+// strengthAgreement counts the ui_filters values pred and gt both have
+// (n) and those of them annotated alike (ok)
+func strengthAgreement(pred, gt ParseResponse) (ok, n int) {
+	for _, slot := range uiFilterSlots {
+		gtValues := *gt.UiFilters.Slot(slot)
+		for _, v := range *pred.UiFilters.Slot(slot) {
+			if !slices.Contains(gtValues, v) {
+				continue
+			}
+			n++
+			if slices.Contains(*pred.PreferredFilters.Slot(slot), v) == slices.Contains(*gt.PreferredFilters.Slot(slot), v) {
+				ok++
+			}
+		}
+	}
+	return ok, n
+}
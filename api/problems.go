@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// ====== Problem responses (RFC 7807) ======
+// Errors are returned as application/problem+json with a stable code and a
+// German message for display. Details never contain raw upstream bodies;
+// those only go to the log.
+
+const (
+	codeInvalidInput        = "invalid_input"
+	codeProviderUnavailable = "provider_unavailable"
+	codeSchemaViolation     = "schema_violation"
+	codeTimeout             = "timeout"
+	codeNotFound            = "not_found"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeUnauthorized        = "unauthorized"
+	codeOverloaded          = "overloaded"
+	codeQuotaExceeded       = "quota_exceeded"
+	codeClientClosed        = "client_closed"
+	codeInternal            = "internal"
+	codeUnusableInput       = "unusable_input"
+)
+
+// statusClientClosed is nginx's non-standard 499, logged for requests the
+// client gave up on; the client never sees it
+const statusClientClosed = 499
+
+var problemTitles = map[string]string{
+	codeInvalidInput:        "Invalid input",
+	codeProviderUnavailable: "Provider unavailable",
+	codeSchemaViolation:     "Schema violation",
+	codeTimeout:             "Timeout",
+	codeNotFound:            "Not found",
+	codeMethodNotAllowed:    "Method not allowed",
+	codeUnauthorized:        "Unauthorized",
+	codeOverloaded:          "Overloaded",
+	codeQuotaExceeded:       "Quota exceeded",
+	codeClientClosed:        "Client closed request",
+	codeInternal:            "Internal error",
+	codeUnusableInput:       "Unusable input",
+}
+
+var problemMessagesDE = map[string]string{
+	codeInvalidInput:        "Die Anfrage ist ungültig. Bitte überprüfe deine Eingabe.",
+	codeProviderUnavailable: "Der Sprachdienst ist derzeit nicht erreichbar. Bitte versuche es später erneut.",
+	codeSchemaViolation:     "Die Antwort des Sprachdienstes konnte nicht verarbeitet werden. Bitte versuche es erneut.",
+	codeTimeout:             "Die Anfrage hat zu lange gedauert. Bitte versuche es erneut.",
+	codeNotFound:            "Nicht gefunden.",
+	codeMethodNotAllowed:    "Diese Methode ist hier nicht erlaubt.",
+	codeUnauthorized:        "Keine Berechtigung.",
+	codeOverloaded:          "Hohe Auslastung. Bitte versuche es in Kürze erneut.",
+	codeQuotaExceeded:       "Das Kontingent für diesen Zeitraum ist aufgebraucht.",
+	codeClientClosed:        "Die Anfrage wurde abgebrochen.",
+	codeInternal:            "Ein interner Fehler ist aufgetreten.",
+	codeUnusableInput:       "Das sieht nicht nach einer Suchanfrage aus.",
+}
+
+type Problem struct {
+	Type      string                    `json:"type"`
+	Title     string                    `json:"title"`
+	Status    int                       `json:"status"`
+	Detail    string                    `json:"detail,omitempty"`
+	Code      string                    `json:"code"`
+	MessageDE string                    `json:"message_de"`
+	RunID     string                    `json:"run_id,omitempty"`
+	Providers map[string]*ProviderError `json:"providers,omitempty"` // per provider for provider=both
+	// RetryAfter (seconds) is sent as the Retry-After header when set
+	RetryAfter int `json:"-"`
+	// EstimatedWaitMS is how long a retry would likely queue, for overloaded
+	EstimatedWaitMS int64 `json:"estimated_wait_ms,omitempty"`
+	// Reason is why an unusable_input query was rejected, see screen.go
+	Reason string `json:"reason,omitempty"`
+}
+
+// ProviderError is why one provider call failed
+type ProviderError struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+	Stage  string `json:"stage,omitempty"` // "extract" or "map" for two_stage runs
+}
+
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:      "urn:hotelparser:problem:" + code,
+		Title:     problemTitles[code],
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		MessageDE: problemMessagesDE[code],
+	}
+}
+
+func writeProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	if p.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(p.RetryAfter))
+	}
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// ----- classifying provider errors -----
+
+// errNotConfigured is wrapped by client constructors when credentials are missing
+var errNotConfigured = errors.New("provider not configured")
+
+// errProviderDisabled is returned for providers switched off via the admin API
+var errProviderDisabled = errors.New("provider disabled")
+
+// upstreamError is a non-2xx answer from a provider API. Body is kept for
+// logging only.
+type upstreamError struct {
+	Provider string
+	Status   int
+	Body     string
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("%s: HTTP %d: %s", e.Provider, e.Status, e.Body)
+}
+
+// parseError is a failure after the provider answered (no JSON, schema or
+// validation errors)
+type parseError struct {
+	Code   string
+	Detail string
+	Err    error
+}
+
+func (e *parseError) Error() string { return e.Detail + ": " + e.Err.Error() }
+func (e *parseError) Unwrap() error { return e.Err }
+
+// classifyProviderError maps any error from a provider call to a stable
+// code and a client-safe detail
+func classifyProviderError(provider string, err error) *ProviderError {
+	var pe *parseError
+	var ue *upstreamError
+	switch {
+	case errors.As(err, &pe):
+		return &ProviderError{Code: pe.Code, Detail: pe.Detail}
+	case errors.Is(err, errNotConfigured):
+		return &ProviderError{Code: codeProviderUnavailable, Detail: provider + " is not configured"}
+	case errors.Is(err, errOverloaded):
+		return &ProviderError{Code: codeOverloaded, Detail: provider + " is at its concurrency limit"}
+	case errors.Is(err, errCircuitOpen):
+		return &ProviderError{Code: codeProviderUnavailable, Detail: provider + " is failing; retrying shortly"}
+	case errors.Is(err, errProviderDisabled):
+		return &ProviderError{Code: codeProviderUnavailable, Detail: provider + " is disabled"}
+	case errors.Is(err, context.Canceled):
+		return &ProviderError{Code: codeClientClosed, Detail: provider + " call cancelled: the client went away"}
+	case errors.Is(err, context.DeadlineExceeded), isTimeout(err):
+		return &ProviderError{Code: codeTimeout, Detail: provider + " did not answer in time"}
+	case errors.As(err, &ue):
+		return &ProviderError{Code: codeProviderUnavailable, Detail: fmt.Sprintf("%s returned HTTP %d", provider, ue.Status)}
+	}
+	return &ProviderError{Code: codeProviderUnavailable, Detail: provider + " request failed"}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// problemStatus is the HTTP status for a provider error code
+func problemStatus(code string) int {
+	switch code {
+	case codeTimeout:
+		return http.StatusGatewayTimeout
+	case codeOverloaded:
+		return http.StatusServiceUnavailable
+	case codeInvalidInput:
+		return http.StatusBadRequest
+	case codeClientClosed:
+		return statusClientClosed
+	}
+	return http.StatusBadGateway
+}
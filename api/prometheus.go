@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// ====== Prometheus metrics ======
+// GET /metrics serves the server's counters and gauges in the Prometheus
+// text format. They are per replica and start at zero.
+
+// promFamily writes one metric family; samples maps a label set (already
+// formatted, "" for none) to its value
+func promFamily(w io.Writer, name, kind, help string, samples map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	labels := make([]string, 0, len(samples))
+	for l := range samples {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s%s %g\n", name, l, samples[l])
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	slow := map[string]float64{}
+	slowRequests.Lock()
+	for provider, n := range slowRequests.byProvider {
+		slow[fmt.Sprintf("{provider=%q}", provider)] = float64(n)
+	}
+	slowRequests.Unlock()
+
+	slots, busy, waiting, shed, wait := map[string]float64{}, map[string]float64{}, map[string]float64{}, map[string]float64{}, map[string]float64{}
+	for _, provider := range []string{"openai", "claude"} {
+		st := limiterStats(provider)
+		label := fmt.Sprintf("{provider=%q}", provider)
+		slots[label] = float64(st.Slots)
+		busy[fmt.Sprintf("{provider=%q,lane=%q}", provider, laneInteractive)] = float64(st.Busy - st.BusyBatch)
+		busy[fmt.Sprintf("{provider=%q,lane=%q}", provider, laneBatch)] = float64(st.BusyBatch)
+		waiting[fmt.Sprintf("{provider=%q,lane=%q}", provider, laneInteractive)] = float64(st.Waiting)
+		waiting[fmt.Sprintf("{provider=%q,lane=%q}", provider, laneBatch)] = float64(st.WaitingBatch)
+		shed[label] = float64(st.Shed)
+		wait[label] = float64(st.EstimatedWaitMS) / 1000
+	}
+
+	gated := map[string]float64{}
+	gatedQueries.Lock()
+	for intent, n := range gatedQueries.byIntent {
+		gated[fmt.Sprintf("{intent=%q}", intent)] = float64(n)
+	}
+	gatedQueries.Unlock()
+	rejected := map[string]float64{}
+	rejectedInputs.Lock()
+	for reason, n := range rejectedInputs.byReason {
+		rejected[fmt.Sprintf("{reason=%q}", reason)] = float64(n)
+	}
+	rejectedInputs.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	promFamily(w, "hotelparser_slow_request_threshold_seconds", "gauge", "Parse requests slower than this are flagged slow.",
+		map[string]float64{"": slowThreshold().Seconds()})
+	promFamily(w, "hotelparser_slow_requests_total", "counter", "Parse requests over the slow threshold, by slowest provider.", slow)
+	promFamily(w, "hotelparser_provider_slots", "gauge", "Concurrent call slots per provider (0 = unlimited).", slots)
+	promFamily(w, "hotelparser_provider_slots_busy", "gauge", "Call slots in use, by lane.", busy)
+	promFamily(w, "hotelparser_provider_queue_depth", "gauge", "Calls waiting for a slot, by lane.", waiting)
+	promFamily(w, "hotelparser_provider_overloaded_total", "counter", "Calls rejected because no slot freed up in time.", shed)
+	promFamily(w, "hotelparser_provider_estimated_wait_seconds", "gauge", "Estimated wait for a slot of a new interactive call.", wait)
+	promFamily(w, "hotelparser_intent_gated_total", "counter", "Queries the intent gate answered without parsing, by intent.", gated)
+	promFamily(w, "hotelparser_rejected_inputs_total", "counter", "Queries screened out as empty, gibberish or profanity, by reason.", rejected)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ====== Prompt cache ======
+// System prompts (prompt file + examples.json) are built once per path and
+// kept in memory. They are re-read every PROMPT_RELOAD_INTERVAL (default 10s,
+// "0" disables polling), on SIGHUP and on POST /v1/admin/reload.
+
+type loadedPrompt struct {
+	Text string
+	Hash string // short sha256 of Text, reported as prompt_hash
+
+	load func() string // rebuilds Text on reload
+}
+
+var promptCache = struct {
+	sync.RWMutex
+	m map[string]*loadedPrompt
+}{m: map[string]*loadedPrompt{}}
+
+func newLoadedPrompt(load func() string) *loadedPrompt {
+	text := load()
+	sum := sha256.Sum256([]byte(text))
+	return &loadedPrompt{Text: text, Hash: hex.EncodeToString(sum[:6]), load: load}
+}
+
+// systemPromptFor returns the cached system prompt for path, loading it on
+// first use
+func systemPromptFor(path string) *loadedPrompt {
+	return cachedPrompt(path, func() string { return loadSystemPrompt(path, examplesFile) })
+}
+
+// cachedPrompt returns the cached entry for key, building it with load on
+// first use. Reloads call load again.
+func cachedPrompt(key string, load func() string) *loadedPrompt {
+	promptCache.RLock()
+	p := promptCache.m[key]
+	promptCache.RUnlock()
+	if p != nil {
+		return p
+	}
+	p = newLoadedPrompt(load)
+	promptCache.Lock()
+	promptCache.m[key] = p
+	promptCache.Unlock()
+	log.Printf("[INFO] prompt %s loaded (%s)", key, p.Hash)
+	return p
+}
+
+// reloadPrompts re-reads every cached prompt and logs the ones that changed
+func reloadPrompts() {
+	promptCache.RLock()
+	entries := make(map[string]*loadedPrompt, len(promptCache.m))
+	for key, p := range promptCache.m {
+		entries[key] = p
+	}
+	promptCache.RUnlock()
+
+	for key, old := range entries {
+		p := newLoadedPrompt(old.load)
+		promptCache.Lock()
+		promptCache.m[key] = p
+		promptCache.Unlock()
+		if old.Hash != p.Hash {
+			log.Printf("[INFO] prompt %s reloaded (%s)", key, p.Hash)
+		}
+	}
+}
+
+// watchPrompts polls the prompt sources for changes
+func watchPrompts() {
+	if os.Getenv("PROMPT_RELOAD_INTERVAL") == "0" {
+		return
+	}
+	every := envDuration("PROMPT_RELOAD_INTERVAL", 10*time.Second)
+	go func() {
+		for range time.Tick(every) {
+			reloadPrompts()
+		}
+	}()
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Prompt suggestions ======
+// A job that turns evaluation failures into prompt changes for a human to
+// review. It scores the stored runs made with each tenant's current system
+// prompt against the ground truth, groups the wrong and missed values by
+// slot and takes the slots with the most failures. For each, the failing
+// queries that fail most often, the most frequent confusions (expected →
+// got) and the prompt itself go to a model, which proposes new rules and
+// picks queries that would make good few-shot examples; an example's
+// output is the query's ground truth, never the model's. A slot is asked
+// about once per prompt version.
+//
+// PROMPT_SUGGEST_INTERVAL (e.g. 24h, off by default) runs the job on the
+// leading replica; POST /v1/admin/prompt-suggestions runs it for the
+// caller's tenant right away. PROMPT_SUGGEST_PROVIDER (openai by default)
+// and PROMPT_SUGGEST_MODEL pick the model; prompt/suggest.txt replaces the
+// built-in instructions. Suggestions are kept in
+// data/prompt_suggestions.json:
+//
+//	GET  /v1/admin/prompt-suggestions?status=open
+//	POST /v1/admin/prompt-suggestions/{id}  {"status": "accepted", "note": "..."}
+//
+// Accepting one changes nothing by itself: rules and examples are copied
+// into the prompt files by hand.
+
+const (
+	promptSuggestionsFile = "data/prompt_suggestions.json"
+	suggestPromptFile     = "prompt/suggest.txt"
+	suggestSlotsPerRun    = 5 // slots asked about per tenant and run
+	suggestMinFailures    = 3
+	suggestSamples        = 5 // failing queries shown per slot
+	suggestMaxExamples    = 2
+)
+
+const (
+	suggestionOpen     = "open"
+	suggestionAccepted = "accepted"
+	suggestionRejected = "rejected"
+)
+
+const defaultSuggestPrompt = `Du verbesserst den System-Prompt eines Parsers, der deutsche Hotelsuchanfragen in JSON übersetzt.
+Du bekommst den aktuellen Prompt, einen Slot, in dem der Parser oft falsch liegt, die häufigsten
+Verwechslungen (erwartet → geliefert) und Anfragen, an denen er scheitert.
+
+Schlage vor, wie der Prompt die Fehler vermeidet:
+- 1 bis 3 neue Regeln im Stil der vorhandenen (eine Zeile je Regel, ohne führendes "- "). Keine Regel
+  wiederholen, die schon im Prompt steht; ist eine vorhandene Regel unklar, eine präzisere Fassung vorschlagen.
+- bis zu 2 der gezeigten Anfragen, die sich als Few-Shot-Beispiel eignen, wortgleich.
+- eine kurze Begründung.
+
+Antworte ausschließlich mit einem JSON-Objekt:
+{"rules": string[], "examples": string[], "rationale": string}
+`
+
+// PromptSuggestion is one proposed prompt change and its review state
+type PromptSuggestion struct {
+	ID         string          `json:"id"`
+	Tenant     string          `json:"tenant,omitempty"`
+	Slot       string          `json:"slot"`
+	Failures   int             `json:"failures"` // wrong or missed values in the slot
+	Answers    int             `json:"answers"`  // evaluated answers looked at
+	Confusion  []ConfusionPair `json:"confusion"`
+	Samples    []string        `json:"samples"` // the failing queries shown
+	Rules      []string        `json:"rules"`
+	Examples   []PromptExample `json:"examples,omitempty"`
+	Rationale  string          `json:"rationale,omitempty"`
+	Provider   string          `json:"provider"`
+	Model      string          `json:"model,omitempty"`
+	PromptHash string          `json:"prompt_hash"`
+	Status     string          `json:"status"`
+	Note       string          `json:"note,omitempty"`
+	Created    time.Time       `json:"created"`
+	Reviewed   *time.Time      `json:"reviewed,omitempty"`
+}
+
+// PromptExample is an entry for prompt/examples.json
+type PromptExample struct {
+	Query  string        `json:"query"`
+	Output ParseResponse `json:"output"`
+}
+
+var promptSuggestions = struct {
+	sync.Mutex
+	list    []PromptSuggestion // oldest first
+	loaded  bool               // list was read from the file
+	running sync.Mutex         // one analysis at a time
+}{}
+
+func suggestPrompt() *loadedPrompt {
+	return cachedPrompt(suggestPromptFile, func() string { return promptOrDefault(suggestPromptFile, defaultSuggestPrompt) })
+}
+
+// loadSuggestions reads the file once; callers hold promptSuggestions
+func loadSuggestions() error {
+	if promptSuggestions.loaded {
+		return nil
+	}
+	b, err := os.ReadFile(dataPath(promptSuggestionsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &promptSuggestions.list); err != nil {
+			return fmt.Errorf("%s: %w", promptSuggestionsFile, err)
+		}
+	}
+	promptSuggestions.loaded = true
+	return nil
+}
+
+// saveSuggestions writes the file; callers hold promptSuggestions
+func saveSuggestions() error {
+	b, err := json.MarshalIndent(promptSuggestions.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dataPath(promptSuggestionsFile), b, 0644)
+}
+
+// failureCluster is one slot's failures
+type failureCluster struct {
+	slot     string
+	failures int
+	queries  map[string]*failingQuery
+}
+
+type failingQuery struct {
+	failures      int
+	expected, got []string // the slot's values in the last failing answer
+}
+
+// tenantFailures are the clusters of one tenant
+type tenantFailures struct {
+	promptHash string
+	answers    int
+	confusion  confusionAcc
+	slots      map[string]*failureCluster
+	truth      map[string]GroundTruthItem
+}
+
+// collectFailures scores the live runs made with each tenant's current
+// prompt (only the given tenant, when only is set)
+func collectFailures(only *string) (map[string]*tenantFailures, error) {
+	byTenant := map[string]*tenantFailures{}
+	forTenant := func(tenant string) *tenantFailures {
+		tf, ok := byTenant[tenant]
+		if !ok {
+			tf = &tenantFailures{
+				promptHash: systemPromptForTenant(tenant, (*Variant)(nil).promptPath()).Hash,
+				confusion:  confusionAcc{},
+				slots:      map[string]*failureCluster{},
+				truth:      map[string]GroundTruthItem{},
+			}
+			for _, item := range loadGroundTruth(tenant) {
+				tf.truth[item.Query] = item
+			}
+			byTenant[tenant] = tf
+		}
+		return tf
+	}
+	err := store.Each(func(run StoredResult) error {
+		if only != nil && run.Tenant != *only {
+			return nil
+		}
+		if run.Shadow != nil || run.Replay != nil || run.Aborted || run.Assisted {
+			return nil
+		}
+		tf := forTenant(run.Tenant)
+		item, ok := tf.truth[run.Query]
+		if !ok || run.Response.PromptHash != tf.promptHash {
+			return nil
+		}
+		for _, pred := range []*ParseResponse{run.Response.OpenAI, run.Response.Claude} {
+			if pred == nil {
+				continue
+			}
+			tf.answers++
+			if item.Ambiguous && matchesAnyAcceptable(*pred, item.AcceptableInterpretation) {
+				continue
+			}
+			tf.add(run.Query, flatten(*pred), flatten(item.Truth))
+		}
+		return nil
+	})
+	return byTenant, err
+}
+
+func (tf *tenantFailures) add(query string, pSet, gSet map[string]bool) {
+	tf.confusion.add(pSet, gSet)
+	expected, got := map[string][]string{}, map[string][]string{}
+	for k := range gSet {
+		if !pSet[k] {
+			slot := slotNameOf(k)
+			expected[slot] = append(expected[slot], k[len(slot)+1:])
+		}
+	}
+	for k := range pSet {
+		if !gSet[k] {
+			slot := slotNameOf(k)
+			got[slot] = append(got[slot], k[len(slot)+1:])
+		}
+	}
+	for _, slot := range unionKeys(expected, got) {
+		if slot == "unsupported" {
+			continue // free text, nothing to cluster
+		}
+		c := tf.slots[slot]
+		if c == nil {
+			c = &failureCluster{slot: slot, queries: map[string]*failingQuery{}}
+			tf.slots[slot] = c
+		}
+		n := len(expected[slot]) + len(got[slot])
+		c.failures += n
+		q := c.queries[query]
+		if q == nil {
+			q = &failingQuery{}
+			c.queries[query] = q
+		}
+		q.failures += n
+		q.expected, q.got = sortedValues(gSet, slot), sortedValues(pSet, slot)
+	}
+}
+
+func unionKeys(a, b map[string][]string) []string {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedValues are the values of slot in a flattened answer
+func sortedValues(set map[string]bool, slot string) []string {
+	var out []string
+	for k := range set {
+		if slotNameOf(k) == slot {
+			out = append(out, k[len(slot)+1:])
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// samples are the queries failing most often in the slot
+func (c *failureCluster) samples() []string {
+	queries := make([]string, 0, len(c.queries))
+	for q := range c.queries {
+		queries = append(queries, q)
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		a, b := c.queries[queries[i]].failures, c.queries[queries[j]].failures
+		if a != b {
+			return a > b
+		}
+		return queries[i] < queries[j]
+	})
+	return queries[:min(len(queries), suggestSamples)]
+}
+
+// worstSlots are the slots with the most failures that weren't asked about
+// for this prompt yet
+func (tf *tenantFailures) worstSlots(tenant string) []*failureCluster {
+	promptSuggestions.Lock()
+	asked := map[string]bool{}
+	for _, s := range promptSuggestions.list {
+		if s.Tenant == tenant && s.PromptHash == tf.promptHash {
+			asked[s.Slot] = true
+		}
+	}
+	promptSuggestions.Unlock()
+	var out []*failureCluster
+	for _, c := range tf.slots {
+		if c.failures >= suggestMinFailures && !asked[c.slot] {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].failures != out[j].failures {
+			return out[i].failures > out[j].failures
+		}
+		return out[i].slot < out[j].slot
+	})
+	return out[:min(len(out), suggestSlotsPerRun)]
+}
+
+// suggestionRequest is the user message for one slot
+func suggestionRequest(prompt string, tf *tenantFailures, c *failureCluster, confusion []ConfusionPair, samples []string) string {
+	dash := func(v string) string { return cmp.Or(v, "–") }
+	var b strings.Builder
+	fmt.Fprintf(&b, "Aktueller Prompt:\n<<<\n%s\n>>>\n\n", strings.TrimSpace(prompt))
+	fmt.Fprintf(&b, "Slot: %s\nFehler: %d falsche oder fehlende Werte in %d Antworten\n\n", c.slot, c.failures, tf.answers)
+	b.WriteString("Häufigste Verwechslungen (erwartet → geliefert):\n")
+	for _, p := range confusion {
+		fmt.Fprintf(&b, "- %s → %s (%d×)\n", dash(p.Expected), dash(p.Got), p.Count)
+	}
+	b.WriteString("\nFehlgeschlagene Anfragen:\n")
+	for _, q := range samples {
+		fq := c.queries[q]
+		fmt.Fprintf(&b, "- %q: erwartet [%s], geliefert [%s]\n", q, strings.Join(fq.expected, ", "), strings.Join(fq.got, ", "))
+	}
+	return b.String()
+}
+
+// suggestFor asks the model about one slot
+func suggestFor(ctx context.Context, tenant string, prompt *loadedPrompt, tf *tenantFailures, c *failureCluster) (PromptSuggestion, error) {
+	provider := strings.ToLower(os.Getenv("PROMPT_SUGGEST_PROVIDER"))
+	if provider == "" {
+		provider = "openai"
+	}
+	model := os.Getenv("PROMPT_SUGGEST_MODEL")
+	s := PromptSuggestion{ID: newRunID()[:12], Tenant: tenant, Slot: c.slot, Failures: c.failures, Answers: tf.answers,
+		Confusion: tf.confusion.report()[c.slot], Samples: c.samples(), Provider: provider, Model: model,
+		PromptHash: tf.promptHash, Status: suggestionOpen, Created: time.Now().UTC()}
+
+	cli, err := newClientFor(provider, model)
+	if err != nil {
+		return s, err
+	}
+	release, err := acquireSlot(ctx, provider)
+	if err != nil {
+		return s, err
+	}
+	defer release()
+	callCtx, cancel := context.WithTimeout(ctx, providerTimeout(provider))
+	defer cancel()
+	out, err := cli.CompleteJSON(callCtx, suggestPrompt().Text, suggestionRequest(prompt.Text, tf, c, s.Confusion, s.Samples))
+	if err != nil {
+		return s, err
+	}
+	var answer struct {
+		Rules     []string `json:"rules"`
+		Examples  []string `json:"examples"`
+		Rationale string   `json:"rationale"`
+	}
+	if err := decodeModelJSON(providerDisplayName(provider)+" prompt suggestion", &out, &answer); err != nil {
+		return s, err
+	}
+	for _, r := range answer.Rules {
+		if r = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(r), "- ")); r != "" {
+			s.Rules = append(s.Rules, r)
+		}
+	}
+	// only queries that were shown, with their ground truth as output
+	for _, q := range answer.Examples {
+		if _, shown := c.queries[q]; shown && len(s.Examples) < suggestMaxExamples {
+			s.Examples = append(s.Examples, PromptExample{Query: q, Output: tf.truth[q].Truth})
+		}
+	}
+	s.Rationale = answer.Rationale
+	if len(s.Rules) == 0 && len(s.Examples) == 0 {
+		return s, errors.New("the model proposed nothing usable")
+	}
+	return s, nil
+}
+
+// suggestPrompts runs the analysis for every tenant, or for only one
+func suggestPrompts(ctx context.Context, only *string) error {
+	ctx = withLane(ctx, laneBatch)
+	promptSuggestions.running.Lock()
+	defer promptSuggestions.running.Unlock()
+	promptSuggestions.Lock()
+	err := loadSuggestions()
+	promptSuggestions.Unlock()
+	if err != nil {
+		return err
+	}
+	byTenant, err := collectFailures(only)
+	if err != nil {
+		return err
+	}
+	added := 0
+	for tenant, tf := range byTenant {
+		prompt := systemPromptForTenant(tenant, (*Variant)(nil).promptPath())
+		for _, c := range tf.worstSlots(tenant) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			s, err := suggestFor(ctx, tenant, prompt, tf, c)
+			if err != nil {
+				log.Printf("[WARN] prompt suggestion for %s (tenant %q): %v", c.slot, tenant, err)
+				continue
+			}
+			promptSuggestions.Lock()
+			promptSuggestions.list = append(promptSuggestions.list, s)
+			err = saveSuggestions()
+			promptSuggestions.Unlock()
+			if err != nil {
+				return err
+			}
+			added++
+		}
+	}
+	log.Printf("[INFO] prompt suggestions: %d new", added)
+	return nil
+}
+
+// startPromptSuggestions schedules the job when PROMPT_SUGGEST_INTERVAL is set
+func startPromptSuggestions() {
+	if os.Getenv("PROMPT_SUGGEST_INTERVAL") == "" {
+		return
+	}
+	every := envDuration("PROMPT_SUGGEST_INTERVAL", 24*time.Hour)
+	scheduleJob("prompt suggestions", every, func(ctx context.Context) error { return suggestPrompts(ctx, nil) })
+}
+
+// promptSuggestionsHandler: GET lists the caller's tenant's suggestions,
+// newest first; POST runs the analysis for it in the background
+func promptSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "", suggestionOpen, suggestionAccepted, suggestionRejected:
+		default:
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "status must be open, accepted or rejected"))
+			return
+		}
+		promptSuggestions.Lock()
+		defer promptSuggestions.Unlock()
+		if err := loadSuggestions(); err != nil {
+			log.Printf("[ERROR] loading prompt suggestions: %v", err)
+			writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not load suggestions"))
+			return
+		}
+		out := []PromptSuggestion{}
+		for i := len(promptSuggestions.list) - 1; i >= 0; i-- {
+			s := promptSuggestions.list[i]
+			if s.Tenant == tenant && (status == "" || s.Status == status) {
+				out = append(out, s)
+			}
+		}
+		writeAdminJSON(w, out)
+	case http.MethodPost:
+		if !promptSuggestions.running.TryLock() {
+			writeProblem(w, newProblem(http.StatusConflict, codeInvalidInput, "an analysis is already running"))
+			return
+		}
+		promptSuggestions.running.Unlock()
+		go func() {
+			if err := suggestPrompts(context.Background(), &tenant); err != nil {
+				log.Printf("[ERROR] prompt suggestions: %v", err)
+			}
+		}()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "running"})
+	default:
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET or POST"))
+	}
+}
+
+// promptSuggestionHandler: POST records the review of one suggestion
+func promptSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST"))
+		return
+	}
+	b, err := readBody(w, r)
+	if err != nil {
+		writeProblem(w, inputProblem(err))
+		return
+	}
+	var review struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&review); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, jsonInputError(err).Error()))
+		return
+	}
+	switch review.Status {
+	case suggestionOpen, suggestionAccepted, suggestionRejected:
+	default:
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "status must be open, accepted or rejected"))
+		return
+	}
+	id, tenant := r.PathValue("id"), tenantOf(r.Context())
+	promptSuggestions.Lock()
+	defer promptSuggestions.Unlock()
+	if err := loadSuggestions(); err != nil {
+		log.Printf("[ERROR] loading prompt suggestions: %v", err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not load suggestions"))
+		return
+	}
+	for i := range promptSuggestions.list {
+		s := &promptSuggestions.list[i]
+		if s.ID != id || s.Tenant != tenant {
+			continue
+		}
+		now := time.Now().UTC()
+		s.Status, s.Note, s.Reviewed = review.Status, review.Note, &now
+		if review.Status == suggestionOpen {
+			s.Reviewed = nil
+		}
+		if err := saveSuggestions(); err != nil {
+			log.Printf("[ERROR] saving prompt suggestions: %v", err)
+			writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not save the review"))
+			return
+		}
+		log.Printf("[INFO] prompt suggestion %s %s", id, review.Status)
+		writeAdminJSON(w, s)
+		return
+	}
+	writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "no prompt suggestion "+id))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// ====== Relaxation suggestions ======
+// When an answer finds no or only few hotels, POST /v1/relax with the
+// answer (v1, like the search simulator takes it) says which filters to
+// drop, with German texts the UI can show as they are:
+//
+//	{"matches": 0, "scope": 4, "few": true,
+//	 "message_de": "Für alle Wünsche gibt es keine Hotels. Tipp: Ohne „Innenpool“ gibt es 2 Hotels.",
+//	 "suggestions": [{"filter": "ui.poolbeach=heated_pool", "label_de": "Innenpool",
+//	   "preferred": true, "matches": 2, "gain": 2, "removed": 0.75,
+//	   "text_de": "„Innenpool“ war nur ein Wunsch. Ohne: 2 Hotels statt 0."}, ...]}
+//
+// Each filter of the answer (see impact.go) is dropped on its own; matches
+// is what the search then finds and gain how many more that is. Nice-to-
+// haves (preferred_filters) come first, then the filters with the larger
+// gain, then those that narrow the scope most; filters whose drop alone
+// finds nothing more come last. Answers with at least ?min_results=
+// matches (default 5) get no suggestions. When even the scope is empty, no
+// filter helps and the message says to change the destination or dates
+// instead.
+//
+// The counts come from the search API or the simulator's dataset, like the
+// filter impact; with an answer of n filters that is 2n+2 searches. The
+// endpoint answers 404 with neither.
+
+const relaxFewResults = 5
+
+type RelaxResponse struct {
+	Source      string            `json:"source"` // "search_api" or "dataset"
+	Scope       int               `json:"scope"`  // hotels without the filters
+	Matches     int               `json:"matches"`
+	Few         bool              `json:"few"` // fewer than min_results
+	MessageDE   string            `json:"message_de"`
+	Suggestions []RelaxSuggestion `json:"suggestions"` // best first
+}
+
+// RelaxSuggestion is dropping one filter
+type RelaxSuggestion struct {
+	Filter    string  `json:"filter"` // keyed like the evaluation's slots
+	LabelDE   string  `json:"label_de"`
+	Preferred bool    `json:"preferred"` // only a nice-to-have
+	Matches   int     `json:"matches"`   // hotels without this filter
+	Gain      int     `json:"gain"`      // more than with all filters
+	Removed   float64 `json:"removed"`   // share of the scope it removes alone
+	TextDE    string  `json:"text_de"`
+}
+
+// relax suggests filters of answer to drop, counting with count
+func relax(answer ParseResponse, source string, count func([]ParseResponse) ([]int, error), minResults int) (RelaxResponse, error) {
+	scope, filters := splitFilters(answer)
+	answers := []ParseResponse{scope, answer}
+	for _, f := range filters {
+		answers = append(answers, f.on(scope))
+	}
+	for i := range filters {
+		without := scope
+		for j, f := range filters {
+			if j != i {
+				f.set(&without)
+			}
+		}
+		answers = append(answers, without)
+	}
+	counts, err := count(answers)
+	if err != nil {
+		return RelaxResponse{}, err
+	}
+
+	resp := RelaxResponse{Source: source, Scope: counts[0], Matches: counts[1], Suggestions: []RelaxSuggestion{}}
+	resp.Few = resp.Matches < minResults
+	switch {
+	case !resp.Few:
+		resp.MessageDE = fmt.Sprintf("Für alle Wünsche gibt es %s.", hotelCountDE(resp.Matches))
+		return resp, nil
+	case resp.Scope == 0:
+		resp.MessageDE = "Auch ohne Filter gibt es keine Hotels. Ändere bitte Reiseziel oder Reisedaten."
+		return resp, nil
+	}
+
+	n := len(filters)
+	for i, f := range filters {
+		s := RelaxSuggestion{
+			Filter: f.key, LabelDE: f.label, Preferred: f.preferred,
+			Matches: counts[2+n+i], Removed: removedShare(counts[2+i], resp.Scope),
+		}
+		s.Gain = max(s.Matches-resp.Matches, 0)
+		switch {
+		case s.Gain == 0:
+			s.TextDE = fmt.Sprintf("Ohne „%s“ gibt es allein noch keine weiteren Hotels.", f.label)
+		case f.preferred:
+			s.TextDE = fmt.Sprintf("„%s“ war nur ein Wunsch. Ohne: %s statt %d.", f.label, hotelCountDE(s.Matches), resp.Matches)
+		default:
+			s.TextDE = fmt.Sprintf("Ohne „%s“: %s statt %d.", f.label, hotelCountDE(s.Matches), resp.Matches)
+		}
+		resp.Suggestions = append(resp.Suggestions, s)
+	}
+	// suggestions that help first, nice-to-haves among them before the rest
+	slices.SortStableFunc(resp.Suggestions, func(a, b RelaxSuggestion) int {
+		if c := cmpBool(b.Gain > 0, a.Gain > 0); c != 0 {
+			return c
+		}
+		if c := cmpBool(b.Preferred, a.Preferred); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Gain, a.Gain); c != 0 {
+			return c
+		}
+		return cmpFloat(b.Removed, a.Removed)
+	})
+
+	if resp.Matches == 0 {
+		resp.MessageDE = "Für alle Wünsche gibt es keine Hotels."
+	} else {
+		resp.MessageDE = fmt.Sprintf("Für alle Wünsche gibt es nur %s.", hotelCountDE(resp.Matches))
+	}
+	if len(resp.Suggestions) > 0 && resp.Suggestions[0].Gain > 0 {
+		best := resp.Suggestions[0]
+		resp.MessageDE += fmt.Sprintf(" Tipp: Ohne „%s“ gibt es %s.", best.LabelDE, hotelCountDE(best.Matches))
+	} else if len(resp.Suggestions) > 0 {
+		resp.MessageDE += " Kein einzelner Filter ist schuld. Lockere bitte mehrere Wünsche."
+	}
+	return resp, nil
+}
+
+// cmpBool orders false before true
+func cmpBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+// hotelCountDE is "1 Hotel" or "n Hotels"
+func hotelCountDE(n int) string {
+	if n == 1 {
+		return "1 Hotel"
+	}
+	return strconv.Itoa(n) + " Hotels"
+}
+
+func relaxHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST"))
+		return
+	}
+	source, count := impactCounter(r.Context())
+	if count == nil {
+		writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "relaxation needs SEARCH_API_URL or SIMULATOR_HOTELS_FILE"))
+		return
+	}
+	minResults := relaxFewResults
+	if s := r.URL.Query().Get("min_results"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, fmt.Sprintf("invalid min_results %q", s)))
+			return
+		}
+		minResults = n
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes()))
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusRequestEntityTooLarge, codeInvalidInput, "body too large"))
+		return
+	}
+	var answer ParseResponse
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&answer); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "body must be a parse answer (schema 1): "+err.Error()))
+		return
+	}
+
+	resp, err := relax(answer, source, count, minResults)
+	if err != nil {
+		log.Printf("[WARN] relax: %v", err)
+		writeProblem(w, newProblem(http.StatusBadGateway, codeInternal, "the search API failed: "+err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
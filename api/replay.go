@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Replay ======
+// POST /v1/replay (admin) re-runs the queries of stored runs against
+// another provider, model, prompt or pipeline, so historical traffic
+// becomes a regression suite for a change:
+//
+//	{"filter": {"from": "2025-06-01T00:00:00Z", "to": "...", "tag": "release-2.3",
+//	            "variant": "v4", "limit": 200},
+//	 "target": {"provider": "claude", "model": "claude-sonnet-4-5",
+//	            "prompt": "prompt/system_v5.txt", "pipeline": "two_stage"}}
+//
+// The filter picks the newest live runs of the caller's tenant (limit
+// defaults to 100, at most 1000); shadow runs, aborted runs and earlier
+// replays are skipped. Runs are tagged at parse time with "tag". Each query
+// goes through the regular pipeline (the parse cache is bypassed) and is
+// stored as a new run with "replay": {"id", "of"} pointing at the original
+// and variant "replay-<id>", so GET /v1/evaluations?by_variant=1 scores the
+// replay next to the live traffic; replays never count in the overall
+// numbers, usage or telemetry. The request answers 202 right away;
+// GET /v1/replay/{id} shows the progress and, per run, the slots whose
+// answer differs from the original's. The last 20 replays are kept in memory.
+
+const (
+	replayDefaultLimit = 100
+	replayMaxLimit     = 1000
+	replayWorkers      = 4
+	replayKeep         = 20
+)
+
+// ReplayInfo is stored with every replayed run
+type ReplayInfo struct {
+	ID string `json:"id"` // the replay
+	Of string `json:"of"` // the original run
+}
+
+type ReplayFilter struct {
+	From    *time.Time `json:"from,omitempty"`
+	To      *time.Time `json:"to,omitempty"`
+	Tag     string     `json:"tag,omitempty"`
+	Variant string     `json:"variant,omitempty"`
+	Limit   int        `json:"limit,omitempty"`
+}
+
+type ReplayTarget struct {
+	Provider string `json:"provider"` // "openai" or "claude"
+	Model    string `json:"model,omitempty"`
+	Prompt   string `json:"prompt,omitempty"` // system prompt path, default prompt/system.txt
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+type ReplayRequest struct {
+	Filter ReplayFilter `json:"filter"`
+	Target ReplayTarget `json:"target"`
+}
+
+// ReplayRun is one replayed query. Baseline is the provider of the
+// original answer it was compared with.
+type ReplayRun struct {
+	Of       string   `json:"of"`
+	RunID    string   `json:"run_id,omitempty"`
+	Query    string   `json:"query"`
+	Baseline string   `json:"baseline,omitempty"`
+	Changed  []string `json:"changed,omitempty"` // slots that differ from the original
+	Error    string   `json:"error,omitempty"`
+}
+
+// Replay is the state of one replay
+type Replay struct {
+	ID       string        `json:"id"`
+	Status   string        `json:"status"` // "running" or "done"
+	Request  ReplayRequest `json:"request"`
+	Started  time.Time     `json:"started"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	Total    int           `json:"total"`
+	Done     int           `json:"done"`
+	Failed   int           `json:"failed"`
+	Changed  int           `json:"changed"` // runs with at least one changed slot
+	Runs     []ReplayRun   `json:"runs"`
+	tenant   string
+}
+
+var replays struct {
+	sync.Mutex
+	list []*Replay // oldest first
+}
+
+func (q *ReplayRequest) validate() error {
+	f, t := &q.Filter, &q.Target
+	if f.From != nil && f.To != nil && f.To.Before(*f.From) {
+		return errors.New("filter.to is before filter.from")
+	}
+	if f.Limit < 0 {
+		return errors.New("filter.limit cannot be negative")
+	}
+	if f.Limit == 0 {
+		f.Limit = replayDefaultLimit
+	}
+	f.Limit = min(f.Limit, replayMaxLimit)
+	t.Provider = strings.ToLower(strings.TrimSpace(t.Provider))
+	switch t.Provider {
+	case "openai", "claude":
+	default:
+		return fmt.Errorf("unknown target.provider %q (use openai or claude)", t.Provider)
+	}
+	switch t.Pipeline {
+	case "", pipelineSingle, pipelineTwoStage:
+	default:
+		return fmt.Errorf("unknown target.pipeline %q (use %s or %s)", t.Pipeline, pipelineSingle, pipelineTwoStage)
+	}
+	if t.Prompt != "" {
+		if _, err := readPrompt(t.Prompt); err != nil {
+			return fmt.Errorf("target.prompt %q not found", t.Prompt)
+		}
+	}
+	return nil
+}
+
+// includes is whether the filter picks run
+func (f ReplayFilter) includes(run StoredResult) bool {
+	switch {
+	case run.Shadow != nil, run.Replay != nil, run.Aborted:
+		return false
+	case f.From != nil && run.Time.Before(*f.From):
+		return false
+	case f.To != nil && !run.Time.Before(*f.To):
+		return false
+	case f.Tag != "" && run.Tag != f.Tag:
+		return false
+	case f.Variant != "" && variantName(run) != f.Variant:
+		return false
+	}
+	return true
+}
+
+// selectReplayRuns are the newest runs of tenant the filter picks, oldest
+// first
+func selectReplayRuns(f ReplayFilter, tenant string) ([]StoredResult, error) {
+	var runs []StoredResult
+	err := store.Each(func(run StoredResult) error {
+		if run.Tenant == tenant && f.includes(run) {
+			run.Raw = nil
+			runs = append(runs, run)
+			if len(runs) > 2*f.Limit {
+				runs = append(runs[:0], runs[len(runs)-f.Limit:]...)
+			}
+		}
+		return nil
+	})
+	if len(runs) > f.Limit {
+		runs = runs[len(runs)-f.Limit:]
+	}
+	return runs, err
+}
+
+// startReplay registers the replay and runs it in the background
+func startReplay(req ReplayRequest, tenant string, runs []StoredResult) *Replay {
+	rp := &Replay{ID: newRunID()[:12], Status: "running", Request: req, Started: time.Now(), Total: len(runs), Runs: []ReplayRun{}, tenant: tenant}
+	replays.Lock()
+	replays.list = append(replays.list, rp)
+	if n := len(replays.list); n > replayKeep {
+		replays.list = replays.list[n-replayKeep:]
+	}
+	replays.Unlock()
+
+	variant := &Variant{Name: "replay-" + rp.ID, Prompt: req.Target.Prompt, Pipeline: req.Target.Pipeline}
+	if req.Target.Model != "" {
+		variant.Models = map[string]string{req.Target.Provider: req.Target.Model}
+	}
+	jobs := make(chan StoredResult)
+	var wg sync.WaitGroup
+	for range min(replayWorkers, max(len(runs), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for run := range jobs {
+				rp.record(replayOne(rp.ID, req.Target.Provider, variant, run))
+			}
+		}()
+	}
+	go func() {
+		for _, run := range runs {
+			jobs <- run
+		}
+		close(jobs)
+		wg.Wait()
+		replays.Lock()
+		defer replays.Unlock()
+		now := time.Now()
+		rp.Status, rp.Finished = "done", &now
+		log.Printf("[INFO] replay %s: %d runs, %d failed, %d changed", rp.ID, rp.Done, rp.Failed, rp.Changed)
+	}()
+	log.Printf("[INFO] replay %s started: %d runs against %s", rp.ID, len(runs), req.Target.Provider)
+	return rp
+}
+
+// replayOne parses an original run's query again and compares the answers
+func replayOne(id, provider string, variant *Variant, orig StoredResult) ReplayRun {
+	out := ReplayRun{Of: orig.ID, Query: orig.Query}
+	input := parseInput{Query: orig.Query, Provider: provider, Tag: orig.Tag, tenant: orig.Tenant, variant: variant,
+		replay: &ReplayInfo{ID: id, Of: orig.ID}}
+	if orig.Assisted {
+		input.Interpretation = interpretAssisted
+	}
+	runID := newRunID()
+	results, fail := runParse(context.Background(), input, runID)
+	if fail != nil {
+		out.Error = fail.Detail
+		return out
+	}
+	if !results.Degraded {
+		out.RunID = runID // rules answers are not stored
+	}
+	answer := results.OpenAI
+	if provider == "claude" {
+		answer = results.Claude
+	}
+	if answer == nil {
+		answer = results.Rules
+	}
+	baseline, base := replayBaseline(orig.Response, provider)
+	if answer == nil || base == nil {
+		return out
+	}
+	out.Baseline = baseline
+	for _, d := range diffResponses(*base, *answer) {
+		if d.Status != diffAgree {
+			out.Changed = append(out.Changed, d.Slot)
+		}
+	}
+	return out
+}
+
+// replayBaseline is the original answer to compare with: the same
+// provider's where there is one
+func replayBaseline(resp MultiParseResponse, provider string) (string, *ParseResponse) {
+	answers := map[string]*ParseResponse{"openai": resp.OpenAI, "claude": resp.Claude, "rules": resp.Rules}
+	for _, name := range []string{provider, "openai", "claude", "rules"} {
+		if answers[name] != nil {
+			return name, answers[name]
+		}
+	}
+	return "", nil
+}
+
+func (rp *Replay) record(run ReplayRun) {
+	replays.Lock()
+	defer replays.Unlock()
+	rp.Done++
+	if run.Error != "" {
+		rp.Failed++
+	} else if len(run.Changed) > 0 {
+		rp.Changed++
+	}
+	rp.Runs = append(rp.Runs, run)
+}
+
+func findReplay(id, tenant string) *Replay {
+	for _, rp := range replays.list {
+		if rp.ID == id && rp.tenant == tenant {
+			return rp
+		}
+	}
+	return nil
+}
+
+// replayHandler: POST starts a replay
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST"))
+		return
+	}
+	b, err := readBody(w, r)
+	if err != nil {
+		writeProblem(w, inputProblem(err))
+		return
+	}
+	var req ReplayRequest
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, jsonInputError(err).Error()))
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, err.Error()))
+		return
+	}
+	tenant := tenantOf(r.Context())
+	runs, err := selectReplayRuns(req.Filter, tenant)
+	if err != nil {
+		log.Printf("[ERROR] selecting runs to replay: %v", err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not load runs"))
+		return
+	}
+	if len(runs) == 0 {
+		writeProblem(w, newProblem(http.StatusUnprocessableEntity, codeInvalidInput, "no stored runs match the filter"))
+		return
+	}
+	rp := startReplay(req, tenant, runs)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/replay/"+rp.ID)
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]any{"id": rp.ID, "status": "running", "total": rp.Total})
+}
+
+// replayStatusHandler: GET shows a replay's progress and results
+func replayStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	id := r.PathValue("id")
+	replays.Lock()
+	defer replays.Unlock()
+	rp := findReplay(id, tenantOf(r.Context()))
+	if rp == nil {
+		writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "no replay with id "+id))
+		return
+	}
+	writeAdminJSON(w, rp)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Append-only results log ======
+// Runs are appended as JSON lines to data/results.jsonl. When the active
+// file grows past RESULTS_ROTATE_BYTES or gets older than
+// RESULTS_ROTATE_EVERY it is renamed to results-<timestamp>.jsonl and
+// gzipped in the background. Readers stream all segments oldest first.
+
+const resultsLogFile = "data/results.jsonl"
+
+type resultLog struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64         // 0 disables size-based rotation
+	maxAge     time.Duration // 0 disables time-based rotation
+	size       int64
+	segStarted time.Time        // time of the first run in the active file
+	archive    *segmentArchiver // nil unless S3 archival is configured
+}
+
+func newResultLog(path string) *resultLog {
+	l := &resultLog{
+		path:     path,
+		maxBytes: 64 << 20,
+	}
+	if v := os.Getenv("RESULTS_ROTATE_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			l.maxBytes = n
+		}
+	}
+	if v := os.Getenv("RESULTS_ROTATE_EVERY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			l.maxAge = d
+		}
+	}
+	l.migrateLegacy()
+	if fi, err := os.Stat(path); err == nil {
+		l.size = fi.Size()
+		l.segStarted = firstRunTime(path)
+	}
+	l.compressPending()
+	return l
+}
+
+// migrateLegacy converts an existing results.json array into the log once
+func (l *resultLog) migrateLegacy() {
+	if len(l.segments()) > 0 {
+		return
+	}
+	if _, err := os.Stat(l.path); err == nil {
+		return
+	}
+	b, err := os.ReadFile(dataPath(resultsFile))
+	if err != nil {
+		return
+	}
+	var legacy []StoredResult
+	if err := json.Unmarshal(b, &legacy); err != nil {
+		log.Printf("[WARN] could not migrate %s: %v", resultsFile, err)
+		return
+	}
+	var buf []byte
+	for _, run := range legacy {
+		line, _ := json.Marshal(run)
+		buf = append(append(buf, line...), '\n')
+	}
+	if err := writeFileAtomic(l.path, buf, 0644); err != nil {
+		log.Printf("[WARN] could not migrate %s: %v", resultsFile, err)
+		return
+	}
+	log.Printf("[INFO] migrated %d runs from %s to %s (the old file is no longer written)", len(legacy), resultsFile, l.path)
+}
+
+func (l *resultLog) Append(run StoredResult) error {
+	b, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.needsRotation(int64(len(b))) {
+		if err := l.rotate(); err != nil {
+			log.Printf("[ERROR] results rotation failed: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if l.size == 0 {
+		l.segStarted = run.Time
+	}
+	l.size += int64(len(b))
+	return f.Close()
+}
+
+func (l *resultLog) needsRotation(next int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.maxBytes > 0 && l.size+next > l.maxBytes {
+		return true
+	}
+	return l.maxAge > 0 && !l.segStarted.IsZero() && time.Since(l.segStarted) > l.maxAge
+}
+
+// rotate renames the active file to a timestamped segment; callers hold mu
+func (l *resultLog) rotate() error {
+	seg := l.segmentName(time.Now())
+	if err := os.Rename(l.path, seg); err != nil {
+		return err
+	}
+	l.size = 0
+	l.segStarted = time.Time{}
+	go compressSegment(seg)
+	return nil
+}
+
+func (l *resultLog) segmentName(t time.Time) string {
+	base := strings.TrimSuffix(l.path, ".jsonl")
+	return base + "-" + t.UTC().Format("20060102T150405.000000000") + ".jsonl"
+}
+
+// segments lists rotated segments (compressed or not), oldest first
+func (l *resultLog) segments() []string {
+	base := strings.TrimSuffix(l.path, ".jsonl")
+	matches, _ := filepath.Glob(base + "-*.jsonl*")
+	sort.Strings(matches)
+	// a segment may briefly exist both raw and gzipped while compressing
+	var out []string
+	for i, m := range matches {
+		if !strings.HasSuffix(m, ".gz") && i+1 < len(matches) && matches[i+1] == m+".gz" {
+			continue
+		}
+		if strings.HasSuffix(m, ".tmp") {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// compressPending gzips raw segments left over from an interrupted run
+func (l *resultLog) compressPending() {
+	for _, seg := range l.segments() {
+		if strings.HasSuffix(seg, ".jsonl") {
+			go compressSegment(seg)
+		}
+	}
+}
+
+func compressSegment(seg string) {
+	in, err := os.Open(seg)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	tmp := seg + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		log.Printf("[ERROR] compressing %s: %v", seg, err)
+		return
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, seg+".gz")
+	}
+	if err != nil {
+		log.Printf("[ERROR] compressing %s: %v", seg, err)
+		os.Remove(tmp)
+		return
+	}
+	os.Remove(seg)
+}
+
+// Each streams every segment and then the active file. Torn lines (e.g.
+// after a crash mid-write) are skipped. Archived segments that were pruned
+// from disk are streamed from S3 in their place.
+func (l *resultLog) Each(fn func(StoredResult) error) error {
+	l.mu.Lock()
+	files := l.segments()
+	l.mu.Unlock()
+
+	type source struct {
+		name   string
+		path   string
+		remote *archivedSegment
+	}
+	var sources []source
+	for _, f := range files {
+		sources = append(sources, source{name: filepath.Base(f), path: f})
+	}
+	if l.archive != nil {
+		for _, m := range l.archive.remoteSegments() {
+			m := m
+			sources = append(sources, source{name: m.Segment, remote: &m})
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].name < sources[j].name })
+	sources = append(sources, source{name: filepath.Base(l.path), path: l.path})
+
+	for _, src := range sources {
+		var err error
+		if src.remote != nil {
+			err = l.archive.eachRemote(*src.remote, fn)
+		} else {
+			err = eachRunInFile(src.path, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *resultLog) All() ([]StoredResult, error) {
+	var results []StoredResult
+	err := l.Each(func(run StoredResult) error {
+		results = append(results, run)
+		return nil
+	})
+	return results, err
+}
+
+func eachRunInFile(path string, fn func(StoredResult) error) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// a raw segment may have been compressed since it was listed
+		if !strings.HasSuffix(path, ".gz") {
+			if f, err = os.Open(path + ".gz"); err == nil {
+				path += ".gz"
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return eachRunInReader(f, strings.HasSuffix(path, ".gz"), fn)
+}
+
+func eachRunInReader(r io.Reader, gzipped bool, fn func(StoredResult) error) error {
+	if gzipped {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var run StoredResult
+		if err := json.Unmarshal(sc.Bytes(), &run); err != nil {
+			continue
+		}
+		if err := fn(run); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func firstRunTime(path string) time.Time {
+	var t time.Time
+	stop := errors.New("stop")
+	_ = eachRunInFile(path, func(run StoredResult) error {
+		t = run.Time
+		return stop
+	})
+	return t
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ====== Run diff ======
+// GET /v1/results/{id}/diff compares the OpenAI and Claude answers of one
+// stored run slot by slot, so the comparison UI doesn't have to compute set
+// differences itself. Values use the same normalisation as the evaluation
+// (flatten); slots neither provider filled are left out.
+
+const (
+	diffAgree      = "agree"
+	diffOnlyOpenAI = "only_openai"
+	diffOnlyClaude = "only_claude"
+	diffConflict   = "conflict"
+)
+
+// SlotDiff is one slot's comparison. Agree holds the values both providers
+// set, OnlyOpenAI/OnlyClaude the rest.
+type SlotDiff struct {
+	Slot       string   `json:"slot"`
+	Status     string   `json:"status"`
+	Agree      []string `json:"agree,omitempty"`
+	OnlyOpenAI []string `json:"only_openai,omitempty"`
+	OnlyClaude []string `json:"only_claude,omitempty"`
+}
+
+type RunDiff struct {
+	RunID   string         `json:"run_id"`
+	Query   string         `json:"query"`
+	Summary map[string]int `json:"summary"` // slots per status
+	Slots   []SlotDiff     `json:"slots"`
+}
+
+// diffResponses compares two answers slot by slot
+func diffResponses(openai, claude ParseResponse) []SlotDiff {
+	o, c := flatten(openai), flatten(claude)
+	bySlot := map[string]*SlotDiff{}
+	slot := func(k string) *SlotDiff {
+		name := slotNameOf(k)
+		d := bySlot[name]
+		if d == nil {
+			d = &SlotDiff{Slot: name}
+			bySlot[name] = d
+		}
+		return d
+	}
+	value := func(k string) string { return strings.TrimPrefix(k, slotNameOf(k)+"=") }
+
+	for k := range o {
+		d := slot(k)
+		if c[k] {
+			d.Agree = append(d.Agree, value(k))
+		} else {
+			d.OnlyOpenAI = append(d.OnlyOpenAI, value(k))
+		}
+	}
+	for k := range c {
+		if !o[k] {
+			d := slot(k)
+			d.OnlyClaude = append(d.OnlyClaude, value(k))
+		}
+	}
+
+	out := make([]SlotDiff, 0, len(bySlot))
+	for _, d := range bySlot {
+		sort.Strings(d.Agree)
+		sort.Strings(d.OnlyOpenAI)
+		sort.Strings(d.OnlyClaude)
+		switch {
+		case len(d.OnlyOpenAI) == 0 && len(d.OnlyClaude) == 0:
+			d.Status = diffAgree
+		case len(d.OnlyOpenAI) > 0 && len(d.OnlyClaude) > 0:
+			d.Status = diffConflict
+		case len(d.OnlyOpenAI) > 0 && len(d.Agree) == 0:
+			d.Status = diffOnlyOpenAI
+		case len(d.OnlyClaude) > 0 && len(d.Agree) == 0:
+			d.Status = diffOnlyClaude
+		default:
+			// both set the slot, one added values the other didn't
+			d.Status = diffConflict
+		}
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Slot < out[j].Slot })
+	return out
+}
+
+func resultDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	id := r.PathValue("id")
+	run, err := findRun(id)
+	if err != nil {
+		log.Printf("[ERROR] loading run %s: %v", id, err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not load run"))
+		return
+	}
+	if run == nil || run.Tenant != tenantOf(r.Context()) {
+		writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "no run with id "+id))
+		return
+	}
+	resp := run.Response
+	if resp.OpenAI == nil || resp.Claude == nil {
+		writeProblem(w, newProblem(http.StatusUnprocessableEntity, codeInvalidInput, "run does not have answers from both providers"))
+		return
+	}
+
+	diff := RunDiff{
+		RunID:   run.ID,
+		Query:   run.Query,
+		Summary: map[string]int{diffAgree: 0, diffOnlyOpenAI: 0, diffOnlyClaude: 0, diffConflict: 0},
+		Slots:   diffResponses(*resp.OpenAI, *resp.Claude),
+	}
+	for _, d := range diff.Slots {
+		diff.Summary[d.Status]++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(diff)
+}
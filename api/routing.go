@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ====== Tiered routing ======
+// With OPENAI_ESCALATION_MODEL / CLAUDE_ESCALATION_MODEL set, each provider
+// answers with its regular (cheap) model first. Low-confidence answers are
+// then re-parsed with the escalation model:
+//
+//	disagreement          provider=both and the two answers differ
+//	unsupported_criteria  at least ESCALATE_MAX_UNSUPPORTED entries (default 3, 0 = off)
+//	json_repair           the answer needed JSON repair
+//	invalid_output        the answer failed decoding or validation
+//
+// The status block records which tier answered and why it escalated.
+
+const (
+	tierBase      = "base"
+	tierEscalated = "escalated"
+)
+
+// EscalationInfo describes the call to the stronger model
+type EscalationInfo struct {
+	Model     string   `json:"model"`
+	Reasons   []string `json:"reasons"`
+	Status    string   `json:"status"` // "ok" or "error"
+	Code      string   `json:"code,omitempty"`
+	LatencyMS int64    `json:"latency_ms"`
+}
+
+func escalationModel(provider string) string {
+	return os.Getenv(strings.ToUpper(provider) + "_ESCALATION_MODEL")
+}
+
+// lowConfidence lists the reasons to escalate a provider's answer
+func lowConfidence(c *providerCall, disagree bool) []string {
+	var reasons []string
+	var pe *parseError
+	if errors.As(c.err, &pe) {
+		reasons = append(reasons, "invalid_output")
+	}
+	if c.res == nil {
+		return reasons
+	}
+	if disagree {
+		reasons = append(reasons, "disagreement")
+	}
+	if max := envInt("ESCALATE_MAX_UNSUPPORTED", 3); os.Getenv("ESCALATE_MAX_UNSUPPORTED") != "0" && len(c.res.UnsupportedCriteria) >= max {
+		reasons = append(reasons, "unsupported_criteria")
+	}
+	if c.repaired {
+		reasons = append(reasons, "json_repair")
+	}
+	return reasons
+}
+
+// escalate re-runs low-confidence calls with their provider's escalation
+// model, concurrently and within the same request budget. A failed
+// escalation keeps the base answer if there was one.
+func escalate(ctx context.Context, calls []*providerCall, plan parsePlan) {
+	disagree := len(calls) == 2 && calls[0].res != nil && calls[1].res != nil &&
+		!setsEqual(flatten(*calls[0].res), flatten(*calls[1].res))
+
+	var wg sync.WaitGroup
+	for _, c := range calls {
+		model := escalationModel(c.provider)
+		if model == "" || c.cached {
+			continue
+		}
+		c.tier = tierBase
+		reasons := lowConfidence(c, disagree)
+		if len(reasons) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(c *providerCall) {
+			defer wg.Done()
+			name := providerDisplayName(c.provider)
+			log.Printf("[INFO] %s escalating to %s (%s)", name, model, strings.Join(reasons, ", "))
+			e := callProvider(ctx, plan, c.provider, model)
+			c.usage.add(e.usage)
+			c.latency += e.latency
+			c.timings.merge(e.timings)
+			c.escalation = &EscalationInfo{Model: model, Reasons: reasons, Status: "ok", LatencyMS: e.latency.Milliseconds()}
+			if e.err != nil {
+				c.escalation.Status, c.escalation.Code = "error", classifyProviderError(name, e.err).Code
+				if c.res != nil {
+					return
+				}
+			}
+			c.res, c.repaired, c.repro, c.confidence, c.trace, c.err = e.res, e.repaired, e.repro, e.confidence, e.trace, e.err
+			c.tier = tierEscalated
+		}(c)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ====== Rules fallback ======
+// When every requested provider fails, a parse request is answered from
+// deterministic rules instead of "both calls failed": prices, stars,
+// ratings, guests and group size, dates, nights and flexibility, departure
+// airports, distances to reference points, a location after
+// "in"/"nach"/"auf"/"an der" and the taxonomy's synonyms. The answer is in "rules", with "degraded": true and the
+// provider errors in "status", so the search UI can still apply the
+// obvious filters during an outage. It is not stored as a run.
+// RULES_FALLBACK=0 turns it off. Cancelled requests never use it, and
+// neither do overloaded or over-quota ones: those must reach the client as
+// backpressure (503/429 with Retry-After), not as a degraded answer.
+
+func rulesFallback() bool {
+	switch strings.ToLower(os.Getenv("RULES_FALLBACK")) {
+	case "0", "false", "off":
+		return false
+	}
+	return true
+}
+
+// fallsBackToRules is whether a request whose providers all failed with
+// code is answered from the rules
+func fallsBackToRules(code string) bool {
+	switch code {
+	case codeClientClosed, codeOverloaded, codeQuotaExceeded:
+		return false
+	}
+	return rulesFallback()
+}
+
+var (
+	rulePrice = regexp.MustCompile(`(?i)(?:unter|bis(?: zu)?|max(?:imal|\.)?|höchstens|nicht mehr als)\s*(\d+(?:[.,]\d+)?)\s*(?:€|euro\b|eur\b)`)
+	ruleStars = regexp.MustCompile(`(?i)\b([1-5])\s*(?:-\s*)?(?:sterne?\b|\*)`)
+	// 3 bis 4 Sterne, 3-4*, höchstens 3 Sterne; Bewertung zwischen 7 und 9
+	ruleStarRange   = regexp.MustCompile(`(?i)\b([1-5])\s*(?:-|–|bis)\s*([1-5])\s*(?:sterne?\b|\*)`)
+	ruleStarsMax    = regexp.MustCompile(`(?i)(?:höchstens|maximal|max\.?|bis)\s*([1-5])\s*(?:sterne?\b|\*)`)
+	ruleRatingRange = regexp.MustCompile(`(?i)bewertung\s*(?:zwischen|von)\s*(\d+(?:[.,]\d)?)\s*(?:und|bis|-|–)\s*(\d+(?:[.,]\d)?)`)
+	ruleRating      = regexp.MustCompile(`(?i)bewertung\s*(?:ab|mindestens|von|über)?\s*(\d+(?:[.,]\d)?)`)
+	ruleAdults      = regexp.MustCompile(`(?i)\b(\d+|eine?|zwei|drei|vier|fünf|sechs)\s+erwachsene?`)
+	ruleChildren    = regexp.MustCompile(`(?i)\b(\d+|eine?m?|zwei|drei|vier|fünf|sechs)\s+kind(?:er|ern)?\b`)
+	ruleFamily      = regexp.MustCompile(`(?i)familienfreundlich|familienhotel|mit kind(?:ern)?\b`)
+	// Gruppe von 8, 8 Personen, für 8 Leute, zu acht
+	ruleGroupSize = regexp.MustCompile(`(?i)\bgruppe\s+(?:von|mit|aus)\s+(\d{1,2}|zwei|drei|vier|fünf|sechs)\b|\b(\d{1,2}|zwei|drei|vier|fünf|sechs)\s+(?:personen|leute|leuten|reisende)\b`)
+	ruleTogether  = regexp.MustCompile(`(?i)\bzu\s+(zweit|dritt|viert|fünft|sechst|siebt|acht|neunt|zehnt)\b`)
+	// 12.10.2025 - 14.10.2025, 12.10. bis 14.10., 12.–14.10.2025
+	ruleDateRange = regexp.MustCompile(`(\d{1,2})\.(\d{1,2})?\.?(\d{4})?\s*(?:-|–|bis)\s*(\d{1,2})\.(\d{1,2})\.(\d{4})?`)
+	// ±3 Tage, +/- 2 Tage, 3 Tage flexibel; "um den ... herum" without a number
+	ruleFlexDays  = regexp.MustCompile(`(?i)(?:±|\+/-|\+-)\s*(\d{1,2})\s*tage?\b|\b(\d{1,2})\s*tage?\s+flexibel`)
+	ruleFlexAbout = regexp.MustCompile(`(?i)\bum\s+den\b.{1,25}?\bherum\b`)
+	// 3 Nächte, zwei Übernachtungen, eine Woche
+	ruleNights   = regexp.MustCompile(`(?i)\b(\d{1,2}|eine?|zwei|drei|vier|fünf|sechs)\s+(nächte?|übernachtungen?|wochen?)\b`)
+	ruleDateFrom = regexp.MustCompile(`(?i)\bab\s+(?:dem\s+)?(\d{1,2})\.(\d{1,2})\.(\d{4})?`)
+	// ab Frankfurt, Abflug ab MUC, Abflug Köln/Bonn, ab Flughafen Hamburg
+	ruleDeparture = regexp.MustCompile(`(?i)\b(?:abflug(?:\s+ab)?|ab|von)\s+((?:flughafen\s+)?[\p{L}]+(?:[\s/-]+[\p{L}]+){0,2})`)
+	// maximal 10 km vom Flughafen, 5 Gehminuten zur Altstadt, 500 m zum Strand
+	ruleDistance = regexp.MustCompile(`(?i)\b(\d+(?:[.,]\d+)?)\s*(km|kilometern?|m|metern?|gehminuten|fußminuten|autominuten|fahrminuten|min(?:uten)?(?:\s+zu\s+fuß|\s+mit\s+dem\s+auto|\s+fahrt)?)\s+(?:entfernt\s+)?(?:vo[mn]|zu[mr]?|bis\s+zu[mr]?|zu\s+fuß\s+zu[mr]?)\s+(?:der\s+|dem\s+|den\s+)?([\p{L}-]+)`)
+	ruleLocation = regexp.MustCompile(`\b(?:in|nach|auf|an der|an die|an den)\s+([A-ZÄÖÜ][\p{L}-]+(?:\s+[A-ZÄÖÜ][\p{L}-]+)?)`)
+)
+
+// ruleNotPlaces are capitalised words that follow "in"/"an der" without
+// being a place
+var ruleNotPlaces = map[string]bool{
+	"Strand": true, "Strandnähe": true, "Nähe": true, "Zentrum": true, "Innenstadt": true,
+	"Ruhe": true, "Ruhiger": true, "Ruhiglage": true, "Lage": true,
+}
+
+var ruleNumberWords = map[string]int{
+	"ein": 1, "eine": 1, "einem": 1, "zwei": 2, "drei": 3, "vier": 4, "fünf": 5, "sechs": 6,
+}
+
+var ruleTogetherWords = map[string]int{
+	"zweit": 2, "dritt": 3, "viert": 4, "fünft": 5, "sechst": 6, "siebt": 7, "acht": 8, "neunt": 9, "zehnt": 10,
+}
+
+func ruleNumber(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return ruleNumberWords[strings.ToLower(s)]
+}
+
+func ruleFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(strings.ReplaceAll(s, ",", "."), 64)
+	return f
+}
+
+// ruleParse is the best-effort answer of the rules for query
+func ruleParse(query string, now time.Time) ParseResponse {
+	res := ParseResponse{UiFilters: emptyUiFilters(), UnsupportedCriteria: []string{}}
+
+	if m := rulePrice.FindStringSubmatch(query); m != nil {
+		res.PriceMaxEUR = ruleFloat(m[1])
+	}
+	if m := ruleStarRange.FindStringSubmatch(query); m != nil {
+		res.StarsMin, res.StarsMax = ruleNumber(m[1]), ruleNumber(m[2])
+	} else if m := ruleStarsMax.FindStringSubmatch(query); m != nil {
+		res.StarsMax = ruleNumber(m[1])
+	} else if m := ruleStars.FindStringSubmatch(query); m != nil {
+		res.StarsMin = ruleNumber(m[1])
+	}
+	if m := ruleRatingRange.FindStringSubmatch(query); m != nil {
+		if lo, hi := ruleFloat(m[1]), ruleFloat(m[2]); lo <= hi && hi <= 10 {
+			res.RatingMin, res.RatingMax = lo, hi
+		}
+	} else if m := ruleRating.FindStringSubmatch(query); m != nil {
+		if f := ruleFloat(m[1]); f <= 10 {
+			res.RatingMin = f
+		}
+	}
+	if m := ruleAdults.FindStringSubmatch(query); m != nil {
+		res.Guests.Adults = ruleNumber(m[1])
+	}
+	if m := ruleChildren.FindStringSubmatch(query); m != nil {
+		res.Guests.Children = ruleNumber(m[1])
+	}
+	if m := ruleGroupSize.FindStringSubmatch(query); m != nil {
+		res.GroupSize = ruleNumber(m[1] + m[2])
+	} else if m := ruleTogether.FindStringSubmatch(query); m != nil {
+		res.GroupSize = ruleTogetherWords[strings.ToLower(m[1])]
+	}
+	if res.GroupSize < res.Guests.Adults+res.Guests.Children {
+		res.GroupSize = 0 // a count of something else, not the party
+	}
+	if ruleFamily.MatchString(query) || res.Guests.Children > 0 {
+		t := true
+		res.FamilyFriendly = &t
+	}
+	res.Dates = ruleDates(query, now)
+	res.DateFlexibilityDays = ruleFlex(query)
+	if m := ruleNights.FindStringSubmatch(query); m != nil {
+		res.Nights = ruleNumber(m[1])
+		if strings.HasPrefix(strings.ToLower(m[2]), "woche") {
+			res.Nights *= 7
+		}
+	}
+	if res.Dates.Checkin == "" && res.Nights > 0 {
+		if m := ruleDateFrom.FindStringSubmatch(query); m != nil {
+			if in, ok := ruleNextDate(ruleNumber(m[3]), ruleNumber(m[2]), ruleNumber(m[1]), now); ok {
+				res.Dates.Checkin = in.Format(time.DateOnly)
+			}
+		}
+	}
+	for _, m := range ruleDeparture.FindAllStringSubmatch(query, -1) {
+		// the longest known name: "Frankfurt Hahn" before "Frankfurt"
+		words := strings.Fields(normalizePhrase(m[1]))
+		for n := len(words); n > 0; n-- {
+			if name := strings.Join(words[:n], " "); lookupAirport(name) != nil {
+				res.DepartureAirports = append(res.DepartureAirports, name)
+				break
+			}
+		}
+	}
+	tax, _ := currentTaxonomy()
+	for _, m := range ruleDistance.FindAllStringSubmatch(query, -1) {
+		if ref := distanceReference(tax, m[3]); ref != "" {
+			res.Distances = append(res.Distances, Distance{Value: ruleFloat(m[1]), Unit: distanceUnit(m[2]), Reference: ref})
+		}
+	}
+	res.postProcess()
+	for _, m := range ruleLocation.FindAllStringSubmatch(query, -1) {
+		if first, _, _ := strings.Cut(m[1], " "); !ruleNotPlaces[first] {
+			res.Location = m[1]
+			break
+		}
+	}
+
+	// ui_filters as in the two-stage mapping
+	res.addRangeFilters()
+	res.ExcludedFilters, res.PreferredFilters = emptyUiFilters(), emptyUiFilters()
+	wanted, excluded := matchPolarity(tax, query)
+	for _, sv := range wanted {
+		res.apply(sv)
+	}
+	for _, sv := range excluded {
+		res.ExcludedFilters.add(sv.Slot, sv.Value)
+	}
+	for _, sv := range matchPreferred(tax, query) {
+		if slices.Contains(*res.UiFilters.Slot(sv.Slot), sv.Value) {
+			res.PreferredFilters.add(sv.Slot, sv.Value)
+		}
+	}
+	return res
+}
+
+// ruleDates reads a date range; without a year the next such date from
+// now is meant
+func ruleDates(query string, now time.Time) Dates {
+	m := ruleDateRange.FindStringSubmatch(query)
+	if m == nil {
+		return Dates{}
+	}
+	outMonth, outYear := ruleNumber(m[5]), ruleNumber(m[6])
+	inMonth, inYear := ruleNumber(m[2]), ruleNumber(m[3])
+	if inMonth == 0 {
+		inMonth = outMonth // 12.–14.10.
+	}
+	if inYear == 0 {
+		inYear = outYear
+	}
+	in, ok := ruleNextDate(inYear, inMonth, ruleNumber(m[1]), now)
+	if !ok {
+		return Dates{}
+	}
+	inYear = in.Year()
+	if outYear == 0 {
+		outYear = inYear
+		if outMonth < inMonth {
+			outYear++ // over New Year
+		}
+	}
+	out, ok := ruleDate(outYear, outMonth, ruleNumber(m[4]))
+	if !ok || !out.After(in) {
+		return Dates{}
+	}
+	return Dates{Checkin: in.Format(time.DateOnly), Checkout: out.Format(time.DateOnly)}
+}
+
+// ruleFlex is the ± days of flexibility around the dates; "um den 15.
+// herum" counts as 3 like in the prompt
+func ruleFlex(query string) int {
+	if m := ruleFlexDays.FindStringSubmatch(query); m != nil {
+		return min(ruleNumber(m[1]+m[2]), 30)
+	}
+	if ruleFlexAbout.MatchString(query) {
+		return 3
+	}
+	return 0
+}
+
+// ruleNextDate is the date; without a year (y == 0) the next one from now
+func ruleNextDate(y, m, d int, now time.Time) (time.Time, bool) {
+	if y == 0 {
+		y = now.Year()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		if t, ok := ruleDate(y, m, d); ok && t.Before(today) {
+			y++
+		}
+	}
+	return ruleDate(y, m, d)
+}
+
+// ruleDate is the date, unless it doesn't exist (31.02.)
+func ruleDate(y, m, d int) (time.Time, bool) {
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	return t, t.Year() == y && int(t.Month()) == m && t.Day() == d
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ====== Minimal S3 client ======
+// PUT/GET of whole objects with SigV4 signing and path-style URLs, which
+// works against AWS S3, MinIO and most S3-compatible stores.
+
+type S3Client struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+func NewS3ClientFromEnv() (*S3Client, error) {
+	bucket := os.Getenv("ARCHIVE_S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("ARCHIVE_S3_BUCKET missing")
+	}
+	region := os.Getenv("ARCHIVE_S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("ARCHIVE_S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	key, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if key == "" || secret == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY missing")
+	}
+	return &S3Client{
+		Endpoint:  strings.TrimRight(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: key,
+		SecretKey: secret,
+		Client:    &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (c *S3Client) PutObject(ctx context.Context, key string, body []byte) error {
+	req, err := c.newRequest(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 2048))
+		return fmt.Errorf("s3 put %s: %s: %s", key, res.Status, b)
+	}
+	return nil
+}
+
+// GetObject streams an object; the caller closes the body
+func (c *S3Client) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 2048))
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 get %s: %s: %s", key, res.Status, b)
+	}
+	return res.Body, nil
+}
+
+func (c *S3Client) newRequest(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	u, err := url.Parse(c.Endpoint + "/" + c.Bucket + "/" + s3EscapePath(key))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, body, time.Now().UTC())
+	return req, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header
+func (c *S3Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + c.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	k := hmacSHA256([]byte("AWS4"+c.SecretKey), day)
+	k = hmacSHA256(k, c.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// s3EscapePath URI-encodes every byte except unreserved characters and
+// "/", as SigV4 expects
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		ch := key[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || ch == '/' {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ====== Sampling + reproducibility ======
+// Parse requests may set "seed", "temperature" and "top_p"; unset values
+// fall back to <PROVIDER>_SEED, <PROVIDER>_TEMPERATURE (default 0) and
+// <PROVIDER>_TOP_P. Claude has no seed parameter. What was actually sent,
+// plus the model and system_fingerprint the provider reported, is returned
+// and stored per provider as status.<provider>.repro.
+
+// Sampling are the generation parameters of a call; nil means "not set"
+type Sampling struct {
+	Seed        *int64   `json:"seed,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+}
+
+// Repro is what it takes to reproduce one provider call
+type Repro struct {
+	Model string `json:"model,omitempty"` // as reported by the provider
+	Sampling
+	SystemFingerprint string `json:"system_fingerprint,omitempty"` // OpenAI only
+	// ToolSchema is the hash of the taxonomy tool schema (tool-calling mode)
+	ToolSchema string `json:"tool_schema,omitempty"`
+}
+
+// envSampling reads a provider's default sampling parameters. Invalid
+// values are ignored.
+func envSampling(prefix string) Sampling {
+	var s Sampling
+	if v := os.Getenv(prefix + "_SEED"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			s.Seed = &n
+		}
+	}
+	temperature := 0.0
+	if v := os.Getenv(prefix + "_TEMPERATURE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			temperature = f
+		}
+	}
+	s.Temperature = &temperature
+	if v := os.Getenv(prefix + "_TOP_P"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			s.TopP = &f
+		}
+	}
+	return s
+}
+
+// over returns s with unset fields taken from def
+func (s Sampling) over(def Sampling) Sampling {
+	if s.Seed == nil {
+		s.Seed = def.Seed
+	}
+	if s.Temperature == nil {
+		s.Temperature = def.Temperature
+	}
+	if s.TopP == nil {
+		s.TopP = def.TopP
+	}
+	return s
+}
+
+// maxTemperature is the highest temperature each provider accepts
+var maxTemperature = map[string]float64{"openai": 2, "claude": 1}
+
+// validate checks the ranges the providers accept; a temperature has to
+// suit every one of them, without providers the strictest applies
+func (s Sampling) validate(providers ...string) error {
+	if len(providers) == 0 {
+		providers = []string{"openai", "claude"}
+	}
+	if t := s.Temperature; t != nil {
+		limit, by := maxTemperature[providers[0]], providers[0]
+		for _, p := range providers[1:] {
+			if maxTemperature[p] < limit {
+				limit, by = maxTemperature[p], p
+			}
+		}
+		if *t < 0 || *t > limit {
+			return fmt.Errorf("temperature must be between 0 and %g for %s, got %g", limit, providerDisplayName(by), *t)
+		}
+	}
+	if p := s.TopP; p != nil && (*p <= 0 || *p > 1) {
+		return fmt.Errorf("top_p must be in (0, 1], got %g", *p)
+	}
+	if s.Seed != nil && *s.Seed < 0 {
+		return errors.New("seed cannot be negative")
+	}
+	return nil
+}
+
+// withSampling applies the request's sampling parameters to a per-request
+// client copy
+func withSampling(cli LLMClient, s Sampling) LLMClient {
+	switch c := cli.(type) {
+	case *OpenAIClient:
+		c.Sampling = s.over(c.Sampling)
+	case *ClaudeClient:
+		c.Sampling = s.over(c.Sampling)
+		c.Sampling.Seed = nil
+	}
+	return cli
+}
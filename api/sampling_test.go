@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSamplingTemperatureRange(t *testing.T) {
+	for _, tc := range []struct {
+		temperature float64
+		providers   []string
+		ok          bool
+	}{
+		{1.5, []string{"openai"}, true},
+		{2, []string{"openai"}, true},
+		{2.5, []string{"openai"}, false},
+		{1, []string{"claude"}, true},
+		{1.5, []string{"claude"}, false},
+		{1.5, []string{"openai", "claude"}, false},
+		{0.7, []string{"openai", "claude"}, true},
+		{-0.1, []string{"openai"}, false},
+	} {
+		err := Sampling{Temperature: &tc.temperature}.validate(tc.providers...)
+		if (err == nil) != tc.ok {
+			t.Errorf("temperature %g for %v: err %v", tc.temperature, tc.providers, err)
+		}
+	}
+}
+
+// The request's temperature reaches Claude, not the configured default
+func TestClaudeGetsRequestedTemperature(t *testing.T) {
+	var sent claudeReq
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"content": [{"type": "text", "text": "{}"}], "stop_reason": "end_turn"}`))
+	}))
+	defer srv.Close()
+
+	zero, temperature := 0.0, 0.7
+	cli := withSampling(&ClaudeClient{BaseURL: srv.URL, Model: "test", MaxTokens: 100, Client: srv.Client(),
+		Sampling: Sampling{Temperature: &zero}}, Sampling{Temperature: &temperature})
+	out, err := cli.CompleteJSON(context.Background(), "system", "Hotel in Rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.Temperature == nil || *sent.Temperature != temperature {
+		t.Errorf("Claude got temperature %v, want %g", sent.Temperature, temperature)
+	}
+	if out.Repro.Temperature == nil || *out.Repro.Temperature != temperature || !strings.Contains(out.Repro.Model, "test") {
+		t.Errorf("repro %+v", out.Repro)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ====== Model output hardening ======
+// Model output is untrusted input. Every string decoded from it is cleaned
+// before anything else sees it: invalid UTF-8 becomes U+FFFD, control
+// characters, bidi overrides and zero-width characters are dropped, and
+// strings are cut at maxModelStringRunes. A consumer once crashed on a
+// completion full of bidi overrides; the answer still has to be usable when
+// the model emits garbage.
+//
+// Code handling model output must not panic the process either: provider
+// calls run in their own goroutines, where a panic isn't caught by
+// recoverMiddleware. guardModelPanic turns one into the call's error.
+// FuzzExtractJSON, FuzzRepairJSON and FuzzValidate (fuzz_test.go) feed
+// arbitrary completions through these paths.
+
+const maxModelStringRunes = 500
+
+// invisibleRune reports characters that change how text around them is
+// shown without being visible themselves
+func invisibleRune(r rune) bool {
+	switch {
+	case r >= 0x200B && r <= 0x200F, // zero-width space/joiners, LRM/RLM
+		r >= 0x202A && r <= 0x202E, // bidi embeddings and overrides
+		r >= 0x2060 && r <= 0x2069, // word joiner, bidi isolates
+		r == 0xFEFF, r == 0x00AD:   // BOM, soft hyphen
+		return true
+	}
+	return false
+}
+
+// sanitizeText cleans one string from model output
+func sanitizeText(s string) string {
+	clean := true
+	for i, r := range s {
+		if r == utf8.RuneError || unicode.IsControl(r) || invisibleRune(r) || i >= maxModelStringRunes {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range strings.ToValidUTF8(s, "�") {
+		if n == maxModelStringRunes {
+			break
+		}
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			b.WriteByte(' ')
+		case unicode.IsControl(r) || invisibleRune(r):
+			continue
+		default:
+			b.WriteRune(r)
+		}
+		n++
+	}
+	return b.String()
+}
+
+// sanitizeStrings cleans every string reachable from v (a pointer)
+func sanitizeStrings(v any) {
+	sanitizeValue(reflect.ValueOf(v))
+}
+
+func sanitizeValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			sanitizeValue(v.Elem())
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				sanitizeValue(v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			sanitizeValue(v.Index(i))
+		}
+	case reflect.Map:
+		// keys stay; values of string maps are cleaned
+		for _, k := range v.MapKeys() {
+			if e := v.MapIndex(k); e.Kind() == reflect.String {
+				v.SetMapIndex(k, reflect.ValueOf(sanitizeText(e.String())).Convert(e.Type()))
+			}
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(sanitizeText(v.String()))
+		}
+	}
+}
+
+// guardModelPanic, deferred, turns a panic while handling model output into
+// a schema violation passed to fail
+func guardModelPanic(provider string, fail func(error)) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	log.Printf("[ERROR] %s: panic handling model output: %v\n%s", provider, p, debug.Stack())
+	fail(&parseError{Code: codeSchemaViolation, Detail: "model output could not be processed", Err: fmt.Errorf("panic: %v", p)})
+}
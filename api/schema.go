@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ====== Schema versions ======
+// ParseResponse is schema 1; it is what models produce and what runs and
+// ground truth are stored as. Clients choose the version they get with
+// ?schema=2 or "Accept: application/vnd.hotelparser.v2+json" (default 1),
+// so the schema can evolve without breaking existing consumers. Responses
+// carry schema_version and the X-Schema-Version header.
+
+const (
+	schemaV1      = 1
+	schemaV2      = 2
+	latestSchema  = schemaV2
+	schemaMimeFmt = "application/vnd.hotelparser.v%d+json"
+)
+
+// ParseResponseV2 generalises v1: several destinations, a price range and
+// children's ages
+type ParseResponseV2 struct {
+	SchemaVersion       int           `json:"schema_version"`
+	Destinations        []Destination `json:"destinations"`
+	DepartureAirports   []string      `json:"departure_airports"`
+	Dates               Dates         `json:"dates"`
+	DateFlexibilityDays int           `json:"date_flexibility_days"`
+	Nights              int           `json:"nights"`
+	Transport           string        `json:"transport"`
+	RoomTypes           []string      `json:"room_types"`
+	RoomViews           []string      `json:"room_views"`
+	Accessibility       []string      `json:"accessibility"`
+	Guests              GuestsV2      `json:"guests"`
+	GroupSize           int           `json:"group_size"`
+	TripPurpose         string        `json:"trip_purpose"`
+	Distances           []Distance    `json:"distances"`
+	Price               PriceRange    `json:"price"`
+	StarsMin            int           `json:"stars_min"`
+	StarsMax            int           `json:"stars_max"`
+	RatingMin           float64       `json:"rating_min"`
+	RatingMax           float64       `json:"rating_max"`
+	FamilyFriendly      *bool         `json:"family_friendly"`
+	UiFilters           UiFilters     `json:"ui_filters"`
+	ExcludedFilters     UiFilters     `json:"excluded_filters"`
+	PreferredFilters    UiFilters     `json:"preferred_filters"`
+	UnsupportedCriteria []string      `json:"unsupported_criteria"`
+	Assumptions         []Assumption  `json:"assumptions,omitempty"`
+	Event               *EventMatch   `json:"event,omitempty"`
+}
+
+type Destination struct {
+	Name string `json:"name"`
+}
+
+type GuestsV2 struct {
+	Adults       int   `json:"adults"`
+	Children     int   `json:"children"`
+	ChildrenAges []int `json:"children_ages"` // may be shorter than Children
+}
+
+// PriceRange is in EUR; nil bounds are open
+type PriceRange struct {
+	MinEUR *float64 `json:"min_eur"`
+	MaxEUR *float64 `json:"max_eur"`
+}
+
+// toV2 converts a v1 response; v1 has no ages or lower price bound
+func toV2(p *ParseResponse) *ParseResponseV2 {
+	if p == nil {
+		return nil
+	}
+	v2 := &ParseResponseV2{
+		SchemaVersion:       schemaV2,
+		Destinations:        []Destination{},
+		DepartureAirports:   p.DepartureAirports,
+		Dates:               p.Dates,
+		DateFlexibilityDays: p.DateFlexibilityDays,
+		Nights:              p.Nights,
+		Transport:           p.Transport,
+		RoomTypes:           p.RoomTypes,
+		RoomViews:           p.RoomViews,
+		Accessibility:       p.Accessibility,
+		Guests:              GuestsV2{Adults: p.Guests.Adults, Children: p.Guests.Children, ChildrenAges: []int{}},
+		GroupSize:           p.GroupSize,
+		TripPurpose:         p.TripPurpose,
+		Distances:           p.Distances,
+		StarsMin:            p.StarsMin,
+		StarsMax:            p.StarsMax,
+		RatingMin:           p.RatingMin,
+		RatingMax:           p.RatingMax,
+		FamilyFriendly:      p.FamilyFriendly,
+		UiFilters:           p.UiFilters,
+		ExcludedFilters:     p.ExcludedFilters,
+		PreferredFilters:    p.PreferredFilters,
+		UnsupportedCriteria: p.UnsupportedCriteria,
+		Assumptions:         p.Assumptions,
+		Event:               p.Event,
+	}
+	if p.Location != "" {
+		v2.Destinations = append(v2.Destinations, Destination{Name: p.Location})
+	}
+	if p.PriceMaxEUR > 0 {
+		max := p.PriceMaxEUR
+		v2.Price.MaxEUR = &max
+	}
+	return v2
+}
+
+// toV1 converts back, dropping what v1 can't express: the first destination
+// becomes the location, a minimum price and the ages are lost
+func toV1(p *ParseResponseV2) *ParseResponse {
+	if p == nil {
+		return nil
+	}
+	v1 := &ParseResponse{
+		SchemaVersion:       schemaV1,
+		DepartureAirports:   p.DepartureAirports,
+		Dates:               p.Dates,
+		DateFlexibilityDays: p.DateFlexibilityDays,
+		Nights:              p.Nights,
+		Transport:           p.Transport,
+		RoomTypes:           p.RoomTypes,
+		RoomViews:           p.RoomViews,
+		Accessibility:       p.Accessibility,
+		Guests:              Guests{Adults: p.Guests.Adults, Children: max(p.Guests.Children, len(p.Guests.ChildrenAges))},
+		GroupSize:           p.GroupSize,
+		TripPurpose:         p.TripPurpose,
+		Distances:           p.Distances,
+		StarsMin:            p.StarsMin,
+		StarsMax:            p.StarsMax,
+		RatingMin:           p.RatingMin,
+		RatingMax:           p.RatingMax,
+		FamilyFriendly:      p.FamilyFriendly,
+		UiFilters:           p.UiFilters,
+		ExcludedFilters:     p.ExcludedFilters,
+		PreferredFilters:    p.PreferredFilters,
+		UnsupportedCriteria: p.UnsupportedCriteria,
+		Assumptions:         p.Assumptions,
+		Event:               p.Event,
+	}
+	if len(p.Destinations) > 0 {
+		v1.Location = p.Destinations[0].Name
+	}
+	if p.Price.MaxEUR != nil {
+		v1.PriceMaxEUR = *p.Price.MaxEUR
+	}
+	return v1
+}
+
+// multiParseResponseV2 is the v2 envelope: the same status, stages etc.,
+// with v2 results
+type multiParseResponseV2 struct {
+	MultiParseResponse
+	SchemaVersion int              `json:"schema_version"`
+	OpenAI        *ParseResponseV2 `json:"openai,omitempty"`
+	Claude        *ParseResponseV2 `json:"claude,omitempty"`
+	Rules         *ParseResponseV2 `json:"rules,omitempty"`
+}
+
+// responseFor renders results in the given schema version
+func responseFor(results MultiParseResponse, version int) any {
+	if version == schemaV2 {
+		return multiParseResponseV2{
+			MultiParseResponse: results,
+			SchemaVersion:      schemaV2,
+			OpenAI:             toV2(results.OpenAI),
+			Claude:             toV2(results.Claude),
+			Rules:              toV2(results.Rules),
+		}
+	}
+	results.SchemaVersion = schemaV1
+	return results
+}
+
+// negotiateSchema picks the schema version from ?schema= or the Accept
+// header; errors are client-safe
+func negotiateSchema(r *http.Request) (int, error) {
+	if v := r.URL.Query().Get("schema"); v != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+		if err != nil || n < schemaV1 || n > latestSchema {
+			return 0, fmt.Errorf("unsupported schema %q (use 1 or 2)", v)
+		}
+		return n, nil
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		var n int
+		if _, err := fmt.Sscanf(mediaType, schemaMimeFmt, &n); err == nil {
+			if n < schemaV1 || n > latestSchema {
+				return 0, fmt.Errorf("unsupported schema version %d (use 1 or 2)", n)
+			}
+			return n, nil
+		}
+	}
+	return schemaV1, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ====== Input screening ======
+// Demo boxes attract "asdfgh", "???" and insults. Parsing them costs a
+// provider call, and storing the answers pollutes the results and the
+// evaluation baseline. Before anything else, runParse screens the query
+// without a model call and answers 422 unusable_input with a reason:
+//
+//	empty      no letters at all ("???", "...", emoji only)
+//	gibberish  most words look like keyboard mash ("asdfgh jklö")
+//	profanity  insults without anything to search for
+//
+// A query with a word of a stay or anything the rules parser can extract
+// is never gibberish or profanity: "scheiß teures Hotel in Rom" is still
+// parsed. Rejected queries are not stored. They are counted per API key
+// (rejected_inputs in GET /v1/usage, in memory only) and per reason in
+// hotelparser_rejected_inputs_total. INPUT_SCREENING=0 turns screening
+// off; replays aren't screened.
+
+const (
+	screenEmpty     = "empty"
+	screenGibberish = "gibberish"
+	screenProfanity = "profanity"
+)
+
+var screenMessagesDE = map[string]string{
+	screenEmpty:     "Die Eingabe enthält keine Suche. Beschreibe bitte, welches Hotel du suchst.",
+	screenGibberish: "Die Eingabe ist nicht lesbar. Beschreibe bitte, welches Hotel du suchst.",
+	screenProfanity: "Bitte formuliere deine Suche ohne Beleidigungen.",
+}
+
+var (
+	// profanityPattern: word starts, so compounds count and "Scunthorpe"
+	// doesn't
+	profanityPattern = regexp.MustCompile(`(?i)\b(?:arschloch|wichser|fotze|hurensohn|schei(?:ß|ss)|fick|verpiss|missgeburt|schlampe|fuck|shit|bitch|asshole|cunt|motherfucker)`)
+	// keyboardRuns are stretches of a QWERTZ row
+	keyboardRuns = []string{"qwert", "wertz", "asdf", "sdfg", "dfgh", "fghj", "ghjk", "hjkl", "jklö", "yxcv", "xcvb", "cvbn", "vbnm"}
+)
+
+var rejectedInputs = struct {
+	sync.Mutex
+	byReason map[string]int64
+}{byReason: map[string]int64{}}
+
+func inputScreening() bool {
+	switch strings.ToLower(os.Getenv("INPUT_SCREENING")) {
+	case "0", "false", "off":
+		return false
+	}
+	return true
+}
+
+// screenQuery is why query can't be a search, "" when it may be one
+func screenQuery(query string, now time.Time) string {
+	var words []string
+	for _, w := range strings.FieldsFunc(query, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		words = append(words, strings.ToLower(w))
+	}
+	if len(words) == 0 {
+		return screenEmpty
+	}
+	if searchPattern.MatchString(query) || len(flatten(ruleParse(query, now))) > 0 {
+		return ""
+	}
+	if profanityPattern.MatchString(query) {
+		return screenProfanity
+	}
+	mashed, long := 0, 0
+	for _, w := range words {
+		if len([]rune(w)) < 4 {
+			continue
+		}
+		long++
+		if looksMashed(w) {
+			mashed++
+		}
+	}
+	if long > 0 && mashed*2 > long {
+		return screenGibberish
+	}
+	return ""
+}
+
+// consonantUnits are spellings of one sound, or clusters German words
+// join on; each counts as one consonant, so "Deutschland",
+// "Hauptstraße" and "Angstschweiß" pass
+var consonantUnits = strings.NewReplacer(
+	"sch", "ʃ", "str", "ş", "spr", "ṡ", "ch", "ç", "ck", "k", "ng", "ŋ",
+	"st", "ş", "sp", "ṡ", "pf", "f", "tz", "z", "ph", "f",
+)
+
+// looksMashed reports whether w looks typed without meaning: no vowel, a
+// run of five consonants (see consonantUnits), one letter four times in a
+// row or a stretch of a keyboard row
+func looksMashed(w string) bool {
+	vowels, consonants, repeat := 0, 0, 1
+	var last rune
+	for _, r := range consonantUnits.Replace(w) {
+		if strings.ContainsRune("aeiouyäöü", r) {
+			vowels++
+			consonants = 0
+		} else if consonants++; consonants >= 5 {
+			return true
+		}
+		if r == last {
+			if repeat++; repeat >= 4 {
+				return true
+			}
+		} else {
+			repeat = 1
+		}
+		last = r
+	}
+	if vowels == 0 {
+		return true
+	}
+	for _, run := range keyboardRuns {
+		if strings.Contains(w, run) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectInput counts a screened query and returns its problem
+func rejectInput(reason, apiKey string) *Problem {
+	rejectedInputs.Lock()
+	rejectedInputs.byReason[reason]++
+	rejectedInputs.Unlock()
+	usage.Lock()
+	usageFor(apiKey, time.Now()).Rejected++
+	usage.Unlock()
+
+	p := newProblem(http.StatusUnprocessableEntity, codeUnusableInput, fmt.Sprintf("the query looks like %s input, not a search", reason))
+	p.Reason, p.MessageDE = reason, screenMessagesDE[reason]
+	log.Printf("[INFO] rejected %s input from %s", reason, apiKey)
+	return p
+}
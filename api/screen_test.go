@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLooksMashed(t *testing.T) {
+	for _, w := range []string{
+		"ölbergstraße", "hauptstraße", "bahnhofstraße", "deutschland", "deutschsprachig", "angstschweiß",
+		"herbstsonne", "kunstschnee", "hamburg", "schwarzwald", "pfalz", "zugspitze", "nordseeküste",
+	} {
+		if looksMashed(w) {
+			t.Errorf("looksMashed(%q) = true", w)
+		}
+	}
+	for _, w := range []string{"asdfgh", "jklö", "qwertz", "xcvbnm", "bcdfgk", "aaaaah", "hmmm"} {
+		if !looksMashed(w) {
+			t.Errorf("looksMashed(%q) = false", w)
+		}
+	}
+}
+
+func TestScreenQuery(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct{ query, want string }{
+		{"Ölbergstraße Berlin", ""},
+		{"in der Nähe der Hauptstraße", ""},
+		{"Herbstsonne am Gardasee", ""},
+		{"Deutschsprachige Kinderbetreuung", ""},
+		{"Kunstschnee Garantie Zugspitze", ""},
+		{"Familienhotel mit Pool an der Ostsee", ""},
+		{"scheiß teures Hotel in Rom", ""},
+		{"???", screenEmpty},
+		{"🙂🙂", screenEmpty},
+		{"asdfgh jklö", screenGibberish},
+		{"qwertz xcvbnm", screenGibberish},
+		{"du Arschloch", screenProfanity},
+	} {
+		if got := screenQuery(tc.query, now); got != tc.want {
+			t.Errorf("screenQuery(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ====== Run search ======
+// GET /v1/results/search finds stored runs for debugging failure patterns:
+//
+//	q=<text>                 words in the query text (all must match)
+//	filter=<slot>:<value>    a provider's answer has value in slot; repeatable,
+//	                         all filters must hold for the same provider
+//	provider=openai|claude   only runs with an answer from that provider, and
+//	                         only its answers for the filters
+//	limit=<n>                default 50, at most 500
+//
+// Slots are named as in the evaluation ("location", "stars_min",
+// "ui.meals", ...); ui_filters slots may drop the "ui." prefix. Results are
+// newest first. SQLite and Postgres answer from a full-text index on the
+// query and an index of slot values per provider; the file stores scan.
+
+const (
+	searchDefaultLimit = 50
+	searchMaxLimit     = 500
+)
+
+type SearchQuery struct {
+	Text     string
+	Filters  []string // flatten keys, "slot=value"
+	Provider string   // "" = any
+	Limit    int
+	Tenant   string
+}
+
+type SearchResponse struct {
+	Count   int            `json:"count"`
+	Results []StoredResult `json:"results"`
+}
+
+// runSearcher is implemented by backends with a search index
+type runSearcher interface {
+	Search(q SearchQuery) ([]StoredResult, error)
+}
+
+func searchRuns(q SearchQuery) ([]StoredResult, error) {
+	if s, ok := store.(runSearcher); ok {
+		return s.Search(q)
+	}
+	var found []StoredResult
+	err := store.Each(func(run StoredResult) error {
+		if q.matches(run) {
+			found = append(found, run)
+		}
+		return nil
+	})
+	slices.Reverse(found)
+	if len(found) > q.Limit {
+		found = found[:q.Limit]
+	}
+	return found, err
+}
+
+// terms are the lower-cased words of the text query
+func (q SearchQuery) terms() []string {
+	return strings.Fields(strings.ToLower(q.Text))
+}
+
+// matches is the scanning equivalent of the database indexes
+func (q SearchQuery) matches(run StoredResult) bool {
+	if run.Tenant != q.Tenant {
+		return false
+	}
+	query := strings.ToLower(run.Query)
+	for _, t := range q.terms() {
+		if !strings.Contains(query, t) {
+			return false
+		}
+	}
+	if len(q.Filters) == 0 && q.Provider == "" {
+		return true
+	}
+	for p, keys := range slotKeys(run) {
+		if q.Provider != "" && p != q.Provider {
+			continue
+		}
+		if !slices.ContainsFunc(q.Filters, func(f string) bool { return !keys[f] }) {
+			return true
+		}
+	}
+	return false
+}
+
+// slotKeys are the flattened answers of each provider in a run, as indexed
+// by the database stores
+func slotKeys(run StoredResult) map[string]map[string]bool {
+	keys := map[string]map[string]bool{}
+	if run.Response.OpenAI != nil {
+		keys["openai"] = flatten(*run.Response.OpenAI)
+	}
+	if run.Response.Claude != nil {
+		keys["claude"] = flatten(*run.Response.Claude)
+	}
+	return keys
+}
+
+// parseSearch reads the query parameters; errors are client-safe
+func parseSearch(v url.Values) (SearchQuery, error) {
+	q := SearchQuery{Text: strings.TrimSpace(v.Get("q")), Provider: v.Get("provider"), Limit: searchDefaultLimit}
+	switch q.Provider {
+	case "", "openai", "claude":
+	default:
+		return q, fmt.Errorf("unknown provider %q (use openai or claude)", q.Provider)
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+		q.Limit = min(n, searchMaxLimit)
+	}
+	for _, f := range v["filter"] {
+		slot, value, ok := strings.Cut(f, ":")
+		slot, value = strings.TrimSpace(slot), strings.TrimSpace(value)
+		if !ok || slot == "" || value == "" {
+			return q, fmt.Errorf("invalid filter %q (use slot:value)", f)
+		}
+		if slices.Contains(uiFilterSlots, slot) {
+			slot = "ui." + slot
+		}
+		q.Filters = append(q.Filters, slot+"="+value)
+	}
+	slices.Sort(q.Filters)
+	q.Filters = slices.Compact(q.Filters)
+	if q.Text == "" && len(q.Filters) == 0 {
+		return q, errors.New("set q or at least one filter")
+	}
+	return q, nil
+}
+
+func resultSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	q, err := parseSearch(r.URL.Query())
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, err.Error()))
+		return
+	}
+	q.Tenant = tenantOf(r.Context())
+	results, err := searchRuns(q)
+	if err != nil {
+		log.Printf("[ERROR] searching runs: %v", err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "search failed"))
+		return
+	}
+	if results == nil {
+		results = []StoredResult{}
+	}
+	for i := range results {
+		results[i].Raw = nil // served by /v1/results/{id}/raw
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(SearchResponse{Count: len(results), Results: results})
+}
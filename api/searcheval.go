@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ====== Search evaluation ======
+// Slot F1 says whether an answer matches the ground truth, not whether it
+// finds hotels. With SEARCH_API_URL set, every answer of a stored run is
+// also sent to that search API (POST, the v1 answer as JSON) and the
+// result count and top hits are kept in the run under search.<provider>.
+// The API answers like the search simulator (simulator.go), which can
+// stand in for it:
+//
+//	{"count": 12, "hotels": [{"id": "...", "name": "..."}, ...]}
+//
+// count defaults to the number of hotels. The calls run in parallel after
+// the response went out, within SEARCH_API_TIMEOUT (default 3s), and
+// aren't part of the run's latency; the run is stored when they are done,
+// so it shows up in results and evaluations up to that long after the
+// response. Aborted runs aren't searched.
+//
+// Evaluations add task_success per provider: the share of searched
+// answers that found at least one hotel, with the zero-result and failed
+// searches counted apart. Failed searches aren't in the rate.
+
+const (
+	defaultSearchTimeout = 3 * time.Second
+	searchTopHits        = 5
+)
+
+// searchClient has no timeout of its own: searchAnswers' context has it
+var searchClient = &http.Client{}
+
+// SearchOutcome is what the search API returned for one answer
+type SearchOutcome struct {
+	Count     int         `json:"count"`
+	Top       []SearchHit `json:"top,omitempty"` // first hits, at most 5
+	LatencyMS int64       `json:"latency_ms"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type SearchHit struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+func searchEnabled() bool {
+	return os.Getenv("SEARCH_API_URL") != ""
+}
+
+// searchAnswers sends results' answers to the search API; nil when it isn't
+// configured
+func searchAnswers(parent context.Context, results MultiParseResponse) map[string]*SearchOutcome {
+	url := os.Getenv("SEARCH_API_URL")
+	if url == "" {
+		return nil
+	}
+	answers := map[string]*ParseResponse{"openai": results.OpenAI, "claude": results.Claude}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), envDuration("SEARCH_API_TIMEOUT", defaultSearchTimeout))
+	defer cancel()
+
+	out := map[string]*SearchOutcome{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, answer := range answers {
+		if answer == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			o, err := searchAnswer(ctx, url, answer)
+			if err != nil {
+				o = &SearchOutcome{Error: err.Error()}
+			}
+			o.LatencyMS = time.Since(start).Milliseconds()
+			mu.Lock()
+			out[name] = o
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+func searchAnswer(ctx context.Context, url string, answer *ParseResponse) (*SearchOutcome, error) {
+	body, err := json.Marshal(answer)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := searchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		return nil, fmt.Errorf("search API answered %s", resp.Status)
+	}
+	var found struct {
+		Count  *int        `json:"count"`
+		Hotels []SearchHit `json:"hotels"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(&found); err != nil {
+		return nil, fmt.Errorf("search API response: %w", err)
+	}
+	o := &SearchOutcome{Count: len(found.Hotels), Top: found.Hotels}
+	if found.Count != nil {
+		o.Count = *found.Count
+	}
+	if len(o.Top) > searchTopHits {
+		o.Top = o.Top[:searchTopHits]
+	}
+	return o, nil
+}
+
+// TaskSuccessMetrics sum up the searches of a provider's answers
+type TaskSuccessMetrics struct {
+	Rate        float64 `json:"rate"` // answers with results / searched without error
+	Searched    int     `json:"searched"`
+	ZeroResults int     `json:"zero_results"`
+	Errors      int     `json:"errors"`
+	AvgResults  float64 `json:"avg_results"` // over successful searches
+}
+
+type searchAcc struct {
+	searched, found, zero, errors, results float64
+}
+
+func (s *searchAcc) add(o *SearchOutcome, w float64) {
+	if o == nil {
+		return
+	}
+	s.searched += w
+	switch {
+	case o.Error != "":
+		s.errors += w
+	case o.Count == 0:
+		s.zero += w
+	default:
+		s.found += w
+		s.results += w * float64(o.Count)
+	}
+}
+
+func (s *searchAcc) metrics() *TaskSuccessMetrics {
+	if s.searched == 0 {
+		return nil
+	}
+	return &TaskSuccessMetrics{
+		Rate:        round2(fdiv(s.found, s.searched-s.errors)),
+		Searched:    int(s.searched + 0.5),
+		ZeroResults: int(s.zero + 0.5),
+		Errors:      int(s.errors + 0.5),
+		AvgResults:  round2(fdiv(s.results, s.found)),
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ====== HTTP server ======
+// Plain HTTP by default. TLS_CERT_FILE/TLS_KEY_FILE serve HTTPS with a given
+// certificate; TLS_AUTOCERT_DOMAINS gets certificates from Let's Encrypt
+// (HTTP-01 challenges on TLS_HTTP_ADDR, default :80). HTTPS connections
+// negotiate HTTP/2 automatically.
+
+func serve(addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	domains := os.Getenv("TLS_AUTOCERT_DOMAINS")
+	switch {
+	case domains != "":
+		var hosts []string
+		for _, d := range strings.Split(domains, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				hosts = append(hosts, d)
+			}
+		}
+		cacheDir := os.Getenv("TLS_AUTOCERT_CACHE")
+		if cacheDir == "" {
+			cacheDir = dataPath("data/autocert")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      os.Getenv("TLS_AUTOCERT_EMAIL"),
+		}
+		srv.TLSConfig = m.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+
+		httpAddr := os.Getenv("TLS_HTTP_ADDR")
+		if httpAddr == "" {
+			httpAddr = ":80"
+		}
+		go func() {
+			// ACME challenges; everything else is redirected to HTTPS
+			log.Printf("[INFO] ACME challenge listener on %s", httpAddr)
+			if err := http.ListenAndServe(httpAddr, m.HTTPHandler(nil)); err != nil {
+				log.Printf("[ERROR] ACME challenge listener: %v", err)
+			}
+		}()
+		log.Printf("Server on %s (HTTPS, autocert for %s)", addr, strings.Join(hosts, ", "))
+		return srv.ListenAndServeTLS("", "")
+
+	case certFile != "" || keyFile != "":
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		log.Printf("Server on %s (HTTPS)", addr)
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	}
+
+	log.Println("Server on " + addr)
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ====== Shadow mode ======
+// A shadow run sends a sample of live queries to a secondary
+// provider/model/prompt. Its output is stored and evaluated, but never
+// returned to the client.
+
+type ShadowConfig struct {
+	Provider   string  // "openai" or "claude"
+	Model      string  // optional model override
+	PromptPath string  // optional system prompt override
+	SampleRate float64 // 0..1
+}
+
+// ShadowInfo is stored with every shadow run
+type ShadowInfo struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model,omitempty"`
+	Prompt   string `json:"prompt,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Usage    *Usage `json:"usage,omitempty"`
+}
+
+// loadShadowConfig reads SHADOW_* env vars; nil means shadow mode is off
+func loadShadowConfig() *ShadowConfig {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("SHADOW_PROVIDER")))
+	if provider == "" {
+		return nil
+	}
+	rate := 1.0
+	if v := os.Getenv("SHADOW_SAMPLE_RATE"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			log.Printf("[WARN] invalid SHADOW_SAMPLE_RATE %q, shadow mode disabled", v)
+			return nil
+		}
+		rate = f
+	}
+	return &ShadowConfig{
+		Provider:   provider,
+		Model:      os.Getenv("SHADOW_MODEL"),
+		PromptPath: os.Getenv("SHADOW_PROMPT"),
+		SampleRate: rate,
+	}
+}
+
+func (s *ShadowConfig) sampled() bool {
+	return s.SampleRate > 0 && rand.Float64() < s.SampleRate
+}
+
+// runShadow is meant to be started in its own goroutine. It uses a detached
+// context so the live request finishing doesn't cancel the shadow call.
+func runShadow(cfg ShadowConfig, livePrompt, query, tenant string) {
+	systemPrompt := livePrompt
+	if cfg.PromptPath != "" {
+		systemPrompt = systemPromptFor(cfg.PromptPath).Text
+	}
+	info := &ShadowInfo{Provider: cfg.Provider, Model: cfg.Model, Prompt: cfg.PromptPath}
+
+	cli, err := newClientFor(cfg.Provider, cfg.Model)
+	if err != nil {
+		log.Printf("[ERROR] shadow client error: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(withLane(context.Background(), laneBatch), requestBudget())
+	defer cancel()
+
+	// Shadow calls share the live concurrency limits in the batch lane and
+	// are dropped, not stored, when no slot frees up within the budget
+	release, err := acquireSlot(ctx, cfg.Provider)
+	if err != nil {
+		log.Printf("[WARN] shadow call skipped: %v", err)
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	res, out, err := parseWith(ctx, cli, "shadow "+cfg.Provider, systemPrompt, query)
+	if out.Usage != (Usage{}) {
+		info.Usage = &out.Usage
+	}
+	resp := MultiParseResponse{}
+	if err != nil {
+		info.Error = err.Error()
+	} else if cfg.Provider == "claude" {
+		resp.Claude = res
+	} else {
+		resp.OpenAI = res
+	}
+	StoreResult(StoredResult{
+		Query:    query,
+		Response: resp,
+		Latency:  time.Since(start).Milliseconds(),
+		Time:     time.Now(),
+		Shadow:   info,
+		Tenant:   tenant,
+	})
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ====== Search simulator ======
+// For end-to-end demos without the production search backend, a small
+// hotel dataset (SIMULATOR_HOTELS_FILE, JSON or CSV; hotels.sample.json is
+// a start) can be searched with a parse answer: POST /v1/simulator/search
+// with a ParseResponse (v1, e.g. the "openai" object of a /v1/parse
+// response) returns the hotels it matches. ?limit= caps the list (default
+// 50). The answer is applied as filters:
+//
+//	location                 the hotel's location or country (any case)
+//	price_max_eur            price_eur per night at most
+//	stars_min/max            stars within
+//	rating_min/max           rating within
+//	family_friendly          true: only family-friendly hotels
+//	ui_filters               every value in the hotel's slot
+//	excluded_filters         none of the values in the hotel's slot
+//	room_types, room_views,  every value offered
+//	accessibility
+//	distances                distances_m to the reference at most the
+//	                         answer's meters
+//
+// preferred_filters don't filter; hotels are ranked by how many of them
+// they have, then by rating and price. Dates, guests, transport and the
+// other slots need availability data and are listed as ignored. The file
+// is reloaded like the prompts.
+//
+// In CSV, the columns are the JSON field names; list columns (ui_filters
+// slots by name, room_types, room_views, accessibility) separate values
+// with "|", and distance_<reference> columns hold meters.
+
+// Hotel is one entry of the simulator's dataset
+type Hotel struct {
+	ID             string         `json:"id"`
+	Name           string         `json:"name"`
+	Location       string         `json:"location"`
+	Country        string         `json:"country,omitempty"`
+	Stars          int            `json:"stars"`
+	Rating         float64        `json:"rating"`    // 0..10
+	PriceEUR       float64        `json:"price_eur"` // per night
+	FamilyFriendly bool           `json:"family_friendly"`
+	UiFilters      UiFilters      `json:"ui_filters"`
+	RoomTypes      []string       `json:"room_types"`
+	RoomViews      []string       `json:"room_views"`
+	Accessibility  []string       `json:"accessibility"`
+	Distances      map[string]int `json:"distances_m"` // by distance_reference
+}
+
+// SimulatedHotel is a matching hotel
+type SimulatedHotel struct {
+	Hotel
+	// PreferredMatches counts the preferred_filters values it has
+	PreferredMatches int `json:"preferred_matches"`
+}
+
+type SimulatorResponse struct {
+	Count   int              `json:"count"` // matches before the limit
+	Hotels  []SimulatedHotel `json:"hotels"`
+	Applied []string         `json:"applied"` // filters used, as flattened keys
+	Ignored []string         `json:"ignored"` // set slots the simulator can't apply
+}
+
+var hotelsMemo struct {
+	sync.Mutex
+	hash   string
+	hotels []Hotel
+	err    error
+}
+
+// simulatorHotels is the dataset; nil without SIMULATOR_HOTELS_FILE
+func simulatorHotels() ([]Hotel, error) {
+	path := os.Getenv("SIMULATOR_HOTELS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	p := cachedPrompt(path, func() string {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[ERROR] simulator hotels: %v", err)
+			return ""
+		}
+		return string(b)
+	})
+	hotelsMemo.Lock()
+	defer hotelsMemo.Unlock()
+	if hotelsMemo.hash != p.Hash {
+		var hotels []Hotel
+		err := errors.New("could not read " + path)
+		if p.Text != "" {
+			if strings.EqualFold(filepath.Ext(path), ".csv") {
+				hotels, err = readHotelsCSV(strings.NewReader(p.Text))
+			} else {
+				err = json.Unmarshal([]byte(p.Text), &hotels)
+			}
+		}
+		if err != nil {
+			log.Printf("[ERROR] invalid simulator hotels %s: %v", path, err)
+		} else {
+			log.Printf("[INFO] simulator: %d hotels from %s", len(hotels), path)
+		}
+		hotelsMemo.hash, hotelsMemo.hotels, hotelsMemo.err = p.Hash, hotels, err
+	}
+	return hotelsMemo.hotels, hotelsMemo.err
+}
+
+// readHotelsCSV reads the CSV form of the dataset
+func readHotelsCSV(r io.Reader) ([]Hotel, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no header row")
+	}
+	header := rows[0]
+	var hotels []Hotel
+	for n, row := range rows[1:] {
+		h := Hotel{Distances: map[string]int{}}
+		for i, col := range header {
+			if err := h.setColumn(strings.TrimSpace(col), strings.TrimSpace(row[i])); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+2, err)
+			}
+		}
+		hotels = append(hotels, h)
+	}
+	return hotels, nil
+}
+
+func (h *Hotel) setColumn(col, v string) error {
+	list := func() []string {
+		var out []string
+		for _, s := range strings.Split(v, "|") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	var err error
+	switch col {
+	case "id":
+		h.ID = v
+	case "name":
+		h.Name = v
+	case "location":
+		h.Location = v
+	case "country":
+		h.Country = v
+	case "stars":
+		h.Stars, err = strconv.Atoi(v)
+	case "rating":
+		h.Rating, err = strconv.ParseFloat(v, 64)
+	case "price_eur":
+		h.PriceEUR, err = strconv.ParseFloat(v, 64)
+	case "family_friendly":
+		h.FamilyFriendly, err = strconv.ParseBool(v)
+	case "room_types":
+		h.RoomTypes = list()
+	case "room_views":
+		h.RoomViews = list()
+	case "accessibility":
+		h.Accessibility = list()
+	default:
+		if ref, ok := strings.CutPrefix(col, "distance_"); ok {
+			if v != "" {
+				h.Distances[ref], err = strconv.Atoi(v)
+			}
+		} else if slot := h.UiFilters.Slot(col); slot != nil {
+			*slot = list()
+		} else {
+			return fmt.Errorf("unknown column %q", col)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", col, err)
+	}
+	return nil
+}
+
+// simulate returns the hotels matching answer, best first
+func simulate(hotels []Hotel, answer ParseResponse, limit int) SimulatorResponse {
+	resp := SimulatorResponse{Hotels: []SimulatedHotel{}, Applied: []string{}, Ignored: []string{}}
+	ignored := ParseResponse{
+		DepartureAirports: answer.DepartureAirports, Dates: answer.Dates, DateFlexibilityDays: answer.DateFlexibilityDays,
+		Nights: answer.Nights, Transport: answer.Transport, Guests: answer.Guests, GroupSize: answer.GroupSize,
+		TripPurpose: answer.TripPurpose, UnsupportedCriteria: answer.UnsupportedCriteria,
+	}
+	for k := range flatten(ignored) {
+		resp.Ignored = append(resp.Ignored, k)
+	}
+	applied := answer
+	applied.DepartureAirports, applied.Dates, applied.DateFlexibilityDays = nil, Dates{}, 0
+	applied.Nights, applied.Transport, applied.Guests, applied.GroupSize = 0, "", Guests{}, 0
+	applied.TripPurpose, applied.UnsupportedCriteria, applied.PreferredFilters = "", nil, UiFilters{}
+	for k := range flatten(applied) {
+		resp.Applied = append(resp.Applied, k)
+	}
+	slices.Sort(resp.Applied)
+	slices.Sort(resp.Ignored)
+
+	for _, h := range hotels {
+		if !hotelMatches(h, answer) {
+			continue
+		}
+		m := SimulatedHotel{Hotel: h}
+		for _, slot := range uiFilterSlots {
+			for _, v := range *answer.PreferredFilters.Slot(slot) {
+				if slices.Contains(*h.UiFilters.Slot(slot), v) {
+					m.PreferredMatches++
+				}
+			}
+		}
+		resp.Hotels = append(resp.Hotels, m)
+	}
+	slices.SortStableFunc(resp.Hotels, func(a, b SimulatedHotel) int {
+		switch {
+		case a.PreferredMatches != b.PreferredMatches:
+			return b.PreferredMatches - a.PreferredMatches
+		case a.Rating != b.Rating:
+			return cmpFloat(b.Rating, a.Rating)
+		}
+		return cmpFloat(a.PriceEUR, b.PriceEUR)
+	})
+	resp.Count = len(resp.Hotels)
+	if len(resp.Hotels) > limit {
+		resp.Hotels = resp.Hotels[:limit]
+	}
+	return resp
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// hotelMatches applies the filters of answer to h
+func hotelMatches(h Hotel, a ParseResponse) bool {
+	if a.Location != "" && !strings.EqualFold(a.Location, h.Location) && !strings.EqualFold(a.Location, h.Country) {
+		return false
+	}
+	if a.PriceMaxEUR > 0 && h.PriceEUR > a.PriceMaxEUR ||
+		a.StarsMin > 0 && h.Stars < a.StarsMin || a.StarsMax > 0 && h.Stars > a.StarsMax ||
+		a.RatingMin > 0 && h.Rating < a.RatingMin || a.RatingMax > 0 && h.Rating > a.RatingMax ||
+		a.FamilyFriendly != nil && *a.FamilyFriendly && !h.FamilyFriendly {
+		return false
+	}
+	hasAll := func(offered, wanted []string) bool {
+		for _, v := range wanted {
+			if !slices.Contains(offered, v) {
+				return false
+			}
+		}
+		return true
+	}
+	if !hasAll(h.RoomTypes, a.RoomTypes) || !hasAll(h.RoomViews, a.RoomViews) || !hasAll(h.Accessibility, a.Accessibility) {
+		return false
+	}
+	for _, slot := range uiFilterSlots {
+		offered := *h.UiFilters.Slot(slot)
+		if !hasAll(offered, *a.UiFilters.Slot(slot)) {
+			return false
+		}
+		for _, v := range *a.ExcludedFilters.Slot(slot) {
+			if slices.Contains(offered, v) {
+				return false
+			}
+		}
+	}
+	for _, d := range a.Distances {
+		meters, ok := h.Distances[d.Reference]
+		if d.Meters > 0 && (!ok || meters > d.Meters) {
+			return false
+		}
+	}
+	return true
+}
+
+func simulatorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use POST"))
+		return
+	}
+	hotels, err := simulatorHotels()
+	switch {
+	case err != nil:
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "the simulator's hotel file is invalid"))
+		return
+	case hotels == nil:
+		writeProblem(w, newProblem(http.StatusNotFound, codeNotFound, "the search simulator is off (set SIMULATOR_HOTELS_FILE)"))
+		return
+	}
+	limit := searchDefaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, fmt.Sprintf("invalid limit %q", s)))
+			return
+		}
+		limit = min(n, searchMaxLimit)
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes()))
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusRequestEntityTooLarge, codeInvalidInput, "body too large"))
+		return
+	}
+	var answer ParseResponse
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&answer); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "body must be a parse answer (schema 1): "+err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(simulate(hotels, answer, limit))
+}
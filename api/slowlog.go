@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Slow requests ======
+// A parse request that takes longer than SLOW_REQUEST_THRESHOLD (default
+// 10s) is stored with slow set and logged with each provider's latency and
+// stage timings. Outliers are usually a provider hiccup, and the log line
+// shows which one. GET /v1/results?slow=1 lists the slow runs, newest
+// first; hotelparser_slow_requests_total (prometheus.go) counts them per
+// slowest provider.
+
+const defaultSlowThreshold = 10 * time.Second
+
+func slowThreshold() time.Duration {
+	return envDuration("SLOW_REQUEST_THRESHOLD", defaultSlowThreshold)
+}
+
+var slowRequests = struct {
+	sync.Mutex
+	byProvider map[string]int64
+}{byProvider: map[string]int64{}}
+
+// slowestProvider is the provider that took longest in results, "none" if
+// no provider was called
+func slowestProvider(results MultiParseResponse) string {
+	name, slowest := "none", int64(-1)
+	for provider, st := range results.Status {
+		if st.LatencyMS > slowest {
+			name, slowest = provider, st.LatencyMS
+		}
+	}
+	return name
+}
+
+// flagSlow marks run slow if it took longer than the threshold, and logs
+// and counts it
+func flagSlow(run *StoredResult) {
+	threshold := slowThreshold()
+	if time.Duration(run.Latency)*time.Millisecond <= threshold {
+		return
+	}
+	run.Slow = true
+	provider := slowestProvider(run.Response)
+	slowRequests.Lock()
+	slowRequests.byProvider[provider]++
+	slowRequests.Unlock()
+
+	var parts []string
+	for name, st := range run.Response.Status {
+		b, _ := json.Marshal(st.Timings)
+		parts = append(parts, fmt.Sprintf("%s %s %dms %s", name, st.Status, st.LatencyMS, b))
+	}
+	slices.Sort(parts)
+	log.Printf("[WARN] slow request %s: %dms (threshold %s), slowest %s; %s", run.ID, run.Latency, threshold, provider, strings.Join(parts, "; "))
+}
+
+// resultsHandler lists the tenant's runs, newest first: ?slow=1 only the
+// slow ones, ?limit=N (default 50)
+func resultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	v := r.URL.Query()
+	limit := searchDefaultLimit
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, fmt.Sprintf("invalid limit %q", s)))
+			return
+		}
+		limit = min(n, searchMaxLimit)
+	}
+	found, err := listRuns(tenantOf(r.Context()), v.Get("slow") == "1", limit)
+	if err != nil {
+		log.Printf("[ERROR] listing runs: %v", err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "could not read results"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(SearchResponse{Count: len(found), Results: found})
+}
+
+// listRuns returns up to limit of the tenant's runs, newest first, without
+// their raw calls
+func listRuns(tenant string, slowOnly bool, limit int) ([]StoredResult, error) {
+	found := []StoredResult{}
+	err := store.Each(func(run StoredResult) error {
+		if run.Tenant == tenant && (!slowOnly || run.Slow) {
+			run.Raw = nil // served by /v1/results/{id}/raw
+			found = append(found, run)
+		}
+		return nil
+	})
+	slices.Reverse(found)
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, err
+}
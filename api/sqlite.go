@@ -0,0 +1,378 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// ====== SQLite result store ======
+// Default backend for single-node deployments. Each run is one row in
+// runs (full record as JSON plus indexed columns) and one row per provider
+// output in run_providers, written in a single transaction.
+
+const sqliteFile = "data/parser.db"
+
+// sqliteMigrations are applied in order; never edit an entry once released,
+// append a new one instead.
+var sqliteMigrations = []string{
+	// 1: runs + per-provider index table
+	`CREATE TABLE runs (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		query      TEXT    NOT NULL,
+		query_hash TEXT    NOT NULL,
+		time_ns    INTEGER NOT NULL,
+		latency_ms INTEGER NOT NULL,
+		variant    TEXT    NOT NULL DEFAULT '',
+		shadow     INTEGER NOT NULL DEFAULT 0,
+		data       TEXT    NOT NULL
+	);
+	CREATE INDEX idx_runs_query_hash ON runs(query_hash);
+	CREATE INDEX idx_runs_time ON runs(time_ns);
+	CREATE TABLE run_providers (
+		run_id   INTEGER NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+		provider TEXT    NOT NULL,
+		PRIMARY KEY (run_id, provider)
+	);
+	CREATE INDEX idx_run_providers_provider ON run_providers(provider, run_id);`,
+	// 2: look runs up by their public run ID
+	`ALTER TABLE runs ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+	UPDATE runs SET uid = COALESCE(json_extract(data, '$.id'), '');
+	CREATE INDEX idx_runs_uid ON runs(uid);`,
+	// 3: search: full-text index on the query, slot values per provider
+	`CREATE VIRTUAL TABLE runs_fts USING fts5(query, content='runs', content_rowid='id', tokenize='unicode61 remove_diacritics 2');
+	INSERT INTO runs_fts(runs_fts) VALUES ('rebuild');
+	CREATE TRIGGER runs_fts_insert AFTER INSERT ON runs BEGIN
+		INSERT INTO runs_fts(rowid, query) VALUES (new.id, new.query);
+	END;
+	CREATE TRIGGER runs_fts_delete AFTER DELETE ON runs BEGIN
+		INSERT INTO runs_fts(runs_fts, rowid, query) VALUES ('delete', old.id, old.query);
+	END;
+	CREATE TABLE run_slots (
+		run_id   INTEGER NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+		provider TEXT    NOT NULL,
+		key      TEXT    NOT NULL,
+		PRIMARY KEY (run_id, provider, key)
+	);
+	CREATE INDEX idx_run_slots_key ON run_slots(key, run_id);`,
+	// 4: tenants
+	`ALTER TABLE runs ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+	CREATE INDEX idx_runs_tenant ON runs(tenant, id);`,
+	// 5: runs from before family_friendly could be null are read with
+	// false as null (see dataSchema); their index entries go too
+	`DELETE FROM run_slots WHERE key = 'family_friendly=false'
+		AND run_id IN (SELECT id FROM runs WHERE json_extract(data, '$.schema') IS NULL);`,
+}
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	dsn := "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_pragma=synchronous(NORMAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; a single connection avoids SQLITE_BUSY churn
+	db.SetMaxOpenConns(1)
+	s := &sqliteStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite migrate: %w", err)
+	}
+	if err := s.indexSlots(); err != nil {
+		log.Printf("[WARN] sqlite: indexing slot values: %v", err)
+	}
+	return s, nil
+}
+
+func (s *sqliteStore) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for i := current; i < len(sqliteMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, i+1, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("[INFO] sqlite: applied migration %d", i+1)
+	}
+	return nil
+}
+
+func (s *sqliteStore) Append(run StoredResult) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO runs (uid, tenant, query, query_hash, time_ns, latency_ms, variant, shadow, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.Tenant, run.Query, queryHash(run.Query), run.Time.UnixNano(), run.Latency, run.Variant, run.Shadow != nil, string(data))
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, p := range runProviders(run) {
+		if _, err := tx.Exec(`INSERT INTO run_providers (run_id, provider) VALUES (?, ?)`, id, p); err != nil {
+			return err
+		}
+	}
+	if err := insertSlots(tx, id, run); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertSlots(tx *sql.Tx, id int64, run StoredResult) error {
+	for p, keys := range slotKeys(run) {
+		for k := range keys {
+			if _, err := tx.Exec(`INSERT INTO run_slots (run_id, provider, key) VALUES (?, ?, ?)`, id, p, k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexSlots fills run_slots for runs stored before migration 3
+func (s *sqliteStore) indexSlots() error {
+	rows, err := s.db.Query(`SELECT id, data FROM runs r
+		WHERE EXISTS (SELECT 1 FROM run_providers p WHERE p.run_id = r.id)
+		AND NOT EXISTS (SELECT 1 FROM run_slots k WHERE k.run_id = r.id)`)
+	if err != nil {
+		return err
+	}
+	pending := map[int64]StoredResult{}
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		var run StoredResult
+		if json.Unmarshal([]byte(data), &run) == nil {
+			pending[id] = run
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(pending) == 0 {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, run := range pending {
+		if err := insertSlots(tx, id, run); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[INFO] sqlite: indexed slot values of %d runs", len(pending))
+	return nil
+}
+
+// sqliteEachBatch is how many runs Each reads before handing them out
+const sqliteEachBatch = 500
+
+// Each reads the runs in batches by id and calls fn with the cursor
+// closed, so a slow callback (an export writing to a slow client) doesn't
+// hold the only connection and block Append
+func (s *sqliteStore) Each(fn func(StoredResult) error) error {
+	var last int64
+	for {
+		runs, next, n, err := s.batchAfter(last)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			if err := fn(run); err != nil {
+				return err
+			}
+		}
+		if n < sqliteEachBatch {
+			return nil
+		}
+		last = next
+	}
+}
+
+// batchAfter reads up to sqliteEachBatch rows with ids after id; it returns
+// the readable runs, the last id and the number of rows read
+func (s *sqliteStore) batchAfter(id int64) (runs []StoredResult, last int64, n int, err error) {
+	rows, err := s.db.Query(`SELECT id, data FROM runs WHERE id > ? ORDER BY id LIMIT ?`, id, sqliteEachBatch)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&last, &data); err != nil {
+			return nil, 0, 0, err
+		}
+		n++
+		var run StoredResult
+		if err := json.Unmarshal([]byte(data), &run); err != nil {
+			log.Printf("[WARN] sqlite: skipping unreadable run %d: %v", last, err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs, last, n, rows.Err()
+}
+
+func (s *sqliteStore) All() ([]StoredResult, error) {
+	var results []StoredResult
+	err := s.Each(func(run StoredResult) error {
+		results = append(results, run)
+		return nil
+	})
+	return results, err
+}
+
+func (s *sqliteStore) Run(id string) (*StoredResult, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM runs WHERE uid = ? ORDER BY id DESC LIMIT 1`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var run StoredResult
+	if err := json.Unmarshal([]byte(data), &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (s *sqliteStore) Search(q SearchQuery) ([]StoredResult, error) {
+	where, args := []string{`r.tenant = ?`}, []any{q.Tenant}
+	if terms := q.terms(); len(terms) > 0 {
+		// each word quoted (no FTS syntax from users), matched as a prefix
+		for i, t := range terms {
+			terms[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"*`
+		}
+		where = append(where, `r.id IN (SELECT rowid FROM runs_fts WHERE runs_fts MATCH ?)`)
+		args = append(args, strings.Join(terms, " "))
+	}
+	if len(q.Filters) > 0 {
+		cond := `key IN (?` + strings.Repeat(", ?", len(q.Filters)-1) + `)`
+		for _, f := range q.Filters {
+			args = append(args, f)
+		}
+		if q.Provider != "" {
+			cond += ` AND provider = ?`
+			args = append(args, q.Provider)
+		}
+		where = append(where, `r.id IN (SELECT run_id FROM run_slots WHERE `+cond+`
+			GROUP BY run_id, provider HAVING COUNT(*) = ?)`)
+		args = append(args, len(q.Filters))
+	} else if q.Provider != "" {
+		where = append(where, `r.id IN (SELECT run_id FROM run_providers WHERE provider = ?)`)
+		args = append(args, q.Provider)
+	}
+	args = append(args, q.Limit)
+
+	rows, err := s.db.Query(`SELECT r.data FROM runs r WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY r.id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []StoredResult
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var run StoredResult
+		if err := json.Unmarshal([]byte(data), &run); err != nil {
+			continue
+		}
+		results = append(results, run)
+	}
+	return results, rows.Err()
+}
+
+func (s *sqliteStore) count() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM runs`).Scan(&n)
+	return n, err
+}
+
+// importFrom copies runs from a file-based store into an empty database
+func (s *sqliteStore) importFrom(src ResultStore) {
+	if n, err := s.count(); err != nil || n > 0 {
+		return
+	}
+	imported := 0
+	err := src.Each(func(run StoredResult) error {
+		if err := s.Append(run); err != nil {
+			return err
+		}
+		imported++
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] sqlite: import stopped after %d runs: %v", imported, err)
+	}
+	if imported > 0 {
+		log.Printf("[INFO] sqlite: imported %d runs from file storage", imported)
+	}
+}
+
+// runProviders lists which providers produced output in a run
+func runProviders(run StoredResult) []string {
+	var ps []string
+	if run.Response.OpenAI != nil {
+		ps = append(ps, "openai")
+	}
+	if run.Response.Claude != nil {
+		ps = append(ps, "claude")
+	}
+	return ps
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Each must not hold the connection while fn runs: an Append from inside
+// the callback (a stalled export next to a parse) would block forever
+func TestSQLiteEachReleasesConnection(t *testing.T) {
+	s, err := openSQLiteStore(filepath.Join(t.TempDir(), "runs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+	n := 2*sqliteEachBatch + 1
+	for i := range n {
+		if err := s.Append(StoredResult{ID: fmt.Sprintf("run-%d", i), Query: "Rom"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := 0
+	err = s.Each(func(run StoredResult) error {
+		if seen++; seen == 1 {
+			return s.Append(StoredResult{ID: "during-scan", Query: "Paris"})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != n+1 {
+		t.Errorf("Each saw %d runs, want %d", seen, n+1)
+	}
+}
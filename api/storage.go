@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ====== Result storage ======
+
+// ResultStore persists parse runs for evaluation
+type ResultStore interface {
+	Append(run StoredResult) error
+	// Each streams runs in insertion order; returning an error stops it
+	Each(fn func(StoredResult) error) error
+	All() ([]StoredResult, error)
+}
+
+// incrementalStore is implemented by shared backends where other replicas
+// append runs too; the eval cache pulls new rows by id instead of relying
+// on local appends.
+type incrementalStore interface {
+	EachSince(afterID int64, fn func(id int64, run StoredResult) error) error
+}
+
+// runLookup is implemented by backends that index runs by run ID; the
+// others are scanned
+type runLookup interface {
+	Run(id string) (*StoredResult, error)
+}
+
+// findRun returns the run with the given ID, or nil
+func findRun(id string) (*StoredResult, error) {
+	if l, ok := store.(runLookup); ok {
+		return l.Run(id)
+	}
+	var found *StoredResult
+	err := store.Each(func(run StoredResult) error {
+		if run.ID == id {
+			found = &run
+		}
+		return nil
+	})
+	return found, err
+}
+
+// tenantRuns lists the runs of one tenant
+func tenantRuns(tenant string) ([]StoredResult, error) {
+	var runs []StoredResult
+	err := store.Each(func(run StoredResult) error {
+		if run.Tenant == tenant {
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// PromptStore serves prompt files from shared storage, keyed by their
+// relative path (e.g. "prompt/system.txt")
+type PromptStore interface {
+	Prompt(name string) ([]byte, error)
+}
+
+// store is set up in main() once the environment is loaded
+var store ResultStore
+
+// prompts is nil unless the backend shares prompts (Postgres)
+var prompts PromptStore
+
+// promptPublisher is a PromptStore that takes the local prompt files
+type promptPublisher interface {
+	publishPrompts(dir string)
+}
+
+// readPrompt reads a prompt file from shared storage or disk
+func readPrompt(path string) ([]byte, error) {
+	if prompts != nil {
+		if b, err := prompts.Prompt(filepath.ToSlash(path)); err == nil {
+			return b, nil
+		}
+	}
+	return readPromptFile(path)
+}
+
+// newResultStore picks the backend from STORAGE_BACKEND: "sqlite"
+// (default), "postgres" (shared by several replicas, also holds ground
+// truth and prompts), "jsonl" (rotated append-only log) or "json" (legacy
+// single pretty-printed array). A fresh database imports the runs of the
+// file backends once. Any other value stops startup rather than silently
+// storing runs somewhere else.
+func newResultStore() ResultStore {
+	switch backend := strings.ToLower(os.Getenv("STORAGE_BACKEND")); backend {
+	case "json":
+		return &jsonArrayStore{path: dataPath(resultsFile)}
+	case "jsonl":
+		l := newResultLog(dataPath(resultsLogFile))
+		startArchiver(l)
+		return l
+	case "postgres":
+		s, err := openPostgresStore(os.Getenv("DATABASE_URL"))
+		if err != nil {
+			log.Fatalf("[FATAL] connecting to postgres: %v", err)
+		}
+		s.importGroundTruth(groundTruth)
+		s.publishPrompts(promptPath("prompt"))
+		return s
+	case "", "sqlite":
+	default:
+		log.Fatalf("[FATAL] STORAGE_BACKEND: unknown backend %q (use sqlite, postgres, jsonl or json)", backend)
+	}
+	path := dataPath(sqliteFile)
+	if p := os.Getenv("SQLITE_PATH"); p != "" {
+		path = p
+	}
+	s, err := openSQLiteStore(path)
+	if err != nil {
+		log.Fatalf("[FATAL] opening %s: %v", path, err)
+	}
+	s.importFrom(newResultLog(dataPath(resultsLogFile)))
+	return s
+}
+
+// queryHash is the indexed lookup key for a query text
+func queryHash(q string) string {
+	sum := sha256.Sum256([]byte(q))
+	return hex.EncodeToString(sum[:16])
+}
+
+// jsonArrayStore keeps all runs in one JSON array. Every append rewrites the
+// file, so writes are serialized and replaced atomically.
+type jsonArrayStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (s *jsonArrayStore) Append(run StoredResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results, err := s.read()
+	if err != nil {
+		return err
+	}
+	results = append(results, run)
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b, 0644)
+}
+
+func (s *jsonArrayStore) Each(fn func(StoredResult) error) error {
+	results, err := s.All()
+	if err != nil {
+		return err
+	}
+	for _, run := range results {
+		if err := fn(run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonArrayStore) All() ([]StoredResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+func (s *jsonArrayStore) read() ([]StoredResult, error) {
+	var results []StoredResult
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// writeFileAtomic writes to a temp file in the same directory, fsyncs it and
+// renames it over path, so readers never see a half-written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====== Stub provider ======
+// A scripted stand-in for the OpenAI and Claude APIs, for integration tests
+// of retries, breakers, the rules fallback and timeouts. It speaks both
+// wire formats, so the real clients run unchanged:
+//
+//	hotelparser stub -addr 127.0.0.1:9911 -script stub.json
+//	OPENAI_BASE_URL=http://127.0.0.1:9911/v1 CLAUDE_BASE_URL=http://127.0.0.1:9911/v1/messages
+//
+// or in-process with STUB_SCRIPT=stub.json, which points both providers at
+// it (never in production). The script is a list of rules; the first rule
+// matching a call decides what happens, calls no rule matches are answered
+// with the script's answer:
+//
+//	{"seed": 1, "answer": {"location": "Berlin", ...},
+//	 "rules": [
+//	   {"provider": "claude", "from": 3, "to": 8, "behavior": "503"},
+//	   {"provider": "openai", "every": 4, "behavior": "429", "retry_after": 2},
+//	   {"match": "Rom", "behavior": "truncate", "max_tokens": 2048},
+//	   {"rate": 0.1, "behavior": "malformed"},
+//	   {"behavior": "ok", "delay": "300ms"}]}
+//
+// A rule matches by provider, by a substring of the query (match), by the
+// provider's call number (from/to, 1-based, for partial outages; every, for
+// every n-th call), by the request's token limit (max_tokens: at most that,
+// so the retry with a larger limit gets through) and by chance (rate, drawn
+// from a generator seeded with seed, so a sequential run is reproducible).
+// Behaviors:
+//
+//	ok         the answer (rule "answer" replaces the script's)
+//	malformed  JSON with a syntax error
+//	truncate   the answer cut in half, stopped at the token limit
+//	empty      no choices / no content
+//	timeout    no answer until the client gives up
+//	429, 500, 503, ...  that status, with Retry-After for 429 and 503
+//
+// delay is added before any behavior. GET /stub/calls shows the calls per
+// provider and behavior; POST /stub/reset starts the counters and the
+// generator over.
+
+// StubScript is a stub provider's script
+type StubScript struct {
+	Seed   int64           `json:"seed"`
+	Answer json.RawMessage `json:"answer,omitempty"` // default: stubAnswer
+	Rules  []StubRule      `json:"rules"`
+}
+
+type StubRule struct {
+	Provider   string          `json:"provider,omitempty"` // "openai", "claude" or both
+	Match      string          `json:"match,omitempty"`
+	From       int             `json:"from,omitempty"`
+	To         int             `json:"to,omitempty"`
+	Every      int             `json:"every,omitempty"`
+	MaxTokens  int             `json:"max_tokens,omitempty"`
+	Rate       float64         `json:"rate,omitempty"`
+	Behavior   string          `json:"behavior"`
+	Delay      string          `json:"delay,omitempty"`
+	RetryAfter int             `json:"retry_after,omitempty"` // seconds, default 1
+	Answer     json.RawMessage `json:"answer,omitempty"`
+	delay      time.Duration
+}
+
+const (
+	stubOK        = "ok"
+	stubMalformed = "malformed"
+	stubTruncate  = "truncate"
+	stubEmpty     = "empty"
+	stubTimeout   = "timeout"
+)
+
+// stubAnswer is the default answer, a Berlin query
+var stubAnswer = func() string {
+	p := ParseResponse{Location: "Berlin", Guests: Guests{Adults: 2}, UiFilters: emptyUiFilters(),
+		UnsupportedCriteria: []string{}}
+	*p.UiFilters.Slot("meals") = []string{"breakfast"}
+	b, _ := json.Marshal(p)
+	return string(b)
+}()
+
+type stubProvider struct {
+	script StubScript
+	mu     sync.Mutex
+	rng    *rand.Rand
+	calls  map[string]int            // per provider
+	counts map[string]map[string]int // provider -> behavior -> calls
+	nanos  atomic.Int64              // time spent answering, for bench
+	served atomic.Int64
+}
+
+func loadStubScript(path string) (StubScript, error) {
+	var s StubScript
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(s.Answer) == 0 {
+		s.Answer = json.RawMessage(stubAnswer)
+	} else if !json.Valid(s.Answer) {
+		return s, fmt.Errorf("%s: invalid answer", path)
+	}
+	for i := range s.Rules {
+		r := &s.Rules[i]
+		switch r.Provider {
+		case "", "openai", "claude":
+		default:
+			return s, fmt.Errorf("%s: rule %d: unknown provider %q", path, i+1, r.Provider)
+		}
+		switch r.Behavior {
+		case stubOK, stubMalformed, stubTruncate, stubEmpty, stubTimeout:
+		default:
+			if status := stubStatus(r.Behavior); status < 400 || status > 599 {
+				return s, fmt.Errorf("%s: rule %d: unknown behavior %q", path, i+1, r.Behavior)
+			}
+		}
+		if r.Delay != "" {
+			if r.delay, err = time.ParseDuration(r.Delay); err != nil {
+				return s, fmt.Errorf("%s: rule %d: invalid delay %q", path, i+1, r.Delay)
+			}
+		}
+	}
+	return s, nil
+}
+
+func newStubProvider(s StubScript) *stubProvider {
+	p := &stubProvider{script: s}
+	p.reset()
+	return p
+}
+
+func (p *stubProvider) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rng = rand.New(rand.NewSource(p.script.Seed))
+	p.calls, p.counts = map[string]int{}, map[string]map[string]int{}
+	p.nanos.Store(0)
+	p.served.Store(0)
+}
+
+// meanMS is the mean time per answered call
+func (p *stubProvider) meanMS() (float64, bool) {
+	n := p.served.Load()
+	if n == 0 {
+		return 0, false
+	}
+	return round2(float64(p.nanos.Load()) / float64(n) / 1e6), true
+}
+
+func stubStatus(behavior string) int {
+	var status int
+	if _, err := fmt.Sscanf(behavior, "%d", &status); err != nil {
+		return 0
+	}
+	return status
+}
+
+// pick counts the call and returns the rule that decides it (nil = ok)
+func (p *stubProvider) pick(provider, query string, maxTokens int) *StubRule {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls[provider]++
+	n := p.calls[provider]
+	var picked *StubRule
+	for i := range p.script.Rules {
+		r := &p.script.Rules[i]
+		switch {
+		case r.Provider != "" && r.Provider != provider,
+			r.Match != "" && !strings.Contains(query, r.Match),
+			r.From > 0 && n < r.From,
+			r.To > 0 && n > r.To,
+			r.Every > 0 && n%r.Every != 0,
+			r.MaxTokens > 0 && maxTokens > r.MaxTokens:
+			continue
+		}
+		// every rate rule that gets here draws, so runs stay reproducible
+		if r.Rate > 0 && p.rng.Float64() >= r.Rate {
+			continue
+		}
+		picked = r
+		break
+	}
+	behavior := stubOK
+	if picked != nil {
+		behavior = picked.Behavior
+	}
+	if p.counts[provider] == nil {
+		p.counts[provider] = map[string]int{}
+	}
+	p.counts[provider][behavior]++
+	return picked
+}
+
+func (p *stubProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/stub/calls":
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		writeAdminJSON(w, map[string]any{"calls": p.calls, "behaviors": p.counts})
+		return
+	case "/stub/reset":
+		p.reset()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	start := time.Now()
+	defer func() {
+		p.nanos.Add(int64(time.Since(start)))
+		p.served.Add(1)
+	}()
+	provider := "openai"
+	if strings.HasSuffix(r.URL.Path, "/messages") {
+		provider = "claude"
+	}
+	var req struct {
+		MaxTokens           int `json:"max_tokens"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+		Messages            []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	b, _ := io.ReadAll(r.Body)
+	_ = json.Unmarshal(b, &req)
+	query := ""
+	for _, m := range req.Messages {
+		if m.Role == "user" {
+			query = m.Content
+		}
+	}
+	rule := p.pick(provider, query, max(req.MaxTokens, req.MaxCompletionTokens))
+
+	answer, behavior := string(p.script.Answer), stubOK
+	if rule != nil {
+		behavior = rule.Behavior
+		if len(rule.Answer) > 0 {
+			answer = string(rule.Answer)
+		}
+		if rule.delay > 0 {
+			select {
+			case <-time.After(rule.delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	finished := true
+	switch behavior {
+	case stubTimeout:
+		<-r.Context().Done()
+		return
+	case stubMalformed:
+		answer = strings.TrimSuffix(strings.TrimSpace(answer), "}") + `,"location": }`
+	case stubTruncate:
+		answer, finished = answer[:len(answer)/2], false
+	case stubOK, stubEmpty:
+	default:
+		status := stubStatus(behavior)
+		if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", fmt.Sprint(max(rule.RetryAfter, 1)))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{
+			"type": "stub_error", "message": "stub: scripted " + behavior}})
+		return
+	}
+
+	var resp any
+	if provider == "claude" {
+		content, stop := []map[string]string{{"type": "text", "text": answer}}, "end_turn"
+		if behavior == stubEmpty {
+			content = []map[string]string{}
+		}
+		if !finished {
+			stop = "max_tokens"
+		}
+		resp = map[string]any{"model": "stub", "content": content, "stop_reason": stop,
+			"usage": map[string]int{"input_tokens": 900, "output_tokens": 120}}
+	} else {
+		choices, reason := []map[string]any{{"message": map[string]string{"content": answer}}}, "stop"
+		if behavior == stubEmpty {
+			choices = []map[string]any{}
+		}
+		if !finished {
+			reason = "length"
+		}
+		for _, c := range choices {
+			c["finish_reason"] = reason
+		}
+		resp = map[string]any{"model": "stub", "choices": choices,
+			"usage": map[string]int{"prompt_tokens": 900, "completion_tokens": 120}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// startStub serves the script on addr; it returns the base URL
+func startStub(script StubScript, addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		log.Printf("[ERROR] stub provider stopped: %v", http.Serve(ln, newStubProvider(script)))
+	}()
+	return "http://" + ln.Addr().String(), nil
+}
+
+// useStubProvider points both providers at an in-process stub when
+// STUB_SCRIPT is set
+func useStubProvider() error {
+	path := os.Getenv("STUB_SCRIPT")
+	if path == "" {
+		return nil
+	}
+	script, err := loadStubScript(path)
+	if err != nil {
+		return fmt.Errorf("STUB_SCRIPT: %w", err)
+	}
+	base, err := startStub(script, "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("STUB_SCRIPT: %w", err)
+	}
+	os.Setenv("OPENAI_BASE_URL", base+"/v1")
+	os.Setenv("CLAUDE_BASE_URL", base+"/v1/messages")
+	for _, key := range []string{"OPENAI_API_KEY", "CLAUDE_API_KEY"} {
+		if os.Getenv(key) == "" {
+			os.Setenv(key, "stub")
+		}
+	}
+	log.Printf("[WARN] STUB_SCRIPT is set: both providers are the stub at %s (%d rules)", base, len(script.Rules))
+	return nil
+}
+
+// runStub is "hotelparser stub"
+func runStub(args []string) int {
+	fs := flag.NewFlagSet("stub", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:9911", "listen address")
+	path := fs.String("script", "", "script file (default: always answer)")
+	_ = fs.Parse(args)
+
+	script := StubScript{Answer: json.RawMessage(stubAnswer)}
+	if *path != "" {
+		var err error
+		if script, err = loadStubScript(*path); err != nil {
+			log.Printf("[ERROR] %v", err)
+			return 2
+		}
+	}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return 1
+	}
+	log.Printf("[INFO] stub provider on http://%s (%d rules)", ln.Addr(), len(script.Rules))
+	if err := http.Serve(ln, newStubProvider(script)); err != nil {
+		log.Printf("[ERROR] %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====== Query suggestions ======
+// GET /v1/suggest?prefix=<text> feeds the search box dropdown from the
+// stored runs of the caller's tenant:
+//
+//	queries  earlier queries that start with the prefix
+//	filters  filter phrases (taxonomy synonyms of the ui_filters values the
+//	         answers set) that start with the prefix's last word, so
+//	         "Rom mit Früh" offers "Frühstück"
+//
+// Both are ranked by frequency with recency: every run counts 0.5^(age /
+// 14 days). Queries are user text, so only anonymous ones are offered: a
+// query must have been sent by at least SUGGEST_MIN_COUNT different API
+// keys (default 3; without API_KEYS callers can't be told apart and every
+// run counts as one), and queries with an e-mail address, a URL or a long
+// number (phone, booking reference) never are. limit=<n> caps each list
+// (default 8, at most 50).
+//
+// The index is rebuilt from the store once a minute, in the background:
+// requests get the previous index until the new one is swapped in. Only a
+// tenant's first request waits for its index.
+
+const (
+	suggestHalfLife     = 14 * 24 * time.Hour
+	suggestRefresh      = time.Minute
+	suggestDefaultLimit = 8
+	suggestMaxLimit     = 50
+	suggestDefaultMin   = 3
+)
+
+// personalQuery matches queries that may identify someone
+var personalQuery = regexp.MustCompile(`(?i)[^\s@]+@[^\s@]+\.[a-z]{2,}|https?://|www\.|\d(?:[ /-]?\d){6,}`)
+
+// Suggestion is one entry of the dropdown
+type Suggestion struct {
+	Text  string  `json:"text"`
+	Slot  string  `json:"slot,omitempty"` // filters: the ui_filters slot and value
+	Value string  `json:"value,omitempty"`
+	Count int     `json:"count"`
+	Score float64 `json:"score"`
+}
+
+type SuggestResponse struct {
+	Prefix  string       `json:"prefix"`
+	Queries []Suggestion `json:"queries"`
+	Filters []Suggestion `json:"filters"`
+}
+
+// suggestEntry is a query or filter value with its weight
+type suggestEntry struct {
+	text    string // as shown: the latest spelling of the query
+	count   int    // runs
+	callers map[string]bool
+	score   float64
+}
+
+// suggestIndex holds one tenant's queries and filter values
+type suggestIndex struct {
+	built   time.Time
+	queries map[string]*suggestEntry // by normalized query
+	filters map[string]*suggestEntry // by "slot=value"
+}
+
+// tenantSuggest is a tenant's current index and its rebuild state
+type tenantSuggest struct {
+	idx      atomic.Pointer[suggestIndex]
+	err      error         // of the first build
+	ready    chan struct{} // closed after the first build
+	building atomic.Bool
+}
+
+var suggestIndexes struct {
+	sync.Mutex
+	m map[string]*tenantSuggest
+}
+
+// suggestMinCount is how often a query must have been sent to be offered
+func suggestMinCount() int {
+	if n, err := strconv.Atoi(os.Getenv("SUGGEST_MIN_COUNT")); err == nil && n > 0 {
+		return n
+	}
+	return suggestDefaultMin
+}
+
+// tenantSuggestIndex returns the tenant's index; a stale one is rebuilt in
+// the background
+func tenantSuggestIndex(ctx context.Context, tenant string) (*suggestIndex, error) {
+	suggestIndexes.Lock()
+	ts := suggestIndexes.m[tenant]
+	if ts == nil {
+		if suggestIndexes.m == nil {
+			suggestIndexes.m = map[string]*tenantSuggest{}
+		}
+		ts = &tenantSuggest{ready: make(chan struct{})}
+		suggestIndexes.m[tenant] = ts
+		ts.building.Store(true)
+		go ts.rebuild(tenant)
+	}
+	suggestIndexes.Unlock()
+
+	select {
+	case <-ts.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	idx := ts.idx.Load()
+	if (idx == nil || time.Since(idx.built) >= suggestRefresh) && ts.building.CompareAndSwap(false, true) {
+		go ts.rebuild(tenant)
+	}
+	if idx == nil {
+		return nil, ts.err
+	}
+	return idx, nil
+}
+
+// rebuild builds the tenant's index and swaps it in
+func (ts *tenantSuggest) rebuild(tenant string) {
+	defer ts.building.Store(false)
+	idx, err := buildSuggestIndex(tenant, time.Now())
+	if err != nil {
+		log.Printf("[ERROR] building suggestions: %v", err)
+	} else {
+		ts.idx.Store(idx)
+	}
+	select {
+	case <-ts.ready:
+	default:
+		ts.err = err
+		close(ts.ready)
+	}
+}
+
+func buildSuggestIndex(tenant string, now time.Time) (*suggestIndex, error) {
+	idx := &suggestIndex{built: now, queries: map[string]*suggestEntry{}, filters: map[string]*suggestEntry{}}
+	add := func(m map[string]*suggestEntry, key, text string, w float64) *suggestEntry {
+		e := m[key]
+		if e == nil {
+			e = &suggestEntry{callers: map[string]bool{}}
+			m[key] = e
+		}
+		e.text = text
+		e.count++
+		e.score += w
+		return e
+	}
+	err := store.Each(func(run StoredResult) error {
+		if run.Tenant != tenant || run.Aborted || run.Replay != nil {
+			return nil
+		}
+		w := math.Pow(0.5, float64(now.Sub(run.Time))/float64(suggestHalfLife))
+		if q := strings.Join(strings.Fields(run.Query), " "); q != "" && !personalQuery.MatchString(q) {
+			caller := run.APIKey
+			if caller == "" || caller == anonymousKey {
+				caller = "run " + run.ID
+			}
+			add(idx.queries, normalizePhrase(q), q, w).callers[caller] = true
+		}
+		// a value counts once per run, whichever answers set it
+		seen := map[string]bool{}
+		for _, p := range []*ParseResponse{run.Response.OpenAI, run.Response.Claude, run.Response.Rules} {
+			if p == nil {
+				continue
+			}
+			for _, slot := range uiFilterSlots {
+				for _, v := range *p.UiFilters.Slot(slot) {
+					seen[slot+"="+v] = true
+				}
+			}
+		}
+		for key := range seen {
+			add(idx.filters, key, key, w)
+		}
+		return nil
+	})
+	return idx, err
+}
+
+// suggest lists the queries and filter phrases for prefix
+func (idx *suggestIndex) suggest(prefix string, limit int, tax Taxonomy) SuggestResponse {
+	res := SuggestResponse{Prefix: prefix, Queries: []Suggestion{}, Filters: []Suggestion{}}
+	norm := normalizePhrase(prefix)
+	if norm == "" {
+		return res
+	}
+	minCount := suggestMinCount()
+	for key, e := range idx.queries {
+		if len(e.callers) >= minCount && strings.HasPrefix(key, norm) && key != norm {
+			res.Queries = append(res.Queries, Suggestion{Text: e.text, Count: e.count, Score: e.score})
+		}
+	}
+	words := strings.Fields(norm)
+	last := words[len(words)-1]
+	for key, e := range idx.filters {
+		slot, value, _ := strings.Cut(key, "=")
+		if text := matchingSynonym(tax[slot][value], norm, last); text != "" {
+			res.Filters = append(res.Filters, Suggestion{Text: text, Slot: slot, Value: value, Count: e.count, Score: e.score})
+		}
+	}
+	res.Queries, res.Filters = rankSuggestions(res.Queries, limit), rankSuggestions(res.Filters, limit)
+	return res
+}
+
+// matchingSynonym is the first synonym starting with the whole prefix or
+// its last word, or ""
+func matchingSynonym(synonyms []string, prefix, last string) string {
+	for _, syn := range synonyms {
+		n := normalizePhrase(syn)
+		if strings.HasPrefix(n, prefix) || strings.HasPrefix(n, last) {
+			return syn
+		}
+	}
+	return ""
+}
+
+func rankSuggestions(s []Suggestion, limit int) []Suggestion {
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Score != s[j].Score {
+			return s[i].Score > s[j].Score
+		}
+		return s[i].Text < s[j].Text
+	})
+	for i := range s {
+		s[i].Score = math.Round(s[i].Score*1000) / 1000
+	}
+	return s[:min(len(s), limit)]
+}
+
+func suggestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	prefix := strings.TrimSpace(r.URL.Query().Get("prefix"))
+	if prefix == "" {
+		writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "set prefix"))
+		return
+	}
+	limit := suggestDefaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeProblem(w, newProblem(http.StatusBadRequest, codeInvalidInput, "invalid limit "+strconv.Quote(s)))
+			return
+		}
+		limit = min(n, suggestMaxLimit)
+	}
+	idx, err := tenantSuggestIndex(r.Context(), tenantOf(r.Context()))
+	if err != nil {
+		log.Printf("[ERROR] building suggestions: %v", err)
+		writeProblem(w, newProblem(http.StatusInternalServerError, codeInternal, "suggestions failed"))
+		return
+	}
+	tax, _ := currentTaxonomy()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(idx.suggest(prefix, limit, tax))
+}
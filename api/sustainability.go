@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// ====== Sustainability labels ======
+// "nachhaltig", "umweltfreundlich" and "Bio-Hotel" map to the certifications
+// ui_filter: generic wishes to "sustainable" (any recognized label), named
+// labels (Green Key, EU Ecolabel, Green Globe, Travelife, GSTC, Viabono,
+// TourCert, BIO HOTELS) to their own value. The labels are maintained in
+// the taxonomy's certifications slot. Sustainability wishes the taxonomy
+// doesn't cover ("plastikfrei", "mit Solarstrom") stay in
+// unsupported_criteria like any unmapped phrase, but the evaluation also
+// reports them on their own, as custom.sustainability_gaps: the phrases
+// with how often answers left them unmapped, so the list can grow where
+// queries need it.
+
+// sustainabilityStems start the words of a sustainability wish;
+// sustainabilityParts may be anywhere in a compound ("Umweltsiegel")
+var (
+	sustainabilityStems = []string{"öko", "oeko", "klima", "co2", "bio", "green", "eco", "plastikfrei", "solar", "erneuerbar"}
+	sustainabilityParts = []string{"nachhaltig", "umwelt", "zertifi", "siegel", "label"}
+)
+
+// isSustainabilityPhrase is whether phrase is about sustainability
+func isSustainabilityPhrase(phrase string) bool {
+	for _, w := range strings.Fields(normalizePhrase(phrase)) {
+		for _, stem := range sustainabilityStems {
+			if strings.HasPrefix(w, stem) {
+				return true
+			}
+		}
+		for _, part := range sustainabilityParts {
+			if strings.Contains(w, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func init() { RegisterMetric(func() Metric { return &sustainabilityGaps{counts: map[string]int{}} }) }
+
+// sustainabilityGaps counts the sustainability phrases answers left in
+// unsupported_criteria
+type sustainabilityGaps struct {
+	counts map[string]int
+}
+
+// SustainabilityGap is one unmapped phrase and how often it was left so
+type SustainabilityGap struct {
+	Phrase string `json:"phrase"`
+	Count  int    `json:"count"`
+}
+
+func (m *sustainabilityGaps) Name() string { return "sustainability_gaps" }
+
+func (m *sustainabilityGaps) Accumulate(pred, _ ParseResponse) {
+	for _, c := range pred.UnsupportedCriteria {
+		if isSustainabilityPhrase(c) {
+			m.counts[normalizePhrase(c)]++
+		}
+	}
+}
+
+func (m *sustainabilityGaps) Result() any {
+	gaps := make([]SustainabilityGap, 0, len(m.counts))
+	for phrase, n := range m.counts {
+		gaps = append(gaps, SustainabilityGap{Phrase: phrase, Count: n})
+	}
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].Count != gaps[j].Count {
+			return gaps[i].Count > gaps[j].Count
+		}
+		return gaps[i].Phrase < gaps[j].Phrase
+	})
+	return gaps
+}
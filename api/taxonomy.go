@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"hotelparser/api/eval/slots"
+)
+
+// ====== ui_filters taxonomy ======
+// The taxonomy lists the canonical ui_filters values per slot together with
+// the German phrases that mean them. The default is the shipped
+// prompt/taxonomy.json, embedded in the binary; the file in PROMPT_DIR
+// overrides it, cached and reloaded like the prompts. Besides the
+// ui_filters slots it holds the values of the top-level fields transport,
+// trip_purpose, room_types, room_views and accessibility, in slots of the
+// same name, and the reference points of distances (distance_reference).
+
+// Taxonomy: slot -> canonical value -> synonyms
+type Taxonomy map[string]map[string][]string
+
+// SlotValue is one canonical ui_filters entry
+type SlotValue struct {
+	Slot  string `json:"slot"`
+	Value string `json:"value"`
+}
+
+const taxonomyFile = "prompt/taxonomy.json"
+
+// defaultTaxonomy is the shipped prompt/taxonomy.json (see assets.go)
+var defaultTaxonomy = string(embeddedDefault(taxonomyFile))
+
+var taxonomyMemo struct {
+	sync.Mutex
+	hash string
+	tax  Taxonomy
+}
+
+// currentTaxonomy returns the parsed taxonomy and its hash
+func currentTaxonomy() (Taxonomy, string) {
+	p := cachedPrompt(taxonomyFile, func() string {
+		if b, err := readPrompt(taxonomyFile); err == nil {
+			return string(b)
+		}
+		return defaultTaxonomy
+	})
+	taxonomyMemo.Lock()
+	defer taxonomyMemo.Unlock()
+	if taxonomyMemo.hash != p.Hash {
+		var t Taxonomy
+		if err := json.Unmarshal([]byte(p.Text), &t); err != nil {
+			log.Printf("[ERROR] invalid %s, using the built-in taxonomy: %v", taxonomyFile, err)
+			_ = json.Unmarshal([]byte(defaultTaxonomy), &t)
+		}
+		taxonomyMemo.hash, taxonomyMemo.tax = p.Hash, t
+	}
+	return taxonomyMemo.tax, p.Hash
+}
+
+// Has reports whether value is a canonical value of slot
+func (t Taxonomy) Has(slot, value string) bool {
+	_, ok := t[slot][value]
+	return ok
+}
+
+// Match returns every canonical value one of whose synonyms occurs in
+// mention as whole words (case-insensitive). Within a slot the longer
+// phrase wins: "Ultra All inclusive" is ultra_all_inclusive, not also
+// only_all_inclusive.
+func (t Taxonomy) Match(mention string) []SlotValue {
+	m := " " + normalizePhrase(mention) + " "
+	var out []SlotValue
+	for slot, values := range t {
+		hits := map[string]string{} // value -> longest matching synonym
+		for value, synonyms := range values {
+			for _, syn := range synonyms {
+				if n := normalizePhrase(syn); n != "" && strings.Contains(m, " "+n+" ") && len(n) > len(hits[value]) {
+					hits[value] = n
+				}
+			}
+		}
+	values:
+		for value, hit := range hits {
+			for other, longer := range hits {
+				if other != value && len(longer) > len(hit) && strings.Contains(" "+longer+" ", " "+hit+" ") {
+					continue values
+				}
+			}
+			out = append(out, SlotValue{Slot: slot, Value: value})
+		}
+	}
+	return out
+}
+
+// normalizePhrase lowercases and turns punctuation into single spaces
+func normalizePhrase(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+// Slot returns the ui_filters list with the given JSON name, or nil
+func (f *UiFilters) Slot(name string) *[]string {
+	switch name {
+	case "meals":
+		return &f.Meals
+	case "ratings":
+		return &f.Ratings
+	case "hotelTypes":
+		return &f.HotelTypes
+	case "hotelfacilities":
+		return &f.Hotelfacilities
+	case "poolbeach":
+		return &f.Poolbeach
+	case "distanceBeach":
+		return &f.DistanceBeach
+	case "travelGroup":
+		return &f.TravelGroup
+	case "stars":
+		return &f.Stars
+	case "wellness":
+		return &f.Wellness
+	case "reference_distance_max":
+		return &f.ReferenceDistance
+	case "flex":
+		return &f.Flex
+	case "children":
+		return &f.Children
+	case "parking":
+		return &f.Parking
+	case "freetime":
+		return &f.Freetime
+	case "certifications":
+		return &f.Certifications
+	case "hotelthemes":
+		return &f.Hotelthemes
+	case "hotelBrand":
+		return &f.HotelBrand
+	case "hotelinformation":
+		return &f.Hotelinformation
+	}
+	return nil
+}
+
+// boardSlot is the board basis slot. Unlike the other ui_filters slots its
+// taxonomy values are the complete list, so other values are dropped.
+const boardSlot = "meals"
+
+// mealAliases are spellings of board bases seen in model answers and
+// imported ground truth (tour-operator codes, other naming schemes)
+var mealAliases = map[string]string{
+	"bb": "breakfast", "uf": "breakfast", "bed_and_breakfast": "breakfast",
+	"hb": "half_board", "hp": "half_board", "halfboard": "half_board", "half-board": "half_board",
+	"fb": "full_board", "vp": "full_board", "fullboard": "full_board", "full-board": "full_board",
+	"ai": "only_all_inclusive", "all_inclusive": "only_all_inclusive", "allinclusive": "only_all_inclusive",
+	"ai+": "all_inclusive_plus", "ai_plus": "all_inclusive_plus", "all_inclusive+": "all_inclusive_plus",
+	"uai": "ultra_all_inclusive", "ultra_ai": "ultra_all_inclusive", "ultra_all_incl": "ultra_all_inclusive",
+	"sc": "self_catering", "selfcatering": "self_catering", "self-catering": "self_catering",
+	"ro": "room_only", "ov": "room_only", "roomonly": "room_only", "room-only": "room_only",
+}
+
+// normalizeMeals maps alias spellings to the canonical meals values,
+// without duplicates; values the taxonomy doesn't know are dropped
+func (p *ParseResponse) normalizeMeals() {
+	if len(p.UiFilters.Meals) == 0 {
+		return
+	}
+	tax, _ := currentTaxonomy()
+	meals := p.UiFilters.Meals[:0]
+	seen := map[string]bool{}
+	for _, v := range p.UiFilters.Meals {
+		v = strings.TrimSpace(v)
+		if canon, ok := mealAliases[strings.ToLower(v)]; ok {
+			v = canon
+		}
+		if tax[boardSlot] != nil && !tax.Has(boardSlot, v) {
+			continue
+		}
+		if !seen[v] {
+			seen[v] = true
+			meals = append(meals, v)
+		}
+	}
+	p.UiFilters.Meals = meals
+}
+
+// Taxonomy slots of top-level fields
+const (
+	transportSlot   = "transport"
+	tripPurposeSlot = "trip_purpose"
+	roomTypesSlot   = "room_types"
+	roomViewsSlot   = "room_views"
+	accessSlot      = "accessibility"
+)
+
+// listSlots are the taxonomy slots of top-level list fields
+var listSlots = []string{roomTypesSlot, roomViewsSlot, accessSlot}
+
+// groupMinSize is the group_size from which a party is a group
+// (travelGroup.group) rather than family or friends
+const groupMinSize = 6
+
+// listField is the top-level list field of a taxonomy slot, or nil
+func (p *ParseResponse) listField(slot string) *[]string {
+	switch slot {
+	case roomTypesSlot:
+		return &p.RoomTypes
+	case roomViewsSlot:
+		return &p.RoomViews
+	case accessSlot:
+		return &p.Accessibility
+	}
+	return nil
+}
+
+// apply sets what a taxonomy match stands for: the transport or trip
+// purpose (unless one is set already), a room type or view, an
+// accessibility need, or a ui_filters value
+func (p *ParseResponse) apply(sv SlotValue) bool {
+	switch sv.Slot {
+	case transportSlot:
+		if p.Transport == "" {
+			p.Transport = sv.Value
+		}
+		return true
+	case tripPurposeSlot:
+		if p.TripPurpose == "" {
+			p.TripPurpose = sv.Value
+		}
+		return true
+	}
+	if list := p.listField(sv.Slot); list != nil {
+		if !slices.Contains(*list, sv.Value) {
+			*list = append(*list, sv.Value)
+		}
+		return true
+	}
+	return p.UiFilters.add(sv.Slot, sv.Value)
+}
+
+// add appends value to the slot unless it is already there
+func (f *UiFilters) add(slot, value string) bool {
+	list := f.Slot(slot)
+	if list == nil {
+		return false
+	}
+	for _, v := range *list {
+		if v == value {
+			return true
+		}
+	}
+	*list = append(*list, value)
+	return true
+}
+
+// uiFilterSlots are the ui_filters keys in schema order
+var uiFilterSlots = slots.UI
+
+// emptyUiFilters has every slot set to [] (as the model would emit it)
+func emptyUiFilters() UiFilters {
+	var f UiFilters
+	for _, name := range uiFilterSlots {
+		*f.Slot(name) = []string{}
+	}
+	return f
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Telemetry ======
+// Opt-in fleet monitoring: with TELEMETRY_URL set, every
+// TELEMETRY_INTERVAL (default 1h) the server POSTs aggregate statistics of
+// the runs stored since the last report:
+//
+//	runs, degraded, cached   counts
+//	latency_ms_avg           over the runs
+//	providers                answers and errors by code, per provider
+//	slots                    how many answers filled each slot
+//	ui_filters               how often each ui_filters value was set
+//
+// Nothing identifies a user or a query: no query text, no run IDs, no API
+// keys or tenants, and of the answers only slot names and the closed
+// ui_filters vocabulary (no locations, dates or prices). The deployment is
+// named by TELEMETRY_DEPLOYMENT, else a random ID per process. With
+// TELEMETRY_SECRET the body is signed like webhooks (X-Signature). A failed
+// report is retried with the next one, counts included.
+
+const telemetryDefaultInterval = time.Hour
+
+// TelemetryReport is one report
+type TelemetryReport struct {
+	Deployment   string                        `json:"deployment"`
+	From         time.Time                     `json:"from"`
+	To           time.Time                     `json:"to"`
+	Runs         int                           `json:"runs"`
+	Degraded     int                           `json:"degraded"`
+	Cached       int                           `json:"cached"` // answers from the parse cache
+	LatencyAvgMS float64                       `json:"latency_ms_avg"`
+	Providers    map[string]*TelemetryProvider `json:"providers"`
+	Slots        map[string]int                `json:"slots"`
+	UiFilters    map[string]int                `json:"ui_filters"`
+}
+
+// TelemetryProvider counts one provider's answers and errors
+type TelemetryProvider struct {
+	Answers int            `json:"answers"`
+	Errors  map[string]int `json:"errors"` // by problem code
+}
+
+type telemetryAgg struct {
+	mu        sync.Mutex
+	report    TelemetryReport
+	latencyMS int64
+}
+
+// telemetry is nil when the export is off
+var telemetry *telemetryAgg
+
+func newTelemetryReport(from time.Time) TelemetryReport {
+	return TelemetryReport{From: from, Providers: map[string]*TelemetryProvider{}, Slots: map[string]int{}, UiFilters: map[string]int{}}
+}
+
+func startTelemetry() {
+	target := os.Getenv("TELEMETRY_URL")
+	if target == "" {
+		return
+	}
+	interval := envDuration("TELEMETRY_INTERVAL", telemetryDefaultInterval)
+	deployment := os.Getenv("TELEMETRY_DEPLOYMENT")
+	if deployment == "" {
+		deployment = newRunID()[:12]
+	}
+	telemetry = &telemetryAgg{report: newTelemetryReport(time.Now())}
+	go telemetry.loop(target, deployment, interval)
+	log.Printf("[INFO] sending telemetry to %s every %s (deployment %s)", target, interval, deployment)
+}
+
+// record adds a stored run to the current report
+func (t *telemetryAgg) record(run StoredResult) {
+	if run.Shadow != nil || run.Replay != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := &t.report
+	r.Runs++
+	t.latencyMS += run.Latency
+	if run.Response.Degraded {
+		r.Degraded++
+	}
+	for provider, status := range run.Response.Status {
+		p := r.Providers[provider]
+		if p == nil {
+			p = &TelemetryProvider{Errors: map[string]int{}}
+			r.Providers[provider] = p
+		}
+		if status.Status == "error" {
+			p.Errors[status.Code]++
+			continue
+		}
+		p.Answers++
+		if status.Cached {
+			r.Cached++
+		}
+	}
+	for _, p := range []*ParseResponse{run.Response.OpenAI, run.Response.Claude, run.Response.Rules} {
+		if p == nil {
+			continue
+		}
+		filled := map[string]bool{}
+		for key := range flatten(*p) {
+			filled[slotNameOf(key)] = true
+			if strings.HasPrefix(key, "ui.") {
+				r.UiFilters[strings.TrimPrefix(key, "ui.")]++
+			}
+		}
+		for slot := range filled {
+			r.Slots[slot]++
+		}
+	}
+}
+
+// take returns the current report and starts a new one
+func (t *telemetryAgg) take(now time.Time) (TelemetryReport, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, latency := t.report, t.latencyMS
+	r.To = now
+	t.report, t.latencyMS = newTelemetryReport(now), 0
+	return r, latency
+}
+
+// putBack merges an unsent report into the current one
+func (t *telemetryAgg) putBack(r TelemetryReport, latencyMS int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cur := &t.report
+	cur.From = r.From
+	cur.Runs += r.Runs
+	cur.Degraded += r.Degraded
+	cur.Cached += r.Cached
+	t.latencyMS += latencyMS
+	for name, p := range r.Providers {
+		c := cur.Providers[name]
+		if c == nil {
+			cur.Providers[name] = p
+			continue
+		}
+		c.Answers += p.Answers
+		for code, n := range p.Errors {
+			c.Errors[code] += n
+		}
+	}
+	for k, n := range r.Slots {
+		cur.Slots[k] += n
+	}
+	for k, n := range r.UiFilters {
+		cur.UiFilters[k] += n
+	}
+}
+
+func (t *telemetryAgg) loop(target, deployment string, interval time.Duration) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	for range time.Tick(interval) {
+		r, latency := t.take(time.Now())
+		if r.Runs == 0 {
+			continue
+		}
+		r.Deployment = deployment
+		r.LatencyAvgMS = float64(latency) / float64(r.Runs)
+		if err := sendTelemetry(client, target, r); err != nil {
+			log.Printf("[WARN] telemetry: %v (retrying with the next report)", err)
+			t.putBack(r, latency)
+		}
+	}
+}
+
+func sendTelemetry(client *http.Client, target string, r TelemetryReport) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := os.Getenv("TELEMETRY_SECRET"); secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Signature-Timestamp", ts)
+		req.Header.Set("X-Signature", "sha256="+signWebhook(secret, ts, body))
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return errors.New(res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ====== Tenants ======
+// One deployment can serve several brands. Each request belongs to a
+// tenant: the "tenant" of its API key, or the X-Tenant header when API_KEYS
+// isn't set (with API_KEYS only the admin token may pick one by header).
+// Requests without either use the default tenant "", which is where all
+// data from before tenants lives.
+//
+// Runs, ground truth and evaluations are kept apart per tenant:
+//
+//	runs          tagged with the tenant; every read filters on it
+//	ground truth  data/tenants/<tenant>/groundtruth.json (Postgres: tenant column)
+//	prompts       prompt/tenants/<tenant>/system.txt and examples.json
+//	              where present, else the shared files
+//	evaluations   one cache per tenant
+
+const tenantHeader = "X-Tenant"
+
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type tenantKey struct{}
+
+// tenantOf is the tenant tenantMiddleware resolved for the request
+func tenantOf(ctx context.Context) string {
+	t, _ := ctx.Value(tenantKey{}).(string)
+	return t
+}
+
+// resolveTenant works out r's tenant; the problem is for callers who may
+// not use the one they asked for
+func resolveTenant(r *http.Request) (string, *Problem) {
+	header := strings.TrimSpace(r.Header.Get(tenantHeader))
+	if header != "" && !tenantName.MatchString(header) {
+		return "", newProblem(http.StatusBadRequest, codeInvalidInput,
+			"invalid "+tenantHeader+" (lower-case letters, digits, - and _)")
+	}
+	keys, err := apiKeys()
+	if err != nil || keys == nil || isAdmin(r) {
+		return header, nil // a broken API_KEYS is reported by authenticate
+	}
+	key, p := authenticate(r)
+	if p != nil {
+		if header != "" {
+			return "", newProblem(http.StatusForbidden, codeUnauthorized, tenantHeader+" needs an API key or the admin token")
+		}
+		return "", nil
+	}
+	if header != "" && header != key.Tenant {
+		return "", newProblem(http.StatusForbidden, codeUnauthorized, "this API key may not use tenant "+header)
+	}
+	return key.Tenant, nil
+}
+
+func tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, p := resolveTenant(r)
+		if p != nil {
+			writeProblem(w, p)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+	})
+}
+
+// ----- ground truth -----
+
+// tenantGroundTruth is implemented by backends that keep ground truth for
+// all tenants themselves
+type tenantGroundTruth interface {
+	GroundTruthFor(tenant string) GroundTruthStore
+}
+
+var tenantFiles = struct {
+	sync.Mutex
+	m map[string]*fileGroundTruth
+}{m: map[string]*fileGroundTruth{}}
+
+// groundTruthFor is the tenant's ground truth store
+func groundTruthFor(tenant string) GroundTruthStore {
+	if tenant == "" {
+		return groundTruth
+	}
+	if s, ok := groundTruth.(tenantGroundTruth); ok {
+		return s.GroundTruthFor(tenant)
+	}
+	tenantFiles.Lock()
+	defer tenantFiles.Unlock()
+	f := tenantFiles.m[tenant]
+	if f == nil {
+		f = &fileGroundTruth{path: dataPath(path.Join("data/tenants", tenant, "groundtruth.json"))}
+		tenantFiles.m[tenant] = f
+	}
+	return f
+}
+
+// ----- prompts -----
+
+// tenantFile is the tenant's copy of a prompt file when it has one
+func tenantFile(tenant, file string) string {
+	if tenant == "" {
+		return file
+	}
+	own := path.Join("prompt/tenants", tenant, strings.TrimPrefix(file, "prompt/"))
+	if _, err := readPrompt(own); err == nil {
+		return own
+	}
+	return file
+}
+
+// systemPromptForTenant is systemPromptFor with the tenant's own prompt and
+// examples where they exist. The lookup is repeated on every reload, so
+// adding a tenant file takes effect like editing a shared one.
+func systemPromptForTenant(tenant, file string) *loadedPrompt {
+	if tenant == "" {
+		return systemPromptFor(file)
+	}
+	return cachedPrompt(tenant+":"+file, func() string {
+		return loadSystemPrompt(tenantFile(tenant, file), tenantFile(tenant, examplesFile))
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Timeouts ======
+
+// requestBudget is the overall deadline for one parse request. In "both"
+// mode whatever finished within the budget is returned.
+func requestBudget() time.Duration {
+	return envDuration("PARSE_BUDGET", 45*time.Second)
+}
+
+// providerTimeout bounds a single call to one provider (OPENAI_TIMEOUT,
+// CLAUDE_TIMEOUT)
+func providerTimeout(provider string) time.Duration {
+	return envDuration(strings.ToUpper(provider)+"_TIMEOUT", 60*time.Second)
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("[WARN] invalid %s=%q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// ====== Adaptive timeouts ======
+// With ADAPTIVE_TIMEOUT=1 a parse call's timeout follows the provider:
+// adaptiveFactor times the p95 of its last adaptiveWindow successful calls,
+// kept between ADAPTIVE_TIMEOUT_MIN (default 5s) and the fixed
+// <PROVIDER>_TIMEOUT. A provider that turns slow is cut
+// off early in "both" mode instead of holding every request up to the
+// budget. A call cut off this way counts as a sample of the timeout itself,
+// so a provider that stays slow moves its timeout up step by step towards
+// the ceiling rather than failing every call. Until adaptiveMinSamples
+// calls are in, the fixed timeout applies. Only parse calls adapt.
+
+const (
+	adaptiveWindow     = 100
+	adaptiveMinSamples = 20
+	adaptiveFactor     = 1.5
+)
+
+var callLatencies = struct {
+	sync.Mutex
+	m map[string]*latencyRing
+}{m: map[string]*latencyRing{}}
+
+type latencyRing struct {
+	values []time.Duration
+	next   int
+}
+
+func adaptiveTimeouts() bool {
+	return envBool("ADAPTIVE_TIMEOUT")
+}
+
+// recordLatency adds one call's duration to provider's window
+func recordLatency(provider string, d time.Duration) {
+	callLatencies.Lock()
+	defer callLatencies.Unlock()
+	r := callLatencies.m[provider]
+	if r == nil {
+		r = &latencyRing{}
+		callLatencies.m[provider] = r
+	}
+	if len(r.values) < adaptiveWindow {
+		r.values = append(r.values, d)
+	} else {
+		r.values[r.next] = d
+		r.next = (r.next + 1) % adaptiveWindow
+	}
+}
+
+// latencyQuantile is the q quantile of provider's window and the number
+// of calls in it
+func latencyQuantile(provider string, q float64) (time.Duration, int) {
+	callLatencies.Lock()
+	r := callLatencies.m[provider]
+	var values []time.Duration
+	if r != nil {
+		values = slices.Clone(r.values)
+	}
+	callLatencies.Unlock()
+	if len(values) == 0 {
+		return 0, 0
+	}
+	slices.Sort(values)
+	return values[max(int(math.Ceil(q*float64(len(values))))-1, 0)], len(values)
+}
+
+// parseTimeout bounds one parse call to provider: the adaptive timeout
+// when it's on and there are enough samples, else providerTimeout
+func parseTimeout(provider string) time.Duration {
+	fixed := providerTimeout(provider)
+	if !adaptiveTimeouts() {
+		return fixed
+	}
+	p95, n := latencyQuantile(provider, 0.95)
+	if n < adaptiveMinSamples {
+		return fixed
+	}
+	floor := envDuration("ADAPTIVE_TIMEOUT_MIN", 5*time.Second)
+	return min(max(time.Duration(float64(p95)*adaptiveFactor), floor), fixed)
+}
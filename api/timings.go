@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// ====== Stage timings ======
+// Every stored run records, per provider, where its time went
+// (status.<provider>.timings_us, microseconds). These aren't the stages of
+// the two_stage pipeline; both of its calls count as upstream:
+//
+//	prompt       assembling the system prompt (cached, so mostly a lookup)
+//	upstream     the provider calls, the escalation included
+//	decode       finding the JSON in the output, repair and decoding
+//	validate     ParseResponse.Validate
+//	postprocess  postProcess, date anchoring and assisted interpretation
+//
+// Evaluations report the mean and p95 of each stage per provider
+// (stage_latency), which shows whether a normalization layer costs more
+// than it should next to the provider call. Cached answers only have the
+// stages that ran for the request.
+
+const (
+	timingPrompt      = "prompt"
+	timingUpstream    = "upstream"
+	timingDecode      = "decode"
+	timingValidate    = "validate"
+	timingPostprocess = "postprocess"
+)
+
+// StageTimings are microseconds spent per stage
+type StageTimings map[string]int64
+
+func (t StageTimings) add(stage string, d time.Duration) {
+	if t != nil {
+		t[stage] += d.Microseconds()
+	}
+}
+
+// merge adds the timings of o, e.g. of an escalation call
+func (t StageTimings) merge(o StageTimings) {
+	for stage, us := range o {
+		t[stage] += us
+	}
+}
+
+type timingsKey struct{}
+
+// withTimings records the stages timed with ctx into t
+func withTimings(ctx context.Context, t StageTimings) context.Context {
+	return context.WithValue(ctx, timingsKey{}, t)
+}
+
+// timeStage starts timing stage; the returned func stops it. Without
+// timings in ctx it does nothing.
+func timeStage(ctx context.Context, stage string) func() {
+	t, _ := ctx.Value(timingsKey{}).(StageTimings)
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { t.add(stage, time.Since(start)) }
+}
+
+// StageLatency is one stage's latency over the runs that recorded it
+type StageLatency struct {
+	AvgMS float64 `json:"avg_ms"`
+	P95MS float64 `json:"p95_ms"`
+	Count int     `json:"count"`
+}
+
+// timingAcc collects stage timings for evaluations
+type timingAcc map[string][]int64
+
+func (a timingAcc) add(t StageTimings) {
+	for stage, us := range t {
+		a[stage] = append(a[stage], us)
+	}
+}
+
+func (a timingAcc) report() map[string]StageLatency {
+	if len(a) == 0 {
+		return nil
+	}
+	out := map[string]StageLatency{}
+	for stage, values := range a {
+		var sum int64
+		for _, v := range values {
+			sum += v
+		}
+		out[stage] = StageLatency{
+			AvgMS: round2(float64(sum) / float64(len(values)) / 1000),
+			P95MS: round2(float64(p95(values)) / 1000),
+			Count: len(values),
+		}
+	}
+	return out
+}
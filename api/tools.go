@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ====== Tool-calling mode ======
+// With OPENAI_TOOLS=1 / CLAUDE_TOOLS=1 the parse call forces a tool call
+// (OpenAI function calling in strict mode, Anthropic tool use) whose input
+// schema is generated from the live taxonomy: every ui_filters slot the
+// taxonomy knows is an enum of its canonical values, so the model cannot
+// answer with values outside the set. stars is an enum of "1".."5"; other
+// slots stay free strings. The schema follows taxonomy reloads, and its
+// hash is recorded in repro.tool_schema. Only the single-stage parse call
+// uses the tool; the two-stage prompts have schemas of their own.
+
+const parseToolName = "set_search_filters"
+
+// parseTool is the tool definition sent with a parse call
+type parseTool struct {
+	Name        string
+	Description string
+	Schema      map[string]any
+	Hash        string // of the schema, for repro
+}
+
+var parseToolMemo struct {
+	sync.Mutex
+	taxHash string
+	tool    *parseTool
+}
+
+// currentParseTool builds the tool from the current taxonomy (cached per
+// taxonomy version)
+func currentParseTool() *parseTool {
+	tax, hash := currentTaxonomy()
+	parseToolMemo.Lock()
+	defer parseToolMemo.Unlock()
+	if parseToolMemo.tool != nil && parseToolMemo.taxHash == hash {
+		return parseToolMemo.tool
+	}
+	schema := parseToolSchema(tax)
+	b, _ := json.Marshal(schema)
+	sum := sha256.Sum256(b)
+	parseToolMemo.taxHash = hash
+	parseToolMemo.tool = &parseTool{
+		Name:        parseToolName,
+		Description: "Setzt die Suchfilter, die aus der Hotelsuchanfrage hervorgehen.",
+		Schema:      schema,
+		Hash:        hex.EncodeToString(sum[:6]),
+	}
+	return parseToolMemo.tool
+}
+
+// parseToolSchema is ParseResponse as JSON Schema, in the strict form
+// OpenAI requires: every property required, no additional properties
+func parseToolSchema(tax Taxonomy) map[string]any {
+	object := func(props map[string]any) map[string]any {
+		required := make([]string, 0, len(props))
+		for k := range props {
+			required = append(required, k)
+		}
+		sort.Strings(required)
+		return map[string]any{"type": "object", "properties": props, "required": required, "additionalProperties": false}
+	}
+	str := map[string]any{"type": "string"}
+	list := func(values []string) map[string]any {
+		items := map[string]any{"type": "string"}
+		if len(values) > 0 {
+			items["enum"] = values
+		}
+		return map[string]any{"type": "array", "items": items}
+	}
+
+	enum := func(slot string) []string {
+		var values []string
+		for v := range tax[slot] {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		return values
+	}
+
+	slots := map[string]any{}
+	for _, slot := range uiFilterSlots {
+		values := enum(slot)
+		if slot == "stars" && len(values) == 0 {
+			values = []string{"1", "2", "3", "4", "5"}
+		}
+		slots[slot] = list(values)
+	}
+	transport := map[string]any{"type": "string", "enum": append([]string{""}, enum(transportSlot)...),
+		"description": "Anreiseart, leer wenn nicht genannt"}
+	purpose := map[string]any{"type": "string", "enum": append([]string{""}, enum(tripPurposeSlot)...),
+		"description": "Reiseanlass, leer wenn nicht erkennbar"}
+	return object(map[string]any{
+		"location": map[string]any{"type": "string", "description": "Ort oder Region, leer wenn nicht genannt"},
+		"departure_airports": map[string]any{"type": "array", "items": map[string]any{"type": "string"},
+			"description": "IATA-Codes der Abflughäfen (z. B. FRA), leer wenn nicht genannt"},
+		"dates":                 object(map[string]any{"checkin": str, "checkout": str}),
+		"date_flexibility_days": map[string]any{"type": "integer", "description": "± Tage um die Reisedaten, 0 wenn fest"},
+		"nights":                map[string]any{"type": "integer", "description": "Anzahl Nächte, 0 wenn nicht genannt"},
+		"transport":             transport,
+		"room_types":            list(enum(roomTypesSlot)),
+		"room_views":            list(enum(roomViewsSlot)),
+		"accessibility":         list(enum(accessSlot)),
+		"guests":                object(map[string]any{"adults": map[string]any{"type": "integer"}, "children": map[string]any{"type": "integer"}}),
+		"group_size":            map[string]any{"type": "integer", "description": "Reisende insgesamt, 0 wenn nicht genannt"},
+		"trip_purpose":          purpose,
+		"distances": map[string]any{"type": "array", "items": object(map[string]any{
+			"value":     map[string]any{"type": "number"},
+			"unit":      map[string]any{"type": "string", "enum": []string{unitMeters, unitKilometers, unitWalkMinutes, unitDriveMinutes}},
+			"reference": map[string]any{"type": "string", "enum": enum(distanceRefSlot)},
+			"meters":    map[string]any{"type": "integer", "description": "Entfernung in Metern"},
+		}), "description": "Höchstentfernungen zu Bezugspunkten, leer wenn nicht genannt"},
+		"price_max_eur": map[string]any{"type": "number", "description": "Höchstpreis pro Nacht in Euro, 0 wenn nicht genannt"},
+		"stars_min":     map[string]any{"type": "integer", "description": "0 bis 5"},
+		"rating_min":    map[string]any{"type": "number", "description": "0 bis 10"},
+		"stars_max":     map[string]any{"type": "integer", "description": "0 bis 5, 0 ohne Obergrenze"},
+		"rating_max":    map[string]any{"type": "number", "description": "0 bis 10, 0 ohne Obergrenze"},
+		"family_friendly": map[string]any{"type": []string{"boolean", "null"},
+			"description": "null, wenn nicht erwähnt"},
+		"ui_filters":           object(slots),
+		"excluded_filters":     object(slots),
+		"preferred_filters":    object(slots),
+		"unsupported_criteria": list(nil),
+	})
+}
+
+// toolsEnabled is whether provider's parse calls use the tool
+// (OPENAI_TOOLS / CLAUDE_TOOLS)
+func toolsEnabled(provider string) bool {
+	return envBool(strings.ToUpper(provider) + "_TOOLS")
+}
+
+// withParseTool has cli answer parse calls through the taxonomy tool when
+// that is switched on for its provider
+func withParseTool(cli LLMClient) LLMClient {
+	switch c := cli.(type) {
+	case *OpenAIClient:
+		if toolsEnabled("openai") {
+			c.Tool = currentParseTool()
+		}
+	case *ClaudeClient:
+		if toolsEnabled("claude") {
+			c.Tool = currentParseTool()
+		}
+	}
+	return cli
+}
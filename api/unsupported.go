@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// ====== unsupported_criteria scoring ======
+// Listing a vague wish in unsupported_criteria is a correct refusal, not an
+// extraction, so the channel is kept out of slot precision/recall and scored
+// on its own. Terms are compared case-insensitively. A query whose ground
+// truth has unsupported criteria counts as hallucinated when the answer
+// drops one of them and sets a filter the truth doesn't have instead.
+
+// UnsupportedMetrics scores the unsupported_criteria channel
+type UnsupportedMetrics struct {
+	Precision float64 `json:"precision"` // flagged terms that are unsupported
+	Recall    float64 `json:"recall"`    // unsupported terms that were flagged
+	F1        float64 `json:"f1"`
+	TP        float64 `json:"tp"`
+	FP        float64 `json:"fp"`
+	FN        float64 `json:"fn"`
+	// queries with unsupported criteria in the ground truth, and the share of
+	// them where the model guessed a filter instead of refusing
+	Queries           int     `json:"queries"`
+	HallucinationRate float64 `json:"hallucination_rate"`
+}
+
+type unsupAcc struct {
+	tp, fp, fn    float64
+	queries       float64
+	hallucinating float64
+}
+
+func unsupportedTerms(p ParseResponse) map[string]bool {
+	terms := map[string]bool{}
+	for _, v := range p.UnsupportedCriteria {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			terms[v] = true
+		}
+	}
+	return terms
+}
+
+func (u *unsupAcc) add(pred, gt ParseResponse, w float64) {
+	pTerms, gTerms := unsupportedTerms(pred), unsupportedTerms(gt)
+	missed := false
+	for t := range pTerms {
+		if gTerms[t] {
+			u.tp += w
+		} else {
+			u.fp += w
+		}
+	}
+	for t := range gTerms {
+		if !pTerms[t] {
+			u.fn += w
+			missed = true
+		}
+	}
+	if len(gTerms) == 0 {
+		return
+	}
+	u.queries += w
+	if !missed {
+		return
+	}
+	gSet := flatten(gt)
+	for k := range flatten(pred) {
+		if slot := slotNameOf(k); slot != "unsupported" && !gSet[k] {
+			u.hallucinating += w
+			return
+		}
+	}
+}
+
+func (u *unsupAcc) metrics() *UnsupportedMetrics {
+	if u.tp+u.fp+u.fn == 0 && u.queries == 0 {
+		return nil
+	}
+	p, r := fdiv(u.tp, u.tp+u.fp), fdiv(u.tp, u.tp+u.fn)
+	return &UnsupportedMetrics{
+		Precision:         round2(p),
+		Recall:            round2(r),
+		F1:                round2(harm(p, r)),
+		TP:                round2(u.tp),
+		FP:                round2(u.fp),
+		FN:                round2(u.fn),
+		Queries:           int(math.Round(u.queries)),
+		HallucinationRate: round2(fdiv(u.hallucinating, u.queries)),
+	}
+}
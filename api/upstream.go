@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== Upstream HTTP ======
+// Provider clients are built once at startup (and again on reload) and
+// share one pooled transport, so connections and TLS sessions are reused
+// across requests. The transport uses UPSTREAM_PROXY when set and the
+// usual HTTPS_PROXY/HTTP_PROXY/NO_PROXY otherwise, and trusts the CA bundle
+// in UPSTREAM_CA_FILE in addition to the system roots. The transport itself
+// is built once, so changing the proxy or the CA bundle needs a restart.
+// <PROVIDER>_EXTRA_HEADERS (a JSON object) adds headers to every call, e.g.
+// for proxy authentication.
+
+var upstream struct {
+	once      sync.Once
+	transport *http.Transport
+	err       error
+}
+
+func upstreamTransport() (*http.Transport, error) {
+	upstream.once.Do(func() {
+		upstream.transport, upstream.err = newUpstreamTransport()
+	})
+	return upstream.transport, upstream.err
+}
+
+func newUpstreamTransport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 100
+	t.MaxIdleConnsPerHost = envInt("UPSTREAM_MAX_IDLE_PER_HOST", 32)
+	t.IdleConnTimeout = envDuration("UPSTREAM_IDLE_TIMEOUT", 90*time.Second)
+	t.TLSHandshakeTimeout = 10 * time.Second
+	if v := os.Getenv("UPSTREAM_PROXY"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid UPSTREAM_PROXY %q", v)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if path := os.Getenv("UPSTREAM_CA_FILE"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("UPSTREAM_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("UPSTREAM_CA_FILE: no certificates in %s", path)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	// HTTPS goes through the proxy via CONNECT, which doesn't carry request
+	// headers; send the provider's Proxy-* headers there instead
+	t.GetProxyConnectHeader = func(_ context.Context, _ *url.URL, target string) (http.Header, error) {
+		h := http.Header{}
+		for _, provider := range []string{"openai", "claude"} {
+			if providerHost(provider) != target {
+				continue
+			}
+			extra, _ := extraHeaders(provider)
+			for k, v := range extra {
+				if strings.HasPrefix(http.CanonicalHeaderKey(k), "Proxy-") {
+					h.Set(k, v)
+				}
+			}
+		}
+		return h, nil
+	}
+	return t, nil
+}
+
+// providerHost is host:port of the provider's base URL
+func providerHost(provider string) string {
+	base := os.Getenv(strings.ToUpper(provider) + "_BASE_URL")
+	if base == "" {
+		base = map[string]string{"openai": "https://api.openai.com", "claude": "https://api.anthropic.com"}[provider]
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "http" {
+		return u.Host + ":80"
+	}
+	return u.Host + ":443"
+}
+
+// upstreamClient is the HTTP client for one provider's calls
+func upstreamClient(provider string) (*http.Client, error) {
+	t, err := upstreamTransport()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotConfigured, err)
+	}
+	return &http.Client{Transport: captureTransport{base: t}, Timeout: providerTimeout(provider)}, nil
+}
+
+// extraHeaders parses <PROVIDER>_EXTRA_HEADERS, e.g.
+// {"Proxy-Authorization":"Basic ..."}
+func extraHeaders(provider string) (map[string]string, error) {
+	key := strings.ToUpper(provider) + "_EXTRA_HEADERS"
+	v := os.Getenv(key)
+	if v == "" {
+		return nil, nil
+	}
+	var h map[string]string
+	if err := json.Unmarshal([]byte(v), &h); err != nil {
+		return nil, fmt.Errorf("%w: %s must be a JSON object of strings", errNotConfigured, key)
+	}
+	return h, nil
+}
+
+func setHeaders(req *http.Request, h map[string]string) {
+	for k, v := range h {
+		req.Header.Set(k, v)
+	}
+}
+
+// providerClients holds the clients built from the current configuration;
+// a provider that isn't configured keeps its error instead
+var providerClients struct {
+	sync.RWMutex
+	openai    *OpenAIClient
+	claude    *ClaudeClient
+	openaiErr error
+	claudeErr error
+}
+
+// buildClients (re)creates the provider clients from the environment
+func buildClients() {
+	openai, openaiErr := NewOpenAIClient()
+	claude, claudeErr := NewClaudeClient()
+	providerClients.Lock()
+	providerClients.openai, providerClients.openaiErr = openai, openaiErr
+	providerClients.claude, providerClients.claudeErr = claude, claudeErr
+	providerClients.Unlock()
+}
+
+// clientFor returns a copy of the shared client (same transport) with the
+// model overridden when model is set
+func clientFor(provider, model string) (LLMClient, error) {
+	providerClients.RLock()
+	defer providerClients.RUnlock()
+	switch provider {
+	case "claude":
+		if providerClients.claudeErr != nil {
+			return nil, providerClients.claudeErr
+		}
+		cli := *providerClients.claude
+		if model != "" {
+			cli.Model = model
+		}
+		return &cli, nil
+	case "openai", "":
+		if providerClients.openaiErr != nil {
+			return nil, providerClients.openaiErr
+		}
+		cli := *providerClients.openai
+		if model != "" {
+			cli.Model = model
+		}
+		return &cli, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", provider)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== API keys, usage and quotas ======
+// API_KEYS (a JSON list) turns on client authentication:
+//
+//	API_KEYS=[{"name":"web","key":"...","quota":{"requests":10000,"tokens":5000000,"cost_usd":25}}]
+//
+// Parse requests and every endpoint serving stored data (results, search,
+// diffs, exports, evaluations, ground truth, suggestions, GraphQL and the
+// live feed) then need "Authorization: Bearer <key>" or the admin token;
+// see requireKey. Every parse request is
+// counted against its key for the current USAGE_PERIOD (UTC "month", the
+// default, or "day"): requests, tokens and the cost estimated from
+// MODEL_PRICES. A key past any of its quotas gets 429 until the period
+// ends. Without API_KEYS parsing stays open and usage is counted under
+// "anonymous".
+//
+// Counters live in memory and are rebuilt from the stored runs at startup,
+// so requests that failed before a restart aren't counted again. They are
+// per replica: behind a load balancer each replica only sees its own
+// requests after startup, so a key can use up to replicas × its quota.
+// Route by key or divide the quotas by the replica count.
+
+const anonymousKey = "anonymous"
+
+type APIKey struct {
+	Name string `json:"name" yaml:"name"`
+	Key  string `json:"key" yaml:"key"`
+	// Tenant whose data the key sees and writes ("" = default)
+	Tenant string `json:"tenant,omitempty" yaml:"tenant"`
+	Quota  Quota  `json:"quota" yaml:"quota"`
+}
+
+// Quota limits one period; zero means unlimited
+type Quota struct {
+	Requests int64   `json:"requests,omitempty" yaml:"requests"`
+	Tokens   int64   `json:"tokens,omitempty" yaml:"tokens"`
+	CostUSD  float64 `json:"cost_usd,omitempty" yaml:"cost_usd"`
+}
+
+// apiKeys parses API_KEYS; nil when authentication is off
+func apiKeys() ([]APIKey, error) {
+	v := os.Getenv("API_KEYS")
+	if v == "" {
+		return nil, nil
+	}
+	var keys []APIKey
+	if err := json.Unmarshal([]byte(v), &keys); err != nil {
+		return nil, fmt.Errorf("API_KEYS: %w", err)
+	}
+	return keys, nil
+}
+
+// authenticate finds the caller's key. With authentication off every
+// caller is anonymous.
+func authenticate(r *http.Request) (*APIKey, *Problem) {
+	keys, err := apiKeys()
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return nil, newProblem(http.StatusInternalServerError, codeInternal, "API keys are misconfigured")
+	}
+	if keys == nil {
+		return &APIKey{Name: anonymousKey}, nil
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok {
+		for i := range keys {
+			if subtle.ConstantTimeCompare([]byte(got), []byte(keys[i].Key)) == 1 {
+				return &keys[i], nil
+			}
+		}
+	}
+	return nil, newProblem(http.StatusUnauthorized, codeUnauthorized, "missing or invalid API key")
+}
+
+// requireKey lets callers with an API key or the admin token through;
+// everyone when authentication is off
+func requireKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			if _, p := authenticate(r); p != nil {
+				writeProblem(w, p)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// ----- usage counters -----
+
+type KeyUsage struct {
+	Key          string  `json:"key"`
+	Requests     int64   `json:"requests"`
+	Tokens       int64   `json:"tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+	Unpriced     int64   `json:"unpriced_calls,omitempty"`  // calls without a price or token usage
+	Rejected     int64   `json:"rejected_inputs,omitempty"` // screened out, not restored on restart
+	Quota        *Quota  `json:"quota,omitempty"`
+	QuotaReached bool    `json:"quota_reached,omitempty"`
+}
+
+type UsageReport struct {
+	Period string     `json:"period"`
+	Start  time.Time  `json:"start"`
+	End    time.Time  `json:"end"`
+	Keys   []KeyUsage `json:"keys"`
+}
+
+var usage = struct {
+	sync.Mutex
+	start time.Time
+	keys  map[string]*KeyUsage
+}{keys: map[string]*KeyUsage{}}
+
+func usagePeriod() string {
+	if strings.EqualFold(os.Getenv("USAGE_PERIOD"), "day") {
+		return "day"
+	}
+	return "month"
+}
+
+// periodBounds is the UTC period containing t
+func periodBounds(t time.Time) (start, end time.Time) {
+	t = t.UTC()
+	if usagePeriod() == "day" {
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	}
+	start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// usageFor returns key's counters, starting over when a new period began.
+// The caller holds usage's lock.
+func usageFor(key string, now time.Time) *KeyUsage {
+	if start, _ := periodBounds(now); !start.Equal(usage.start) {
+		usage.start, usage.keys = start, map[string]*KeyUsage{}
+	}
+	u := usage.keys[key]
+	if u == nil {
+		u = &KeyUsage{Key: key}
+		usage.keys[key] = u
+	}
+	return u
+}
+
+// recordUsage counts one parse request
+func recordUsage(key string, t time.Time, res MultiParseResponse) {
+	prices, err := modelPrices()
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	usage.Lock()
+	defer usage.Unlock()
+	u := usageFor(key, t)
+	u.Requests++
+	for _, st := range res.Status {
+		if st.Usage == nil {
+			u.Unpriced++
+			continue
+		}
+		u.Tokens += int64(st.Usage.PromptTokens + st.Usage.CompletionTokens)
+		model := ""
+		if st.Repro != nil {
+			model = st.Repro.Model
+		}
+		if price, ok := priceFor(prices, model); ok {
+			u.CostUSD += price.cost(*st.Usage)
+		} else {
+			u.Unpriced++
+		}
+	}
+}
+
+// loadUsage rebuilds the current period's counters from the stored runs
+func loadUsage() {
+	start, _ := periodBounds(time.Now())
+	err := store.Each(func(run StoredResult) error {
+		if run.Shadow == nil && run.Replay == nil && !run.Time.Before(start) {
+			recordUsage(cmp.Or(run.APIKey, anonymousKey), run.Time, run.Response)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] rebuilding usage: %v", err)
+	}
+}
+
+// checkQuota answers 429 once key used up any of its quotas
+func checkQuota(key *APIKey) *Problem {
+	q := key.Quota
+	if q == (Quota{}) {
+		return nil
+	}
+	now := time.Now()
+	usage.Lock()
+	u := *usageFor(key.Name, now)
+	usage.Unlock()
+	if !quotaReached(u, q) {
+		return nil
+	}
+	_, end := periodBounds(now)
+	p := newProblem(http.StatusTooManyRequests, codeQuotaExceeded,
+		fmt.Sprintf("quota of %s for this %s is used up", key.Name, usagePeriod()))
+	p.RetryAfter = int(math.Ceil(end.Sub(now).Seconds()))
+	return p
+}
+
+func quotaReached(u KeyUsage, q Quota) bool {
+	return (q.Requests > 0 && u.Requests >= q.Requests) ||
+		(q.Tokens > 0 && u.Tokens >= q.Tokens) ||
+		(q.CostUSD > 0 && u.CostUSD >= q.CostUSD)
+}
+
+// usageReport lists the keys' usage; only is the caller's key, nil for all
+func usageReport(only *APIKey) UsageReport {
+	now := time.Now()
+	start, end := periodBounds(now)
+	keys, _ := apiKeys()
+	quotas := map[string]Quota{}
+	for _, k := range keys {
+		quotas[k.Name] = k.Quota
+	}
+
+	usage.Lock()
+	defer usage.Unlock()
+	rep := UsageReport{Period: usagePeriod(), Start: start, End: end, Keys: []KeyUsage{}}
+	names := []string{}
+	if only != nil {
+		names = append(names, only.Name)
+	} else {
+		for _, k := range keys {
+			names = append(names, k.Name)
+		}
+		for name := range usage.keys {
+			if _, ok := quotas[name]; !ok {
+				names = append(names, name) // keys since removed, or anonymous
+			}
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		u := *usageFor(name, now)
+		u.CostUSD = math.Round(u.CostUSD*10000) / 10000
+		if q, ok := quotas[name]; ok && q != (Quota{}) {
+			u.Quota = &q
+			u.QuotaReached = quotaReached(u, q)
+		}
+		rep.Keys = append(rep.Keys, u)
+	}
+	return rep
+}
+
+// usageHandler serves GET /v1/usage: a client key sees its own usage, the
+// admin token (or anyone, without API_KEYS) every key's
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	var only *APIKey
+	if !isAdmin(r) {
+		key, p := authenticate(r)
+		if p != nil {
+			writeProblem(w, p)
+			return
+		}
+		if key.Name != anonymousKey {
+			only = key
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(usageReport(only))
+}
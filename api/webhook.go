@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// ====== Webhook callbacks ======
+// A parse request with callback_url is answered with 202 + run ID; the
+// MultiParseResponse is POSTed to the callback when done. The body is
+// signed with HMAC-SHA256 over "<timestamp>.<body>" using WEBHOOK_SECRET:
+//
+//	X-Run-ID: <run id>
+//	X-Parse-Status: ok | error
+//	X-Parse-Error: <code>: <detail>, on error
+//	X-Signature-Timestamp: <unix seconds>
+//	X-Signature: sha256=<hex>
+//
+// Callbacks are off without WEBHOOK_SECRET: nothing is sent unsigned.
+// WEBHOOK_ALLOWED_HOSTS (comma-separated) lists the only hosts callbacks go
+// to. Without it, any host resolving to public addresses only is accepted;
+// loopback, private, link-local and similar addresses are refused when the
+// request comes in and again when connecting, so a host can't be pointed
+// at the internal network after the check.
+
+var webhookClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}).DialContext,
+	},
+}
+
+// validateCallbackURL accepts absolute http(s) URLs to an allowed host, or
+// without WEBHOOK_ALLOWED_HOSTS to a host with public addresses only
+func validateCallbackURL(ctx context.Context, raw string) error {
+	if os.Getenv("WEBHOOK_SECRET") == "" {
+		return errors.New("callbacks need WEBHOOK_SECRET on the server")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return errors.New("host is required")
+	}
+	if allowed := os.Getenv("WEBHOOK_ALLOWED_HOSTS"); allowed != "" {
+		for _, h := range strings.Split(allowed, ",") {
+			if strings.EqualFold(strings.TrimSpace(h), u.Hostname()) {
+				return nil
+			}
+		}
+		return errors.New("host not allowed")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("host does not resolve: %w", err)
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return fmt.Errorf("host resolves to non-public address %s", a.IP)
+		}
+	}
+	return nil
+}
+
+// sharedAddressSpace is carrier-grade NAT (RFC 6598); some networks use it
+// for internal hosts
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is routable on the internet
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// headerValue drops control characters (CR/LF among them) from text that
+// goes into a header
+func headerValue(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// dialPublicOnly refuses connections to non-public addresses unless
+// WEBHOOK_ALLOWED_HOSTS vouches for the hosts
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	if os.Getenv("WEBHOOK_ALLOWED_HOSTS") != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("callback to non-public address %s refused", host)
+	}
+	return nil
+}
+
+// deliverCallback POSTs the result, retrying a few times on failure
+func deliverCallback(callbackURL, runID string, payload any, fail *Problem) {
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		log.Printf("[ERROR] webhook %s: WEBHOOK_SECRET is not set, not sending an unsigned callback", runID)
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[ERROR] webhook %s: %v", runID, err)
+		return
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+		if err != nil {
+			cancel()
+			log.Printf("[ERROR] webhook %s: %v", runID, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Run-ID", runID)
+		if fail != nil {
+			req.Header.Set("X-Parse-Status", "error")
+			req.Header.Set("X-Parse-Error", headerValue(fail.Code+": "+fail.Detail))
+		} else {
+			req.Header.Set("X-Parse-Status", "ok")
+		}
+		req.Header.Set("X-Signature-Timestamp", ts)
+		req.Header.Set("X-Signature", "sha256="+signWebhook(secret, ts, body))
+
+		res, err := webhookClient.Do(req)
+		cancel()
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode < 300 {
+				log.Printf("[INFO] webhook %s delivered", runID)
+				return
+			}
+			err = errors.New(res.Status)
+		}
+		log.Printf("[WARN] webhook %s attempt %d failed: %v", runID, attempt, err)
+		time.Sleep(time.Duration(attempt*attempt) * time.Second)
+	}
+	log.Printf("[ERROR] webhook %s: giving up", runID)
+}
+
+func signWebhook(secret, ts string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts + "."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
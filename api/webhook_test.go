@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8":         true,
+		"100.63.255.255":  true,
+		"100.64.0.1":      false,
+		"100.127.255.254": false,
+		"100.128.0.1":     true,
+		"10.0.0.1":        false,
+		"127.0.0.1":       false,
+		"169.254.169.254": false,
+		"::1":             false,
+		"2001:4860::8888": true,
+	} {
+		if got := publicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("publicIP(%s) = %t, want %t", addr, got, want)
+		}
+	}
+}
+
+func TestHeaderValue(t *testing.T) {
+	if got := headerValue("schema_violation: bad\r\nX-Injected: 1\tx"); got != "schema_violation: badX-Injected: 1x" {
+		t.Errorf("headerValue = %q", got)
+	}
+}
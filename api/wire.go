@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ====== Binary encodings ======
+// /v1/parse answers in JSON unless the Accept header asks for one of:
+//
+//	application/x-protobuf (or application/protobuf)   proto/parse.proto,
+//	                                                   message ParseResult
+//	application/msgpack (or application/x-msgpack)     the JSON document as
+//	                                                   MessagePack
+//
+// For high-volume backend callers both are smaller and cheaper to decode
+// than JSON. MessagePack carries exactly what the JSON would, so it works
+// with every schema version, locale and ?fields=/?compact= projection.
+// Protobuf is schema version 1 with the iso locale only (anything else is
+// a 406); it has the answers and the main status fields, and leaves out the
+// debugging extras (confidence, escalation, stages, contract). The .proto
+// is served at GET /v1/proto/parse.proto for generating client types; it
+// and protoParseResult below must be changed together. Problems and
+// callback payloads stay JSON.
+//
+// Like the Thrift writer in parquet.go, both encoders are small and
+// hand-written, so the service needs no code generator.
+
+//go:embed proto/parse.proto
+var parseProto []byte
+
+const (
+	encodingJSON     = "json"
+	encodingProtobuf = "protobuf"
+	encodingMsgpack  = "msgpack"
+)
+
+// responseEncodings maps the accepted media types to the encoding and the
+// Content-Type it is answered with
+var responseEncodings = map[string][2]string{
+	"application/x-protobuf":          {encodingProtobuf, "application/x-protobuf"},
+	"application/protobuf":            {encodingProtobuf, "application/x-protobuf"},
+	"application/vnd.google.protobuf": {encodingProtobuf, "application/x-protobuf"},
+	"application/msgpack":             {encodingMsgpack, "application/msgpack"},
+	"application/x-msgpack":           {encodingMsgpack, "application/msgpack"},
+	"application/vnd.msgpack":         {encodingMsgpack, "application/msgpack"},
+}
+
+// negotiateEncoding picks the response encoding from the Accept header: the
+// first binary type listed, else JSON. Errors are client-safe.
+func negotiateEncoding(r *http.Request, schema int, loc outputLocale) (encoding, contentType string, err error) {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		enc, ok := responseEncodings[strings.ToLower(mediaType)]
+		if !ok {
+			continue
+		}
+		if enc[0] == encodingProtobuf {
+			if schema != schemaV1 {
+				return "", "", fmt.Errorf("protobuf is only available for schema 1")
+			}
+			if loc.name != "iso" {
+				return "", "", fmt.Errorf("protobuf is only available with the iso locale")
+			}
+		}
+		return enc[0], enc[1], nil
+	}
+	return encodingJSON, "application/json", nil
+}
+
+// encodeResponse renders response (as written by json.Marshal) in encoding
+func encodeResponse(response any, encoding string) ([]byte, error) {
+	b, err := json.Marshal(response)
+	if err != nil || encoding == encodingJSON {
+		return append(b, '\n'), err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	v, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == encodingMsgpack {
+		return appendMsgpack(nil, v)
+	}
+	obj, ok := v.(jsonObject)
+	if !ok {
+		return nil, errors.New("response is not an object")
+	}
+	return appendProto(nil, obj, protoParseResult)
+}
+
+// protoDocHandler serves the .proto of the protobuf encoding
+func protoDocHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, "use GET"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(parseProto)
+}
+
+// --- MessagePack ---
+
+// appendMsgpack appends v, a value from decodeOrdered, to b. Numbers are
+// written as the smallest integer that holds them, else as float64.
+func appendMsgpack(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, n), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f)), nil
+	case string:
+		b = appendMsgpackHeader(b, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		return append(b, v...), nil
+	case []any:
+		b = appendMsgpackHeader(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			var err error
+			if b, err = appendMsgpack(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case jsonObject:
+		b = appendMsgpackHeader(b, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, f := range v {
+			b = appendMsgpackHeader(b, len(f.Key), 0xa0, 31, 0xd9, 0xda, 0xdb)
+			b = append(b, f.Key...)
+			var err error
+			if b, err = appendMsgpack(b, f.Value); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: unexpected %T", v)
+}
+
+// appendMsgpackHeader writes a length: fixed up to fixMax, else with the
+// 8-bit (if the type has one), 16- or 32-bit marker
+func appendMsgpackHeader(b []byte, n int, fix byte, fixMax int, m8, m16, m32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(b, fix|byte(n))
+	case m8 != 0 && n <= math.MaxUint8:
+		return append(b, m8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, m16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, m32), uint32(n))
+}
+
+func appendMsgpackInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= 127, n < 0 && n >= -32:
+		return append(b, byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+}
+
+// --- Protocol Buffers ---
+
+type protoKind int
+
+const (
+	protoString protoKind = iota
+	protoInt              // int32/int64: varint
+	protoBool
+	protoDouble
+	protoMessage
+	protoMap // map<string, value>
+)
+
+// protoField is one field of a message in proto/parse.proto
+type protoField struct {
+	num      int
+	kind     protoKind
+	repeated bool
+	optional bool // explicit presence: zero values are written
+	msg      protoMessageType
+	value    *protoField // map values; num is ignored
+}
+
+// protoMessageType maps JSON names to fields; JSON fields it doesn't name
+// are left out
+type protoMessageType map[string]protoField
+
+var protoUiFilters = protoMessageType{}
+
+func init() {
+	for i, name := range []string{"meals", "ratings", "hotelTypes", "hotelfacilities", "poolbeach", "distanceBeach", "travelGroup", "stars", "wellness", "reference_distance_max", "flex", "children", "parking", "freetime", "certifications", "hotelthemes", "hotelBrand", "hotelinformation"} {
+		protoUiFilters[name] = protoField{num: i + 1, kind: protoString, repeated: true}
+	}
+}
+
+var protoParseResponse = protoMessageType{
+	"schema_version":        {num: 1, kind: protoInt},
+	"location":              {num: 2, kind: protoString},
+	"departure_airports":    {num: 3, kind: protoString, repeated: true},
+	"dates":                 {num: 4, kind: protoMessage, msg: protoMessageType{"checkin": {num: 1, kind: protoString}, "checkout": {num: 2, kind: protoString}}},
+	"date_flexibility_days": {num: 5, kind: protoInt},
+	"nights":                {num: 6, kind: protoInt},
+	"transport":             {num: 7, kind: protoString},
+	"room_types":            {num: 8, kind: protoString, repeated: true},
+	"room_views":            {num: 9, kind: protoString, repeated: true},
+	"accessibility":         {num: 10, kind: protoString, repeated: true},
+	"guests":                {num: 11, kind: protoMessage, msg: protoMessageType{"adults": {num: 1, kind: protoInt}, "children": {num: 2, kind: protoInt}}},
+	"group_size":            {num: 12, kind: protoInt},
+	"trip_purpose":          {num: 13, kind: protoString},
+	"distances": {num: 14, kind: protoMessage, repeated: true, msg: protoMessageType{
+		"value": {num: 1, kind: protoDouble}, "unit": {num: 2, kind: protoString},
+		"reference": {num: 3, kind: protoString}, "meters": {num: 4, kind: protoInt},
+	}},
+	"price_max_eur":        {num: 15, kind: protoDouble},
+	"stars_min":            {num: 16, kind: protoInt},
+	"stars_max":            {num: 17, kind: protoInt},
+	"rating_min":           {num: 18, kind: protoDouble},
+	"rating_max":           {num: 19, kind: protoDouble},
+	"family_friendly":      {num: 20, kind: protoBool, optional: true},
+	"ui_filters":           {num: 21, kind: protoMessage, msg: protoUiFilters},
+	"excluded_filters":     {num: 22, kind: protoMessage, msg: protoUiFilters},
+	"preferred_filters":    {num: 23, kind: protoMessage, msg: protoUiFilters},
+	"unsupported_criteria": {num: 24, kind: protoString, repeated: true},
+	"assumptions": {num: 25, kind: protoMessage, repeated: true, msg: protoMessageType{
+		"phrase": {num: 1, kind: protoString}, "field": {num: 2, kind: protoString}, "value": {num: 3, kind: protoString},
+	}},
+	"event": {num: 26, kind: protoMessage, msg: protoMessageType{
+		"name": {num: 1, kind: protoString}, "location": {num: 2, kind: protoString},
+		"from": {num: 3, kind: protoString}, "to": {num: 4, kind: protoString},
+	}},
+}
+
+var protoProviderStatus = protoMessageType{
+	"status":     {num: 1, kind: protoString},
+	"code":       {num: 2, kind: protoString},
+	"detail":     {num: 3, kind: protoString},
+	"stage":      {num: 4, kind: protoString},
+	"latency_ms": {num: 5, kind: protoInt},
+	"usage": {num: 6, kind: protoMessage, msg: protoMessageType{
+		"prompt_tokens": {num: 1, kind: protoInt}, "completion_tokens": {num: 2, kind: protoInt},
+	}},
+	"tier":                  {num: 7, kind: protoString},
+	"cached":                {num: 8, kind: protoBool},
+	"calibrated_confidence": {num: 9, kind: protoDouble, optional: true},
+	"timings_us":            {num: 10, kind: protoMap, value: &protoField{kind: protoInt}},
+	"repro": {num: 11, kind: protoMessage, msg: protoMessageType{
+		"model":              {num: 1, kind: protoString},
+		"seed":               {num: 2, kind: protoInt, optional: true},
+		"temperature":        {num: 3, kind: protoDouble, optional: true},
+		"top_p":              {num: 4, kind: protoDouble, optional: true},
+		"system_fingerprint": {num: 5, kind: protoString},
+		"tool_schema":        {num: 6, kind: protoString},
+	}},
+}
+
+var protoParseResult = protoMessageType{
+	"schema_version": {num: 1, kind: protoInt},
+	"openai":         {num: 2, kind: protoMessage, msg: protoParseResponse},
+	"claude":         {num: 3, kind: protoMessage, msg: protoParseResponse},
+	"status":         {num: 4, kind: protoMap, value: &protoField{kind: protoMessage, msg: protoProviderStatus}},
+	"prompt_hash":    {num: 5, kind: protoString},
+	"pipeline":       {num: 6, kind: protoString},
+	"degraded":       {num: 7, kind: protoBool},
+	"rules":          {num: 8, kind: protoMessage, msg: protoParseResponse},
+	"intent": {num: 9, kind: protoMessage, msg: protoMessageType{
+		"label":      {num: 1, kind: protoString},
+		"source":     {num: 2, kind: protoString},
+		"message_de": {num: 3, kind: protoString},
+	}},
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// appendProto appends obj encoded as msg to b
+func appendProto(b []byte, obj jsonObject, msg protoMessageType) ([]byte, error) {
+	for _, f := range obj {
+		field, ok := msg[f.Key]
+		if !ok || f.Value == nil {
+			continue
+		}
+		var err error
+		switch {
+		case field.repeated:
+			values, ok := f.Value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("protobuf: %s is not a list", f.Key)
+			}
+			for _, v := range values {
+				if b, err = appendProtoValue(b, field, v, true); err != nil {
+					return nil, fmt.Errorf("protobuf: %s: %w", f.Key, err)
+				}
+			}
+		case field.kind == protoMap:
+			entries, ok := f.Value.(jsonObject)
+			if !ok {
+				return nil, fmt.Errorf("protobuf: %s is not an object", f.Key)
+			}
+			for _, e := range entries {
+				entry := appendProtoTag(nil, 1, wireBytes)
+				entry = appendProtoBytes(entry, []byte(e.Key))
+				if entry, err = appendProtoValue(entry, protoField{num: 2, kind: field.value.kind, msg: field.value.msg}, e.Value, true); err != nil {
+					return nil, fmt.Errorf("protobuf: %s.%s: %w", f.Key, e.Key, err)
+				}
+				b = appendProtoBytes(appendProtoTag(b, field.num, wireBytes), entry)
+			}
+		default:
+			if b, err = appendProtoValue(b, field, f.Value, field.optional); err != nil {
+				return nil, fmt.Errorf("protobuf: %s: %w", f.Key, err)
+			}
+		}
+	}
+	return b, nil
+}
+
+// appendProtoValue appends one value of field; zero values are left out
+// unless force is set (list elements, map values and optional fields)
+func appendProtoValue(b []byte, field protoField, v any, force bool) ([]byte, error) {
+	switch field.kind {
+	case protoString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("want a string, got %T", v)
+		}
+		if s == "" && !force {
+			return b, nil
+		}
+		return appendProtoBytes(appendProtoTag(b, field.num, wireBytes), []byte(s)), nil
+	case protoInt:
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("want an integer, got %T", v)
+		}
+		i, err := strconv.ParseInt(n.String(), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 && !force {
+			return b, nil
+		}
+		return binary.AppendUvarint(appendProtoTag(b, field.num, wireVarint), uint64(i)), nil
+	case protoBool:
+		t, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("want a bool, got %T", v)
+		}
+		if !t && !force {
+			return b, nil
+		}
+		var u uint64
+		if t {
+			u = 1
+		}
+		return binary.AppendUvarint(appendProtoTag(b, field.num, wireVarint), u), nil
+	case protoDouble:
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("want a number, got %T", v)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, err
+		}
+		if f == 0 && !force {
+			return b, nil
+		}
+		return binary.LittleEndian.AppendUint64(appendProtoTag(b, field.num, wireFixed64), math.Float64bits(f)), nil
+	case protoMessage:
+		obj, ok := v.(jsonObject)
+		if !ok {
+			return nil, fmt.Errorf("want an object, got %T", v)
+		}
+		inner, err := appendProto(nil, obj, field.msg)
+		if err != nil {
+			return nil, err
+		}
+		return appendProtoBytes(appendProtoTag(b, field.num, wireBytes), inner), nil
+	}
+	return nil, fmt.Errorf("unexpected field kind %d", field.kind)
+}
+
+func appendProtoTag(b []byte, num, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(wireType))
+}
+
+func appendProtoBytes(b, v []byte) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(v))), v...)
+}
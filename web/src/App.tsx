@@ -1,4 +1,4 @@
-import { useState } from 'react'
+import { useEffect, useRef, useState } from 'react'
 import EvaluationsPage from './pages/EvaluationsPage'
 
 type Dates = { checkin: string; checkout: string }
@@ -25,18 +25,118 @@ type UiFilters = {
 }
 type ParseResponse = {
   location: string
+  departure_airports: string[]
   dates: Dates
+  date_flexibility_days: number
+  nights: number
+  transport: string
+  room_types: string[]
+  room_views: string[]
+  accessibility: string[]
   guests: Guests
+  group_size: number
+  trip_purpose: string
+  distances: { value: number; unit: string; reference: string; meters: number }[]
   price_max_eur: number
   stars_min: number
+  stars_max: number
   rating_min: number
-  family_friendly: boolean
+  rating_max: number
+  family_friendly: boolean | null
   ui_filters: UiFilters
+  excluded_filters: UiFilters
+  preferred_filters: UiFilters
   unsupported_criteria: string[]
+  // values set by the interpretation policy (assisted mode)
+  assumptions?: { phrase: string; field: string; value: string }[]
+  // the event the dates were taken from ("während des Oktoberfests")
+  event?: { name: string; location: string; from: string; to: string }
+}
+type ProviderStatus = {
+  status: 'ok' | 'error'
+  code?: string
+  detail?: string
+  latency_ms: number
 }
 type MultiParseResponse = {
   openai?: ParseResponse
   claude?: ParseResponse
+  status?: Record<string, ProviderStatus>
+  // set when every provider failed and `rules` is the rules-based fallback
+  degraded?: boolean
+  rules?: ParseResponse
+  // set when the server checks answers against the downstream contract
+  contract?: Record<string, { valid: boolean; errors?: string[]; warnings?: string[] }>
+  // how much each filter narrows the results, per answer
+  impact?: Record<string, FilterImpact>
+  // set when the server labels queries; non-searches come without answers
+  intent?: { label: 'hotel_search' | 'booking_support' | 'other'; source: string; message_de?: string }
+}
+type FilterImpact = {
+  source: 'search_api' | 'dataset'
+  scope: number
+  matches: number
+  over_constrained?: boolean
+  filters: { filter: string; remaining: number; removed: number; narrow?: boolean }[]
+  error?: string
+}
+type Suggestion = {
+  text: string
+  slot?: string
+  value?: string
+  count: number
+}
+type ProviderHealth = {
+  name: string
+  available: boolean
+  breaker: 'closed' | 'open' | 'half_open'
+  model?: string
+}
+type Problem = {
+  code: string
+  detail?: string
+  message_de: string
+  providers?: Record<string, { code: string; detail: string }>
+  estimated_wait_ms?: number
+}
+
+// retries of a parse request the server rejected as overloaded
+const overloadRetries = 3
+
+// sleep resolves after ms, or rejects when signal aborts
+const sleep = (ms: number, signal: AbortSignal) =>
+  new Promise<void>((resolve, reject) => {
+    const timer = setTimeout(resolve, ms)
+    signal.addEventListener('abort', () => {
+      clearTimeout(timer)
+      reject(new DOMException('aborted', 'AbortError'))
+    })
+  })
+
+// answers with fewer matches get relaxation tips from /v1/relax
+const fewResults = 5
+
+// ImpactNote warns about filters that leave few or no hotels
+function ImpactNote({ impact, tip }: { impact?: FilterImpact; tip?: string }) {
+  if (!impact || impact.error) return null
+  const narrow = impact.filters.filter((f) => f.narrow)
+  if (!impact.over_constrained && narrow.length === 0 && !tip) return null
+  return (
+    <div style={{ color: '#b45309', marginTop: 8 }}>
+      {tip ?? (impact.over_constrained
+        ? `Keine Treffer mit allen Filtern (${impact.scope} ohne Filter).`
+        : `${impact.matches} von ${impact.scope} Hotels passen.`)}
+      {impact.filters.length > 0 && (
+        <ul style={{ margin: '4px 0 0', paddingLeft: 20 }}>
+          {(narrow.length > 0 ? narrow : impact.filters.slice(0, 3)).map((f) => (
+            <li key={f.filter}>
+              {f.filter}: schließt {Math.round(f.removed * 100)} % aus
+            </li>
+          ))}
+        </ul>
+      )}
+    </div>
+  )
 }
 
 function App() {
@@ -44,40 +144,142 @@ function App() {
     'Familienfreundliches Hotel mit Frühstück und WLAN unter 120€ in Berlin vom 12.–14.10.2025, 2 Erwachsene, 1 Kind.'
   )
   const [provider, setProvider] = useState<"openai" | "claude" | "both">("openai")
+  const [assisted, setAssisted] = useState(false)
+  const [accessible, setAccessible] = useState(false)
   const [result, setResult] = useState<MultiParseResponse | null>(null)
+  // German relaxation tips per answer, for answers with few matches
+  const [tips, setTips] = useState<Record<string, string>>({})
   const [error, setError] = useState<string | null>(null)
   const [loading, setLoading] = useState(false)
+  // shown while waiting out an overloaded answer before retrying
+  const [busyNotice, setBusyNotice] = useState<string | null>(null)
   const [showEval, setShowEval] = useState(false)
+  const [available, setAvailable] = useState<Record<string, boolean>>({})
+  // aborting the fetch closes the connection, which cancels the provider calls
+  const inflight = useRef<AbortController | null>(null)
+
+  // grey out providers that are unconfigured, disabled or failing
+  useEffect(() => {
+    fetch('http://localhost:8080/v1/providers')
+      .then((res) => (res.ok ? (res.json() as Promise<ProviderHealth[]>) : []))
+      .then((list) => setAvailable(Object.fromEntries(list.map((p) => [p.name, p.available]))))
+      .catch(() => setAvailable({}))
+  }, [])
+  const unavailable = (name: string) => available[name] === false
+
+  // search box dropdown: earlier queries, or the filter phrase being typed
+  const [suggestions, setSuggestions] = useState<string[]>([])
+  useEffect(() => {
+    const prefix = query.trim()
+    if (prefix.length < 2) {
+      setSuggestions([])
+      return
+    }
+    const controller = new AbortController()
+    const timer = setTimeout(() => {
+      fetch(`http://localhost:8080/v1/suggest?prefix=${encodeURIComponent(prefix)}`, { signal: controller.signal })
+        .then((res) => (res.ok ? res.json() : { queries: [], filters: [] }))
+        .then((s: { queries: Suggestion[]; filters: Suggestion[] }) => {
+          // a filter phrase replaces the word being typed
+          const head = query.replace(/\S*$/, '')
+          setSuggestions([...s.queries.map((q) => q.text), ...s.filters.map((f) => head + f.text)])
+        })
+        .catch(() => {})
+    }, 200)
+    return () => {
+      clearTimeout(timer)
+      controller.abort()
+    }
+  }, [query])
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
     setError(null)
+    setBusyNotice(null)
     setLoading(true)
     setResult(null)
+    setTips({})
+    inflight.current?.abort()
+    const controller = new AbortController()
+    inflight.current = controller
 
     try {
-      const res = await fetch('http://localhost:8080/v1/parse', {
-        method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ query_de: query, provider }),
-      })
+      let res: Response
+      for (let attempt = 0; ; attempt++) {
+        res = await fetch('http://localhost:8080/v1/parse', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({
+            query_de: query,
+            provider,
+            interpretation: assisted ? 'assisted' : 'strict',
+            ...(accessible && { profile: 'accessibility' }),
+            impact: true,
+          }),
+          signal: controller.signal,
+        })
+        if (res.status !== 503 || attempt === overloadRetries) break
+        const problem: Problem | null = res.headers.get('Content-Type')?.includes('application/problem+json')
+          ? await res.clone().json()
+          : null
+        if (problem?.code !== 'overloaded') break
+        // wait as long as the server estimates, then try again
+        const wait = problem.estimated_wait_ms || Number(res.headers.get('Retry-After') ?? 1) * 1000
+        setBusyNotice(`Hohe Auslastung, bitte warten … (ca. ${Math.ceil(wait / 1000)} s)`)
+        await sleep(wait, controller.signal)
+        setBusyNotice(null)
+      }
 
       if (!res.ok) {
+        if (res.headers.get('Content-Type')?.includes('application/problem+json')) {
+          const problem: Problem = await res.json()
+          const perProvider = Object.entries(problem.providers ?? {})
+            .map(([name, p]) => `${name}: ${p.detail}`)
+            .join('\n')
+          throw new Error([problem.message_de, perProvider].filter(Boolean).join('\n'))
+        }
         const text = await res.text()
         throw new Error(text || res.statusText)
       }
 
       const data: MultiParseResponse = await res.json()
       setResult(data)
+      for (const name of ['openai', 'claude', 'rules'] as const) {
+        const impact = data.impact?.[name]
+        if (!data[name] || !impact || impact.error || impact.matches >= fewResults) continue
+        fetch(`http://localhost:8080/v1/relax?min_results=${fewResults}`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify(data[name]),
+          signal: controller.signal,
+        })
+          .then((r) => (r.ok ? r.json() : null))
+          .then((relax: { message_de: string } | null) => {
+            if (relax) setTips((prev) => ({ ...prev, [name]: relax.message_de }))
+          })
+          .catch(() => {})
+      }
     } catch (err: unknown) {
+      if (controller.signal.aborted) return
       const message =
         err instanceof Error ? err.message : 'Unbekannter Fehler bei der Anfrage.'
       setError(message)
     } finally {
-      setLoading(false)
+      if (inflight.current === controller) {
+        inflight.current = null
+        setLoading(false)
+        setBusyNotice(null)
+      }
     }
   }
 
+  const handleCancel = () => {
+    inflight.current?.abort()
+    inflight.current = null
+    setLoading(false)
+    setBusyNotice(null)
+  }
+
   return (
     <div style={{ maxWidth: 1000, margin: '0 auto', padding: 24 }}>
       <h1>Hotel Query Parser (LLM-backed)</h1>
@@ -100,7 +302,13 @@ function App() {
           value={query}
           onChange={(e) => setQuery(e.target.value)}
           aria-label="Hotelsuchanfrage"
+          list="query-suggestions"
         />
+        <datalist id="query-suggestions">
+          {suggestions.map((s) => (
+            <option key={s} value={s} />
+          ))}
+        </datalist>
         <select
           value={provider}
           onChange={(e) =>
@@ -108,21 +316,57 @@ function App() {
           }
           style={{ padding: 8 }}
         >
-          <option value="openai">OpenAI</option>
-          <option value="claude">Claude</option>
-          <option value="both">Both</option>
+          <option value="openai" disabled={unavailable('openai')}>OpenAI</option>
+          <option value="claude" disabled={unavailable('claude')}>Claude</option>
+          <option value="both" disabled={unavailable('openai') && unavailable('claude')}>Both</option>
         </select>
+        <label style={{ display: 'flex', alignItems: 'center', gap: 4 }}>
+          <input
+            type="checkbox"
+            checked={assisted}
+            onChange={(e) => setAssisted(e.target.checked)}
+          />
+          Annahmen
+        </label>
+        <label style={{ display: 'flex', alignItems: 'center', gap: 4 }}>
+          <input
+            type="checkbox"
+            checked={accessible}
+            onChange={(e) => setAccessible(e.target.checked)}
+          />
+          Barrierefreiheit
+        </label>
         <button type="submit" disabled={loading} style={{ padding: '8px 16px' }}>
           {loading ? '…' : 'Parse'}
         </button>
+        {loading && (
+          <button type="button" onClick={handleCancel} style={{ padding: '8px 16px' }}>
+            Abbrechen
+          </button>
+        )}
       </form>
 
+      {busyNotice && <div style={{ color: '#b45309', marginBottom: 8 }}>{busyNotice}</div>}
+
       {error && (
         <div style={{ color: 'red', marginBottom: 8, whiteSpace: 'pre-wrap' }}>
           <strong>Fehler:</strong> {error}
         </div>
       )}
 
+      {result?.status &&
+        Object.entries(result.status)
+          .filter(([, st]) => st.status === 'error')
+          .map(([name, st]) => (
+            <div key={name} style={{ color: '#b45309', marginBottom: 8 }}>
+              <strong>{name === 'openai' ? 'OpenAI' : 'Claude'}:</strong> {st.detail} ({st.code})
+            </div>
+          ))}
+
+      {result?.intent?.message_de && (
+        <div style={{ color: '#1d4ed8', marginBottom: 8 }}>{result.intent.message_de}</div>
+      )}
+
       {result && (
         <div style={{ display: 'grid', gridTemplateColumns: '1fr 1fr', gap: 16 }}>
           {result.openai && (
@@ -139,6 +383,7 @@ function App() {
               >
                 {JSON.stringify(result.openai, null, 2)}
               </pre>
+              <ImpactNote impact={result.impact?.openai} tip={tips.openai} />
             </div>
           )}
           {result.claude && (
@@ -155,6 +400,27 @@ function App() {
               >
                 {JSON.stringify(result.claude, null, 2)}
               </pre>
+              <ImpactNote impact={result.impact?.claude} tip={tips.claude} />
+            </div>
+          )}
+          {result.degraded && result.rules && (
+            <div>
+              <h2>Regeln (Notbetrieb)</h2>
+              <p style={{ color: '#b45309' }}>
+                Die Sprachdienste sind nicht erreichbar; dies ist eine vereinfachte Auswertung.
+              </p>
+              <pre
+                style={{
+                  background: '#fff7ed',
+                  padding: 12,
+                  borderRadius: 6,
+                  overflow: 'auto',
+                  maxHeight: 480,
+                }}
+              >
+                {JSON.stringify(result.rules, null, 2)}
+              </pre>
+              <ImpactNote impact={result.impact?.rules} tip={tips.rules} />
             </div>
           )}
         </div>
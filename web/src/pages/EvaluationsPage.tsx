@@ -29,6 +29,8 @@ type ProviderMetrics = {
   count: number
   ambiguity_handling_rate: number
   per_slot: Record<string, SlotAgg>
+  // answers that found hotels in the search API, when SEARCH_API_URL is set
+  task_success?: { rate: number; searched: number; zero_results: number; errors: number; avg_results: number }
 }
 type EvalResp = { openai?: ProviderMetrics; claude?: ProviderMetrics }
 
@@ -39,7 +41,7 @@ type ParseResponse = {
   price_max_eur: number
   stars_min: number
   rating_min: number
-  family_friendly: boolean
+  family_friendly: boolean | null
   ui_filters: {
     meals: string[]
     ratings: string[]
@@ -68,6 +70,11 @@ type StoredEntry = {
   response: { openai?: ParseResponse; claude?: ParseResponse }
   time: string
 }
+type QueryScores = { exact_match: boolean; jaccard: number; f1: number; latency_ms: number }
+type LiveMessage = { type: "run"; run: StoredEntry & { id: string }; scores?: Record<string, QueryScores> }
+
+// newest runs kept by the live tab
+const liveKeep = 50
 
 // ---------------- Helpers ----------------
 function pct(n?: number) {
@@ -191,7 +198,10 @@ export default function EvaluationsPage() {
   const [runs, setRuns] = useState<StoredEntry[] | null>(null)
   const [loading, setLoading] = useState(false)
   const [sortKey, setSortKey] = useState<"slot" | "openai_f1" | "claude_f1">("slot")
+  const [live, setLive] = useState<LiveMessage[]>([])
+  const [liveOpen, setLiveOpen] = useState(false)
 
+  // no credentials are sent: the page needs a backend with API_KEYS unset
   const load = async () => {
     setLoading(true)
     try {
@@ -213,6 +223,31 @@ export default function EvaluationsPage() {
     load()
   }, [])
 
+  // runs stored from now on, scored as they come in; reconnects after a drop
+  useEffect(() => {
+    let ws: WebSocket | null = null
+    let retry: ReturnType<typeof setTimeout> | undefined
+    let stopped = false
+    const connect = () => {
+      ws = new WebSocket("ws://localhost:8080/v1/ws")
+      ws.onopen = () => setLiveOpen(true)
+      ws.onmessage = (e) => {
+        const msg = JSON.parse(e.data) as LiveMessage
+        if (msg.type === "run") setLive((prev) => [msg, ...prev].slice(0, liveKeep))
+      }
+      ws.onclose = () => {
+        setLiveOpen(false)
+        if (!stopped) retry = setTimeout(connect, 3000)
+      }
+    }
+    connect()
+    return () => {
+      stopped = true
+      clearTimeout(retry)
+      ws?.close()
+    }
+  }, [])
+
   const latest = useMemo(() => {
     if (!runs || runs.length === 0) return null
     return runs[runs.length - 1]
@@ -261,6 +296,7 @@ export default function EvaluationsPage() {
             <TabsTrigger value="per-slot">Per‑slot</TabsTrigger>
             <TabsTrigger value="latest">Latest Diff</TabsTrigger>
             <TabsTrigger value="history">History</TabsTrigger>
+            <TabsTrigger value="live">Live</TabsTrigger>
           </TabsList>
 
           {/* Summary: metric cards for OpenAI and Claude */}
@@ -281,6 +317,9 @@ export default function EvaluationsPage() {
                   <MetricCard title="Jaccard" value={metrics.openai?.jaccard} />
                   <MetricCard title="Avg Latency" value={(metrics.openai?.avg_latency_ms ?? 0) / 2000} hint={ms(metrics.openai?.avg_latency_ms)} max={1} />
                   <MetricCard title="Ambiguity Handling" value={metrics.openai?.ambiguity_handling_rate} hint="On ambiguous queries" />
+                  {metrics.openai?.task_success && (
+                    <MetricCard title="Task Success" value={metrics.openai.task_success.rate} hint={`${metrics.openai.task_success.zero_results} of ${metrics.openai.task_success.searched} searches without results`} />
+                  )}
                 </CardContent>
               </Card>
 
@@ -299,6 +338,9 @@ export default function EvaluationsPage() {
                   <MetricCard title="Jaccard" value={metrics.claude?.jaccard} />
                   <MetricCard title="Avg Latency" value={(metrics.claude?.avg_latency_ms ?? 0) / 2000} hint={ms(metrics.claude?.avg_latency_ms)} max={1} />
                   <MetricCard title="Ambiguity Handling" value={metrics.claude?.ambiguity_handling_rate} hint="On ambiguous queries" />
+                  {metrics.claude?.task_success && (
+                    <MetricCard title="Task Success" value={metrics.claude.task_success.rate} hint={`${metrics.claude.task_success.zero_results} of ${metrics.claude.task_success.searched} searches without results`} />
+                  )}
                 </CardContent>
               </Card>
             </div>
@@ -471,6 +513,45 @@ export default function EvaluationsPage() {
               </Card>
             )}
           </TabsContent>
+
+          {/* Live feed: runs as they are stored, with their scores */}
+          <TabsContent value="live" className="pt-4">
+            <Card>
+              <CardHeader>
+                <CardTitle>
+                  Live {liveOpen ? <Badge className="ml-2">connected</Badge> : <Badge className="ml-2" variant="destructive">offline</Badge>}
+                </CardTitle>
+              </CardHeader>
+              <CardContent>
+                {live.length === 0 ? (
+                  <div className="text-sm text-muted-foreground">Waiting for runs… parse a query to see it here.</div>
+                ) : (
+                  <Table>
+                    <TableHeader>
+                      <TableRow>
+                        <TableHead className="w-[40%]">Query</TableHead>
+                        <TableHead>Time</TableHead>
+                        <TableHead>Latency</TableHead>
+                        <TableHead>OpenAI F1</TableHead>
+                        <TableHead>Claude F1</TableHead>
+                      </TableRow>
+                    </TableHeader>
+                    <TableBody>
+                      {live.map((m) => (
+                        <TableRow key={m.run.id}>
+                          <TableCell className="text-sm">{m.run.query}</TableCell>
+                          <TableCell className="text-xs text-muted-foreground">{new Date(m.run.time).toLocaleTimeString()}</TableCell>
+                          <TableCell>{ms(m.run.latency_ms)}</TableCell>
+                          <TableCell>{m.scores ? pct(m.scores.openai?.f1) : "no ground truth"}</TableCell>
+                          <TableCell>{m.scores ? pct(m.scores.claude?.f1) : "no ground truth"}</TableCell>
+                        </TableRow>
+                      ))}
+                    </TableBody>
+                  </Table>
+                )}
+              </CardContent>
+            </Card>
+          </TabsContent>
         </Tabs>
       )}
     </div>